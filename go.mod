@@ -1,6 +1,6 @@
 module github.com/avinash240/pusher
 
-go 1.15
+go 1.16
 
 require (
 	github.com/buger/jsonparser v1.1.1
@@ -10,5 +10,7 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.8.1
 	github.com/vishen/go-chromecast v0.2.10
+	golang.org/x/image v0.0.0-20210628002857-a66eb6448b8d
+	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is where pusher looks for its configuration file.
+const DefaultPath = "./config/pusher.yaml"
+
+// Where the self-signed certificate the API is served with, when TLS is
+// enabled without a certificate, and its key are kept.
+const (
+	SelfSignedCertFile = "./config/tls/cert.pem"
+	SelfSignedKeyFile  = "./config/tls/key.pem"
+)
+
+// Config is the pusher configuration.
+type Config struct {
+	API       APIConfig       `yaml:"api"`
+	Media     MediaConfig     `yaml:"media"`
+	Transcode TranscodeConfig `yaml:"transcode"`
+	Shutdown  ShutdownConfig  `yaml:"shutdown"`
+	Sleep     SleepConfig     `yaml:"sleep"`
+	Schedule  ScheduleConfig  `yaml:"schedule"`
+	Sync      SyncConfig      `yaml:"sync"`
+	// Groups of devices controlled together, keyed by group name. Members
+	// are device uuids or friendly names.
+	Groups map[string][]string `yaml:"groups"`
+	Live   []LiveConfig        `yaml:"live"`
+}
+
+// APIConfig configures the device control API.
+type APIConfig struct {
+	Addr string     `yaml:"addr"`
+	Auth AuthConfig `yaml:"auth"`
+	TLS  TLSConfig  `yaml:"tls"`
+	// AllowedOrigins are the origins, such as "https://remote.example.com",
+	// of pages allowed to open the control socket besides those served by
+	// pusher itself.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// TLSConfig configures serving the API over https. The media server is
+// always served over http, as devices need it to be.
+type TLSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CertFile and KeyFile are the certificate and its key, in PEM. A
+	// self-signed certificate is generated, and used, when neither is set.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile lists the CAs, in PEM, client certificates are verified
+	// against. Admins need a client certificate as well as a token when it
+	// is set.
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// AuthConfig configures the tokens the API and the local media server
+// accept. Every request is served when there are none.
+type AuthConfig struct {
+	Tokens []TokenConfig `yaml:"tokens"`
+	// TokenFile lists more tokens, in the same form, so they can be kept
+	// out of the config.
+	TokenFile string `yaml:"token_file"`
+}
+
+// TokenConfig is a bearer token, and the name of the caller it was issued
+// to, which their requests are logged as. Scopes are read, control or
+// admin, each including those before it.
+type TokenConfig struct {
+	Name   string   `yaml:"name"`
+	Token  string   `yaml:"token"`
+	Scopes []string `yaml:"scopes"`
+}
+
+// MediaConfig configures the shared media server devices stream from.
+type MediaConfig struct {
+	// Port is fixed so it can be allowed through host firewalls.
+	Port int `yaml:"port"`
+	// TokenTTL expires media urls after they are issued. Zero never
+	// expires them.
+	TokenTTL time.Duration `yaml:"token_ttl"`
+	// SigningKey signs media urls with an HMAC when set.
+	SigningKey string `yaml:"signing_key"`
+	// RestrictClients only serves media to connected devices, the host
+	// and clients in AllowedNetworks, which are CIDRs.
+	RestrictClients bool     `yaml:"restrict_clients"`
+	AllowedNetworks []string `yaml:"allowed_networks"`
+}
+
+// TranscodeConfig configures transcoder processes.
+type TranscodeConfig struct {
+	// MaxConcurrent limits the transcoders running at once across all
+	// devices. Zero or less means no limit.
+	MaxConcurrent int `yaml:"max_concurrent"`
+	// HLSCacheDir keeps completed HLS output, so it is reused across
+	// sessions and restarts. Output is removed with each session when it
+	// is empty.
+	HLSCacheDir string `yaml:"hls_cache_dir"`
+}
+
+// ShutdownConfig configures what happens on SIGINT/SIGTERM.
+type ShutdownConfig struct {
+	// Timeout is how long to wait for devices, servers and transcoders to
+	// stop before giving up.
+	Timeout time.Duration `yaml:"timeout"`
+	// StopMedia stops media playing on every connected device.
+	StopMedia bool `yaml:"stop_media"`
+}
+
+// SleepConfig configures sleep timers.
+type SleepConfig struct {
+	// Fade is how long the volume is faded out for before a sleep timer
+	// stops or pauses the media. It is restored afterwards.
+	Fade time.Duration `yaml:"fade"`
+}
+
+// ScheduleConfig configures scheduled actions.
+type ScheduleConfig struct {
+	// Path is where schedules and the history of their runs are kept.
+	Path string `yaml:"path"`
+}
+
+// SyncConfig configures how sync groups keep their devices in step.
+type SyncConfig struct {
+	// Interval is how often devices are checked for drift.
+	Interval time.Duration `yaml:"interval"`
+	// Tolerance is how far a device may drift before it is seeked back in
+	// line with the rest of its group.
+	Tolerance time.Duration `yaml:"tolerance"`
+}
+
+// LiveConfig configures a live source devices can play. Exactly one of
+// Command, Pipe, TCP and UDP is set.
+type LiveConfig struct {
+	Name        string `yaml:"name"`
+	ContentType string `yaml:"content_type"`
+	// Command is split into arguments the way a shell would, and is
+	// restarted whenever it exits.
+	Command string   `yaml:"command"`
+	Env     []string `yaml:"env"`
+	// Pipe is the path of a named pipe.
+	Pipe string `yaml:"pipe"`
+	// TCP and UDP are addresses to listen on.
+	TCP string `yaml:"tcp"`
+	UDP string `yaml:"udp"`
+}
+
+// Default returns the configuration used when no file is present.
+func Default() *Config {
+	return &Config{
+		API: APIConfig{
+			Addr: "127.0.0.1:8080",
+		},
+		Media: MediaConfig{
+			Port:            9002,
+			RestrictClients: true,
+		},
+		Transcode: TranscodeConfig{
+			MaxConcurrent: 2,
+		},
+		Shutdown: ShutdownConfig{
+			Timeout: time.Second * 10,
+		},
+		Sleep: SleepConfig{
+			Fade: time.Second * 30,
+		},
+		Schedule: ScheduleConfig{
+			Path: "./config/schedules.json",
+		},
+		Sync: SyncConfig{
+			Interval:  time.Second * 10,
+			Tolerance: time.Millisecond * 150,
+		},
+	}
+}
+
+// Load reads the configuration at path on top of the defaults. A missing
+// file isn't an error, the defaults are returned instead.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read config %q", path)
+	}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse config %q", path)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ActionType is what an action does to a device.
+type ActionType string
+
+const (
+	// Connect connects to the device, if it isn't connected already.
+	Connect ActionType = "connect"
+	// Load loads Path, a file, directory, playlist or url, on the device.
+	Load ActionType = "load"
+	// Volume sets the volume of the device to Volume.
+	Volume ActionType = "volume"
+	// Stop stops the media playing on the device.
+	Stop ActionType = "stop"
+	// Disconnect disconnects from the device.
+	Disconnect ActionType = "disconnect"
+)
+
+// AllDevices targets every device that can be discovered.
+const AllDevices = "*"
+
+// Action is something done to a device when a job runs. Devices are named
+// by uuid, by their friendly name or by the name of a group of devices, or
+// are AllDevices. Devices that aren't connected are connected to first.
+type Action struct {
+	Type        ActionType `json:"type"`
+	Device      string     `json:"device"`
+	Path        string     `json:"path,omitempty"`
+	ContentType string     `json:"content_type,omitempty"`
+	Volume      float32    `json:"volume,omitempty"`
+}
+
+func (a Action) validate() error {
+	if a.Device == "" {
+		return errors.Errorf("%s action has no device", a.Type)
+	}
+	switch a.Type {
+	case Connect, Stop, Disconnect:
+	case Load:
+		if a.Path == "" {
+			return errors.New("load action has no path")
+		}
+	case Volume:
+		if a.Volume < 0 || a.Volume > 1 {
+			return errors.New("volume action needs a volume between 0 and 1")
+		}
+	default:
+		return errors.Errorf("unknown action %q", a.Type)
+	}
+	return nil
+}
+
+// Job runs its actions in order on a cron schedule, or once at a given
+// time. Both are in TimeZone, or the local time zone if it isn't set.
+type Job struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Cron is a cron expression, see ParseCron.
+	Cron string `json:"cron,omitempty"`
+	// At is a time in RFC 3339, or "2006-01-02 15:04" in TimeZone. Jobs
+	// that run once are disabled once they have run.
+	At       string   `json:"at,omitempty"`
+	TimeZone string   `json:"time_zone,omitempty"`
+	Enabled  bool     `json:"enabled"`
+	Actions  []Action `json:"actions"`
+
+	LastRun *time.Time `json:"last_run,omitempty"`
+	NextRun *time.Time `json:"next_run,omitempty"`
+}
+
+// atLayouts are the layouts At may be in, besides RFC 3339.
+var atLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+}
+
+func (j *Job) validate() error {
+	if (j.Cron == "") == (j.At == "") {
+		return errors.New("job needs exactly one of cron or at")
+	}
+	if len(j.Actions) == 0 {
+		return errors.New("job has no actions")
+	}
+	for _, a := range j.Actions {
+		if err := a.validate(); err != nil {
+			return err
+		}
+	}
+	_, err := j.next(time.Now())
+	return err
+}
+
+func (j *Job) location() (*time.Location, error) {
+	if j.TimeZone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(j.TimeZone)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unknown time zone %q", j.TimeZone)
+	}
+	return loc, nil
+}
+
+// next returns when the job runs next after t, or the zero time if it
+// doesn't.
+func (j *Job) next(t time.Time) (time.Time, error) {
+	loc, err := j.location()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if j.Cron != "" {
+		c, err := ParseCron(j.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return c.Next(t.In(loc)), nil
+	}
+
+	at, err := time.Parse(time.RFC3339, j.At)
+	for _, layout := range atLayouts {
+		if err == nil {
+			break
+		}
+		at, err = time.ParseInLocation(layout, j.At, loc)
+	}
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid time %q", j.At)
+	}
+	if !at.After(t) {
+		return time.Time{}, nil
+	}
+	return at, nil
+}
+
+// Run is the record of a job having run.
+type Run struct {
+	JobID    string    `json:"job_id"`
+	JobName  string    `json:"job_name"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Error is set if any action failed, or the job was missed.
+	Error   string         `json:"error,omitempty"`
+	Actions []ActionResult `json:"actions"`
+}
+
+// ActionResult is the outcome of an action.
+type ActionResult struct {
+	Action Action `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
@@ -0,0 +1,473 @@
+// Package scheduler runs actions against devices on cron schedules or at
+// given times, such as alarms or turning every speaker off at night. Jobs
+// and a history of their runs are persisted as JSON.
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultHistoryLength is the number of runs kept unless configured
+// otherwise.
+const DefaultHistoryLength = 200
+
+// actionTimeout is how long an action may take, which includes discovering
+// and connecting to its device.
+const actionTimeout = time.Minute * 2
+
+// ErrNotFound is returned for jobs that don't exist.
+var ErrNotFound = errors.New("job not found")
+
+// ErrNotLoaded is returned for changes to jobs that couldn't be loaded, so
+// they aren't overwritten.
+var ErrNotLoaded = errors.New("schedules weren't loaded")
+
+// Runner runs actions against devices.
+type Runner interface {
+	RunAction(ctx context.Context, action Action) error
+}
+
+// Scheduler runs jobs when they are due.
+type Scheduler struct {
+	path          string
+	runner        Runner
+	historyLength int
+	// loadErr is why the jobs at path couldn't be loaded.
+	loadErr error
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	history []Run
+
+	// The loop runs until 'stop' is closed, and actions until 'ctx' is
+	// done.
+	changed   chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	ctx       context.Context
+	cancel    context.CancelFunc
+	running   sync.WaitGroup
+}
+
+type Option func(*Scheduler)
+
+// WithHistoryLength keeps the last n runs.
+func WithHistoryLength(n int) Option {
+	return func(s *Scheduler) {
+		s.historyLength = n
+	}
+}
+
+// state is what is persisted.
+type state struct {
+	Jobs    []*Job `json:"jobs"`
+	History []Run  `json:"history"`
+}
+
+// New returns a scheduler running actions with runner, persisting its jobs
+// to path. Jobs already at path are loaded. Nothing is persisted if path is
+// empty. If the jobs at path can't be loaded the error is returned along
+// with a scheduler that refuses every change, so they aren't overwritten.
+func New(path string, runner Runner, opts ...Option) (*Scheduler, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		path:          path,
+		runner:        runner,
+		historyLength: DefaultHistoryLength,
+		jobs:          map[string]*Job{},
+		changed:       make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	if err := s.load(); err != nil {
+		s.loadErr = err
+		return s, err
+	}
+	return s, nil
+}
+
+func (s *Scheduler) load() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "unable to read schedules %q", s.path)
+	}
+	var st state
+	if err := json.Unmarshal(b, &st); err != nil {
+		return errors.Wrapf(err, "unable to parse schedules %q", s.path)
+	}
+
+	now := time.Now()
+	s.history = st.History
+	for _, j := range st.Jobs {
+		s.jobs[j.ID] = j
+		j.NextRun = nil
+		if !j.Enabled {
+			continue
+		}
+		next, err := j.next(now)
+		if err != nil {
+			s.logger(j).WithError(err).Warn("disabling invalid job")
+			j.Enabled = false
+			continue
+		}
+		if next.IsZero() && j.At != "" {
+			// Jobs that only run once and were due while we weren't
+			// running are missed rather than run late.
+			if j.LastRun == nil {
+				s.logger(j).Warn("missed job")
+				s.appendHistory(Run{JobID: j.ID, JobName: j.Name, Started: now, Finished: now, Error: "missed while pusher wasn't running"})
+			}
+			j.Enabled = false
+		}
+		s.schedule(j, next)
+	}
+	return nil
+}
+
+// Start runs jobs as they become due until Stop is called.
+func (s *Scheduler) Start() {
+	s.startOnce.Do(func() { go s.loop() })
+}
+
+// Stop stops running jobs, and waits for those already running to finish
+// until ctx is done, when they are cancelled.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	// The loop is never started once stopped.
+	s.startOnce.Do(func() { close(s.done) })
+	close(s.stop)
+	<-s.done
+	defer s.cancel()
+
+	finished := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return errors.New("scheduler: timed out waiting for jobs to finish")
+	}
+}
+
+// Jobs returns every job, by name.
+func (s *Scheduler) Jobs() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, *j)
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		if jobs[i].Name != jobs[k].Name {
+			return jobs[i].Name < jobs[k].Name
+		}
+		return jobs[i].ID < jobs[k].ID
+	})
+	return jobs
+}
+
+// Job returns the job id.
+func (s *Scheduler) Job(id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return *j, nil
+}
+
+// Add adds job, giving it an id, and returns it as scheduled.
+func (s *Scheduler) Add(job Job) (Job, error) {
+	if s.loadErr != nil {
+		return Job{}, s.notLoaded()
+	}
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+	job.ID, job.LastRun = id, nil
+	return s.put(job, true)
+}
+
+// Update replaces the job id with job, keeping when it last ran.
+func (s *Scheduler) Update(id string, job Job) (Job, error) {
+	s.mu.Lock()
+	existing, ok := s.jobs[id]
+	if ok {
+		job.LastRun = existing.LastRun
+	}
+	s.mu.Unlock()
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	job.ID = id
+	return s.put(job, false)
+}
+
+func (s *Scheduler) put(job Job, added bool) (Job, error) {
+	if s.loadErr != nil {
+		return Job{}, s.notLoaded()
+	}
+	if err := job.validate(); err != nil {
+		return Job{}, err
+	}
+	next, err := job.next(time.Now())
+	if err != nil {
+		return Job{}, err
+	}
+	if job.Enabled && next.IsZero() {
+		return Job{}, errors.New("job never runs")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !added {
+		if _, ok := s.jobs[job.ID]; !ok {
+			return Job{}, ErrNotFound
+		}
+	}
+	j := &job
+	s.schedule(j, next)
+	s.jobs[j.ID] = j
+	s.notify()
+	return *j, s.save()
+}
+
+// Remove removes the job id. Its runs are kept in the history.
+func (s *Scheduler) Remove(id string) error {
+	if s.loadErr != nil {
+		return s.notLoaded()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.jobs, id)
+	s.notify()
+	return s.save()
+}
+
+// RunNow runs the job id straight away, whether or not it is enabled.
+func (s *Scheduler) RunNow(id string) error {
+	job, err := s.Job(id)
+	if err != nil {
+		return err
+	}
+	s.running.Add(1)
+	go s.run(job)
+	return nil
+}
+
+// History returns the runs of the job id, or of every job if id is empty,
+// latest first.
+func (s *Scheduler) History(id string) []Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs := []Run{}
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if id == "" || s.history[i].JobID == id {
+			runs = append(runs, s.history[i])
+		}
+	}
+	return runs
+}
+
+func (s *Scheduler) loop() {
+	defer close(s.done)
+
+	for {
+		now := time.Now()
+		var wake time.Time
+		var due []Job
+
+		s.mu.Lock()
+		for _, j := range s.jobs {
+			if j.NextRun == nil {
+				continue
+			}
+			if !j.NextRun.After(now) {
+				due = append(due, *j)
+				next, _ := j.next(now)
+				if j.At != "" {
+					j.Enabled = false
+				}
+				s.schedule(j, next)
+			}
+			if j.NextRun != nil && (wake.IsZero() || j.NextRun.Before(wake)) {
+				wake = *j.NextRun
+			}
+		}
+		if len(due) > 0 {
+			if err := s.save(); err != nil {
+				log.WithField("package", "scheduler").WithError(err).Warn("unable to save schedules")
+			}
+		}
+		s.mu.Unlock()
+
+		for _, j := range due {
+			s.running.Add(1)
+			go s.run(j)
+		}
+
+		timer := time.NewTimer(time.Until(wake))
+		if wake.IsZero() {
+			// Nothing is scheduled until a job changes.
+			timer.Stop()
+		}
+		select {
+		case <-timer.C:
+		case <-s.changed:
+		case <-s.stop:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// run runs the actions of job in order. Every action is run, even if an
+// earlier one failed.
+func (s *Scheduler) run(job Job) {
+	defer s.running.Done()
+
+	logger := s.logger(&job)
+	logger.Info("running job")
+
+	run := Run{JobID: job.ID, JobName: job.Name, Started: time.Now()}
+	failed := 0
+	for _, a := range job.Actions {
+		ctx, cancel := context.WithTimeout(s.ctx, actionTimeout)
+		err := s.runner.RunAction(ctx, a)
+		cancel()
+
+		result := ActionResult{Action: a}
+		if err != nil {
+			failed++
+			result.Error = err.Error()
+			logger.WithError(err).Warnf("%s action failed for device %q", a.Type, a.Device)
+		}
+		run.Actions = append(run.Actions, result)
+	}
+	run.Finished = time.Now()
+	if failed > 0 {
+		run.Error = fmt.Sprintf("%d of %d actions failed", failed, len(job.Actions))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.appendHistory(run)
+	if j, ok := s.jobs[job.ID]; ok {
+		started := run.Started
+		j.LastRun = &started
+	}
+	if err := s.save(); err != nil {
+		logger.WithError(err).Warn("unable to save schedules")
+	}
+}
+
+// notLoaded returns ErrNotLoaded with why the jobs weren't loaded.
+func (s *Scheduler) notLoaded() error {
+	return errors.Wrap(ErrNotLoaded, s.loadErr.Error())
+}
+
+// schedule sets when j runs next. s.mu must be held.
+func (s *Scheduler) schedule(j *Job, next time.Time) {
+	j.NextRun = nil
+	if j.Enabled && !next.IsZero() {
+		j.NextRun = &next
+	}
+}
+
+// appendHistory records run, dropping the oldest runs. s.mu must be held.
+func (s *Scheduler) appendHistory(run Run) {
+	s.history = append(s.history, run)
+	if over := len(s.history) - s.historyLength; over > 0 {
+		s.history = append([]Run(nil), s.history[over:]...)
+	}
+}
+
+// save persists the jobs and history. s.mu must be held.
+func (s *Scheduler) save() error {
+	if s.loadErr != nil {
+		return s.notLoaded()
+	}
+	if s.path == "" {
+		return nil
+	}
+	st := state{Jobs: make([]*Job, 0, len(s.jobs)), History: s.history}
+	for _, j := range s.jobs {
+		st.Jobs = append(st.Jobs, j)
+	}
+	sort.Slice(st.Jobs, func(i, k int) bool { return st.Jobs[i].ID < st.Jobs[k].ID })
+
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "unable to encode schedules")
+	}
+	// Write then rename, so a crash never leaves a truncated file.
+	tmp := s.path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return errors.Wrap(err, "unable to create schedules directory")
+	}
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Wrapf(err, "unable to write schedules %q", tmp)
+	}
+	return errors.Wrapf(os.Rename(tmp, s.path), "unable to write schedules %q", s.path)
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) logger(j *Job) *log.Entry {
+	return log.WithField("package", "scheduler").WithFields(log.Fields{
+		"job":  j.ID,
+		"name": j.Name,
+	})
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "unable to generate job id")
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/avinash240/pusher/internal/scheduler"
+	application "github.com/avinash240/pusher/internal/server/application"
+	auth "github.com/avinash240/pusher/internal/server/auth"
+	chttp "github.com/avinash240/pusher/internal/server/chttp"
+	"github.com/avinash240/pusher/internal/transcode"
+)
+
+// apiPrefix is where version 1 of the API is served.
+const apiPrefix = "/api/v1"
+
+// Codes of the errors returned by the API.
+const (
+	codeInvalidRequest    = "invalid_request"
+	codeNotFound          = "not_found"
+	codeMethodNotAllowed  = "method_not_allowed"
+	codeUnsupportedBody   = "unsupported_content_type"
+	codeNotConnected      = "device_not_connected"
+	codeAlreadyConnected  = "device_already_connected"
+	codeDeviceNotFound    = "device_not_found"
+	codeDeviceUnreachable = "device_unreachable"
+	codeUnsupportedMedia  = "unsupported_media"
+	codeNoMedia           = "no_media"
+	codeGroupFailed       = "group_failed"
+	codeUnauthorized      = "unauthorized"
+	codeForbidden         = "forbidden"
+	codeInternal          = "internal_error"
+)
+
+// apiErrorCodes is every code of the errors returned by the API.
+var apiErrorCodes = []string{
+	codeInvalidRequest,
+	codeNotFound,
+	codeMethodNotAllowed,
+	codeUnsupportedBody,
+	codeNotConnected,
+	codeAlreadyConnected,
+	codeDeviceNotFound,
+	codeDeviceUnreachable,
+	codeUnsupportedMedia,
+	codeNoMedia,
+	codeGroupFailed,
+	codeUnauthorized,
+	codeForbidden,
+	codeInternal,
+}
+
+// apiError is an error returned by the API, with the status and code it is
+// written with.
+type apiError struct {
+	status  int
+	code    string
+	message string
+	details interface{}
+}
+
+func (e *apiError) Error() string { return e.message }
+
+func newAPIError(status int, code, format string, args ...interface{}) *apiError {
+	return &apiError{status: status, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// invalid returns an error for a request that isn't valid.
+func invalid(format string, args ...interface{}) *apiError {
+	return newAPIError(http.StatusBadRequest, codeInvalidRequest, format, args...)
+}
+
+// toAPIError returns err as it is written by the API. Errors the API
+// doesn't know about are internal errors.
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, auth.ErrNoToken), errors.Is(err, auth.ErrInvalidToken):
+		return newAPIError(http.StatusUnauthorized, codeUnauthorized, "%v", err)
+	case errors.Is(err, auth.ErrForbidden), errors.Is(err, auth.ErrNoClientCert):
+		return newAPIError(http.StatusForbidden, codeForbidden, "%v", err)
+	case errors.Is(err, application.ErrUnsupportedMedia):
+		return newAPIError(http.StatusUnsupportedMediaType, codeUnsupportedMedia, "%v", err)
+	case errors.Is(err, application.ErrVolumeOutOfRange),
+		errors.Is(err, application.ErrSleepExtension),
+		errors.Is(err, application.ErrNoMediaEnd):
+		return invalid("%v", err)
+	case errors.Is(err, application.ErrMediaNotYetInitialised),
+		errors.Is(err, application.ErrNoMediaNext),
+		errors.Is(err, application.ErrNoMediaPause),
+		errors.Is(err, application.ErrNoMediaPrevious),
+		errors.Is(err, application.ErrNoMediaSkip),
+		errors.Is(err, application.ErrNoMediaStop),
+		errors.Is(err, application.ErrNoMediaUnpause),
+		errors.Is(err, application.ErrNoMediaSleep):
+		return newAPIError(http.StatusConflict, codeNoMedia, "%v", err)
+	case errors.Is(err, application.ErrNoSleepTimer),
+		errors.Is(err, scheduler.ErrNotFound),
+		errors.Is(err, transcode.ErrUnknownJob),
+		errors.Is(err, errGroupNotFound):
+		return newAPIError(http.StatusNotFound, codeNotFound, "%v", err)
+	}
+	return newAPIError(http.StatusInternalServerError, codeInternal, "%v", err)
+}
+
+// apiHandler handles a request to a route, with the params named in its
+// pattern. The value returned is written as json, or nothing is written if
+// it is nil.
+type apiHandler func(r *http.Request, params map[string]string) (interface{}, error)
+
+type apiRoute struct {
+	method  string
+	pattern string
+	// The scope tokens need, and the status written on success.
+	scope    auth.Scope
+	status   int
+	segments []string
+	handle   apiHandler
+}
+
+// match returns the params of the route in path segments, if it matches.
+func (rt apiRoute) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, s := range rt.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[s[1:len(s)-1]] = segments[i]
+			continue
+		}
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// apiRouter routes requests under apiPrefix to the route matching their
+// method and path. Patterns name params in braces, such as
+// "/devices/{uuid}/playback".
+type apiRouter struct {
+	routes []apiRoute
+	// Requests are authorised for the scope of their route by auth.
+	auth *auth.Authenticator
+}
+
+func (a *apiRouter) handle(method, pattern string, scope auth.Scope, status int, handle apiHandler) {
+	a.routes = append(a.routes, apiRoute{
+		method:   method,
+		pattern:  pattern,
+		scope:    scope,
+		status:   status,
+		segments: strings.Split(strings.Trim(pattern, "/"), "/"),
+		handle:   handle,
+	})
+}
+
+func (a *apiRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/")
+	segments := strings.Split(path, "/")
+
+	var allowed []string
+	for _, rt := range a.routes {
+		params, ok := rt.match(segments)
+		if !ok {
+			continue
+		}
+		if rt.method != r.Method {
+			allowed = append(allowed, rt.method)
+			continue
+		}
+
+		r, err := a.auth.Check(r, rt.scope)
+		if err != nil {
+			auth.Challenge(w, err)
+			writeAPIError(w, r, err)
+			return
+		}
+		v, err := rt.handle(r, params)
+		if err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		if v == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(rt.status)
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			log.Printf("error encoding json: %v", err)
+		}
+		return
+	}
+
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeAPIError(w, r, newAPIError(http.StatusMethodNotAllowed, codeMethodNotAllowed, "%s isn't allowed, use %s", r.Method, strings.Join(allowed, " or ")))
+		return
+	}
+	writeAPIError(w, r, newAPIError(http.StatusNotFound, codeNotFound, "no route for %s", r.URL.Path))
+}
+
+// writeAPIError writes err in the error envelope every API error shares.
+func writeAPIError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := toAPIError(err)
+	if apiErr.status >= http.StatusInternalServerError {
+		log.Printf("%s %s failed: %v", r.Method, r.URL.Path, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.status)
+	resp := chttp.ErrorResponse{Error: chttp.ErrorBody{
+		Code:    apiErr.code,
+		Message: apiErr.message,
+		Details: apiErr.details,
+	}}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("error encoding json: %v", err)
+	}
+}
+
+// decodeBody decodes the json body of r into v, rejecting fields v doesn't
+// have. An empty body leaves v as it is, unless required is set.
+func decodeBody(r *http.Request, v interface{}, required bool) error {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mt, _, err := mime.ParseMediaType(ct); err != nil || mt != "application/json" {
+			return newAPIError(http.StatusUnsupportedMediaType, codeUnsupportedBody, "request body must be application/json")
+		}
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == io.EOF {
+		if required {
+			return invalid("missing request body")
+		}
+		return nil
+	}
+	if err != nil {
+		return invalid("unable to decode request body: %v", err)
+	}
+	return nil
+}
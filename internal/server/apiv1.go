@@ -0,0 +1,603 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/avinash240/pusher/internal/scheduler"
+	application "github.com/avinash240/pusher/internal/server/application"
+	auth "github.com/avinash240/pusher/internal/server/auth"
+	chttp "github.com/avinash240/pusher/internal/server/chttp"
+)
+
+// registerAPI serves version 1 of the API under apiPrefix. Every response
+// is json, and every error is a chttp.ErrorResponse.
+func (h *Handler) registerAPI() {
+	/*
+		GET    /api/v1/devices?interface=<iface>&wait=<seconds>
+		GET    /api/v1/devices/{uuid}
+		PUT    /api/v1/devices/{uuid}/connection (json chttp.ConnectRequest, optional)
+		DELETE /api/v1/devices/{uuid}/connection?stop=<bool>
+		POST   /api/v1/devices/{uuid}/playback (json chttp.PlaybackRequest)
+		GET    /api/v1/devices/{uuid}/volume
+		PUT    /api/v1/devices/{uuid}/volume (json chttp.VolumeRequest)
+		POST   /api/v1/devices/{uuid}/media (json chttp.LoadRequest)
+		POST   /api/v1/devices/{uuid}/live (json chttp.LiveRequest)
+		GET    /api/v1/devices/{uuid}/sleep
+		PUT    /api/v1/devices/{uuid}/sleep (json chttp.SleepRequest)
+		POST   /api/v1/devices/{uuid}/sleep/extend (json chttp.SleepRequest)
+		DELETE /api/v1/devices/{uuid}/sleep
+		GET    /api/v1/groups
+		PUT    /api/v1/groups/{name} (json chttp.GroupRequest)
+		DELETE /api/v1/groups/{name}
+		DELETE /api/v1/groups/{name}/connection?stop=<bool>
+		POST   /api/v1/groups/{name}/playback (json chttp.PlaybackRequest)
+		PUT    /api/v1/groups/{name}/volume (json chttp.VolumeRequest)
+		POST   /api/v1/groups/{name}/media (json chttp.LoadRequest)
+		GET    /api/v1/schedules
+		POST   /api/v1/schedules (json schedule)
+		GET    /api/v1/schedules/{id}
+		PUT    /api/v1/schedules/{id} (json schedule)
+		DELETE /api/v1/schedules/{id}
+		POST   /api/v1/schedules/{id}/run
+		GET    /api/v1/schedules/{id}/history
+		GET    /api/v1/transcodes
+		DELETE /api/v1/transcodes/{id}
+		GET    /api/v1/live
+		GET    /api/v1/library
+	*/
+
+	api := &apiRouter{auth: h.auth}
+	api.handle(http.MethodGet, "/devices", auth.Read, http.StatusOK, h.apiListDevices)
+	api.handle(http.MethodGet, "/devices/{uuid}", auth.Read, http.StatusOK, h.apiDeviceStatus)
+	api.handle(http.MethodPut, "/devices/{uuid}/connection", auth.Admin, http.StatusOK, h.apiConnect)
+	api.handle(http.MethodDelete, "/devices/{uuid}/connection", auth.Admin, http.StatusNoContent, h.apiDisconnect)
+	api.handle(http.MethodPost, "/devices/{uuid}/playback", auth.Control, http.StatusOK, h.apiPlayback)
+	api.handle(http.MethodGet, "/devices/{uuid}/volume", auth.Read, http.StatusOK, h.apiVolume)
+	api.handle(http.MethodPut, "/devices/{uuid}/volume", auth.Control, http.StatusOK, h.apiSetVolume)
+	api.handle(http.MethodPost, "/devices/{uuid}/media", auth.Admin, http.StatusOK, h.apiLoad)
+	api.handle(http.MethodPost, "/devices/{uuid}/live", auth.Control, http.StatusOK, h.apiLoadLive)
+	api.handle(http.MethodGet, "/devices/{uuid}/sleep", auth.Read, http.StatusOK, h.apiSleepStatus)
+	api.handle(http.MethodPut, "/devices/{uuid}/sleep", auth.Control, http.StatusOK, h.apiSleep)
+	api.handle(http.MethodPost, "/devices/{uuid}/sleep/extend", auth.Control, http.StatusOK, h.apiExtendSleep)
+	api.handle(http.MethodDelete, "/devices/{uuid}/sleep", auth.Control, http.StatusNoContent, h.apiCancelSleep)
+	api.handle(http.MethodGet, "/groups", auth.Read, http.StatusOK, h.apiListGroups)
+	api.handle(http.MethodPut, "/groups/{name}", auth.Admin, http.StatusOK, h.apiSetGroup)
+	api.handle(http.MethodDelete, "/groups/{name}", auth.Admin, http.StatusNoContent, h.apiDeleteGroup)
+	api.handle(http.MethodDelete, "/groups/{name}/connection", auth.Admin, http.StatusOK, h.apiDisconnectGroup)
+	api.handle(http.MethodPost, "/groups/{name}/playback", auth.Control, http.StatusOK, h.apiGroupPlayback)
+	api.handle(http.MethodPut, "/groups/{name}/volume", auth.Control, http.StatusOK, h.apiGroupVolume)
+	api.handle(http.MethodPost, "/groups/{name}/media", auth.Admin, http.StatusOK, h.apiGroupLoad)
+	api.handle(http.MethodGet, "/schedules", auth.Read, http.StatusOK, h.apiListSchedules)
+	api.handle(http.MethodPost, "/schedules", auth.Admin, http.StatusCreated, h.apiAddSchedule)
+	api.handle(http.MethodGet, "/schedules/{id}", auth.Read, http.StatusOK, h.apiSchedule)
+	api.handle(http.MethodPut, "/schedules/{id}", auth.Admin, http.StatusOK, h.apiUpdateSchedule)
+	api.handle(http.MethodDelete, "/schedules/{id}", auth.Admin, http.StatusNoContent, h.apiDeleteSchedule)
+	api.handle(http.MethodPost, "/schedules/{id}/run", auth.Admin, http.StatusAccepted, h.apiRunSchedule)
+	api.handle(http.MethodGet, "/schedules/{id}/history", auth.Read, http.StatusOK, h.apiScheduleHistory)
+	api.handle(http.MethodGet, "/transcodes", auth.Read, http.StatusOK, h.apiListTranscodes)
+	api.handle(http.MethodDelete, "/transcodes/{id}", auth.Control, http.StatusNoContent, h.apiStopTranscode)
+	api.handle(http.MethodGet, "/live", auth.Read, http.StatusOK, h.apiListLive)
+	api.handle(http.MethodGet, "/library", auth.Read, http.StatusOK, h.apiListLibrary)
+
+	h.api = api
+	h.mux.Handle(apiPrefix+"/", api)
+}
+
+// apiApp returns the connected device 'uuid' in params, with its status up
+// to date.
+func (h *Handler) apiApp(params map[string]string) (*application.Application, error) {
+	uuid := params["uuid"]
+	app, ok := h.app(uuid)
+	if !ok {
+		return nil, newAPIError(http.StatusNotFound, codeNotConnected, "device %s is not connected", uuid)
+	}
+	if err := app.Update(); err != nil {
+		return nil, newAPIError(http.StatusBadGateway, codeDeviceUnreachable, "unable to reach device %s: %v", uuid, err)
+	}
+	return app, nil
+}
+
+// apiRun runs op on the device in params, and returns its status updated
+// by respond.
+func (h *Handler) apiRun(params map[string]string, what string, op deviceOp, respond func(*application.Application) interface{}) (interface{}, error) {
+	app, err := h.apiApp(params)
+	if err != nil {
+		return nil, err
+	}
+	uuid := params["uuid"]
+	log.Printf("%s device %s", what, uuid)
+	if err := op(uuid, app); err != nil {
+		return nil, err
+	}
+	if err := app.Update(); err != nil {
+		log.Printf("unable to update device %s: %v", uuid, err)
+	}
+	return respond(app), nil
+}
+
+// apiRunOnGroup runs op on every member of the group in params. It is an
+// error, with the result of every member in its details, if op failed for
+// them all.
+func (h *Handler) apiRunOnGroup(params map[string]string, what string, connect bool, op deviceOp) (interface{}, error) {
+	res, err := h.runOnGroup(params["name"], what, connect, op)
+	if err == errGroupNotFound {
+		return nil, newAPIError(http.StatusNotFound, codeNotFound, "group %s doesn't exist", params["name"])
+	}
+	if err != nil {
+		apiErr := newAPIError(http.StatusBadGateway, codeGroupFailed, "%v", err)
+		apiErr.details = res
+		return nil, apiErr
+	}
+	return res, nil
+}
+
+func apiStatus(app *application.Application) interface{} { return statusOf(app) }
+
+func apiVolume(app *application.Application) interface{} {
+	return chttp.FromApplicationVolume(app.Volume())
+}
+
+// playbackOp returns the operation req asks for.
+func playbackOp(req chttp.PlaybackRequest) (deviceOp, error) {
+	var op deviceOp
+	switch req.Action {
+	case "pause":
+		op = func(uuid string, app *application.Application) error { return app.Pause() }
+	case "unpause":
+		op = func(uuid string, app *application.Application) error { return app.Unpause() }
+	case "stop":
+		op = func(uuid string, app *application.Application) error { return app.StopMedia() }
+	case "next":
+		op = func(uuid string, app *application.Application) error { return app.Next() }
+	case "previous":
+		op = func(uuid string, app *application.Application) error { return app.Previous() }
+	case "skip":
+		op = func(uuid string, app *application.Application) error { return app.Skip() }
+	case "seek":
+		op = func(uuid string, app *application.Application) error { return app.Seek(int(req.Seconds)) }
+	case "rewind":
+		op = func(uuid string, app *application.Application) error { return app.Seek(-int(req.Seconds)) }
+	case "seek_to":
+		if req.Seconds < 0 {
+			return nil, invalid("'seconds' is negative")
+		}
+		op = func(uuid string, app *application.Application) error { return app.SeekToTime(float32(req.Seconds)) }
+	case "":
+		return nil, invalid("missing 'action'")
+	default:
+		return nil, invalid("unknown action %q, expected pause, unpause, stop, next, previous, skip, seek, rewind or seek_to", req.Action)
+	}
+	return updated(op), nil
+}
+
+// volumeOp returns the operation req asks for.
+func volumeOp(req chttp.VolumeRequest) (deviceOp, error) {
+	if req.Level == nil && req.Muted == nil {
+		return nil, invalid("one of 'level' or 'muted' is needed")
+	}
+	if req.Level != nil && (*req.Level < 0 || *req.Level > 1) {
+		return nil, invalid("'level' is not between 0 and 1")
+	}
+	return func(uuid string, app *application.Application) error {
+		if req.Level != nil {
+			if err := app.SetVolume(*req.Level); err != nil {
+				return err
+			}
+		}
+		if req.Muted != nil {
+			return app.SetMuted(*req.Muted)
+		}
+		return nil
+	}, nil
+}
+
+// loadOp returns the operation req asks for.
+func loadOp(req chttp.LoadRequest) (deviceOp, error) {
+	if req.Path == "" {
+		return nil, invalid("missing 'path'")
+	}
+	if req.Relay && !strings.HasPrefix(req.Path, "http://") && !strings.HasPrefix(req.Path, "https://") {
+		return nil, invalid("only http and https urls can be relayed")
+	}
+	return func(uuid string, app *application.Application) error {
+		if req.Relay {
+			return app.LoadRelay(req.Path, req.ContentType, req.ReloadMetadata, true, req.AllowAnyClient)
+		}
+		return app.Load(req.Path, req.ContentType, true, true, true, req.AllowAnyClient)
+	}, nil
+}
+
+func (h *Handler) apiListDevices(r *http.Request, params map[string]string) (interface{}, error) {
+	q := r.URL.Query()
+	devices := h.discoverDnsEntries(r.Context(), q.Get("interface"), q.Get("wait"))
+	log.Printf("found %d devices", len(devices))
+	return devices, nil
+}
+
+func (h *Handler) apiDeviceStatus(r *http.Request, params map[string]string) (interface{}, error) {
+	app, err := h.apiApp(params)
+	if err != nil {
+		return nil, err
+	}
+	return statusOf(app), nil
+}
+
+func (h *Handler) apiConnect(r *http.Request, params map[string]string) (interface{}, error) {
+	uuid := params["uuid"]
+	var req chttp.ConnectRequest
+	if err := decodeBody(r, &req, false); err != nil {
+		return nil, err
+	}
+	if (req.Addr == "") != (req.Port == 0) {
+		return nil, invalid("'addr' and 'port' are needed together")
+	}
+	if req.ImageWidth < 0 || req.ImageHeight < 0 {
+		return nil, invalid("'image_width' and 'image_height' can't be negative")
+	}
+	if _, ok := h.app(uuid); ok {
+		return nil, newAPIError(http.StatusConflict, codeAlreadyConnected, "device %s is already connected", uuid)
+	}
+
+	if req.Addr == "" {
+		log.Printf("looking up address for uuid %q", uuid)
+		for _, d := range h.discoverDnsEntries(r.Context(), req.Interface, strconv.Itoa(req.Wait)) {
+			if d.UUID == uuid {
+				req.Addr, req.Port = d.Addr, d.Port
+			}
+		}
+		if req.Addr == "" {
+			return nil, newAPIError(http.StatusNotFound, codeDeviceNotFound, "device %s wasn't found, and no 'addr' and 'port' were given", uuid)
+		}
+	}
+
+	log.Printf("connecting to addr=%s port=%d...", req.Addr, req.Port)
+	_, err := h.connectDevice(uuid, req.Addr, req.Port,
+		application.WithHLS(req.HLS),
+		application.WithImageResolution(req.ImageWidth, req.ImageHeight),
+	)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadGateway, codeDeviceUnreachable, "unable to connect to device %s: %v", uuid, err)
+	}
+	return chttp.ConnectResponse{DeviceUUID: uuid, Connected: true}, nil
+}
+
+func (h *Handler) apiDisconnect(r *http.Request, params map[string]string) (interface{}, error) {
+	uuid := params["uuid"]
+	h.mu.Lock()
+	app, ok := h.apps[uuid]
+	delete(h.apps, uuid)
+	h.mu.Unlock()
+	if !ok {
+		return nil, newAPIError(http.StatusNotFound, codeNotConnected, "device %s is not connected", uuid)
+	}
+	h.disconnected(uuid)
+
+	log.Printf("disconnecting device %s", uuid)
+	if err := app.Close(r.URL.Query().Get("stop") == "true"); err != nil {
+		log.Printf("unable to close application: %v", err)
+	}
+	return nil, nil
+}
+
+func (h *Handler) apiPlayback(r *http.Request, params map[string]string) (interface{}, error) {
+	var req chttp.PlaybackRequest
+	if err := decodeBody(r, &req, true); err != nil {
+		return nil, err
+	}
+	op, err := playbackOp(req)
+	if err != nil {
+		return nil, err
+	}
+	return h.apiRun(params, req.Action, op, apiStatus)
+}
+
+func (h *Handler) apiVolume(r *http.Request, params map[string]string) (interface{}, error) {
+	app, err := h.apiApp(params)
+	if err != nil {
+		return nil, err
+	}
+	return apiVolume(app), nil
+}
+
+func (h *Handler) apiSetVolume(r *http.Request, params map[string]string) (interface{}, error) {
+	var req chttp.VolumeRequest
+	if err := decodeBody(r, &req, true); err != nil {
+		return nil, err
+	}
+	op, err := volumeOp(req)
+	if err != nil {
+		return nil, err
+	}
+	return h.apiRun(params, "set volume of", op, apiVolume)
+}
+
+func (h *Handler) apiLoad(r *http.Request, params map[string]string) (interface{}, error) {
+	var req chttp.LoadRequest
+	if err := decodeBody(r, &req, true); err != nil {
+		return nil, err
+	}
+	op, err := loadOp(req)
+	if err != nil {
+		return nil, err
+	}
+	return h.apiRun(params, "load media on", h.reportLoad(op), apiStatus)
+}
+
+func (h *Handler) apiLoadLive(r *http.Request, params map[string]string) (interface{}, error) {
+	var req chttp.LiveRequest
+	if err := decodeBody(r, &req, true); err != nil {
+		return nil, err
+	}
+	stream, ok := h.live[req.Source]
+	if !ok {
+		return nil, invalid("unknown live source %q", req.Source)
+	}
+	return h.apiRun(params, "load live source on", h.reportLoad(func(uuid string, app *application.Application) error {
+		return app.LoadLive(stream, true)
+	}), apiStatus)
+}
+
+func (h *Handler) apiSleepStatus(r *http.Request, params map[string]string) (interface{}, error) {
+	app, err := h.apiApp(params)
+	if err != nil {
+		return nil, err
+	}
+	info := app.SleepStatus()
+	if info == nil {
+		return nil, application.ErrNoSleepTimer
+	}
+	return info, nil
+}
+
+func (h *Handler) apiSleep(r *http.Request, params map[string]string) (interface{}, error) {
+	var req chttp.SleepRequest
+	if err := decodeBody(r, &req, true); err != nil {
+		return nil, err
+	}
+	opts := application.SleepOptions{
+		Action: application.SleepAction(req.Action),
+		Fade:   h.sleepFade,
+	}
+	switch {
+	case req.Minutes < 0:
+		return nil, invalid("'minutes' is not a positive number")
+	case req.Minutes > 0 && req.Until != "":
+		return nil, invalid("only one of 'minutes' and 'until' can be set")
+	case req.Minutes > 0:
+		opts.Until, opts.After = application.SleepAfter, time.Duration(req.Minutes)*time.Minute
+	default:
+		opts.Until = application.SleepUntil(req.Until)
+		if opts.Until != application.SleepEndOfItem && opts.Until != application.SleepEndOfQueue {
+			return nil, invalid("missing 'minutes', or 'until' isn't end_of_item or end_of_queue")
+		}
+	}
+	if opts.Action != "" && opts.Action != application.SleepStop && opts.Action != application.SleepPause {
+		return nil, invalid("'action' isn't stop or pause")
+	}
+	if req.Fade != nil {
+		if *req.Fade < 0 {
+			return nil, invalid("'fade' is negative")
+		}
+		opts.Fade = time.Duration(*req.Fade) * time.Second
+	}
+
+	app, err := h.apiApp(params)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("setting sleep timer for device: %+v", opts)
+	if err := app.Sleep(opts); err != nil {
+		return nil, err
+	}
+	return app.SleepStatus(), nil
+}
+
+func (h *Handler) apiExtendSleep(r *http.Request, params map[string]string) (interface{}, error) {
+	var req chttp.SleepRequest
+	if err := decodeBody(r, &req, true); err != nil {
+		return nil, err
+	}
+	if req.Minutes <= 0 {
+		return nil, invalid("'minutes' is not a positive number")
+	}
+	app, err := h.apiApp(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := app.ExtendSleep(time.Duration(req.Minutes) * time.Minute); err != nil {
+		return nil, err
+	}
+	return app.SleepStatus(), nil
+}
+
+func (h *Handler) apiCancelSleep(r *http.Request, params map[string]string) (interface{}, error) {
+	app, err := h.apiApp(params)
+	if err != nil {
+		return nil, err
+	}
+	return nil, app.CancelSleep()
+}
+
+func (h *Handler) apiListGroups(r *http.Request, params map[string]string) (interface{}, error) {
+	return h.groupList(), nil
+}
+
+func (h *Handler) apiSetGroup(r *http.Request, params map[string]string) (interface{}, error) {
+	var req chttp.GroupRequest
+	if err := decodeBody(r, &req, true); err != nil {
+		return nil, err
+	}
+	if err := h.setGroupMembers(params["name"], req.Members); err != nil {
+		return nil, invalid("%v", err)
+	}
+	return chttp.Group{Name: params["name"], Members: req.Members}, nil
+}
+
+func (h *Handler) apiDeleteGroup(r *http.Request, params map[string]string) (interface{}, error) {
+	return nil, h.removeGroup(params["name"])
+}
+
+func (h *Handler) apiDisconnectGroup(r *http.Request, params map[string]string) (interface{}, error) {
+	stopMedia := r.URL.Query().Get("stop") == "true"
+	return h.apiRunOnGroup(params, "disconnect", false, func(uuid string, app *application.Application) error {
+		h.mu.Lock()
+		delete(h.apps, uuid)
+		h.mu.Unlock()
+		h.disconnected(uuid)
+		return app.Close(stopMedia)
+	})
+}
+
+func (h *Handler) apiGroupPlayback(r *http.Request, params map[string]string) (interface{}, error) {
+	var req chttp.PlaybackRequest
+	if err := decodeBody(r, &req, true); err != nil {
+		return nil, err
+	}
+	op, err := playbackOp(req)
+	if err != nil {
+		return nil, err
+	}
+	return h.apiRunOnGroup(params, req.Action, true, op)
+}
+
+func (h *Handler) apiGroupVolume(r *http.Request, params map[string]string) (interface{}, error) {
+	var req chttp.VolumeRequest
+	if err := decodeBody(r, &req, true); err != nil {
+		return nil, err
+	}
+	op, err := volumeOp(req)
+	if err != nil {
+		return nil, err
+	}
+	return h.apiRunOnGroup(params, "set volume of", true, op)
+}
+
+func (h *Handler) apiGroupLoad(r *http.Request, params map[string]string) (interface{}, error) {
+	var req chttp.LoadRequest
+	if err := decodeBody(r, &req, true); err != nil {
+		return nil, err
+	}
+	op, err := loadOp(req)
+	if err != nil {
+		return nil, err
+	}
+	return h.apiRunOnGroup(params, "load media on", true, h.reportLoad(op))
+}
+
+func (h *Handler) apiListSchedules(r *http.Request, params map[string]string) (interface{}, error) {
+	return h.scheduler.Jobs(), nil
+}
+
+func (h *Handler) apiAddSchedule(r *http.Request, params map[string]string) (interface{}, error) {
+	var job scheduler.Job
+	if err := decodeBody(r, &job, true); err != nil {
+		return nil, err
+	}
+	job, err := h.scheduler.Add(job)
+	if errors.Is(err, scheduler.ErrNotLoaded) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+	log.Printf("added schedule %s (%s)", job.ID, job.Name)
+	return job, nil
+}
+
+func (h *Handler) apiSchedule(r *http.Request, params map[string]string) (interface{}, error) {
+	job, err := h.scheduler.Job(params["id"])
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (h *Handler) apiUpdateSchedule(r *http.Request, params map[string]string) (interface{}, error) {
+	var job scheduler.Job
+	if err := decodeBody(r, &job, true); err != nil {
+		return nil, err
+	}
+	job, err := h.scheduler.Update(params["id"], job)
+	if err == scheduler.ErrNotFound || errors.Is(err, scheduler.ErrNotLoaded) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+	log.Printf("updated schedule %s (%s)", job.ID, job.Name)
+	return job, nil
+}
+
+func (h *Handler) apiDeleteSchedule(r *http.Request, params map[string]string) (interface{}, error) {
+	if err := h.scheduler.Remove(params["id"]); err != nil {
+		return nil, err
+	}
+	log.Printf("deleted schedule %s", params["id"])
+	return nil, nil
+}
+
+func (h *Handler) apiRunSchedule(r *http.Request, params map[string]string) (interface{}, error) {
+	job, err := h.scheduler.Job(params["id"])
+	if err != nil {
+		return nil, err
+	}
+	if err := h.scheduler.RunNow(job.ID); err != nil {
+		return nil, err
+	}
+	log.Printf("running schedule %s", job.ID)
+	return job, nil
+}
+
+func (h *Handler) apiScheduleHistory(r *http.Request, params map[string]string) (interface{}, error) {
+	if _, err := h.scheduler.Job(params["id"]); err != nil {
+		return nil, err
+	}
+	return h.scheduler.History(params["id"]), nil
+}
+
+func (h *Handler) apiListTranscodes(r *http.Request, params map[string]string) (interface{}, error) {
+	return h.transcoder.Jobs(), nil
+}
+
+func (h *Handler) apiStopTranscode(r *http.Request, params map[string]string) (interface{}, error) {
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return nil, invalid("transcode id %q is not a number", params["id"])
+	}
+	log.Printf("stopping transcode %d", id)
+	return nil, h.transcoder.Stop(id)
+}
+
+func (h *Handler) apiListLive(r *http.Request, params map[string]string) (interface{}, error) {
+	sources := []chttp.LiveSource{}
+	for _, stream := range h.live {
+		sources = append(sources, chttp.LiveSource{
+			Name:        stream.Name(),
+			ContentType: stream.ContentType(),
+			Source:      stream.Source().String(),
+			Listeners:   stream.Listeners(),
+		})
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+	return sources, nil
+}
+
+func (h *Handler) apiListLibrary(r *http.Request, params map[string]string) (interface{}, error) {
+	items, _ := h.library.get()
+	library := make([]chttp.LibraryItem, len(items))
+	for i, item := range items {
+		library[i] = chttp.LibraryItem{
+			Filename:    item.filename,
+			ContentType: item.contentType,
+			Transcode:   item.transcode,
+		}
+	}
+	return library, nil
+}
@@ -0,0 +1,297 @@
+package application
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/pkg/errors"
+
+	cast "github.com/avinash240/pusher/internal/server/cast"
+	pb "github.com/avinash240/pusher/internal/server/cast/proto"
+)
+
+// DefaultAnnounceTimeout is the longest an announcement plays for before
+// what was playing is restored regardless.
+const DefaultAnnounceTimeout = time.Minute * 5
+
+var ErrAnnounceTimeout = errors.New("announcement didn't finish in time")
+
+// AnnounceOptions configures an announcement.
+type AnnounceOptions struct {
+	ContentType string
+	// Volume is set while the announcement plays, unless it is zero.
+	Volume  float32
+	Timeout time.Duration
+}
+
+// snapshot is what a device was doing before an announcement.
+type snapshot struct {
+	appID  string
+	media  *cast.Media
+	volume cast.Volume
+	served map[string]mediaItem
+	queue  []mediaItem
+}
+
+// Announce interrupts whatever the device is doing to play clip, a file or
+// url, then restores the media that was playing at the position it was at,
+// along with the volume. Media played by other apps can't be restored, so
+// the app is launched again instead. It blocks until the clip has played.
+func (a *Application) Announce(clip string, opts AnnounceOptions) error {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultAnnounceTimeout
+	}
+
+	snap, err := a.snapshot()
+	if err != nil {
+		return errors.Wrap(err, "unable to snapshot device")
+	}
+	a.log("announcing %s, interrupting app=%q", clip, snap.appID)
+
+	if opts.Volume > 0 {
+		if err := a.SetVolume(opts.Volume); err != nil {
+			return errors.Wrap(err, "unable to set announcement volume")
+		}
+	}
+	if snap.volume.Muted {
+		a.SetMuted(false)
+	}
+
+	err = a.playClip(clip, opts)
+	if rerr := a.restore(snap); rerr != nil {
+		if err != nil {
+			return errors.Wrapf(err, "unable to restore device (%v) after announcement", rerr)
+		}
+		return errors.Wrap(rerr, "unable to restore device after announcement")
+	}
+	return errors.Wrap(err, "unable to play announcement")
+}
+
+func (a *Application) snapshot() (snapshot, error) {
+	if err := a.Update(); err != nil {
+		return snapshot{}, err
+	}
+
+	var snap snapshot
+	if a.volumeReceiver != nil {
+		snap.volume = *a.volumeReceiver
+	}
+	if a.application == nil || a.application.IsIdleScreen {
+		return snap, nil
+	}
+	snap.appID = a.application.AppId
+
+	// 'a.media' is left as it was when nothing is playing.
+	status, err := a.getMediaStatus()
+	if err != nil {
+		return snapshot{}, err
+	}
+	for _, media := range status.Status {
+		media := media
+		snap.media = &media
+	}
+
+	a.servedMu.Lock()
+	snap.served, snap.queue = a.served, a.queue
+	a.servedMu.Unlock()
+	return snap, nil
+}
+
+// playClip plays clip on the default media receiver, returning once it has
+// finished.
+func (a *Application) playClip(clip string, opts AnnounceOptions) error {
+	mi, err := a.mediaItemFor(clip, opts.ContentType)
+	if err != nil {
+		return err
+	}
+
+	// Statuses are followed from before the clip is loaded, so a short clip
+	// can't finish unnoticed.
+	statuses, unwatch := a.watchMediaStatus()
+	defer unwatch()
+
+	loaded, err := a.loadMediaItem(mi, true)
+	if err != nil {
+		return err
+	}
+	sessionID := loaded.MediaSessionId
+
+	timeout := time.NewTimer(opts.Timeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case status := <-statuses:
+			if status.MediaSessionId != sessionID || status.PlayerState != "IDLE" {
+				continue
+			}
+			if status.IdleReason == "ERROR" {
+				return errors.New("device was unable to play the announcement")
+			}
+			return nil
+		case <-timeout.C:
+			return ErrAnnounceTimeout
+		}
+	}
+}
+
+// mediaItemFor returns the media item to play filenameOrUrl as, serving it if
+// it is a file.
+func (a *Application) mediaItemFor(filenameOrUrl, contentType string) (mediaItem, error) {
+	if strings.HasPrefix(filenameOrUrl, "http://") || strings.HasPrefix(filenameOrUrl, "https://") {
+		if contentType == "" {
+			contentType, _ = a.possibleContentType(filenameOrUrl)
+		}
+		return mediaItem{contentURL: filenameOrUrl, contentType: contentType}, nil
+	}
+	items, err := a.loadAndServeFiles([]string{filenameOrUrl}, contentType, true, false)
+	if err != nil {
+		return mediaItem{}, err
+	}
+	return items[0], nil
+}
+
+// loadMediaItem loads mi on the default media receiver, returning the
+// status the device describes it with.
+func (a *Application) loadMediaItem(mi mediaItem, autoplay bool) (cast.Media, error) {
+	if err := a.ensureIsDefaultMediaReceiver(); err != nil {
+		return cast.Media{}, err
+	}
+	resp, err := a.sendAndWaitMediaRecv(&cast.LoadMediaCommand{
+		PayloadHeader: cast.LoadHeader,
+		CurrentTime:   0,
+		Autoplay:      autoplay,
+		Media:         mi.castMedia(),
+	})
+	if err != nil {
+		return cast.Media{}, err
+	}
+	payload := []byte(resp.GetPayloadUtf8())
+	if messageType, _ := jsonparser.GetString(payload, "type"); messageType != "MEDIA_STATUS" {
+		return cast.Media{}, errors.Errorf("device responded with %s", messageType)
+	}
+	var loaded cast.MediaStatusResponse
+	if err := json.Unmarshal(payload, &loaded); err != nil || len(loaded.Status) == 0 {
+		return cast.Media{}, errors.New("device didn't describe the media loaded")
+	}
+	return loaded.Status[0], nil
+}
+
+// restore puts the device back the way it was in snap.
+func (a *Application) restore(snap snapshot) error {
+	var err error
+	switch {
+	case snap.appID == "":
+		// Nothing was running, so go back to the idle screen.
+		err = a.Stop()
+	case snap.appID != defaultChromecastAppID:
+		err = a.ensureIsAppID(snap.appID)
+	case snap.media != nil && snap.media.Media.ContentId != "":
+		err = a.restoreMedia(snap)
+	}
+
+	if snap.volume.Level > 0 {
+		if verr := a.SetVolume(snap.volume.Level); verr != nil && err == nil {
+			err = verr
+		}
+	}
+	if snap.volume.Muted {
+		a.SetMuted(true)
+	}
+	return err
+}
+
+// restoreMedia loads the media in snap where it was. Queues loaded by this
+// application are loaded again in full.
+func (a *Application) restoreMedia(snap snapshot) error {
+	a.servedMu.Lock()
+	a.served, a.queue = snap.served, snap.queue
+	a.servedMu.Unlock()
+
+	m := snap.media
+	position := int(m.CurrentTime)
+	if m.Media.StreamType == "LIVE" {
+		position = 0
+	}
+	autoplay := m.PlayerState != "PAUSED"
+
+	for i, mi := range snap.queue {
+		if len(snap.queue) < 2 || mi.contentURL != m.Media.ContentId {
+			continue
+		}
+		// Only the item restored stays paused, so the queue still plays
+		// on once it is unpaused.
+		items := make([]cast.QueueLoadItem, len(snap.queue))
+		for k, mi := range snap.queue {
+			items[k] = cast.QueueLoadItem{Autoplay: autoplay || k != i, Media: mi.castMedia()}
+		}
+		return a.sendMediaRecv(&cast.QueueLoad{
+			PayloadHeader: cast.QueueLoadHeader,
+			CurrentTime:   float32(position),
+			StartIndex:    i,
+			RepeatMode:    "REPEAT_OFF",
+			Items:         items,
+		})
+	}
+
+	return a.sendMediaRecv(&cast.LoadMediaCommand{
+		PayloadHeader: cast.LoadHeader,
+		CurrentTime:   position,
+		Autoplay:      autoplay,
+		Media:         m.Media,
+	})
+}
+
+// mediaWatchers are sent every media status received.
+type mediaWatchers struct {
+	once     sync.Once
+	mu       sync.Mutex
+	watchers map[chan cast.Media]struct{}
+}
+
+// watchMediaStatus returns a channel receiving the media statuses sent by
+// the device until unwatch is called. Statuses are dropped if the channel
+// is full.
+func (a *Application) watchMediaStatus() (statuses <-chan cast.Media, unwatch func()) {
+	a.mediaWatchers.once.Do(func() {
+		a.AddMessageFunc(a.dispatchMediaStatus)
+	})
+
+	ch := make(chan cast.Media, 32)
+	a.mediaWatchers.mu.Lock()
+	if a.mediaWatchers.watchers == nil {
+		a.mediaWatchers.watchers = map[chan cast.Media]struct{}{}
+	}
+	a.mediaWatchers.watchers[ch] = struct{}{}
+	a.mediaWatchers.mu.Unlock()
+
+	return ch, func() {
+		a.mediaWatchers.mu.Lock()
+		delete(a.mediaWatchers.watchers, ch)
+		a.mediaWatchers.mu.Unlock()
+	}
+}
+
+func (a *Application) dispatchMediaStatus(msg *pb.CastMessage) {
+	payload := []byte(msg.GetPayloadUtf8())
+	if messageType, _ := jsonparser.GetString(payload, "type"); messageType != "MEDIA_STATUS" {
+		return
+	}
+	resp := cast.MediaStatusResponse{}
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		return
+	}
+
+	a.mediaWatchers.mu.Lock()
+	defer a.mediaWatchers.mu.Unlock()
+	for ch := range a.mediaWatchers.watchers {
+		for _, status := range resp.Status {
+			select {
+			case ch <- status:
+			default:
+			}
+		}
+	}
+}
@@ -15,10 +15,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"image"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"path"
 	"strconv"
 	"strings"
@@ -34,14 +34,28 @@ import (
 
 	cast "github.com/avinash240/pusher/internal/server/cast"
 	pb "github.com/avinash240/pusher/internal/server/cast/proto"
+	device "github.com/avinash240/pusher/internal/server/device"
+	media "github.com/avinash240/pusher/internal/server/media"
+	hls "github.com/avinash240/pusher/internal/streaming/hls"
+	imaging "github.com/avinash240/pusher/internal/streaming/imaging"
+	live "github.com/avinash240/pusher/internal/streaming/live"
+	playlist "github.com/avinash240/pusher/internal/streaming/playlist"
+	probe "github.com/avinash240/pusher/internal/streaming/probe"
+	radio "github.com/avinash240/pusher/internal/streaming/radio"
+	"github.com/avinash240/pusher/internal/transcode"
 	// "github.com/vishen/go-chromecast/storage"
 )
 
 var (
-	// Global request id
+	// Global request id, taken holding 'requestMu'.
 	requestID int
+	requestMu sync.Mutex
 )
 
+// ErrUnsupportedMedia is returned when media can't be played on the device,
+// even by transcoding it.
+var ErrUnsupportedMedia = errors.New("media not supported by device")
+
 const (
 	// 'CC1AD845' seems to be a predefined app; check link
 	// https://gist.github.com/jloutsenhizer/8855258
@@ -53,6 +67,9 @@ const (
 	namespaceConn  = "urn:x-cast:com.google.cast.tp.connection"
 	namespaceRecv  = "urn:x-cast:com.google.cast.receiver"
 	namespaceMedia = "urn:x-cast:com.google.cast.media"
+	// Custom receivers can listen on this namespace for metadata updates,
+	// which the default media receiver ignores.
+	namespaceMetadata = "urn:x-cast:com.github.avinash240.pusher.metadata"
 )
 
 var (
@@ -82,6 +99,7 @@ type Application struct {
 	// 'cast.Connection' will send receieved messages back on this channel.
 	recvMsgChan chan *pb.CastMessage
 	// Internal mapping of request id to result channel
+	resultMu      sync.Mutex
 	resultChanMap map[int]chan *pb.CastMessage
 
 	messageMu sync.Mutex
@@ -100,15 +118,73 @@ type Application struct {
 	// we will keep the other one around in-case we need it at some point.
 	volumeMedia    *cast.Volume
 	volumeReceiver *cast.Volume
-
-	httpServer *http.ServeMux
-	serverPort int
-	localIP    string
-	iface      *net.Interface
+	// Held while 'media' and 'volumeReceiver' are replaced, so goroutines
+	// other than the one receiving statuses can read them.
+	statusMu sync.Mutex
+
+	// Media is served from this application's namespace on 'mediaServer',
+	// which is usually shared with other applications.
+	mediaServer  *media.Server
+	mediaSession *media.Session
+	serverPort   int
+	localIP      string
+	iface        *net.Interface
+
+	// Set when 'mediaServer' was started for this application alone, and
+	// should be shut down with it.
+	ownsMediaServer bool
+
+	// When enabled, media that needs transcoding is served as HLS instead
+	// of a single fragmented mp4 response.
+	hlsEnabled  bool
+	hlsCacheDir string
+	segmenter   *hls.Segmenter
+
+	// Slideshow images are scaled down to fit 'imageWidth' by 'imageHeight',
+	// rotated and converted by 'images', which caches in 'imageCacheDir'.
+	imageWidth    int
+	imageHeight   int
+	imageCacheDir string
+	images        *imaging.Processor
+
+	// What the device can play, which decides whether and how media is
+	// transcoded, along with what 'prober' finds in the media.
+	capabilities device.Capabilities
+	prober       *probe.Prober
+
+	// Media items served to the device, keyed by content url, so the item
+	// playing can be described.
+	servedMu sync.Mutex
+	served   map[string]mediaItem
+	// The items last loaded or queued, in order.
+	queue []mediaItem
+
+	// Transcoder processes are run through 'transcoder' as part of
+	// 'sessionID', so they can be stopped when the application is closed.
+	transcoder *transcode.Manager
+	sessionID  string
+
+	// Live streams started by this application, rather than shared with it,
+	// which are closed with it.
+	liveStreams []*live.Stream
+
+	// The radio station relayed to the device, and the url it is served at.
+	relayMu  sync.Mutex
+	relay    *radio.Relay
+	relayURL string
+
+	// The sleep timer set. Media status is followed once a timer has been
+	// set, for timers waiting for the end of the media.
+	sleepMu    sync.Mutex
+	sleep      *sleepTimer
+	sleepWatch sync.Once
+
+	// Followers of the media status, such as announcements waiting for
+	// their clip to finish.
+	mediaWatchers mediaWatchers
 
 	// NOTE: Currently only playing one media file at a time is handled
-	mediaFinished  chan bool
-	mediaFilenames []string
+	mediaFinished chan bool
 
 	playedItems   map[string]PlayedItem
 	cacheDisabled bool
@@ -127,12 +203,89 @@ func WithIface(iface *net.Interface) ApplicationOption {
 	}
 }
 
+// WithServerPort sets the port of the media server started for this
+// application. It is ignored when a media server is given with
+// WithMediaServer.
 func WithServerPort(port int) ApplicationOption {
 	return func(a *Application) {
 		a.serverPort = port
 	}
 }
 
+// WithMediaServer serves media from a namespace on s rather than from a
+// media server of its own.
+func WithMediaServer(s *media.Server) ApplicationOption {
+	return func(a *Application) {
+		a.mediaServer = s
+	}
+}
+
+// WithHLS serves media that needs transcoding as an HLS playlist, which
+// lets the chromecast buffer, seek and recover from network drops.
+func WithHLS(enabled bool) ApplicationOption {
+	return func(a *Application) {
+		a.hlsEnabled = enabled
+	}
+}
+
+// WithHLSCacheDir keeps completed HLS output in dir so it can be reused by
+// later sessions. Without it HLS output is removed when the application is
+// closed.
+func WithHLSCacheDir(dir string) ApplicationOption {
+	return func(a *Application) {
+		a.hlsCacheDir = dir
+	}
+}
+
+// WithImageResolution sets the resolution slideshow images are scaled down
+// to fit, which should match the display of the device. Defaults to 1080p.
+func WithImageResolution(width, height int) ApplicationOption {
+	return func(a *Application) {
+		a.imageWidth = width
+		a.imageHeight = height
+	}
+}
+
+// WithImageCacheDir keeps processed slideshow images in dir. Defaults to a
+// directory beneath the system temporary directory.
+func WithImageCacheDir(dir string) ApplicationOption {
+	return func(a *Application) {
+		a.imageCacheDir = dir
+	}
+}
+
+// WithCapabilities sets what the device can play. Without it the device is
+// assumed to be a video chromecast.
+func WithCapabilities(c device.Capabilities) ApplicationOption {
+	return func(a *Application) {
+		a.capabilities = c
+	}
+}
+
+// WithProber probes media with p, which is usually shared between
+// applications so probe results are cached across devices.
+func WithProber(p *probe.Prober) ApplicationOption {
+	return func(a *Application) {
+		a.prober = p
+	}
+}
+
+// WithTranscoder runs transcoder processes through m, which is usually
+// shared between applications to limit the number of concurrent transcodes.
+func WithTranscoder(m *transcode.Manager) ApplicationOption {
+	return func(a *Application) {
+		a.transcoder = m
+	}
+}
+
+// WithSessionID sets the session transcoder processes are started as part
+// of. Defaults to a generated id.
+func WithSessionID(id string) ApplicationOption {
+	return func(a *Application) {
+		a.sessionID = id
+	}
+}
+
 func WithDebug(debug bool) ApplicationOption {
 	return func(a *Application) {
 		a.debug = debug
@@ -162,12 +315,27 @@ func NewApplication(opts ...ApplicationOption) *Application {
 		playedItems:       map[string]PlayedItem{},
 		cache:             storage.NewStorage(),
 		connectionRetries: 5,
+		capabilities:      device.Unknown,
+		served:            map[string]mediaItem{},
 	}
 
 	// Apply options
 	for _, o := range opts {
 		o(a)
 	}
+	if a.transcoder == nil {
+		a.transcoder = transcode.NewManager(0)
+	}
+	if a.prober == nil {
+		a.prober = probe.NewProber()
+	}
+	if a.sessionID == "" {
+		a.sessionID = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	if a.mediaServer == nil {
+		a.mediaServer = media.NewServer(a.serverPort)
+		a.ownsMediaServer = true
+	}
 
 	// Kick off the listener for asynchronous messages received from the
 	// cast connection.
@@ -178,8 +346,18 @@ func NewApplication(opts ...ApplicationOption) *Application {
 }
 
 func (a *Application) Application() *cast.Application { return a.application }
-func (a *Application) Media() *cast.Media             { return a.media }
-func (a *Application) Volume() *cast.Volume           { return a.volumeReceiver }
+
+func (a *Application) Media() *cast.Media {
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
+	return a.media
+}
+
+func (a *Application) Volume() *cast.Volume {
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
+	return a.volumeReceiver
+}
 
 func (a *Application) AddMessageFunc(f CastMessageFunc) {
 	a.messageMu.Lock()
@@ -224,7 +402,10 @@ func (a *Application) recvMessages() {
 	for msg := range a.recvMsgChan {
 		requestID, err := jsonparser.GetInt([]byte(*msg.PayloadUtf8), "requestId")
 		if err == nil {
-			if resultChan, ok := a.resultChanMap[int(requestID)]; ok {
+			a.resultMu.Lock()
+			resultChan, ok := a.resultChanMap[int(requestID)]
+			a.resultMu.Unlock()
+			if ok {
 				resultChan <- msg
 				// Relay the event to any user specified message funcs.
 				a.messageChan <- msg
@@ -269,7 +450,9 @@ func (a *Application) recvMessages() {
 					}
 					a.application = &app
 				}
+				a.statusMu.Lock()
 				a.volumeReceiver = &resp.Status.Volume
+				a.statusMu.Unlock()
 			}
 		}
 		// Relay the event to any user specified message funcs.
@@ -340,7 +523,9 @@ func (a *Application) Update() error {
 	for _, app := range recvStatus.Status.Applications {
 		a.application = &app
 	}
+	a.statusMu.Lock()
 	a.volumeReceiver = &recvStatus.Status.Volume
+	a.statusMu.Unlock()
 
 	if a.application == nil || a.application.IsIdleScreen {
 		return nil
@@ -360,51 +545,108 @@ func (a *Application) updateMediaStatus() error {
 		return err
 	}
 	for _, media := range mediaStatus.Status {
-		a.media = &media
+		media := media
+		a.setMedia(&media)
 		a.volumeMedia = &media.Volume
 	}
 	return nil
 }
 
+// setMedia replaces the media status.
+func (a *Application) setMedia(media *cast.Media) {
+	a.statusMu.Lock()
+	a.media = media
+	a.statusMu.Unlock()
+}
+
+// currentMedia returns the media status last received, and whether there
+// is one.
+func (a *Application) currentMedia() (cast.Media, bool) {
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
+	if a.media == nil {
+		return cast.Media{}, false
+	}
+	return *a.media, true
+}
+
+// currentVolume returns the receiver volume last received, and whether
+// there is one.
+func (a *Application) currentVolume() (cast.Volume, bool) {
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
+	if a.volumeReceiver == nil {
+		return cast.Volume{}, false
+	}
+	return *a.volumeReceiver, true
+}
+
 func (a *Application) Close(stopMedia bool) error {
+	a.closeSleep()
 	if stopMedia {
 		a.sendMediaConn(&cast.CloseHeader)
 		a.sendDefaultConn(&cast.CloseHeader)
 	}
+	for _, stream := range a.liveStreams {
+		stream.Close()
+	}
+	if a.segmenter != nil {
+		if err := a.segmenter.Close(); err != nil {
+			a.log("unable to clean up hls output: %v", err)
+		}
+	}
+	if a.mediaSession != nil {
+		a.mediaSession.Close()
+	}
+	a.transcoder.StopSession(a.sessionID)
+	if a.ownsMediaServer {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		if err := a.mediaServer.Shutdown(ctx); err != nil {
+			a.log("unable to shut down media server: %v", err)
+		}
+	}
 	return a.conn.Close()
 }
 
 func (a *Application) Status() (*cast.Application, *cast.Media, *cast.Volume) {
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
 	return a.application, a.media, a.volumeReceiver
 }
 
+// Pause, Unpause and StopMedia are also called by sleep timers and sync
+// groups, alongside statuses being received.
 func (a *Application) Pause() error {
-	if a.media == nil {
+	media, ok := a.currentMedia()
+	if !ok {
 		return ErrNoMediaPause
 	}
 	return a.sendMediaRecv(&cast.MediaHeader{
 		PayloadHeader:  cast.PauseHeader,
-		MediaSessionId: a.media.MediaSessionId,
+		MediaSessionId: media.MediaSessionId,
 	})
 }
 
 func (a *Application) Unpause() error {
-	if a.media == nil {
+	media, ok := a.currentMedia()
+	if !ok {
 		return ErrNoMediaUnpause
 	}
 	return a.sendMediaRecv(&cast.MediaHeader{
 		PayloadHeader:  cast.PlayHeader,
-		MediaSessionId: a.media.MediaSessionId,
+		MediaSessionId: media.MediaSessionId,
 	})
 }
 
 func (a *Application) StopMedia() error {
-	if a.media == nil {
+	media, ok := a.currentMedia()
+	if !ok {
 		return ErrNoMediaStop
 	}
 	return a.sendMediaRecv(&cast.MediaHeader{
 		PayloadHeader:  cast.StopHeader,
-		MediaSessionId: a.media.MediaSessionId,
+		MediaSessionId: media.MediaSessionId,
 	})
 }
 
@@ -651,7 +893,7 @@ func (a *Application) castPlayableContentType(contentType string) bool {
 	switch contentType {
 	case "image/apng", "image/bmp", "image/gif", "image/jpeg", "image/png", "image/webp":
 		return true
-	case "audio/mp2t", "audio/mp3", "audio/mp4", "audio/ogg", "audio/wav", "audio/webm":
+	case "audio/mp2t", "audio/mp3", "audio/mpeg", "audio/mp4", "audio/ogg", "audio/wav", "audio/webm":
 		return true
 	case "video/mp4", "video/webm":
 		return true
@@ -660,11 +902,70 @@ func (a *Application) castPlayableContentType(contentType string) bool {
 	return false
 }
 
+// PlaybackInfo describes how media served to the device is played.
+type PlaybackInfo struct {
+	Filename string           `json:"filename"`
+	Probe    *probe.Info      `json:"probe,omitempty"`
+	Decision *device.Decision `json:"decision,omitempty"`
+}
+
+// Playback describes the media playing on the device, or returns nil if
+// nothing served by this application is playing.
+func (a *Application) Playback() *PlaybackInfo {
+	if a.media == nil {
+		return nil
+	}
+	a.servedMu.Lock()
+	m, ok := a.served[a.media.Media.ContentId]
+	a.servedMu.Unlock()
+	if !ok {
+		return nil
+	}
+	return &PlaybackInfo{
+		Filename: m.filename,
+		Probe:    m.probe,
+		Decision: m.decision,
+	}
+}
+
+// Capabilities returns what the device can play.
+func (a *Application) Capabilities() device.Capabilities {
+	return a.capabilities
+}
+
+// unsupported returns the error for media the device can't play.
+func (a *Application) unsupported(filename, contentType string) error {
+	return errors.Wrapf(ErrUnsupportedMedia, "%s can't play %s content from %q", a.deviceName(), contentType, filename)
+}
+
+func (a *Application) deviceName() string {
+	if a.capabilities.Model != "" {
+		return fmt.Sprintf("device model %q", a.capabilities.Model)
+	}
+	return "device"
+}
+
 func (a *Application) PlayedItems() map[string]PlayedItem {
 	return a.playedItems
 }
 
-func (a *Application) Load(filenameOrUrl, contentType string, transcode, detach, forceDetach bool) error {
+// Load plays filenameOrUrl, queueing it when it is a playlist. Files are
+// served to any client when anyClient is set, for debugging, and otherwise
+// only to those the media server allows.
+func (a *Application) Load(filenameOrUrl, contentType string, transcode, detach, forceDetach, anyClient bool) error {
+	// Playlists are queued, unless they are HLS playlists which are played
+	// like any other stream.
+	if playlist.IsPlaylist(filenameOrUrl) {
+		entries, err := playlist.Load(filenameOrUrl)
+		switch {
+		case err == playlist.ErrHLSPlaylist:
+		case err != nil:
+			return errors.Wrapf(err, "unable to load playlist %q", filenameOrUrl)
+		default:
+			return a.queueEntries(entries, contentType, transcode, detach || forceDetach, anyClient)
+		}
+	}
+
 	var mi mediaItem
 	isExternalMedia := false
 	if strings.HasPrefix(filenameOrUrl, "http://") || strings.HasPrefix(filenameOrUrl, "https://") {
@@ -674,12 +975,15 @@ func (a *Application) Load(filenameOrUrl, contentType string, transcode, detach,
 			// let the chromecast try and handle the media file anyway.
 			contentType, _ = a.possibleContentType(filenameOrUrl)
 		}
+		if contentType != "" && !a.capabilities.CanDisplay(contentType) {
+			return a.unsupported(filenameOrUrl, contentType)
+		}
 		mi = mediaItem{
 			contentURL:  filenameOrUrl,
 			contentType: contentType,
 		}
 	} else {
-		mediaItems, err := a.loadAndServeFiles([]string{filenameOrUrl}, contentType, transcode)
+		mediaItems, err := a.loadAndServeFiles([]string{filenameOrUrl}, contentType, transcode, anyClient)
 		if err != nil {
 			return errors.Wrap(err, "unable to load and serve files")
 		}
@@ -694,6 +998,10 @@ func (a *Application) Load(filenameOrUrl, contentType string, transcode, detach,
 		return fmt.Errorf("unable to detach from locally playing media content")
 	}
 
+	a.servedMu.Lock()
+	a.queue = []mediaItem{mi}
+	a.servedMu.Unlock()
+
 	if err := a.ensureIsDefaultMediaReceiver(); err != nil {
 		return err
 	}
@@ -750,30 +1058,58 @@ func (a *Application) LoadApp(appID, contentID string) error {
 	return nil
 }
 
-func (a *Application) QueueLoad(filenames []string, contentType string, transcode bool) error {
+// QueueLoad queues filenames on the device, expanding any playlists among
+// them into their entries. Unless detach is set it blocks until the queue
+// has finished playing.
+func (a *Application) QueueLoad(filenames []string, contentType string, transcode, detach bool) error {
+	var entries []playlist.Entry
+	for _, filename := range filenames {
+		if playlist.IsPlaylist(filename) {
+			pl, err := playlist.Load(filename)
+			if err == nil {
+				entries = append(entries, pl...)
+				continue
+			}
+			if err != playlist.ErrHLSPlaylist {
+				return errors.Wrapf(err, "unable to load playlist %q", filename)
+			}
+		}
+		entries = append(entries, playlist.Entry{Location: filename})
+	}
+	return a.queueEntries(entries, contentType, transcode, detach, false)
+}
 
-	mediaItems, err := a.loadAndServeFiles(filenames, contentType, transcode)
+func (a *Application) queueEntries(entries []playlist.Entry, contentType string, transcode, detach, anyClient bool) error {
+	if len(entries) == 0 {
+		return errors.New("nothing to queue")
+	}
+
+	mediaItems, err := a.loadEntries(entries, contentType, transcode, anyClient)
 	if err != nil {
-		return errors.Wrap(err, "unable to load and serve files")
+		return err
 	}
 
 	if err := a.ensureIsDefaultMediaReceiver(); err != nil {
 		return err
 	}
 
+	a.servedMu.Lock()
+	a.queue = mediaItems
+	a.servedMu.Unlock()
+
 	items := make([]cast.QueueLoadItem, len(mediaItems))
 	for i, mi := range mediaItems {
 		items[i] = cast.QueueLoadItem{
-			Autoplay:         true,
-			PlaybackDuration: 60,
-			Media: cast.MediaItem{
-				ContentId:   mi.contentURL,
-				StreamType:  "BUFFERED",
-				ContentType: mi.contentType,
-			},
+			Autoplay: true,
+			Media:    mi.castMedia(),
 		}
 	}
 
+	// NOTE: This isn't concurrent safe, but it doesn't need to be at the moment!
+	if !detach {
+		a.MediaStart()
+	}
+
 	// Send the command to the chromecast
 	a.sendMediaRecv(&cast.QueueLoad{
 		PayloadHeader: cast.QueueLoadHeader,
@@ -783,13 +1119,57 @@ func (a *Application) QueueLoad(filenames []string, contentType string, transcod
 		Items:         items,
 	})
 
+	if detach {
+		return nil
+	}
+
 	// Wait until we have been notified that the media has finished playing
-	// TODO: This does nothing. This hasn't been initialised and just blocks
-	// forever.
 	a.MediaWait()
 	return nil
 }
 
+// loadEntries serves the local entries and returns the media items for
+// every entry, in order. Remote entries are played from where they are.
+func (a *Application) loadEntries(entries []playlist.Entry, contentType string, transcode, anyClient bool) ([]mediaItem, error) {
+	mediaItems := make([]mediaItem, len(entries))
+	var (
+		local   []string
+		indexes []int
+	)
+	for i, e := range entries {
+		if e.Remote() {
+			ct := contentType
+			if ct == "" {
+				ct, _ = a.possibleContentType(e.Location)
+			}
+			mediaItems[i] = mediaItem{
+				contentURL:  e.Location,
+				contentType: ct,
+			}
+		} else {
+			local = append(local, e.Location)
+			indexes = append(indexes, i)
+		}
+	}
+
+	if len(local) > 0 {
+		served, err := a.loadAndServeFiles(local, contentType, transcode, anyClient)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load and serve files")
+		}
+		for i, mi := range served {
+			mediaItems[indexes[i]] = mi
+		}
+	}
+
+	for i, e := range entries {
+		mediaItems[i].title = e.Title
+		mediaItems[i].artist = e.Artist
+		mediaItems[i].duration = e.Duration
+	}
+	return mediaItems, nil
+}
+
 func (a *Application) ensureIsDefaultMediaReceiver() error {
 	// If the current chromecast application isn't the Default Media Receiver
 	// we need to change it.
@@ -812,14 +1192,33 @@ func (a *Application) ensureIsAppID(appID string) error {
 	return nil
 }
 
+// Slideshow shows the images in filenames for duration seconds each,
+// blocking until the last has been shown, or forever when repeating.
 func (a *Application) Slideshow(filenames []string, duration int, repeat bool) error {
-	mediaItems, err := a.loadAndServeFiles(filenames, "", false)
+	done, err := a.StartSlideshow(filenames, duration, repeat)
 	if err != nil {
-		return errors.Wrap(err, "unable to load and serve files")
+		return err
+	}
+	return <-done
+}
+
+// StartSlideshow is Slideshow, returning once the images are showing. The
+// slideshow is moved on in the background, and how it ended is sent on done.
+func (a *Application) StartSlideshow(filenames []string, duration int, repeat bool) (<-chan error, error) {
+	if !a.capabilities.VideoOut {
+		return nil, errors.Wrapf(ErrUnsupportedMedia, "%s has no display, unable to show a slideshow", a.deviceName())
+	}
+	filenames, err := a.processImages(filenames)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to process images")
+	}
+	mediaItems, err := a.loadAndServeFiles(filenames, "", false, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load and serve files")
 	}
 
 	if err := a.ensureIsDefaultMediaReceiver(); err != nil {
-		return err
+		return nil, err
 	}
 
 	items := make([]cast.QueueLoadItem, len(mediaItems))
@@ -843,17 +1242,29 @@ func (a *Application) Slideshow(filenames []string, duration int, repeat bool) e
 	}
 
 	// Send the command to the chromecast
-	a.sendMediaRecv(&cast.QueueLoad{
+	if err := a.sendMediaRecv(&cast.QueueLoad{
 		PayloadHeader: cast.QueueLoadHeader,
 		CurrentTime:   0,
 		StartIndex:    0,
 		RepeatMode:    repeatMode,
 		Items:         items,
-	})
+	}); err != nil {
+		return nil, errors.Wrap(err, "unable to queue images")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- a.advanceSlideshow(len(filenames), duration, repeat)
+	}()
+	return done, nil
+}
 
+// advanceSlideshow moves a slideshow of n images on every duration seconds.
+func (a *Application) advanceSlideshow(n, duration int, repeat bool) error {
 	// Timer for when to call the next image
 	t := time.NewTicker(time.Second * time.Duration(duration))
-	i := len(filenames)
+	defer t.Stop()
+	i := n
 	for {
 		//  If we are not repeating, we need to stop after we have show the last image.
 		if !repeat {
@@ -890,50 +1301,203 @@ type mediaItem struct {
 	contentType string
 	contentURL  string
 	transcode   bool
+	// Set when the item is served to any client, for debugging.
+	anyClient bool
+	// Set when the item is served as an HLS playlist.
+	hlsID string
+
+	// Metadata from the playlist the item was loaded from, if any.
+	title    string
+	artist   string
+	duration time.Duration
+
+	// Set when the item was probed, with how it is played on the device.
+	probe    *probe.Info
+	decision *device.Decision
+}
+
+// knownDuration returns how long the item plays for, or zero if that isn't
+// known.
+func (mi mediaItem) knownDuration() time.Duration {
+	if mi.duration > 0 {
+		return mi.duration
+	}
+	if mi.probe != nil {
+		return time.Duration(mi.probe.Duration * float64(time.Second))
+	}
+	return 0
+}
+
+// castMedia returns the cast media description of the item.
+func (mi mediaItem) castMedia() cast.MediaItem {
+	m := cast.MediaItem{
+		ContentId:   mi.contentURL,
+		StreamType:  "BUFFERED",
+		ContentType: mi.contentType,
+		Duration:    float32(mi.duration.Seconds()),
+		Metadata: cast.MediaMetadata{
+			Title:  mi.title,
+			Artist: mi.artist,
+		},
+	}
+	if mi.artist != "" {
+		m.Metadata.MetadataType = cast.MetadataTypeMusicTrack
+	}
+	return m
 }
 
-func (a *Application) loadAndServeFiles(filenames []string, contentType string, transcode bool) ([]mediaItem, error) {
+// processImages returns the files to serve in place of filenames, with
+// images scaled, rotated and converted for the device. Files that aren't
+// images are returned as they are.
+func (a *Application) processImages(filenames []string) ([]string, error) {
+	if a.images == nil {
+		images, err := imaging.NewProcessor(a.imageCacheDir)
+		if err != nil {
+			return nil, err
+		}
+		a.images = images
+	}
+
+	// Fit the display of the device unless told otherwise.
+	width, height := a.imageWidth, a.imageHeight
+	if width <= 0 && height <= 0 {
+		width, height = a.capabilities.MaxResolution.Width, a.capabilities.MaxResolution.Height
+	}
+
+	processed := make([]string, len(filenames))
+	for i, filename := range filenames {
+		res, err := a.images.Process(filename, width, height)
+		switch {
+		case err == image.ErrFormat:
+			processed[i] = filename
+		case err != nil:
+			return nil, errors.Wrapf(err, "unable to process %q", filename)
+		default:
+			if res.Filename != filename {
+				a.log("processed %s into %s", filename, res.Filename)
+			}
+			processed[i] = res.Filename
+		}
+	}
+	return processed, nil
+}
+
+// errNotProbed is returned by probedMediaItem when the media wasn't probed.
+var errNotProbed = errors.New("media not probed")
+
+// probedMediaItem decides how to play filename by probing its container and
+// codecs. errNotProbed is returned when the file couldn't be probed, or
+// probing wouldn't change anything because the content type is given or
+// transcoding isn't allowed.
+func (a *Application) probedMediaItem(filename, contentType string, transcode bool) (mediaItem, error) {
+	if contentType != "" || !transcode {
+		return mediaItem{}, errNotProbed
+	}
+	info, err := a.prober.Probe(context.Background(), filename)
+	if err != nil {
+		if err != probe.ErrNoMedia && err != probe.ErrUnavailable {
+			a.log("unable to probe %s: %v", filename, err)
+		}
+		return mediaItem{}, errNotProbed
+	}
+	if info.Audio() == nil && !a.capabilities.VideoOut {
+		return mediaItem{}, a.unsupported(filename, "video")
+	}
+
+	d := a.capabilities.Decide(info)
+	a.log("playing %s as %s: %s", filename, d.Mode, strings.Join(d.Reasons, ", "))
+	return mediaItem{
+		filename:    filename,
+		contentType: d.ContentType,
+		transcode:   d.Mode != device.DirectPlay,
+		probe:       info,
+		decision:    &d,
+	}, nil
+}
+
+// guessedMediaItem decides how to play filename from its file type.
+func (a *Application) guessedMediaItem(filename, contentType string, transcode bool) (mediaItem, error) {
+	transcodeFile := transcode
+	/*
+		We can play media for the following:
+
+		- if we have a filename with a known content type
+		- if we have a filename, and a specified contentType
+		- if we have a filename with an unknown content type, and transcode is true
+		-
+	*/
+	knownFileType := a.knownFileType(filename)
+	if !knownFileType && contentType == "" && !transcodeFile {
+		return mediaItem{}, fmt.Errorf("unknown content-type for %q, either specify a content-type or set transcode to true", filename)
+	}
+
+	// If we have a content-type specified we should always
+	// attempt to use that
+	contentTypeToUse := contentType
+	if contentType != "" {
+	} else if knownFileType {
+		// If this is a media file we know the chromecast can play,
+		// then we don't need to transcode it.
+		contentTypeToUse, _ = a.possibleContentType(filename)
+		if a.castPlayableContentType(contentTypeToUse) {
+			transcodeFile = false
+		}
+	} else if transcodeFile {
+		contentTypeToUse = a.capabilities.TranscodeProfile().ContentType
+	}
+
+	// Video can still be played on speakers by transcoding away the
+	// video, but nothing else the device can't output can be played.
+	if !a.capabilities.CanDisplay(contentTypeToUse) {
+		if !transcode || !a.capabilities.AudioOut || !strings.HasPrefix(contentTypeToUse, "video/") {
+			return mediaItem{}, a.unsupported(filename, contentTypeToUse)
+		}
+		transcodeFile = true
+		contentTypeToUse = a.capabilities.TranscodeProfile().ContentType
+	}
+
+	return mediaItem{
+		filename:    filename,
+		contentType: contentTypeToUse,
+		transcode:   transcodeFile,
+	}, nil
+}
+
+func (a *Application) addHLSStream(filename string) (string, error) {
+	if a.segmenter == nil {
+		segmenter, err := hls.NewSegmenter(a.hlsCacheDir, a.transcoder, a.sessionID, a.debug)
+		if err != nil {
+			return "", err
+		}
+		segmenter.CodecArgs = a.capabilities.TranscodeProfile().CodecArgs()
+		a.segmenter = segmenter
+	}
+	return a.segmenter.Add(filename)
+}
+
+func (a *Application) loadAndServeFiles(filenames []string, contentType string, transcode, anyClient bool) ([]mediaItem, error) {
 	mediaItems := make([]mediaItem, len(filenames))
 	for i, filename := range filenames {
-		transcodeFile := transcode
 		if _, err := os.Stat(filename); err != nil {
 			return nil, errors.Wrapf(err, "unable to find %q", filename)
 		}
-		/*
-			We can play media for the following:
-
-			- if we have a filename with a known content type
-			- if we have a filename, and a specified contentType
-			- if we have a filename with an unknown content type, and transcode is true
-			-
-		*/
-		knownFileType := a.knownFileType(filename)
-		if !knownFileType && contentType == "" && !transcodeFile {
-			return nil, fmt.Errorf("unknown content-type for %q, either specify a content-type or set transcode to true", filename)
+		mi, err := a.probedMediaItem(filename, contentType, transcode)
+		if err == errNotProbed {
+			mi, err = a.guessedMediaItem(filename, contentType, transcode)
 		}
-
-		// If we have a content-type specified we should always
-		// attempt to use that
-		contentTypeToUse := contentType
-		if contentType != "" {
-		} else if knownFileType {
-			// If this is a media file we know the chromecast can play,
-			// then we don't need to transcode it.
-			contentTypeToUse, _ = a.possibleContentType(filename)
-			if a.castPlayableContentType(contentTypeToUse) {
-				transcodeFile = false
-			}
-		} else if transcodeFile {
-			contentTypeToUse = "video/mp4"
+		if err != nil {
+			return nil, err
 		}
-
-		mediaItems[i] = mediaItem{
-			filename:    filename,
-			contentType: contentTypeToUse,
-			transcode:   transcodeFile,
+		mi.anyClient = anyClient
+		mediaItems[i] = mi
+		if mi.transcode && a.hlsEnabled && a.capabilities.VideoOut {
+			id, err := a.addHLSStream(filename)
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to prepare hls stream")
+			}
+			mediaItems[i].hlsID = id
+			mediaItems[i].contentType = hls.ContentType
 		}
-		// Add the filename to the list of filenames that go-chromecast will serve.
-		a.mediaFilenames = append(a.mediaFilenames, filename)
 	}
 
 	localIP, err := a.getLocalIP()
@@ -949,10 +1513,23 @@ func (a *Application) loadAndServeFiles(filenames []string, contentType string,
 	}
 	a.log("started streaming server")
 
+	// Only the latest items loaded can be playing.
+	a.servedMu.Lock()
+	a.served = map[string]mediaItem{}
+	a.servedMu.Unlock()
+
 	// We can only set the content url after the server has started, otherwise we have
 	// no way to know the port used.
 	for i, m := range mediaItems {
-		mediaItems[i].contentURL = fmt.Sprintf("http://%s:%d?media_file=%s&live_streaming=%t", localIP, a.serverPort, m.filename, m.transcode)
+		contentURL, err := a.registerMediaItem(localIP, m)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to register media")
+		}
+		mediaItems[i].contentURL = contentURL
+
+		a.servedMu.Lock()
+		a.served[contentURL] = mediaItems[i]
+		a.servedMu.Unlock()
 	}
 
 	return mediaItems, nil
@@ -989,52 +1566,49 @@ func (a *Application) getLocalIP() (string, error) {
 }
 
 func (a *Application) startStreamingServer() error {
-	if a.httpServer != nil {
+	if a.mediaSession != nil {
 		return nil
 	}
-	a.log("trying to find available port to start streaming server on")
+	return a.startMediaSession()
+}
 
-	listener, err := net.Listen("tcp", ":"+strconv.Itoa(a.serverPort))
+// registerMediaItem registers m with the media session and returns the url
+// the chromecast can load it from. The url only contains an opaque token for
+// the item, never the filename.
+func (a *Application) registerMediaItem(localIP string, m mediaItem) (string, error) {
+	item := media.Item{
+		Filename:       m.filename,
+		ContentType:    m.contentType,
+		Handler:        a.mediaHandler(m),
+		AllowAnyClient: m.anyClient,
+	}
+	suffix := ""
+	if m.hlsID != "" {
+		item.Handler = a.segmenter.Handler(m.hlsID)
+		item.SubPaths = true
+		suffix = "/" + hls.PlaylistName
+	}
+	token, err := a.mediaSession.Register(item)
 	if err != nil {
-		return errors.Wrap(err, "unable to bind to local tcp address")
+		return "", err
 	}
+	return fmt.Sprintf("http://%s:%d%s", localIP, a.serverPort, a.mediaSession.ItemPath(token, suffix)), nil
+}
 
-	a.serverPort = listener.Addr().(*net.TCPAddr).Port
-	a.log("found available port :%d", a.serverPort)
-
-	a.httpServer = http.NewServeMux()
-
-	a.httpServer.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// Check to see if we have a 'filename' and if it is one of the ones that have
-		// already been validated and is useable.
-		filename := r.URL.Query().Get("media_file")
-		canServe := false
-		for _, fn := range a.mediaFilenames {
-			if fn == filename {
-				canServe = true
-			}
-		}
-
+// mediaHandler serves filename and records it as played. When liveStreaming
+// is set the file is transcoded as it is served, which needs an infinite
+// range request / response.
+func (a *Application) mediaHandler(m mediaItem) http.Handler {
+	filename, liveStreaming := m.filename, m.transcode
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		a.playedItems[filename] = PlayedItem{ContentID: filename, Started: time.Now().Unix()}
 		a.writePlayedItems()
 
-		// Check to see if this is a live streaming video and we need to use an
-		// infinite range request / response. This comes from media that is either
-		// live or currently being transcoded to a different media format.
-		liveStreaming := false
-		if ls := r.URL.Query().Get("live_streaming"); ls == "true" {
-			liveStreaming = true
-		}
-
-		a.log("canServe=%t, liveStreaming=%t, filename=%s", canServe, liveStreaming, filename)
-		if canServe {
-			if !liveStreaming {
-				http.ServeFile(w, r, filename)
-			} else {
-				a.serveLiveStreaming(w, r, filename)
-			}
+		a.log("liveStreaming=%t, filename=%s", liveStreaming, filename)
+		if !liveStreaming {
+			http.ServeFile(w, r, filename)
 		} else {
-			http.Error(w, "Invalid file", 400)
+			a.serveLiveStreaming(w, r, m)
 		}
 		a.log("method=%s, headers=%v, reponse_headers=%v", r.Method, r.Header, w.Header())
 		pi := a.playedItems[filename]
@@ -1044,44 +1618,71 @@ func (a *Application) startStreamingServer() error {
 		a.playedItems[filename] = pi
 		a.writePlayedItems()
 	})
+}
 
-	go func() {
-		a.log("media server listening on %d", a.serverPort)
-		if err := http.Serve(listener, a.httpServer); err != nil && err != http.ErrServerClosed {
-			log.WithField("package", "application").WithError(err).Fatal("error serving HTTP")
-		}
-	}()
-
+// startMediaSession makes sure the media server is running and registers
+// the namespace this application serves media from.
+func (a *Application) startMediaSession() error {
+	if err := a.mediaServer.Start(); err != nil {
+		return err
+	}
+	a.serverPort = a.mediaServer.Port()
+	a.mediaSession = a.mediaServer.NewSession(a.sessionID)
+	a.log("serving media on port :%d at %s", a.serverPort, a.mediaSession.Path("/"))
 	return nil
 }
 
-func (a *Application) serveLiveStreaming(w http.ResponseWriter, r *http.Request, filename string) {
-	cmd := exec.Command(
+// serveLiveStreaming remuxes or transcodes m for the device as it is
+// served, copying the streams the device can play as they are.
+func (a *Application) serveLiveStreaming(w http.ResponseWriter, r *http.Request, m mediaItem) {
+	profile := a.capabilities.TranscodeProfile()
+	codecArgs := profile.CodecArgs()
+	if m.decision != nil {
+		profile = m.decision.Profile
+		codecArgs = m.decision.CodecArgs()
+	}
+	args := []string{
 		"ffmpeg",
 		"-re", // encode at 1x playback speed, to not burn the CPU
-		"-i", filename,
-		"-vcodec", "h264",
-		"-acodec", "aac",
-		"-ac", "2", // chromecasts don't support more than two audio channels
-		"-f", "mp4",
-		"-movflags", "frag_keyframe+faststart",
+		"-i", m.filename,
+	}
+	args = append(args, codecArgs...)
+	args = append(args, "-f", profile.Format)
+	args = append(args, profile.FormatArgs...)
+	args = append(args,
 		"-strict", "-experimental",
 		"pipe:1",
 	)
+	a.runTranscoder(w, r, m.filename, args)
+}
 
-	cmd.Stdout = w
-	if a.debug {
-		cmd.Stderr = os.Stderr
-	}
-
+// runTranscoder streams the output of the transcoder command args to w. The
+// transcoder is killed when the request is cancelled or the application is
+// closed.
+func (a *Application) runTranscoder(w http.ResponseWriter, r *http.Request, filename string, args []string) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Transfer-Encoding", "chunked")
 
-	if err := cmd.Run(); err != nil {
+	job, err := a.transcoder.Start(r.Context(), transcode.Spec{
+		Session:  a.sessionID,
+		Filename: filename,
+		Args:     args,
+		Stdout:   w,
+		Verbose:  a.debug,
+	})
+	if err == transcode.ErrLimitReached {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
 		log.WithField("package", "application").WithFields(log.Fields{
 			"filename": filename,
 		}).WithError(err).Error("error transcoding")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	// Failures are logged by the transcoder manager.
+	job.Wait()
 }
 
 func (a *Application) log(message string, args ...interface{}) {
@@ -1090,17 +1691,36 @@ func (a *Application) log(message string, args ...interface{}) {
 	}
 }
 
-func (a *Application) send(payload cast.Payload, sourceID, destinationID, namespace string) (int, error) {
-	// NOTE: Not concurrent safe, but currently only synchronous flow is possible
-	// TODO(vishen): just make concurrent safe regardless of current flow
+// nextRequestID returns a request id no other request has been sent with.
+func nextRequestID() int {
+	requestMu.Lock()
+	defer requestMu.Unlock()
 	requestID += 1
+	return requestID
+}
+
+func (a *Application) send(payload cast.Payload, sourceID, destinationID, namespace string) (int, error) {
+	requestID := nextRequestID()
 	payload.SetRequestId(requestID)
 	return requestID, a.conn.Send(requestID, payload, sourceID, destinationID, namespace)
 }
 
 func (a *Application) sendAndWait(payload cast.Payload, sourceID, destinationID, namespace string) (*pb.CastMessage, error) {
-	requestID, err := a.send(payload, sourceID, destinationID, namespace)
-	if err != nil {
+	// The response is waited for from before the request is sent, so quick
+	// responses aren't missed.
+	requestID := nextRequestID()
+	resultChan := make(chan *pb.CastMessage, 1)
+	a.resultMu.Lock()
+	a.resultChanMap[requestID] = resultChan
+	a.resultMu.Unlock()
+	defer func() {
+		a.resultMu.Lock()
+		delete(a.resultChanMap, requestID)
+		a.resultMu.Unlock()
+	}()
+
+	payload.SetRequestId(requestID)
+	if err := a.conn.Send(requestID, payload, sourceID, destinationID, namespace); err != nil {
 		return nil, err
 	}
 
@@ -1108,14 +1728,6 @@ func (a *Application) sendAndWait(payload cast.Payload, sourceID, destinationID,
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
-	// TODO(vishen): not concurrent safe. Not a problem at the moment
-	// because only synchronous flow currently allowed.
-	resultChan := make(chan *pb.CastMessage, 1)
-	a.resultChanMap[requestID] = resultChan
-	defer func() {
-		delete(a.resultChanMap, requestID)
-	}()
-
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
@@ -1174,123 +1786,234 @@ func (a *Application) sendAndWaitMediaRecv(payload cast.Payload) (*pb.CastMessag
 	return a.sendAndWait(payload, defaultSender, a.application.TransportId, namespaceMedia)
 }
 
-func (a *Application) startTranscodingServer(command string) error {
-	if a.httpServer != nil {
-		return nil
+// transcodeHandler serves the output of command, recording it as played
+// under filename.
+
+// Transcode plays the output of command, which is split into arguments the
+// way a shell would, and may start with NAME=value environment settings.
+// The command is restarted if it exits while the device is listening.
+func (a *Application) Transcode(command string, contentType string) error {
+
+	if command == "" || contentType == "" {
+		return errors.New("command and content-type flags needs to be set when transcoding")
 	}
-	a.log("trying to find available port to start transcoding server on")
 
-	listener, err := net.Listen("tcp", ":0")
+	args, env, err := live.ParseCommand(command)
 	if err != nil {
-		return errors.Wrap(err, "unable to bind to local tcp address")
+		return errors.Wrap(err, "unable to parse transcode command")
 	}
+	stream := live.NewStream(args[0], contentType, live.Command(a.transcoder, a.sessionID, args, env))
+	a.liveStreams = append(a.liveStreams, stream)
 
-	a.serverPort = listener.Addr().(*net.TCPAddr).Port
-	a.log("found available port :%d", a.serverPort)
-
-	a.httpServer = http.NewServeMux()
+	return a.LoadLive(stream, false)
+}
 
-	a.httpServer.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// Check to see if we have a 'filename' and if it is one of the ones that have
-		// already been validated and is useable.
-		filename := r.URL.Query().Get("media_file")
-		canServe := false
-		for _, fn := range a.mediaFilenames {
-			if fn == filename {
-				canServe = true
-			}
-		}
+// LoadLive plays stream on the device. Streams are served at a url of their
+// own, so any number can be registered at once, and are usually shared
+// between applications. Unless detach is set it blocks until the device
+// stops playing.
+func (a *Application) LoadLive(stream *live.Stream, detach bool) error {
+	if !a.capabilities.CanDisplay(stream.ContentType()) {
+		return a.unsupported(stream.Name(), stream.ContentType())
+	}
 
-		a.playedItems[filename] = PlayedItem{ContentID: filename, Started: time.Now().Unix()}
-		a.writePlayedItems()
+	localIP, err := a.getLocalIP()
+	if err != nil {
+		return err
+	}
+	a.log("local IP address: %s", localIP)
 
-		a.log("canServe=%t, liveStreaming=%t, filename=%s", canServe, true, filename)
-		if canServe {
-			args := strings.Split(command, " ")
-			cmd := exec.Command(args[0], args[1:]...)
+	// Start server to serve the media
+	if err := a.startStreamingServer(); err != nil {
+		return errors.Wrap(err, "unable to start streaming server")
+	}
 
-			cmd.Stdout = w
-			if a.debug {
-				cmd.Stderr = os.Stderr
-			}
+	token, err := a.mediaSession.Register(media.Item{
+		Filename:    stream.Name(),
+		ContentType: stream.ContentType(),
+		Handler:     stream,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to register live stream")
+	}
+	contentURL := fmt.Sprintf("http://%s:%d%s", localIP, a.serverPort, a.mediaSession.ItemPath(token, ""))
+	a.log("serving live stream %s (%s) at %s", stream.Name(), stream.Source(), contentURL)
 
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Transfer-Encoding", "chunked")
+	if err := a.ensureIsDefaultMediaReceiver(); err != nil {
+		return err
+	}
 
-			if err := cmd.Run(); err != nil {
-				log.WithField("package", "application").WithFields(log.Fields{
-					"filename": filename,
-				}).WithError(err).Error("error transcoding")
-			}
-		} else {
-			http.Error(w, "Invalid file", 400)
-		}
-		a.log("method=%s, headers=%v, reponse_headers=%v", r.Method, r.Header, w.Header())
-		pi := a.playedItems[filename]
+	// NOTE: This isn't concurrent safe, but it doesn't need to be at the moment!
+	if !detach {
+		a.MediaStart()
+	}
 
-		// TODO(vishen): make this a pointer?
-		pi.Finished = time.Now().Unix()
-		a.playedItems[filename] = pi
-		a.writePlayedItems()
+	// Send the command to the chromecast
+	a.sendMediaRecv(&cast.LoadMediaCommand{
+		PayloadHeader: cast.LoadHeader,
+		CurrentTime:   0,
+		Autoplay:      true,
+		Media: cast.MediaItem{
+			ContentId:   contentURL,
+			StreamType:  "LIVE",
+			ContentType: stream.ContentType(),
+		},
 	})
 
-	go func() {
-		a.log("media server listening on %d", a.serverPort)
-		if err := http.Serve(listener, a.httpServer); err != nil && err != http.ErrServerClosed {
-			log.WithField("package", "application").WithError(err).Fatal("error serving HTTP")
-		}
-	}()
+	if detach {
+		return nil
+	}
 
+	// Wait until we have been notified that the media has finished playing
+	a.MediaWait()
 	return nil
 }
 
-func (a *Application) Transcode(command string, contentType string) error {
-
-	if command == "" || contentType == "" {
-		return errors.New("command and content-type flags needs to be set when transcoding")
+// LoadRelay plays the radio station at url through the media server, for
+// stations the device can't reach or play itself. ICY metadata is stripped
+// from the audio, and the track playing is sent on the metadata namespace
+// whenever it changes. When reloadMetadata is set the stream is also
+// reloaded with the new metadata, so the default media receiver shows it at
+// the cost of a short gap in the audio. Unless detach is set it blocks until
+// the device stops playing. The relay is served to any client when
+// anyClient is set, as for Load.
+func (a *Application) LoadRelay(url, contentType string, reloadMetadata, detach, anyClient bool) error {
+	loadType := contentType
+	if loadType == "" {
+		loadType, _ = a.possibleContentType(url)
+	}
+	if loadType == "" {
+		loadType = radio.DefaultContentType
+	}
+	if !a.capabilities.CanDisplay(loadType) {
+		return a.unsupported(url, loadType)
 	}
-
-	filename := "pipe_output"
-	// Add the filename to the list of filenames that go-chromecast will serve.
-	a.mediaFilenames = append(a.mediaFilenames, filename)
 
 	localIP, err := a.getLocalIP()
 	if err != nil {
 		return err
 	}
-	a.log("local IP address: %s", localIP)
+	if err := a.startStreamingServer(); err != nil {
+		return errors.Wrap(err, "unable to start streaming server")
+	}
 
-	a.log("starting transcoding server...")
-	// Start server to serve the media
-	if err := a.startTranscodingServer(command); err != nil {
-		return errors.Wrap(err, "unable to start transcoding server")
+	opts := []radio.RelayOption{}
+	if contentType != "" {
+		opts = append(opts, radio.WithContentType(contentType))
 	}
-	a.log("started transcoding server")
+	var relay *radio.Relay
+	opts = append(opts, radio.WithMetadataFunc(func(m radio.Metadata) {
+		a.relayMetadataChanged(relay, m, loadType, reloadMetadata)
+	}))
+	relay = radio.NewRelay(url, opts...)
 
-	// We can only set the content url after the server has started, otherwise we have
-	// no way to know the port used.
-	contentURL := fmt.Sprintf("http://%s:%d?media_file=%s", localIP, a.serverPort, filename)
+	token, err := a.mediaSession.Register(media.Item{
+		Filename:       url,
+		ContentType:    loadType,
+		Handler:        relay,
+		AllowAnyClient: anyClient,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to register relay")
+	}
+	contentURL := fmt.Sprintf("http://%s:%d%s", localIP, a.serverPort, a.mediaSession.ItemPath(token, ""))
+	a.log("relaying %s at %s", url, contentURL)
+
+	a.relayMu.Lock()
+	a.relay, a.relayURL = relay, contentURL
+	a.relayMu.Unlock()
 
 	if err := a.ensureIsDefaultMediaReceiver(); err != nil {
 		return err
 	}
 
 	// NOTE: This isn't concurrent safe, but it doesn't need to be at the moment!
-	a.MediaStart()
+	if !detach {
+		a.MediaStart()
+	}
 
-	// Send the command to the chromecast
 	a.sendMediaRecv(&cast.LoadMediaCommand{
 		PayloadHeader: cast.LoadHeader,
 		CurrentTime:   0,
 		Autoplay:      true,
-		Media: cast.MediaItem{
-			ContentId:   contentURL,
-			StreamType:  "BUFFERED",
-			ContentType: contentType,
-		},
+		Media:         relayMedia(contentURL, loadType, relay),
 	})
 
+	if detach {
+		return nil
+	}
+
 	// Wait until we have been notified that the media has finished playing
 	a.MediaWait()
 	return nil
 }
+
+// relayMetadataChanged pushes the track playing on relay to the device, if
+// relay is still the one playing.
+func (a *Application) relayMetadataChanged(relay *radio.Relay, m radio.Metadata, contentType string, reload bool) {
+	a.relayMu.Lock()
+	current, contentURL := a.relay == relay, a.relayURL
+	a.relayMu.Unlock()
+	if !current || a.application == nil {
+		return
+	}
+
+	item := relayMedia(contentURL, contentType, relay)
+	if _, err := a.send(&cast.MetadataMessage{
+		PayloadHeader: cast.MetadataHeader,
+		Metadata:      item.Metadata,
+	}, defaultSender, a.application.TransportId, namespaceMetadata); err != nil {
+		a.log("unable to send metadata: %v", err)
+	}
+	if reload {
+		a.sendMediaRecv(&cast.LoadMediaCommand{
+			PayloadHeader: cast.LoadHeader,
+			CurrentTime:   0,
+			Autoplay:      true,
+			Media:         item,
+		})
+	}
+}
+
+// relayMedia returns the cast media description of relay served at
+// contentURL, with the track playing as its metadata.
+func relayMedia(contentURL, contentType string, relay *radio.Relay) cast.MediaItem {
+	artist, title := relay.Metadata().ArtistTitle()
+	if title == "" {
+		title = relay.Station()
+	}
+	return cast.MediaItem{
+		ContentId:   contentURL,
+		StreamType:  "LIVE",
+		ContentType: contentType,
+		Metadata: cast.MediaMetadata{
+			MetadataType: cast.MetadataTypeMusicTrack,
+			Title:        title,
+			Artist:       artist,
+			Subtitle:     relay.Station(),
+		},
+	}
+}
+
+// RelayInfo describes a radio station relayed to the device.
+type RelayInfo struct {
+	URL     string `json:"url"`
+	Station string `json:"station,omitempty"`
+	radio.Metadata
+}
+
+// Relay describes the radio station playing on the device, or returns nil
+// if no relayed station is playing.
+func (a *Application) Relay() *RelayInfo {
+	a.relayMu.Lock()
+	relay, contentURL := a.relay, a.relayURL
+	a.relayMu.Unlock()
+	if relay == nil || a.media == nil || a.media.Media.ContentId != contentURL {
+		return nil
+	}
+	return &RelayInfo{
+		URL:      relay.URL(),
+		Station:  relay.Station(),
+		Metadata: relay.Metadata(),
+	}
+}
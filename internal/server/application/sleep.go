@@ -0,0 +1,429 @@
+package application
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/pkg/errors"
+
+	cast "github.com/avinash240/pusher/internal/server/cast"
+	pb "github.com/avinash240/pusher/internal/server/cast/proto"
+)
+
+// DefaultSleepFade is how long sleep timers fade the volume out for unless
+// told otherwise.
+const DefaultSleepFade = time.Second * 30
+
+// sleepFadeStep is how often the volume is lowered while fading out.
+const sleepFadeStep = time.Second
+
+var (
+	ErrNoSleepTimer   = errors.New("no sleep timer is set")
+	ErrNoMediaSleep   = errors.New("media not yet initialised, there is nothing to wait for the end of")
+	ErrNoMediaEnd     = errors.New("media playing has no known end")
+	ErrSleepExtension = errors.New("sleep timer can only be extended by a positive duration")
+)
+
+// SleepAction is what a sleep timer does to the media when it goes off.
+type SleepAction string
+
+const (
+	SleepStop  SleepAction = "stop"
+	SleepPause SleepAction = "pause"
+)
+
+// SleepUntil is when a sleep timer goes off.
+type SleepUntil string
+
+const (
+	// SleepAfter goes off once a duration has passed.
+	SleepAfter SleepUntil = "duration"
+	// SleepEndOfItem goes off when the item playing ends.
+	SleepEndOfItem SleepUntil = "end_of_item"
+	// SleepEndOfQueue goes off when the last item queued ends.
+	SleepEndOfQueue SleepUntil = "end_of_queue"
+)
+
+// SleepOptions configures a sleep timer.
+type SleepOptions struct {
+	Until SleepUntil
+	// After is how long SleepAfter timers wait.
+	After  time.Duration
+	Action SleepAction
+	// Fade is how long the volume is lowered for before the timer goes off.
+	// The volume is restored once the media has been stopped or paused.
+	Fade time.Duration
+}
+
+// SleepInfo describes a sleep timer.
+type SleepInfo struct {
+	Until  SleepUntil  `json:"until"`
+	Action SleepAction `json:"action"`
+	// Fade and Remaining are in seconds. Remaining and EndsAt aren't set
+	// while the end of the media isn't known, such as when it is paused.
+	Fade      float64    `json:"fade"`
+	Remaining *float64   `json:"remaining,omitempty"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	Fading    bool       `json:"fading"`
+}
+
+type sleepTimer struct {
+	opts SleepOptions
+	// The item playing when the timer was set, and the last one seen.
+	item      string
+	contentID string
+	duration  float32
+
+	// deadline is zero while it isn't known. Once extended the deadline no
+	// longer follows the media.
+	deadline time.Time
+	extended bool
+	fading   bool
+
+	changed chan struct{}
+	cancel  chan struct{}
+	// done is closed once the timer has stopped and the volume restored.
+	done chan struct{}
+}
+
+// Sleep sets a sleep timer, replacing any already set, that stops or
+// pauses the media after a while or at the end of the item or queue
+// playing. The volume is faded out beforehand.
+func (a *Application) Sleep(opts SleepOptions) error {
+	if opts.Action == "" {
+		opts.Action = SleepStop
+	}
+	if opts.Action != SleepStop && opts.Action != SleepPause {
+		return errors.Errorf("unknown sleep action %q", opts.Action)
+	}
+	if opts.Fade < 0 {
+		opts.Fade = 0
+	}
+
+	t := &sleepTimer{
+		opts:    opts,
+		changed: make(chan struct{}, 1),
+		cancel:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	switch opts.Until {
+	case SleepAfter:
+		if opts.After <= 0 {
+			return errors.New("sleep timer needs a positive duration")
+		}
+		t.deadline = time.Now().Add(opts.After)
+		t.extended = true
+	case SleepEndOfItem, SleepEndOfQueue:
+		media, ok := a.currentMedia()
+		if !ok || media.PlayerState == "IDLE" {
+			return ErrNoMediaSleep
+		}
+		if media.Media.StreamType == "LIVE" {
+			return ErrNoMediaEnd
+		}
+		t.item = media.Media.ContentId
+		a.updateSleepTimer(t, media)
+	default:
+		return errors.Errorf("unknown sleep timer %q", opts.Until)
+	}
+
+	// Message funcs are called holding the lock AddMessageFunc takes, so it
+	// can't be called holding 'sleepMu'.
+	a.sleepWatch.Do(func() {
+		a.AddMessageFunc(a.sleepMediaStatus)
+	})
+
+	a.sleepMu.Lock()
+	if a.sleep != nil {
+		close(a.sleep.cancel)
+	}
+	a.sleep = t
+	a.sleepMu.Unlock()
+
+	go a.runSleepTimer(t)
+	return nil
+}
+
+// ExtendSleep moves the sleep timer back by d. Timers waiting for the end
+// of the media wait for d longer than it currently ends.
+func (a *Application) ExtendSleep(d time.Duration) error {
+	if d <= 0 {
+		return ErrSleepExtension
+	}
+
+	a.sleepMu.Lock()
+	defer a.sleepMu.Unlock()
+
+	t := a.sleep
+	if t == nil {
+		return ErrNoSleepTimer
+	}
+	if t.deadline.IsZero() {
+		return errors.Wrap(ErrNoMediaEnd, "unable to extend sleep timer")
+	}
+	t.deadline = t.deadline.Add(d)
+	t.extended = true
+	t.notify()
+	return nil
+}
+
+// CancelSleep cancels the sleep timer, restoring the volume if it was
+// fading out.
+func (a *Application) CancelSleep() error {
+	a.sleepMu.Lock()
+	defer a.sleepMu.Unlock()
+
+	if a.sleep == nil {
+		return ErrNoSleepTimer
+	}
+	close(a.sleep.cancel)
+	a.sleep = nil
+	return nil
+}
+
+// SleepStatus describes the sleep timer, or returns nil if none is set.
+func (a *Application) SleepStatus() *SleepInfo {
+	a.sleepMu.Lock()
+	defer a.sleepMu.Unlock()
+
+	t := a.sleep
+	if t == nil {
+		return nil
+	}
+	info := &SleepInfo{
+		Until:  t.opts.Until,
+		Action: t.opts.Action,
+		Fade:   t.opts.Fade.Seconds(),
+		Fading: t.fading,
+	}
+	if !t.deadline.IsZero() {
+		endsAt := t.deadline
+		remaining := time.Until(endsAt).Seconds()
+		if remaining < 0 {
+			remaining = 0
+		}
+		info.EndsAt, info.Remaining = &endsAt, &remaining
+	}
+	return info
+}
+
+// closeSleep cancels the sleep timer and waits for the volume to be
+// restored, so it isn't left faded when the connection is closed.
+func (a *Application) closeSleep() {
+	a.sleepMu.Lock()
+	t := a.sleep
+	a.sleepMu.Unlock()
+	if t == nil || a.CancelSleep() != nil {
+		return
+	}
+	select {
+	case <-t.done:
+	case <-time.After(time.Second * 5):
+	}
+}
+
+func (a *Application) runSleepTimer(t *sleepTimer) {
+	defer close(t.done)
+
+	var volume float32
+	restore := func() {
+		a.setSleepFading(t, false)
+		if volume > 0 {
+			if err := a.SetVolume(volume); err != nil {
+				a.log("unable to restore volume after sleep timer: %v", err)
+			}
+			volume = 0
+		}
+	}
+
+	for {
+		a.sleepMu.Lock()
+		deadline := t.deadline
+		a.sleepMu.Unlock()
+
+		var timer *time.Timer
+		var wake <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			switch {
+			case wait <= 0:
+				a.sleepNow(t)
+				restore()
+				return
+			case wait > t.opts.Fade:
+				// Extended, or not fading yet.
+				restore()
+				wait -= t.opts.Fade
+			default:
+				if current, ok := a.currentVolume(); volume == 0 && ok {
+					volume = current.Level
+				}
+				// The level is never set to zero, which the device treats
+				// as no level at all.
+				if level := volume * float32(wait) / float32(t.opts.Fade); level > 0 {
+					a.setSleepFading(t, true)
+					a.SetVolume(level)
+				}
+				if wait > sleepFadeStep {
+					wait = sleepFadeStep
+				}
+			}
+			timer = time.NewTimer(wait)
+			wake = timer.C
+		} else {
+			// The media is paused, so it isn't ending any time soon.
+			restore()
+		}
+
+		select {
+		case <-wake:
+		case <-t.changed:
+		case <-t.cancel:
+			if timer != nil {
+				timer.Stop()
+			}
+			restore()
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// sleepNow does what the sleep timer t is set to do, unless it has been
+// cancelled.
+func (a *Application) sleepNow(t *sleepTimer) {
+	a.sleepMu.Lock()
+	if a.sleep != t {
+		a.sleepMu.Unlock()
+		return
+	}
+	a.sleep = nil
+	a.sleepMu.Unlock()
+
+	a.log("sleep timer went off, %s media", t.opts.Action)
+	var err error
+	if t.opts.Action == SleepPause {
+		err = a.Pause()
+	} else {
+		err = a.StopMedia()
+	}
+	if err != nil {
+		a.log("unable to %s media for sleep timer: %v", t.opts.Action, err)
+	}
+}
+
+func (a *Application) setSleepFading(t *sleepTimer, fading bool) {
+	a.sleepMu.Lock()
+	t.fading = fading
+	a.sleepMu.Unlock()
+}
+
+// sleepMediaStatus follows the media playing for timers waiting for it to
+// end.
+func (a *Application) sleepMediaStatus(msg *pb.CastMessage) {
+	payload := []byte(msg.GetPayloadUtf8())
+	if messageType, _ := jsonparser.GetString(payload, "type"); messageType != "MEDIA_STATUS" {
+		return
+	}
+	resp := cast.MediaStatusResponse{}
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		return
+	}
+
+	a.sleepMu.Lock()
+	t := a.sleep
+	a.sleepMu.Unlock()
+	if t == nil {
+		return
+	}
+	for _, status := range resp.Status {
+		a.updateSleepTimer(t, status)
+	}
+}
+
+// updateSleepTimer moves the deadline of t to when the media in status
+// ends.
+func (a *Application) updateSleepTimer(t *sleepTimer, status cast.Media) {
+	if t.opts.Until == SleepAfter {
+		return
+	}
+
+	a.sleepMu.Lock()
+	defer a.sleepMu.Unlock()
+	if t.extended {
+		return
+	}
+	defer t.notify()
+
+	// Updates only describe the media when it has changed.
+	if id := status.Media.ContentId; id != "" && id != t.contentID {
+		t.contentID, t.duration = id, 0
+		if t.opts.Until == SleepEndOfItem && t.item != "" && id != t.item {
+			t.deadline = time.Now()
+			return
+		}
+	}
+	if status.Media.Duration > 0 {
+		t.duration = status.Media.Duration
+	}
+
+	switch status.PlayerState {
+	case "IDLE":
+		// Queues move on to the next item through an idle state.
+		if t.opts.Until == SleepEndOfQueue && status.IdleReason == "FINISHED" && status.LoadingItemId != 0 {
+			return
+		}
+		t.deadline = time.Now()
+	case "PLAYING":
+		if t.duration <= 0 {
+			t.deadline = time.Time{}
+			return
+		}
+		remaining := time.Duration(float64(t.duration-status.CurrentTime) * float64(time.Second))
+		if t.opts.Until == SleepEndOfQueue {
+			after, ok := a.queuedAfter(t.contentID)
+			if !ok {
+				t.deadline = time.Time{}
+				return
+			}
+			remaining += after
+		}
+		t.deadline = time.Now().Add(remaining)
+	default:
+		t.deadline = time.Time{}
+	}
+}
+
+// queuedAfter returns how long the items queued after contentURL play
+// for, if all of their durations are known.
+func (a *Application) queuedAfter(contentURL string) (time.Duration, bool) {
+	a.servedMu.Lock()
+	defer a.servedMu.Unlock()
+
+	var after time.Duration
+	for i, mi := range a.queue {
+		if mi.contentURL != contentURL {
+			continue
+		}
+		for _, next := range a.queue[i+1:] {
+			d := next.knownDuration()
+			if d <= 0 {
+				return 0, false
+			}
+			after += d
+		}
+		return after, true
+	}
+	// Media that wasn't queued is the only item.
+	return 0, true
+}
+
+func (t *sleepTimer) notify() {
+	select {
+	case t.changed <- struct{}{}:
+	default:
+	}
+}
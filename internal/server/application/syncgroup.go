@@ -0,0 +1,623 @@
+package application
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	cast "github.com/avinash240/pusher/internal/server/cast"
+)
+
+const (
+	// DefaultSyncInterval is how often the devices of a sync group are
+	// checked for drift.
+	DefaultSyncInterval = time.Second * 10
+	// DefaultSyncTolerance is how far a device may drift from the rest of
+	// its group before it is seeked back in line.
+	DefaultSyncTolerance = time.Millisecond * 150
+
+	// syncStartDelay is how long after the slowest device has been told to
+	// play that the group starts, covering the time taken to send to every
+	// device.
+	syncStartDelay = time.Millisecond * 500
+	// syncLoadTimeout is how long devices have to buffer media paused.
+	syncLoadTimeout = time.Second * 30
+	// latencySamples is the number of status round trips latency is
+	// measured from.
+	latencySamples = 5
+)
+
+var (
+	ErrSyncGroupEmpty  = errors.New("sync group has no devices")
+	ErrPreloadTimeout  = errors.New("media wasn't ready to play in time")
+	ErrSyncGroupIdle   = errors.New("sync group isn't playing anything")
+	ErrNoMediaPosition = errors.New("media not yet initialised, there is no position")
+)
+
+// MediaPosition is where the media on a device was at a moment.
+type MediaPosition struct {
+	PlayerState string
+	// Position is in seconds.
+	Position float32
+	// At is the estimated moment the device reported Position, half way
+	// through the status round trip.
+	At        time.Time
+	RoundTrip time.Duration
+}
+
+// estimate returns where the media is at t, if it is playing.
+func (p MediaPosition) estimate(t time.Time) float64 {
+	position := float64(p.Position)
+	if p.PlayerState == "PLAYING" {
+		position += t.Sub(p.At).Seconds()
+	}
+	return position
+}
+
+// Position asks the device where its media is.
+func (a *Application) Position() (MediaPosition, error) {
+	sent := time.Now()
+	status, err := a.getMediaStatus()
+	if err != nil {
+		return MediaPosition{}, err
+	}
+	roundTrip := time.Since(sent)
+	if len(status.Status) == 0 {
+		return MediaPosition{}, ErrNoMediaPosition
+	}
+	media := status.Status[0]
+	a.setMedia(&media)
+	return MediaPosition{
+		PlayerState: media.PlayerState,
+		Position:    media.CurrentTime,
+		At:          sent.Add(roundTrip / 2),
+		RoundTrip:   roundTrip,
+	}, nil
+}
+
+// Preload loads a file or url paused at its start, returning once the
+// device has buffered it and is ready to play.
+func (a *Application) Preload(filenameOrUrl, contentType string) error {
+	mi, err := a.mediaItemFor(filenameOrUrl, contentType)
+	if err != nil {
+		return errors.Wrap(err, "unable to load and serve file")
+	}
+	if mi.contentType != "" && !a.capabilities.CanDisplay(mi.contentType) {
+		return a.unsupported(filenameOrUrl, mi.contentType)
+	}
+
+	a.servedMu.Lock()
+	a.queue = []mediaItem{mi}
+	a.servedMu.Unlock()
+
+	statuses, unwatch := a.watchMediaStatus()
+	defer unwatch()
+
+	loaded, err := a.loadMediaItem(mi, false)
+	if err != nil {
+		return err
+	}
+	a.setMedia(&loaded)
+
+	timeout := time.NewTimer(syncLoadTimeout)
+	defer timeout.Stop()
+	for status := loaded; ; {
+		if status.MediaSessionId == loaded.MediaSessionId {
+			switch status.PlayerState {
+			case "PAUSED":
+				return nil
+			case "IDLE":
+				return errors.Errorf("device was unable to load media (%s)", status.IdleReason)
+			}
+		}
+		select {
+		case status = <-statuses:
+		case <-timeout.C:
+			return ErrPreloadTimeout
+		}
+	}
+}
+
+// seekPaused seeks the media to position without playing it.
+func (a *Application) seekPaused(position float64) error {
+	if a.media == nil {
+		return ErrMediaNotYetInitialised
+	}
+	return a.sendMediaRecv(&cast.MediaHeader{
+		PayloadHeader:  cast.SeekHeader,
+		MediaSessionId: a.media.MediaSessionId,
+		CurrentTime:    float32(position),
+		ResumeState:    "PLAYBACK_PAUSE",
+	})
+}
+
+// SyncGroup plays the same media on several devices in step, which native
+// cast groups can't do for older and third party devices. Media is loaded
+// paused on every device, and each is then told to play at a moment offset
+// by its latency, measured from status round trips. Devices are checked
+// for drift while playing, and seeked back in line with the others.
+//
+// A SyncGroup expects to be the only thing controlling its devices while
+// it plays.
+type SyncGroup struct {
+	name      string
+	interval  time.Duration
+	tolerance time.Duration
+
+	// Held for the whole of Play, Pause, Unpause and Stop, so only one
+	// runs at a time.
+	opMu sync.Mutex
+
+	mu      sync.Mutex
+	members map[string]*syncMember
+	path    string
+	started time.Time
+	// Closed to stop following the playing media for drift, which closes
+	// 'done' once it has.
+	stop chan struct{}
+	done chan struct{}
+}
+
+type syncMember struct {
+	app *Application
+	// Set once the media played has been loaded, and cleared for devices
+	// that couldn't load it, which are left out until it is played again.
+	ready bool
+	// One way latency, and the drift last measured.
+	latency time.Duration
+	drift   time.Duration
+	resyncs int
+	err     error
+}
+
+type SyncOption func(*SyncGroup)
+
+// WithSyncInterval checks the devices for drift every d. Intervals that
+// aren't positive keep DefaultSyncInterval.
+func WithSyncInterval(d time.Duration) SyncOption {
+	return func(g *SyncGroup) {
+		if d > 0 {
+			g.interval = d
+		}
+	}
+}
+
+// WithSyncTolerance seeks devices that have drifted by more than d.
+func WithSyncTolerance(d time.Duration) SyncOption {
+	return func(g *SyncGroup) {
+		g.tolerance = d
+	}
+}
+
+// NewSyncGroup returns a group playing on apps, keyed by device uuid.
+func NewSyncGroup(name string, apps map[string]*Application, opts ...SyncOption) *SyncGroup {
+	g := &SyncGroup{
+		name:      name,
+		interval:  DefaultSyncInterval,
+		tolerance: DefaultSyncTolerance,
+		members:   map[string]*syncMember{},
+	}
+	for uuid, app := range apps {
+		g.members[uuid] = &syncMember{app: app}
+	}
+	for _, o := range opts {
+		o(g)
+	}
+	return g
+}
+
+func (g *SyncGroup) Name() string { return g.name }
+
+// Members returns the application of every device in the group, by uuid.
+func (g *SyncGroup) Members() map[string]*Application {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	apps := make(map[string]*Application, len(g.members))
+	for uuid, m := range g.members {
+		apps[uuid] = m.app
+	}
+	return apps
+}
+
+// Play loads a file or url on every device, then starts them together. It
+// fails only if no device could play it; devices that couldn't are left
+// out, with their errors in the status.
+func (g *SyncGroup) Play(filenameOrUrl, contentType string) error {
+	if len(g.members) == 0 {
+		return ErrSyncGroupEmpty
+	}
+	g.opMu.Lock()
+	defer g.opMu.Unlock()
+	g.follow(nil)
+
+	errs := g.eachDevice(true, func(uuid string, m *syncMember) error {
+		m.app.log("preloading %s for sync group %q", filenameOrUrl, g.name)
+		err := m.app.Preload(filenameOrUrl, contentType)
+		g.mu.Lock()
+		m.ready = err == nil
+		g.mu.Unlock()
+		return errors.Wrap(err, "unable to preload media")
+	})
+	if len(errs) == len(g.members) {
+		return errors.Wrap(firstError(errs), "no device could load media")
+	}
+
+	g.mu.Lock()
+	g.path = filenameOrUrl
+	g.mu.Unlock()
+	return g.start()
+}
+
+// Pause pauses every device.
+func (g *SyncGroup) Pause() error {
+	g.opMu.Lock()
+	defer g.opMu.Unlock()
+	if !g.playing() {
+		return ErrSyncGroupIdle
+	}
+	g.follow(nil)
+	return firstError(g.each(func(uuid string, m *syncMember) error {
+		return m.app.Pause()
+	}))
+}
+
+// Unpause lines the devices up where they were paused on average, then
+// starts them together again.
+func (g *SyncGroup) Unpause() error {
+	g.opMu.Lock()
+	defer g.opMu.Unlock()
+	if !g.playing() {
+		return ErrSyncGroupIdle
+	}
+	g.follow(nil)
+
+	positions := g.positions()
+	if len(positions) == 0 {
+		return errors.New("no device has media to unpause")
+	}
+	now := time.Now()
+	var estimates []float64
+	for _, p := range positions {
+		estimates = append(estimates, p.estimate(now))
+	}
+	position := median(estimates)
+	errs := g.each(func(uuid string, m *syncMember) error {
+		if _, ok := positions[uuid]; !ok {
+			return m.err
+		}
+		return errors.Wrap(m.app.seekPaused(position), "unable to seek")
+	})
+	seeked := 0
+	for uuid := range positions {
+		if errs[uuid] == nil {
+			seeked++
+		}
+	}
+	if seeked == 0 {
+		return errors.Wrap(firstError(errs), "no device could be lined up")
+	}
+	return g.start()
+}
+
+// Stop stops the media on every device.
+func (g *SyncGroup) Stop() error {
+	g.opMu.Lock()
+	defer g.opMu.Unlock()
+	g.follow(nil)
+
+	errs := g.each(func(uuid string, m *syncMember) error {
+		err := m.app.StopMedia()
+		if err == ErrNoMediaStop {
+			return nil
+		}
+		return err
+	})
+
+	g.mu.Lock()
+	g.path = ""
+	g.started = time.Time{}
+	for _, m := range g.members {
+		m.ready = false
+	}
+	g.mu.Unlock()
+	return firstError(errs)
+}
+
+// Close stops following the devices, leaving them playing.
+func (g *SyncGroup) Close() {
+	g.opMu.Lock()
+	defer g.opMu.Unlock()
+	g.follow(nil)
+}
+
+// start measures the latency of every device still in the group, then
+// tells each to play so they all start at the same moment.
+func (g *SyncGroup) start() error {
+	latencies := map[string]time.Duration{}
+	var latenciesMu sync.Mutex
+	errs := g.each(func(uuid string, m *syncMember) error {
+		latency, err := measureLatency(m.app)
+		if err != nil {
+			return errors.Wrap(err, "unable to measure latency")
+		}
+		latenciesMu.Lock()
+		latencies[uuid] = latency
+		latenciesMu.Unlock()
+		return nil
+	})
+	if len(latencies) == 0 {
+		return errors.Wrap(firstError(errs), "no device could be reached")
+	}
+
+	var slowest time.Duration
+	for _, latency := range latencies {
+		if latency > slowest {
+			slowest = latency
+		}
+	}
+	startAt := time.Now().Add(slowest + syncStartDelay)
+
+	errs = g.each(func(uuid string, m *syncMember) error {
+		latency, ok := latencies[uuid]
+		if !ok {
+			return m.err
+		}
+		g.mu.Lock()
+		m.latency = latency
+		g.mu.Unlock()
+		time.Sleep(time.Until(startAt.Add(-latency)))
+		return errors.Wrap(m.app.Unpause(), "unable to play")
+	})
+	played := 0
+	for uuid := range latencies {
+		if errs[uuid] == nil {
+			played++
+		}
+	}
+	if played == 0 {
+		return errors.Wrap(firstError(errs), "no device could play")
+	}
+
+	g.mu.Lock()
+	g.started = startAt
+	g.mu.Unlock()
+
+	stop := make(chan struct{})
+	go g.resyncLoop(stop, g.follow(stop))
+	return nil
+}
+
+// follow stops following the devices for drift. If stop is set, following
+// starts again until it is closed, and the returned channel is to be closed
+// once it has stopped.
+func (g *SyncGroup) follow(stop chan struct{}) chan struct{} {
+	var done chan struct{}
+	if stop != nil {
+		done = make(chan struct{})
+	}
+
+	g.mu.Lock()
+	prevStop, prevDone := g.stop, g.done
+	g.stop, g.done = stop, done
+	g.mu.Unlock()
+
+	if prevStop != nil {
+		close(prevStop)
+		<-prevDone
+	}
+	return done
+}
+
+func (g *SyncGroup) resyncLoop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if !g.resync() {
+			return
+		}
+	}
+}
+
+// resync seeks the devices that have drifted from the others back in line.
+// It returns false once no device is playing.
+func (g *SyncGroup) resync() bool {
+	positions := g.positions()
+	now := time.Now()
+
+	playing := map[string]float64{}
+	active := false
+	for uuid, p := range positions {
+		switch p.PlayerState {
+		case "PLAYING":
+			playing[uuid] = p.estimate(now)
+			active = true
+		case "PAUSED", "BUFFERING":
+			active = true
+		}
+	}
+	if len(playing) < 2 {
+		return active
+	}
+
+	estimates := make([]float64, 0, len(playing))
+	for _, position := range playing {
+		estimates = append(estimates, position)
+	}
+	reference := median(estimates)
+
+	g.each(func(uuid string, m *syncMember) error {
+		position, ok := playing[uuid]
+		if !ok {
+			return m.err
+		}
+		drift := time.Duration((position - reference) * float64(time.Second))
+		resync := time.Duration(math.Abs(float64(drift))) > g.tolerance
+
+		g.mu.Lock()
+		m.drift = drift
+		if resync {
+			m.resyncs++
+		}
+		g.mu.Unlock()
+		if !resync {
+			return nil
+		}
+		m.app.log("sync group %q drifted by %v, resyncing", g.name, drift)
+		// The group will have moved on by the time the seek arrives.
+		target := reference + time.Since(now).Seconds() + m.latency.Seconds()
+		return errors.Wrap(m.app.SeekToTime(float32(target)), "unable to resync")
+	})
+	return true
+}
+
+// positions returns the positions of the devices that could be reached.
+func (g *SyncGroup) positions() map[string]MediaPosition {
+	positions := map[string]MediaPosition{}
+	var mu sync.Mutex
+	g.each(func(uuid string, m *syncMember) error {
+		p, err := m.app.Position()
+		if err != nil {
+			return errors.Wrap(err, "unable to get position")
+		}
+		mu.Lock()
+		positions[uuid] = p
+		mu.Unlock()
+		return nil
+	})
+	return positions
+}
+
+// each runs f for every device ready to play at once, recording the error
+// each returns, and returns those errors by uuid.
+func (g *SyncGroup) each(f func(uuid string, m *syncMember) error) map[string]error {
+	return g.eachDevice(false, f)
+}
+
+// eachDevice is each, including the devices that aren't ready if all is
+// set.
+func (g *SyncGroup) eachDevice(all bool, f func(uuid string, m *syncMember) error) map[string]error {
+	g.mu.Lock()
+	members := make(map[string]*syncMember, len(g.members))
+	for uuid, m := range g.members {
+		if all || m.ready {
+			members[uuid] = m
+		}
+	}
+	g.mu.Unlock()
+
+	errs := map[string]error{}
+	var wg sync.WaitGroup
+	for uuid, m := range members {
+		wg.Add(1)
+		go func(uuid string, m *syncMember) {
+			defer wg.Done()
+			err := f(uuid, m)
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			m.err = err
+			if err != nil {
+				m.app.log("sync group %q: %v", g.name, err)
+				errs[uuid] = err
+			}
+		}(uuid, m)
+	}
+	wg.Wait()
+	return errs
+}
+
+func (g *SyncGroup) playing() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.path != ""
+}
+
+// SyncStatus describes a sync group.
+type SyncStatus struct {
+	Name    string             `json:"name"`
+	Path    string             `json:"path,omitempty"`
+	Started *time.Time         `json:"started,omitempty"`
+	Devices []SyncDeviceStatus `json:"devices"`
+}
+
+// SyncDeviceStatus describes a device in a sync group. Latency and Drift
+// are in seconds.
+type SyncDeviceStatus struct {
+	UUID    string  `json:"uuid"`
+	Latency float64 `json:"latency"`
+	Drift   float64 `json:"drift"`
+	Resyncs int     `json:"resyncs"`
+	Error   string  `json:"error,omitempty"`
+}
+
+func (g *SyncGroup) Status() SyncStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	status := SyncStatus{Name: g.name, Path: g.path, Devices: []SyncDeviceStatus{}}
+	if !g.started.IsZero() {
+		started := g.started
+		status.Started = &started
+	}
+	for uuid, m := range g.members {
+		d := SyncDeviceStatus{
+			UUID:    uuid,
+			Latency: m.latency.Seconds(),
+			Drift:   m.drift.Seconds(),
+			Resyncs: m.resyncs,
+		}
+		if m.err != nil {
+			d.Error = m.err.Error()
+		}
+		status.Devices = append(status.Devices, d)
+	}
+	sort.Slice(status.Devices, func(i, j int) bool { return status.Devices[i].UUID < status.Devices[j].UUID })
+	return status
+}
+
+// measureLatency returns the one way latency to app, taken from the
+// quickest of several status round trips.
+func measureLatency(app *Application) (time.Duration, error) {
+	var quickest time.Duration
+	for i := 0; i < latencySamples; i++ {
+		p, err := app.Position()
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 || p.RoundTrip < quickest {
+			quickest = p.RoundTrip
+		}
+	}
+	return quickest / 2, nil
+}
+
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// firstError returns the error of the first uuid, in order, or nil.
+func firstError(errs map[string]error) error {
+	uuids := make([]string, 0, len(errs))
+	for uuid := range errs {
+		uuids = append(uuids, uuid)
+	}
+	if len(uuids) == 0 {
+		return nil
+	}
+	sort.Strings(uuids)
+	return errors.Wrapf(errs[uuids[0]], "device %s", uuids[0])
+}
@@ -0,0 +1,273 @@
+// Package auth authenticates requests by the bearer token they are made
+// with, and authorises them by the scopes of the token.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Scope is what a token may be used for. Each scope includes those below
+// it, so admin tokens may control devices and control tokens may read
+// their status.
+type Scope string
+
+const (
+	// None is the scope of routes anyone may use, without a token.
+	None Scope = ""
+	// Read reads the status of devices, groups, schedules and the like.
+	Read Scope = "read"
+	// Control controls the media playing on devices.
+	Control Scope = "control"
+	// Admin connects and disconnects devices, manages groups and schedules,
+	// and loads files from the local media server.
+	Admin Scope = "admin"
+)
+
+var scopeRank = map[Scope]int{None: 0, Read: 1, Control: 2, Admin: 3}
+
+// MinTokenLength is the length tokens need to be, so they can't be
+// guessed.
+const MinTokenLength = 16
+
+// realm is the realm challenges name.
+const realm = "pusher"
+
+var (
+	// ErrNoToken is returned for requests made without a token to routes
+	// that need one.
+	ErrNoToken = errors.New("a bearer token is needed")
+	// ErrInvalidToken is returned for requests made with a token that isn't
+	// known.
+	ErrInvalidToken = errors.New("the bearer token isn't valid")
+	// ErrForbidden is returned for requests made with a token that doesn't
+	// have the scope the route needs.
+	ErrForbidden = errors.New("the bearer token doesn't have the scope needed")
+	// ErrNoClientCert is returned for requests made without a verified
+	// client certificate to routes that need one.
+	ErrNoClientCert = errors.New("a verified client certificate is needed")
+)
+
+// Token is a bearer token, and the name of the caller it was issued to.
+// Requests made with it are logged as being made by Name.
+type Token struct {
+	Name   string  `yaml:"name"`
+	Token  string  `yaml:"token"`
+	Scopes []Scope `yaml:"scopes"`
+}
+
+// Identity is the caller a request was made by.
+type Identity struct {
+	Name   string
+	Scopes []Scope
+}
+
+// Has returns whether the caller may use routes that need scope.
+func (id Identity) Has(scope Scope) bool {
+	for _, s := range id.Scopes {
+		if scopeRank[s] >= scopeRank[scope] {
+			return true
+		}
+	}
+	return scope == None
+}
+
+// LoadFile reads the tokens in the yaml file at path, which lists them in
+// the same form as the config does.
+func LoadFile(path string) ([]Token, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read token file %q", path)
+	}
+	var tokens []Token
+	if err := yaml.Unmarshal(b, &tokens); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse token file %q", path)
+	}
+	return tokens, nil
+}
+
+// Authenticator knows the tokens requests may be made with. A nil
+// Authenticator lets every request through, as an anonymous caller.
+type Authenticator struct {
+	// Tokens are kept, and looked up, by their hash, so how long lookups
+	// take says nothing of the tokens.
+	identities map[[sha256.Size]byte]Identity
+
+	// Routes needing 'certScope' or above need a verified client
+	// certificate as well as a token, unless it is None.
+	certScope Scope
+}
+
+// Option configures an Authenticator.
+type Option func(*Authenticator)
+
+// WithClientCerts requires a client certificate, verified by the TLS
+// server, of requests to routes that need scope or above, as well as a
+// token.
+func WithClientCerts(scope Scope) Option {
+	return func(a *Authenticator) {
+		a.certScope = scope
+	}
+}
+
+// New returns an Authenticator accepting tokens. Every token needs a
+// name, at least one known scope and to be at least MinTokenLength long.
+func New(tokens []Token, opts ...Option) (*Authenticator, error) {
+	a := &Authenticator{identities: map[[sha256.Size]byte]Identity{}}
+	for _, o := range opts {
+		o(a)
+	}
+	for _, t := range tokens {
+		if t.Name == "" {
+			return nil, errors.New("every token needs a name")
+		}
+		if len(t.Token) < MinTokenLength {
+			return nil, errors.Errorf("token %s is shorter than %d characters", t.Name, MinTokenLength)
+		}
+		if len(t.Scopes) == 0 {
+			return nil, errors.Errorf("token %s has no scopes", t.Name)
+		}
+		for _, s := range t.Scopes {
+			if _, ok := scopeRank[s]; !ok || s == None {
+				return nil, errors.Errorf("token %s has unknown scope %q, expected read, control or admin", t.Name, s)
+			}
+		}
+		sum := sha256.Sum256([]byte(t.Token))
+		if _, ok := a.identities[sum]; ok {
+			return nil, errors.Errorf("token %s is used by another token", t.Name)
+		}
+		a.identities[sum] = Identity{Name: t.Name, Scopes: t.Scopes}
+	}
+	return a, nil
+}
+
+// token returns the bearer token r was made with, from its Authorization
+// header or, as browsers can't set headers on event streams or WebSockets,
+// its 'access_token' query param.
+func token(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if i := strings.IndexByte(h, ' '); i > 0 && strings.EqualFold(h[:i], "Bearer") {
+			return strings.TrimSpace(h[i+1:])
+		}
+		return ""
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// Authorize returns the caller r was made by, if they may use routes that
+// need scope. It returns ErrNoToken or ErrInvalidToken for callers that
+// can't be identified, ErrForbidden for those without scope and
+// ErrNoClientCert for those without a client certificate it needs.
+func (a *Authenticator) Authorize(r *http.Request, scope Scope) (Identity, error) {
+	if a == nil || scope == None {
+		return Identity{}, nil
+	}
+	t := token(r)
+	if t == "" {
+		return Identity{}, ErrNoToken
+	}
+	id, ok := a.identities[sha256.Sum256([]byte(t))]
+	if !ok {
+		return Identity{}, ErrInvalidToken
+	}
+	if !id.Has(scope) {
+		return id, ErrForbidden
+	}
+	if a.certScope != None && scopeRank[scope] >= scopeRank[a.certScope] && clientCert(r) == "" {
+		return id, ErrNoClientCert
+	}
+	return id, nil
+}
+
+// clientCert returns the subject of the client certificate r was made
+// with, if it was verified.
+func clientCert(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.String()
+}
+
+// Check authorises r for scope, as Authorize does, logging who it was made
+// by or why it was denied. The request returned carries the caller in its
+// context.
+func (a *Authenticator) Check(r *http.Request, scope Scope) (*http.Request, error) {
+	id, err := a.Authorize(r, scope)
+	if err != nil {
+		log.WithFields(log.Fields{"remote": r.RemoteAddr, "caller": id.Name, "scope": scope}).
+			Warnf("denied %s %s: %v", r.Method, r.URL.Path, err)
+		return r, err
+	}
+	if id.Name != "" {
+		fields := log.Fields{"remote": r.RemoteAddr, "caller": id.Name}
+		if cert := clientCert(r); cert != "" {
+			fields["client_cert"] = cert
+		}
+		log.WithFields(fields).Infof("%s %s", r.Method, r.URL.Path)
+	}
+	return r.WithContext(NewContext(r.Context(), id)), nil
+}
+
+// Require returns next, only letting through requests authorised for scope.
+// Others are failed with a challenge and a text error.
+func (a *Authenticator) Require(scope Scope, next http.Handler) http.Handler {
+	if a == nil || scope == None {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, err := a.Check(r, scope)
+		if err != nil {
+			http.Error(w, err.Error(), Challenge(w, err))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Challenge sets the WWW-Authenticate header for the error err returned by
+// Authorize, and returns the status it is written with.
+func Challenge(w http.ResponseWriter, err error) int {
+	switch err {
+	case ErrNoToken:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`"`)
+		return http.StatusUnauthorized
+	case ErrInvalidToken:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`", error="invalid_token"`)
+		return http.StatusUnauthorized
+	case ErrForbidden:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`", error="insufficient_scope"`)
+		return http.StatusForbidden
+	case ErrNoClientCert:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying the caller id.
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the caller carried by ctx, if any.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(Identity)
+	return id, ok && id.Name != ""
+}
+
+// Caller describes who made r for logs, by the name of their token when
+// they were identified and by their address otherwise.
+func Caller(r *http.Request) string {
+	if id, ok := FromContext(r.Context()); ok {
+		return id.Name + " (" + r.RemoteAddr + ")"
+	}
+	return r.RemoteAddr
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -26,6 +27,8 @@ const (
 
 type Connection struct {
 	conn *tls.Conn
+	// Held while writing messages, which are sent from several goroutines.
+	writeMu sync.Mutex
 
 	recvMsgChan chan *pb.CastMessage
 
@@ -118,6 +121,8 @@ func (c *Connection) Send(requestID int, payload Payload, sourceID, destinationI
 
 	c.log("(%d)%s -> %s [%s]: %s", requestID, sourceID, destinationID, namespace, payloadJson)
 
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
 	if err := binary.Write(c.conn, binary.BigEndian, uint32(len(data))); err != nil {
 		return errors.Wrap(err, "unable to write binary format")
 	}
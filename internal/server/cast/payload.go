@@ -15,6 +15,7 @@ var (
 	LoadHeader        = PayloadHeader{Type: "LOAD"}         // Loads an application onto the chromecast
 	QueueLoadHeader   = PayloadHeader{Type: "QUEUE_LOAD"}   // Loads an application onto the chromecast
 	QueueUpdateHeader = PayloadHeader{Type: "QUEUE_UPDATE"} // Loads an application onto the chromecast
+	MetadataHeader    = PayloadHeader{Type: "METADATA"}     // Metadata of the media playing, for custom receivers
 )
 
 type Payload interface {
@@ -30,6 +31,13 @@ func (p *PayloadHeader) SetRequestId(id int) {
 	p.RequestId = id
 }
 
+// MetadataMessage updates the metadata of the media playing on receivers
+// that listen for it, without reloading the media.
+type MetadataMessage struct {
+	PayloadHeader
+	Metadata MediaMetadata `json:"metadata"`
+}
+
 type QueueUpdate struct {
 	PayloadHeader
 	MediaSessionId int `json:"mediaSessionId,omitempty"`
@@ -48,7 +56,7 @@ type QueueLoad struct {
 type QueueLoadItem struct {
 	Media            MediaItem `json:"media"`
 	Autoplay         bool      `json:"autoplay"`
-	PlaybackDuration int       `json:"playbackDuration"`
+	PlaybackDuration int       `json:"playbackDuration,omitempty"`
 }
 
 type MediaHeader struct {
@@ -114,6 +122,15 @@ type MediaItem struct {
 	Metadata    MediaMetadata `json:"metadata"`
 }
 
+// Metadata types of MediaMetadata.
+const (
+	MetadataTypeGeneric    = 0
+	MetadataTypeMovie      = 1
+	MetadataTypeTVShow     = 2
+	MetadataTypeMusicTrack = 3
+	MetadataTypePhoto      = 4
+)
+
 type MediaMetadata struct {
 	MetadataType int     `json:"metadataType"`
 	Artist       string  `json:"artist"`
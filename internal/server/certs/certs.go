@@ -0,0 +1,183 @@
+// Package certs loads the certificates the API is served with, reloading
+// them on demand, and generates a self-signed one when none is configured.
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// selfSignedValidity is how long generated certificates are valid for.
+const selfSignedValidity = 10 * 365 * 24 * time.Hour
+
+// Store holds the certificate in a pair of PEM files, which is reloaded
+// from them by Reload.
+type Store struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// Load returns a Store of the certificate in certFile and its key in
+// keyFile.
+func Load(certFile, keyFile string) (*Store, error) {
+	s := &Store{certFile: certFile, keyFile: keyFile}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the certificate from its files again, for the connections
+// made from then on. The certificate in use is kept if they can't be read.
+func (s *Store) Reload() error {
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return errors.Wrapf(err, "unable to load certificate %q", s.certFile)
+	}
+	s.mu.Lock()
+	s.cert = &cert
+	s.mu.Unlock()
+	return nil
+}
+
+// GetCertificate returns the certificate, for use as the GetCertificate of
+// a tls.Config.
+func (s *Store) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert, nil
+}
+
+// Fingerprint returns the fingerprint of the certificate.
+func (s *Store) Fingerprint() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Fingerprint(s.cert.Certificate[0])
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the DER encoded
+// certificate der, as colon separated hex.
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+// LoadClientCAs returns the pool of the PEM encoded certificates in path,
+// which client certificates are verified against.
+func LoadClientCAs(path string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read client CAs %q", path)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.Errorf("no certificates in client CAs %q", path)
+	}
+	return pool, nil
+}
+
+// EnsureSelfSigned generates a self-signed certificate, and its key, into
+// certFile and keyFile unless they both exist. The certificate is for
+// localhost, the host name and every address of the host. It returns
+// whether one was generated.
+func EnsureSelfSigned(certFile, keyFile string) (bool, error) {
+	if exists(certFile) && exists(keyFile) {
+		return false, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return false, errors.Wrap(err, "unable to generate key")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return false, errors.Wrap(err, "unable to generate serial number")
+	}
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"pusher"}, CommonName: hostname},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+	}
+	if hostname != "" && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	template.IPAddresses = hostIPs()
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return false, errors.Wrap(err, "unable to create certificate")
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return false, errors.Wrap(err, "unable to encode key")
+	}
+
+	if err := writePEM(keyFile, "EC PRIVATE KEY", keyDER, 0600); err != nil {
+		return false, err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// hostIPs returns the loopback addresses and every address of the host.
+func hostIPs() []net.IP {
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if network, ok := addr.(*net.IPNet); ok && !network.IP.IsLoopback() {
+			ips = append(ips, network.IP)
+		}
+	}
+	return ips
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrapf(err, "unable to create directory for %q", path)
+	}
+	b := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(path, b, perm); err != nil {
+		return errors.Wrapf(err, "unable to write %q", path)
+	}
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
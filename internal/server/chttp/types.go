@@ -1,18 +1,33 @@
 package chttp
 
-import cast "github.com/avinash240/pusher/internal/server/cast"
+import (
+	"time"
+
+	application "github.com/avinash240/pusher/internal/server/application"
+	cast "github.com/avinash240/pusher/internal/server/cast"
+)
 
 type ConnectResponse struct {
 	DeviceUUID string `json:"device_uuid"`
 	Connected  bool   `json:"connected"`
 }
 
-type volumeResponse struct {
+// VolumeResponse is the volume of a device.
+type VolumeResponse struct {
 	Level float32 `json:"level"`
 	Muted bool    `json:"muted"`
 }
 
-type statusResponse struct {
+// FromApplicationVolume returns the response for the volume of a device.
+func FromApplicationVolume(volume *cast.Volume) VolumeResponse {
+	if volume == nil {
+		return VolumeResponse{}
+	}
+	return VolumeResponse{Level: volume.Level, Muted: volume.Muted}
+}
+
+// StatusResponse is the status of a device and the media playing on it.
+type StatusResponse struct {
 	AppID        string `json:"app_id"`
 	DisplayName  string `json:"display_name"`
 	IsIdleScreen bool   `json:"is_idle_screen"`
@@ -32,6 +47,8 @@ type statusResponse struct {
 	Artist   string `json:"artist"`
 	Title    string `json:"title"`
 	Subtitle string `json:"subtitle"`
+	// Artwork is the url of the first image of the media, if it has any.
+	Artwork string `json:"artwork,omitempty"`
 
 	VolumeLevel      float32 `json:"volume_level"`
 	VolumeMuted      bool    `json:"volume_muted"`
@@ -41,16 +58,26 @@ type statusResponse struct {
 	SessionID      string `json:"session_id"`
 	TransportID    string `json:"transport_id"`
 	MediaSessionID int    `json:"media_session_id"`
+
+	// Playback describes how media served by pusher is played.
+	Playback *application.PlaybackInfo `json:"playback,omitempty"`
+	// Relay describes the radio station relayed by pusher, and the track
+	// playing on it.
+	Relay *application.RelayInfo `json:"relay,omitempty"`
+	// Sleep describes the sleep timer set, if any.
+	Sleep *application.SleepInfo `json:"sleep,omitempty"`
 }
 
-func fromApplicationStatus(app *cast.Application, media *cast.Media, volume *cast.Volume) statusResponse {
-	status := statusResponse{
-		AppID:        app.AppId,
-		DisplayName:  app.DisplayName,
-		IsIdleScreen: app.IsIdleScreen,
-		StatusText:   app.StatusText,
-		SessionID:    app.SessionId,
-		TransportID:  app.TransportId,
+// FromApplicationStatus returns the response for the status of a device.
+func FromApplicationStatus(app *cast.Application, media *cast.Media, volume *cast.Volume) StatusResponse {
+	status := StatusResponse{}
+	if app != nil {
+		status.AppID = app.AppId
+		status.DisplayName = app.DisplayName
+		status.IsIdleScreen = app.IsIdleScreen
+		status.StatusText = app.StatusText
+		status.SessionID = app.SessionId
+		status.TransportID = app.TransportId
 	}
 
 	if media != nil {
@@ -72,6 +99,9 @@ func fromApplicationStatus(app *cast.Application, media *cast.Media, volume *cas
 		status.Artist = media.Media.Metadata.Artist
 		status.Title = media.Media.Metadata.Title
 		status.Subtitle = media.Media.Metadata.Subtitle
+		if images := media.Media.Metadata.Images; len(images) > 0 {
+			status.Artwork = images[0].URL
+		}
 	}
 
 	if volume != nil {
@@ -95,3 +125,164 @@ type device struct {
 	DeviceName string            `json:"device_name"`
 	InfoFields map[string]string `json:"info_fields"`
 }
+
+// GroupResponse is the outcome of an operation on every member of a group.
+type GroupResponse struct {
+	Group   string         `json:"group"`
+	Results []MemberResult `json:"results"`
+}
+
+// MemberResult is the outcome of an operation on a member of a group. UUID
+// isn't set for members that couldn't be found or connected to.
+type MemberResult struct {
+	Member string `json:"member"`
+	UUID   string `json:"uuid,omitempty"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AnnounceResult is the outcome of an announcement on a device.
+type AnnounceResult struct {
+	UUID      string `json:"uuid"`
+	Announced bool   `json:"announced"`
+	Error     string `json:"error,omitempty"`
+}
+
+// LiveSource is a live source devices can play.
+type LiveSource struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Source      string `json:"source"`
+	Listeners   int    `json:"listeners"`
+}
+
+// LibraryItem is an item loaded by the local media server, in the order it
+// was loaded. Filename is the path or url it can be loaded on devices by.
+type LibraryItem struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Transcode   bool   `json:"transcode"`
+}
+
+// ErrorResponse is the body of every error returned by version 1 of the
+// API.
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody describes an error. Code is stable for clients to match on,
+// Message is meant for people. Details are set for some errors, such as
+// the results of a group operation that failed on every member.
+type ErrorBody struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// ConnectRequest connects to a device. The device is looked up by uuid
+// when Addr and Port aren't set.
+type ConnectRequest struct {
+	Addr        string `json:"addr,omitempty"`
+	Port        int    `json:"port,omitempty"`
+	Interface   string `json:"interface,omitempty"`
+	Wait        int    `json:"wait,omitempty"`
+	HLS         bool   `json:"hls,omitempty"`
+	ImageWidth  int    `json:"image_width,omitempty"`
+	ImageHeight int    `json:"image_height,omitempty"`
+}
+
+// PlaybackRequest controls the media playing. Seconds is needed by the
+// seek, seek_to and rewind actions.
+type PlaybackRequest struct {
+	Action  string  `json:"action"`
+	Seconds float64 `json:"seconds,omitempty"`
+}
+
+// VolumeRequest sets the level and mute of the volume, either of which may
+// be left out.
+type VolumeRequest struct {
+	Level *float32 `json:"level,omitempty"`
+	Muted *bool    `json:"muted,omitempty"`
+}
+
+// LoadRequest loads a file, directory, playlist or url. Only http and https
+// urls can be relayed. AllowAnyClient serves the media to any client, even
+// with the client allowlist on, for debugging.
+type LoadRequest struct {
+	Path           string `json:"path"`
+	ContentType    string `json:"content_type,omitempty"`
+	Relay          bool   `json:"relay,omitempty"`
+	ReloadMetadata bool   `json:"reload_metadata,omitempty"`
+	AllowAnyClient bool   `json:"allow_any_client,omitempty"`
+}
+
+// LiveRequest plays a live source.
+type LiveRequest struct {
+	Source string `json:"source"`
+}
+
+// SleepRequest sets a sleep timer for Minutes, or until the end of the item
+// or queue. Fade is in seconds.
+type SleepRequest struct {
+	Minutes int    `json:"minutes,omitempty"`
+	Until   string `json:"until,omitempty"`
+	Action  string `json:"action,omitempty"`
+	Fade    *int   `json:"fade,omitempty"`
+}
+
+// GroupRequest sets the members of a group, by device uuid or friendly
+// name.
+type GroupRequest struct {
+	Members []string `json:"members"`
+}
+
+// Group is a group of devices controlled together.
+type Group struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// Event is a change to a connected device. Status holds the fields of its
+// StatusResponse that changed, by their json names, or every field for
+// status and connected events. Load failures are described by Error.
+type Event struct {
+	ID     uint64                 `json:"id,omitempty"`
+	Type   string                 `json:"type"`
+	UUID   string                 `json:"uuid"`
+	Time   time.Time              `json:"time"`
+	Status map[string]interface{} `json:"status,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// QueueRequest loads files, playlists or urls as a queue, played in order.
+type QueueRequest struct {
+	Paths       []string `json:"paths"`
+	ContentType string   `json:"content_type,omitempty"`
+}
+
+// Command is sent over the control socket to run on the device UUID, or on
+// every member of Group. It is one of status, playback, volume, load or
+// queue, and is described by the field of the same name. Its result is
+// sent back with the same ID.
+type Command struct {
+	ID       string           `json:"id,omitempty"`
+	Command  string           `json:"command"`
+	UUID     string           `json:"uuid,omitempty"`
+	Group    string           `json:"group,omitempty"`
+	Playback *PlaybackRequest `json:"playback,omitempty"`
+	Volume   *VolumeRequest   `json:"volume,omitempty"`
+	Load     *LoadRequest     `json:"load,omitempty"`
+	Queue    *QueueRequest    `json:"queue,omitempty"`
+}
+
+// SocketMessage is sent over the control socket. Results of commands have
+// the type result, the ID of the command and either its Result or Error.
+// Events have the type event.
+type SocketMessage struct {
+	Type   string      `json:"type"`
+	ID     string      `json:"id,omitempty"`
+	OK     bool        `json:"ok,omitempty"`
+	Result interface{} `json:"result,omitempty"`
+	Error  *ErrorBody  `json:"error,omitempty"`
+	Event  *Event      `json:"event,omitempty"`
+}
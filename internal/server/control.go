@@ -0,0 +1,227 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	application "github.com/avinash240/pusher/internal/server/application"
+	chttp "github.com/avinash240/pusher/internal/server/chttp"
+)
+
+// control runs op on the device 'uuid', or on every member of 'group', in
+// the query params. The device is described by respond once op has run,
+// members of a group by their result.
+func (h *Handler) control(w http.ResponseWriter, r *http.Request, what string, op deviceOp, respond func(http.ResponseWriter, *application.Application)) {
+	if group := r.URL.Query().Get("group"); group != "" {
+		h.fanOut(w, group, what, true, op)
+		return
+	}
+
+	app, found := h.appForRequest(w, r)
+	if !found {
+		return
+	}
+	uuid := r.URL.Query().Get("uuid")
+	log.Printf("%s device %s", what, uuid)
+	if err := op(uuid, app); err != nil {
+		log.Printf("unable to %s device %s: %v", what, uuid, err)
+		httpError(w, fmt.Errorf("unable to %s device: %v", what, err))
+		return
+	}
+	if err := app.Update(); err != nil {
+		log.Printf("unable to update device %s: %v", uuid, err)
+	}
+	respond(w, app)
+}
+
+// updated runs op once the status of the device is up to date, which
+// operations on the media playing need.
+func updated(op deviceOp) deviceOp {
+	return func(uuid string, app *application.Application) error {
+		if err := app.Update(); err != nil {
+			return err
+		}
+		return op(uuid, app)
+	}
+}
+
+// writeStatus writes the status of app and the media playing on it.
+func writeStatus(w http.ResponseWriter, app *application.Application) {
+	writeJSON(w, statusOf(app), "status")
+}
+
+// statusOf returns the status of app and the media playing on it.
+func statusOf(app *application.Application) chttp.StatusResponse {
+	castApplication, castMedia, castVolume := app.Status()
+	status := chttp.FromApplicationStatus(castApplication, castMedia, castVolume)
+	status.Playback = app.Playback()
+	status.Sleep = app.SleepStatus()
+	if status.Relay = app.Relay(); status.Relay != nil && status.Title == "" {
+		// The device only knows the track when the metadata is reloaded.
+		status.Artist, status.Title = status.Relay.ArtistTitle()
+	}
+	return status
+}
+
+// writeVolume writes the volume of app.
+func writeVolume(w http.ResponseWriter, app *application.Application) {
+	writeJSON(w, chttp.FromApplicationVolume(app.Volume()), "volume")
+}
+
+// seconds returns the 'seconds' query param, writing an error if it isn't
+// a number.
+func seconds(w http.ResponseWriter, r *http.Request) (float64, bool) {
+	v, err := strconv.ParseFloat(r.URL.Query().Get("seconds"), 32)
+	if err != nil {
+		httpValidationError(w, "'seconds' is not a number")
+		return 0, false
+	}
+	return v, true
+}
+
+func (h *Handler) status(w http.ResponseWriter, r *http.Request) {
+	app, found := h.appForRequest(w, r)
+	if !found {
+		return
+	}
+	writeStatus(w, app)
+}
+
+func (h *Handler) pause(w http.ResponseWriter, r *http.Request) {
+	h.control(w, r, "pause", updated(func(uuid string, app *application.Application) error {
+		return app.Pause()
+	}), writeStatus)
+}
+
+func (h *Handler) unpause(w http.ResponseWriter, r *http.Request) {
+	h.control(w, r, "unpause", updated(func(uuid string, app *application.Application) error {
+		return app.Unpause()
+	}), writeStatus)
+}
+
+func (h *Handler) stop(w http.ResponseWriter, r *http.Request) {
+	h.control(w, r, "stop", updated(func(uuid string, app *application.Application) error {
+		return app.StopMedia()
+	}), writeStatus)
+}
+
+func (h *Handler) next(w http.ResponseWriter, r *http.Request) {
+	h.control(w, r, "skip to the next item on", updated(func(uuid string, app *application.Application) error {
+		return app.Next()
+	}), writeStatus)
+}
+
+func (h *Handler) previous(w http.ResponseWriter, r *http.Request) {
+	h.control(w, r, "go back to the previous item on", updated(func(uuid string, app *application.Application) error {
+		return app.Previous()
+	}), writeStatus)
+}
+
+func (h *Handler) skip(w http.ResponseWriter, r *http.Request) {
+	h.control(w, r, "skip to the end of the media on", updated(func(uuid string, app *application.Application) error {
+		return app.Skip()
+	}), writeStatus)
+}
+
+func (h *Handler) rewind(w http.ResponseWriter, r *http.Request) {
+	s, ok := seconds(w, r)
+	if !ok {
+		return
+	}
+	h.control(w, r, "rewind", updated(func(uuid string, app *application.Application) error {
+		return app.Seek(-int(s))
+	}), writeStatus)
+}
+
+func (h *Handler) seek(w http.ResponseWriter, r *http.Request) {
+	s, ok := seconds(w, r)
+	if !ok {
+		return
+	}
+	h.control(w, r, "seek", updated(func(uuid string, app *application.Application) error {
+		return app.Seek(int(s))
+	}), writeStatus)
+}
+
+func (h *Handler) seekTo(w http.ResponseWriter, r *http.Request) {
+	s, ok := seconds(w, r)
+	if !ok {
+		return
+	}
+	if s < 0 {
+		httpValidationError(w, "'seconds' is negative")
+		return
+	}
+	h.control(w, r, "seek", updated(func(uuid string, app *application.Application) error {
+		return app.SeekToTime(float32(s))
+	}), writeStatus)
+}
+
+// volume gets the volume of a device, or sets it when posted a volume.
+func (h *Handler) volume(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		app, found := h.appForRequest(w, r)
+		if !found {
+			return
+		}
+		writeVolume(w, app)
+		return
+	}
+
+	volume, err := strconv.ParseFloat(r.URL.Query().Get("volume"), 32)
+	if err != nil || volume < 0 || volume > 1 {
+		httpValidationError(w, "'volume' is not between 0 and 1")
+		return
+	}
+	h.control(w, r, "set volume of", func(uuid string, app *application.Application) error {
+		return app.SetVolume(float32(volume))
+	}, writeVolume)
+}
+
+func (h *Handler) mute(w http.ResponseWriter, r *http.Request) {
+	h.control(w, r, "mute", func(uuid string, app *application.Application) error {
+		return app.SetMuted(true)
+	}, writeVolume)
+}
+
+func (h *Handler) unmute(w http.ResponseWriter, r *http.Request) {
+	h.control(w, r, "unmute", func(uuid string, app *application.Application) error {
+		return app.SetMuted(false)
+	}, writeVolume)
+}
+
+// disconnectAll disconnects from every connected device, stopping their
+// media first if 'stop' is set.
+func (h *Handler) disconnectAll(w http.ResponseWriter, r *http.Request) {
+	stopMedia := r.URL.Query().Get("stop") == "true"
+
+	h.mu.Lock()
+	apps := h.apps
+	h.apps = map[string]*application.Application{}
+	h.mu.Unlock()
+
+	log.Printf("disconnecting %d devices", len(apps))
+	responses := make([]chttp.ConnectResponse, 0, len(apps))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for uuid, app := range apps {
+		wg.Add(1)
+		go func(uuid string, app *application.Application) {
+			defer wg.Done()
+			h.disconnected(uuid)
+			if err := app.Close(stopMedia); err != nil {
+				log.Printf("unable to close application %s: %v", uuid, err)
+			}
+			mu.Lock()
+			responses = append(responses, chttp.ConnectResponse{DeviceUUID: uuid, Connected: false})
+			mu.Unlock()
+		}(uuid, app)
+	}
+	wg.Wait()
+	sort.Slice(responses, func(i, j int) bool { return responses[i].DeviceUUID < responses[j].DeviceUUID })
+	writeJSON(w, responses, "disconnected devices")
+}
@@ -0,0 +1,175 @@
+package device
+
+import (
+	"fmt"
+
+	probe "github.com/avinash240/pusher/internal/streaming/probe"
+)
+
+// Mode is how media is played on a device.
+type Mode string
+
+const (
+	// DirectPlay serves the file as it is.
+	DirectPlay Mode = "direct_play"
+	// Remux copies the streams into a container the device can play.
+	Remux Mode = "remux"
+	// Transcode re-encodes at least one stream.
+	Transcode Mode = "transcode"
+)
+
+// Decision is how a probed file is played on a device.
+type Decision struct {
+	Mode Mode `json:"mode"`
+	// ContentType the device is sent.
+	ContentType string `json:"content_type"`
+	// CopyVideo and CopyAudio are set for streams that are copied rather
+	// than re-encoded when remuxing or transcoding.
+	CopyVideo bool `json:"copy_video"`
+	CopyAudio bool `json:"copy_audio"`
+	// Reasons the file can't be played directly.
+	Reasons []string `json:"reasons,omitempty"`
+
+	// Profile is the output of remuxing or transcoding.
+	Profile Profile `json:"-"`
+}
+
+// CodecArgs returns the ffmpeg output codec arguments for remuxing or
+// transcoding, copying the streams that can be.
+func (d Decision) CodecArgs() []string {
+	// Subtitles are dropped, as image based subtitles can't be converted.
+	args := []string{"-sn"}
+	if d.CopyVideo {
+		args = append(args, "-vcodec", "copy")
+	} else {
+		args = append(args, d.Profile.VideoArgs...)
+	}
+	if d.CopyAudio {
+		args = append(args, "-acodec", "copy")
+	} else {
+		args = append(args, d.Profile.AudioArgs...)
+	}
+	return args
+}
+
+// muxable are the codecs each transcode output format can hold.
+var muxable = map[string][]string{
+	"mp4": {"h264", "hevc", "av1", "aac", "mp3", "opus", "flac"},
+	"mp3": {"mp3"},
+}
+
+// Decide returns how the probed file should be played on the device.
+func (c Capabilities) Decide(info *probe.Info) Decision {
+	video, audio := info.Video(), info.Audio()
+	d := Decision{Profile: c.TranscodeProfile()}
+
+	videoOK := true
+	if video != nil {
+		switch {
+		case !c.VideoOut:
+			videoOK = false
+			d.Reasons = append(d.Reasons, "device has no video output")
+		case !c.SupportsVideoCodec(video.Codec):
+			videoOK = false
+			d.Reasons = append(d.Reasons, fmt.Sprintf("video codec %s not supported", video.Codec))
+		case c.MaxResolution.Width > 0 && (video.Width > c.MaxResolution.Width || video.Height > c.MaxResolution.Height):
+			videoOK = false
+			d.Reasons = append(d.Reasons, fmt.Sprintf("resolution %dx%d exceeds %dx%d",
+				video.Width, video.Height, c.MaxResolution.Width, c.MaxResolution.Height))
+		}
+	}
+
+	audioOK := true
+	if audio != nil {
+		switch {
+		case !c.SupportsAudioCodec(audio.Codec) && !c.supportsPCM(info, audio):
+			audioOK = false
+			d.Reasons = append(d.Reasons, fmt.Sprintf("audio codec %s not supported", audio.Codec))
+		case audio.Channels > 2:
+			audioOK = false
+			d.Reasons = append(d.Reasons, fmt.Sprintf("%d audio channels not supported", audio.Channels))
+		}
+	}
+
+	if videoOK && audioOK {
+		if ct := directContentType(info); ct != "" {
+			d.Mode = DirectPlay
+			d.ContentType = ct
+			d.Reasons = nil
+			return d
+		}
+		d.Reasons = append(d.Reasons, fmt.Sprintf("container %s not supported", info.Container))
+	}
+
+	// Copy whatever the output format can hold as it is.
+	keepVideo := video != nil && c.VideoOut
+	d.CopyVideo = keepVideo && videoOK && canMux(d.Profile.Format, video.Codec)
+	d.CopyAudio = audio != nil && audioOK && canMux(d.Profile.Format, audio.Codec)
+	d.ContentType = d.Profile.ContentType
+	d.Mode = Transcode
+	if (!keepVideo || d.CopyVideo) && (audio == nil || d.CopyAudio) {
+		d.Mode = Remux
+		if !keepVideo && d.Profile.Format == "mp4" {
+			d.ContentType = "audio/mp4"
+		}
+	}
+	return d
+}
+
+// supportsPCM reports whether audio is uncompressed audio the device can
+// play, which is only the case in wav files.
+func (c Capabilities) supportsPCM(info *probe.Info, audio *probe.Stream) bool {
+	return c.AudioOut && info.IsContainer("wav") &&
+		(audio.Codec == "pcm_s16le" || audio.Codec == "pcm_u8" || audio.Codec == "pcm_s24le")
+}
+
+// directContentType returns the content type of the container if the
+// device can play it, otherwise an empty string.
+func directContentType(info *probe.Info) string {
+	video := info.Video()
+	kind := "audio/"
+	if video != nil {
+		kind = "video/"
+	}
+	switch {
+	case info.IsContainer("mp4", "mov"):
+		return kind + "mp4"
+	case info.IsContainer("webm") && isWebM(info):
+		return kind + "webm"
+	case video != nil:
+		return ""
+	case info.IsContainer("mp3"):
+		return "audio/mpeg"
+	case info.IsContainer("ogg"):
+		return "audio/ogg"
+	case info.IsContainer("wav"):
+		return "audio/wav"
+	case info.IsContainer("flac"):
+		return "audio/flac"
+	case info.IsContainer("aac"):
+		return "audio/aac"
+	}
+	return ""
+}
+
+// isWebM reports whether a matroska file only holds codecs allowed in
+// webm, as ffprobe reports both as "matroska,webm".
+func isWebM(info *probe.Info) bool {
+	for _, s := range info.Streams {
+		switch s.Type {
+		case "video":
+			if s.Codec != "vp8" && s.Codec != "vp9" && s.Codec != "av1" {
+				return false
+			}
+		case "audio":
+			if s.Codec != "opus" && s.Codec != "vorbis" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func canMux(format, codec string) bool {
+	return contains(muxable[format], codec)
+}
@@ -0,0 +1,144 @@
+package device
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Bits of the 'ca' capability bitmask advertised in the mDNS TXT record.
+const (
+	caVideoOut       = 1 << 0
+	caVideoIn        = 1 << 1
+	caAudioOut       = 1 << 2
+	caAudioIn        = 1 << 3
+	caDevMode        = 1 << 4
+	caMultizoneGroup = 1 << 5
+)
+
+// Resolution is a display resolution in pixels.
+type Resolution struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Capabilities describes what a cast device can play.
+type Capabilities struct {
+	Model string `json:"model"`
+	// Known is false when nothing is known about the device, in which case
+	// it is assumed to be a video chromecast.
+	Known          bool `json:"known"`
+	VideoOut       bool `json:"video_out"`
+	AudioOut       bool `json:"audio_out"`
+	MultizoneGroup bool `json:"multizone_group"`
+	// Codecs as named by ffmpeg, e.g. "h264" and "aac".
+	VideoCodecs   []string   `json:"video_codecs"`
+	AudioCodecs   []string   `json:"audio_codecs"`
+	MaxResolution Resolution `json:"max_resolution"`
+}
+
+var (
+	hd  = Resolution{Width: 1920, Height: 1080}
+	uhd = Resolution{Width: 3840, Height: 2160}
+
+	audioCodecs = []string{"aac", "mp3", "opus", "vorbis", "flac"}
+)
+
+// Unknown is assumed for devices that haven't been discovered, and matches
+// a first generation chromecast.
+var Unknown = Capabilities{
+	VideoOut:      true,
+	AudioOut:      true,
+	VideoCodecs:   []string{"h264", "vp8"},
+	AudioCodecs:   audioCodecs,
+	MaxResolution: hd,
+}
+
+// models are the capabilities of known devices, keyed by the lowercase 'md'
+// TXT field.
+var models = map[string]Capabilities{
+	"chromecast":                {VideoOut: true, AudioOut: true, VideoCodecs: []string{"h264", "vp8"}, MaxResolution: hd},
+	"chromecast ultra":          {VideoOut: true, AudioOut: true, VideoCodecs: []string{"h264", "vp8", "vp9", "hevc"}, MaxResolution: uhd},
+	"chromecast hd":             {VideoOut: true, AudioOut: true, VideoCodecs: []string{"h264", "vp8", "vp9", "hevc"}, MaxResolution: hd},
+	"chromecast with google tv": {VideoOut: true, AudioOut: true, VideoCodecs: []string{"h264", "vp8", "vp9", "hevc"}, MaxResolution: uhd},
+	"google tv streamer":        {VideoOut: true, AudioOut: true, VideoCodecs: []string{"h264", "vp8", "vp9", "hevc", "av1"}, MaxResolution: uhd},
+	"google home hub":           {VideoOut: true, AudioOut: true, VideoCodecs: []string{"h264", "vp8", "vp9"}, MaxResolution: Resolution{Width: 1024, Height: 600}},
+	"google nest hub":           {VideoOut: true, AudioOut: true, VideoCodecs: []string{"h264", "vp8", "vp9"}, MaxResolution: Resolution{Width: 1024, Height: 600}},
+	"google nest hub max":       {VideoOut: true, AudioOut: true, VideoCodecs: []string{"h264", "vp8", "vp9"}, MaxResolution: Resolution{Width: 1280, Height: 800}},
+	"chromecast audio":          {AudioOut: true},
+	"google home":               {AudioOut: true},
+	"google home mini":          {AudioOut: true},
+	"google home max":           {AudioOut: true},
+	"google nest mini":          {AudioOut: true},
+	"google nest audio":         {AudioOut: true},
+	"google cast group":         {AudioOut: true, MultizoneGroup: true},
+}
+
+// FromInfoFields derives the capabilities of a device from the TXT fields
+// it advertises over mDNS. The 'ca' bitmask decides what the device can
+// output, and the 'md' model fills in codecs and resolution.
+func FromInfoFields(fields map[string]string) Capabilities {
+	model := fields["md"]
+	c, ok := models[strings.ToLower(model)]
+	if ok {
+		c.Known = true
+	}
+	c.Model = model
+
+	if ca, err := strconv.Atoi(fields["ca"]); err == nil {
+		c.Known = true
+		c.VideoOut = ca&caVideoOut != 0
+		c.AudioOut = ca&caAudioOut != 0
+		c.MultizoneGroup = ca&caMultizoneGroup != 0
+	}
+	if !c.Known {
+		c = Unknown
+		c.Model = model
+		return c
+	}
+
+	if c.AudioOut {
+		c.AudioCodecs = audioCodecs
+	} else {
+		c.AudioCodecs = nil
+	}
+	if !c.VideoOut {
+		c.VideoCodecs = nil
+		c.MaxResolution = Resolution{}
+	} else if len(c.VideoCodecs) == 0 {
+		// A video device missing from the model table.
+		c.VideoCodecs = Unknown.VideoCodecs
+		c.MaxResolution = Unknown.MaxResolution
+	}
+	return c
+}
+
+// SupportsVideoCodec reports whether the device can decode codec.
+func (c Capabilities) SupportsVideoCodec(codec string) bool {
+	return contains(c.VideoCodecs, codec)
+}
+
+// SupportsAudioCodec reports whether the device can decode codec.
+func (c Capabilities) SupportsAudioCodec(codec string) bool {
+	return contains(c.AudioCodecs, codec)
+}
+
+// CanDisplay reports whether the device can play content of contentType at
+// all, going by whether it needs a screen.
+func (c Capabilities) CanDisplay(contentType string) bool {
+	switch {
+	case strings.HasPrefix(contentType, "image/"), strings.HasPrefix(contentType, "video/"):
+		return c.VideoOut
+	case strings.HasPrefix(contentType, "audio/"):
+		return c.AudioOut
+	}
+	return c.VideoOut || c.AudioOut
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
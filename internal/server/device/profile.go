@@ -0,0 +1,70 @@
+package device
+
+import "fmt"
+
+// Profile is the output media is transcoded to for a device.
+type Profile struct {
+	Name        string
+	ContentType string
+	// VideoArgs and AudioArgs are the ffmpeg output codec arguments for
+	// each kind of stream.
+	VideoArgs []string
+	AudioArgs []string
+	// Format is the ffmpeg output format when streaming over HTTP, with
+	// FormatArgs for any options it needs.
+	Format     string
+	FormatArgs []string
+}
+
+// CodecArgs returns the ffmpeg output codec arguments for every stream.
+// Subtitles are dropped, as image based subtitles can't be converted.
+func (p Profile) CodecArgs() []string {
+	args := append([]string{"-sn"}, p.VideoArgs...)
+	return append(args, p.AudioArgs...)
+}
+
+var (
+	// VideoProfile is H.264 and AAC in fragmented mp4, which every video
+	// device can play.
+	VideoProfile = Profile{
+		Name:        "video",
+		ContentType: "video/mp4",
+		VideoArgs:   []string{"-vcodec", "h264"},
+		AudioArgs: []string{
+			"-acodec", "aac",
+			"-ac", "2", // chromecasts don't support more than two audio channels
+		},
+		Format:     "mp4",
+		FormatArgs: []string{"-movflags", "frag_keyframe+faststart"},
+	}
+
+	// AudioProfile is MP3 with any video dropped, for speakers.
+	AudioProfile = Profile{
+		Name:        "audio",
+		ContentType: "audio/mpeg",
+		VideoArgs:   []string{"-vn"},
+		AudioArgs: []string{
+			"-acodec", "libmp3lame",
+			"-ac", "2",
+			"-b:a", "192k",
+		},
+		Format: "mp3",
+	}
+)
+
+// TranscodeProfile returns the profile media should be transcoded to for
+// the device. Video is scaled down to fit the maximum resolution of the
+// device.
+func (c Capabilities) TranscodeProfile() Profile {
+	if !c.VideoOut {
+		return AudioProfile
+	}
+	p := VideoProfile
+	if r := c.MaxResolution; r.Width > 0 && r.Height > 0 {
+		p.VideoArgs = []string{
+			"-vf", fmt.Sprintf("scale=w='min(%d,iw)':h='min(%d,ih)':force_original_aspect_ratio=decrease:force_divisible_by=2", r.Width, r.Height),
+			"-vcodec", "h264",
+		}
+	}
+	return p
+}
@@ -0,0 +1,392 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+
+	application "github.com/avinash240/pusher/internal/server/application"
+	auth "github.com/avinash240/pusher/internal/server/auth"
+	cast "github.com/avinash240/pusher/internal/server/cast"
+	pb "github.com/avinash240/pusher/internal/server/cast/proto"
+	chttp "github.com/avinash240/pusher/internal/server/chttp"
+)
+
+const (
+	// eventBacklog is how many events are kept for clients resuming from
+	// the last event they saw.
+	eventBacklog = 256
+	// eventBuffer is how many events a client may fall behind by before it
+	// is disconnected, to resume once it has caught up.
+	eventBuffer = 64
+	// DefaultEventHeartbeat is how often idle clients are sent a comment,
+	// so the connection isn't closed by proxies along the way, unless
+	// configured otherwise.
+	DefaultEventHeartbeat = 15 * time.Second
+	// eventRetry is how long clients wait before reconnecting.
+	eventRetry = 3 * time.Second
+)
+
+// Types of the events sent.
+const (
+	eventStatus       = "status"
+	eventMediaStatus  = "media_status"
+	eventVolume       = "volume"
+	eventApplication  = "application"
+	eventConnected    = "connected"
+	eventDisconnected = "disconnected"
+	eventLoadFailed   = "load_failed"
+)
+
+// volumeFields are the fields of a StatusResponse sent as volume events,
+// rather than application events, when the receiver status changes.
+var volumeFields = map[string]bool{"volume_level": true, "volume_muted": true}
+
+// deviceEvents is what is known of a device from the messages it sends,
+// and the status last sent for it.
+type deviceEvents struct {
+	app    *cast.Application
+	media  *cast.Media
+	volume *cast.Volume
+	status map[string]interface{}
+}
+
+// eventSubscriber is a client following the events of uuids, or of every
+// device if there are none.
+type eventSubscriber struct {
+	uuids  map[string]bool
+	events chan chttp.Event
+	// dropped is closed once the subscriber has fallen too far behind.
+	dropped chan struct{}
+}
+
+func (s *eventSubscriber) follows(uuid string) bool {
+	return len(s.uuids) == 0 || s.uuids[uuid]
+}
+
+// eventHub sends the changes to connected devices to every subscriber.
+// Each event has an id, and the last eventBacklog are kept so subscribers
+// can resume from the last one they saw.
+type eventHub struct {
+	mu          sync.Mutex
+	lastID      uint64
+	backlog     []chttp.Event
+	devices     map[string]*deviceEvents
+	subscribers map[*eventSubscriber]struct{}
+	done        chan struct{}
+	closed      bool
+	// How often idle streams are sent a heartbeat.
+	heartbeat time.Duration
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		heartbeat:   DefaultEventHeartbeat,
+		devices:     map[string]*deviceEvents{},
+		subscribers: map[*eventSubscriber]struct{}{},
+		done:        make(chan struct{}),
+	}
+}
+
+// publish sends ev to every subscriber following its device. It must be
+// called holding e.mu.
+func (e *eventHub) publish(ev chttp.Event) {
+	e.lastID++
+	ev.ID = e.lastID
+	ev.Time = time.Now()
+	e.backlog = append(e.backlog, ev)
+	if len(e.backlog) > eventBacklog {
+		e.backlog = e.backlog[len(e.backlog)-eventBacklog:]
+	}
+
+	for s := range e.subscribers {
+		if !s.follows(ev.UUID) {
+			continue
+		}
+		select {
+		case s.events <- ev:
+		default:
+			close(s.dropped)
+			delete(e.subscribers, s)
+		}
+	}
+}
+
+// subscribe returns a subscriber following uuids, and the events it is to
+// be sent first. Those are the events after lastID when they are still
+// kept, or the status of every device followed otherwise.
+func (e *eventHub) subscribe(uuids []string, lastID string) (*eventSubscriber, []chttp.Event) {
+	s := &eventSubscriber{
+		uuids:   map[string]bool{},
+		events:  make(chan chttp.Event, eventBuffer),
+		dropped: make(chan struct{}),
+	}
+	for _, uuid := range uuids {
+		s.uuids[uuid] = true
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.subscribers[s] = struct{}{}
+
+	var first []chttp.Event
+	id, err := strconv.ParseUint(lastID, 10, 64)
+	resumed := err == nil && id <= e.lastID &&
+		(len(e.backlog) == 0 || id+1 >= e.backlog[0].ID)
+	if resumed {
+		for _, ev := range e.backlog {
+			if ev.ID > id && s.follows(ev.UUID) {
+				first = append(first, ev)
+			}
+		}
+		return s, first
+	}
+
+	// The events missed aren't known, so the client starts over from the
+	// status of every device. These events have no id, so the client
+	// resumes from the last event it was sent with one.
+	for uuid, d := range e.devices {
+		if s.follows(uuid) {
+			first = append(first, chttp.Event{Type: eventStatus, UUID: uuid, Time: time.Now(), Status: d.status})
+		}
+	}
+	return s, first
+}
+
+func (e *eventHub) unsubscribe(s *eventSubscriber) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.subscribers, s)
+}
+
+// close ends the streams of every subscriber.
+func (e *eventHub) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.closed {
+		e.closed = true
+		close(e.done)
+	}
+}
+
+// connected follows the messages of app, the device uuid, sending the
+// changes they make to its status.
+func (e *eventHub) connected(uuid string, app *application.Application) {
+	castApplication, castMedia, castVolume := app.Status()
+	d := &deviceEvents{app: castApplication, media: castMedia, volume: castVolume}
+	d.status = statusFields(chttp.FromApplicationStatus(d.app, d.media, d.volume))
+
+	e.mu.Lock()
+	e.devices[uuid] = d
+	e.publish(chttp.Event{Type: eventConnected, UUID: uuid, Status: d.status})
+	e.mu.Unlock()
+
+	// Message funcs are called holding the lock AddMessageFunc takes, so it
+	// isn't called holding e.mu, which they take.
+	app.AddMessageFunc(func(msg *pb.CastMessage) {
+		e.message(uuid, msg)
+	})
+}
+
+// disconnected stops sending events for the device uuid.
+func (e *eventHub) disconnected(uuid string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.devices[uuid]; !ok {
+		return
+	}
+	delete(e.devices, uuid)
+	e.publish(chttp.Event{Type: eventDisconnected, UUID: uuid})
+}
+
+// loadFailed sends the failure to load media on the device uuid.
+func (e *eventHub) loadFailed(uuid string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.devices[uuid]; !ok {
+		return
+	}
+	e.publish(chttp.Event{Type: eventLoadFailed, UUID: uuid, Error: err.Error()})
+}
+
+// message sends the changes msg, from the device uuid, makes to its status.
+func (e *eventHub) message(uuid string, msg *pb.CastMessage) {
+	payload := []byte(msg.GetPayloadUtf8())
+	messageType, _ := jsonparser.GetString(payload, "type")
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	d, ok := e.devices[uuid]
+	if !ok {
+		return
+	}
+
+	switch messageType {
+	case "MEDIA_STATUS":
+		resp := cast.MediaStatusResponse{}
+		if err := json.Unmarshal(payload, &resp); err != nil {
+			return
+		}
+		var media *cast.Media
+		for _, status := range resp.Status {
+			status := status
+			// Devices only describe the media when it changes.
+			if status.Media.ContentId == "" && d.media != nil && d.media.MediaSessionId == status.MediaSessionId {
+				status.Media = d.media.Media
+			}
+			media = &status
+		}
+		d.media = media
+		e.changed(uuid, d, func(field string) string { return eventMediaStatus })
+	case "RECEIVER_STATUS":
+		resp := cast.ReceiverStatusResponse{}
+		if err := json.Unmarshal(payload, &resp); err != nil {
+			return
+		}
+		d.app = nil
+		for _, app := range resp.Status.Applications {
+			app := app
+			d.app = &app
+		}
+		volume := resp.Status.Volume
+		d.volume = &volume
+		e.changed(uuid, d, func(field string) string {
+			if volumeFields[field] {
+				return eventVolume
+			}
+			return eventApplication
+		})
+	case "LOAD_FAILED":
+		msg := "device failed to load media"
+		if reason, _ := jsonparser.GetString(payload, "reason"); reason != "" {
+			msg += ": " + reason
+		}
+		e.publish(chttp.Event{Type: eventLoadFailed, UUID: uuid, Error: msg})
+	}
+}
+
+// reportLoad returns op, sending its failure to load media as an event.
+func (h *Handler) reportLoad(op deviceOp) deviceOp {
+	return func(uuid string, app *application.Application) error {
+		err := op(uuid, app)
+		if err != nil {
+			h.events.loadFailed(uuid, err)
+		}
+		return err
+	}
+}
+
+// changed sends the fields of the status of d that have changed since it
+// was last sent, as events of the type eventType returns for each field.
+// It must be called holding e.mu.
+func (e *eventHub) changed(uuid string, d *deviceEvents, eventType func(field string) string) {
+	status := statusFields(chttp.FromApplicationStatus(d.app, d.media, d.volume))
+	deltas := map[string]map[string]interface{}{}
+	var types []string
+	for field, v := range status {
+		if old, ok := d.status[field]; ok && reflect.DeepEqual(old, v) {
+			continue
+		}
+		t := eventType(field)
+		if deltas[t] == nil {
+			deltas[t] = map[string]interface{}{}
+			types = append(types, t)
+		}
+		deltas[t][field] = v
+	}
+	d.status = status
+	sort.Strings(types)
+	for _, t := range types {
+		e.publish(chttp.Event{Type: t, UUID: uuid, Status: deltas[t]})
+	}
+}
+
+// statusFields returns the fields of status by their json names.
+func statusFields(status chttp.StatusResponse) map[string]interface{} {
+	fields := map[string]interface{}{}
+	b, err := json.Marshal(status)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(b, &fields)
+	return fields
+}
+
+// writeEvent writes ev as a server-sent event.
+func writeEvent(w io.Writer, ev chttp.Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if ev.ID != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", ev.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+	return err
+}
+
+// streamEvents streams the events of the devices 'uuid', or of every
+// device, as server-sent events. Clients resume from the event in the
+// Last-Event-ID header, or the 'last_event_id' query param.
+func (h *Handler) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, errors.New("streaming isn't supported"))
+		return
+	}
+	q := r.URL.Query()
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = q.Get("last_event_id")
+	}
+
+	s, first := h.events.subscribe(q["uuid"], lastID)
+	defer h.events.unsubscribe(s)
+	log.Printf("streaming events to %s", auth.Caller(r))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry.Milliseconds())
+	for _, ev := range first {
+		if err := writeEvent(w, ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(h.events.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case ev := <-s.events:
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-s.dropped:
+			log.Printf("%s fell behind on events, disconnecting", auth.Caller(r))
+			return
+		case <-h.events.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/avinash240/pusher/internal/scheduler"
+	application "github.com/avinash240/pusher/internal/server/application"
+	chttp "github.com/avinash240/pusher/internal/server/chttp"
+)
+
+// groupConnectTimeout is how long a group operation may spend discovering
+// and connecting to members that aren't connected.
+const groupConnectTimeout = time.Minute
+
+// deviceOp is a control operation on the device uuid.
+type deviceOp func(uuid string, app *application.Application) error
+
+// group returns the members of the group name.
+func (h *Handler) group(name string) ([]string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	members, ok := h.groups[name]
+	return members, ok
+}
+
+// errGroupNotFound is returned for groups that don't exist.
+var errGroupNotFound = errors.New("group doesn't exist")
+
+// fanOut runs op on every member of group, and writes the result of each.
+// The response is an error only if op failed for every member.
+func (h *Handler) fanOut(w http.ResponseWriter, group, what string, connect bool, op deviceOp) {
+	res, err := h.runOnGroup(group, what, connect, op)
+	if err == errGroupNotFound {
+		httpValidationError(w, fmt.Sprintf("group %s doesn't exist", group))
+		return
+	}
+	if err != nil {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	writeJSON(w, res, "group results")
+}
+
+// runOnGroup runs op on every member of group at once, connecting to
+// members that aren't connected if connect is set, and returns the result
+// of each. It returns an error as well if op failed for every member.
+func (h *Handler) runOnGroup(group, what string, connect bool, op deviceOp) (chttp.GroupResponse, error) {
+	members, ok := h.group(group)
+	if !ok {
+		return chttp.GroupResponse{}, errGroupNotFound
+	}
+	log.Printf("%s group %s", what, group)
+
+	ctx, cancel := context.WithTimeout(context.Background(), groupConnectTimeout)
+	defer cancel()
+
+	results := make([]chttp.MemberResult, 0, len(members))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, member := range members {
+		wg.Add(1)
+		go func(member string) {
+			defer wg.Done()
+			memberResults := h.runOnMember(ctx, member, what, connect, op)
+			mu.Lock()
+			results = append(results, memberResults...)
+			mu.Unlock()
+		}(member)
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Member != results[j].Member {
+			return results[i].Member < results[j].Member
+		}
+		return results[i].UUID < results[j].UUID
+	})
+
+	res := chttp.GroupResponse{Group: group, Results: results}
+	for _, r := range results {
+		if r.OK {
+			return res, nil
+		}
+	}
+	if len(results) == 0 {
+		return res, nil
+	}
+	return res, fmt.Errorf("unable to %s any member of group %s", what, group)
+}
+
+// runOnMember runs op on the devices member names, which is usually one.
+func (h *Handler) runOnMember(ctx context.Context, member, what string, connect bool, op deviceOp) []chttp.MemberResult {
+	apps, err := h.deviceApps(ctx, member, connect)
+	if err == nil && len(apps) == 0 {
+		err = fmt.Errorf("device %s is not connected", member)
+	}
+	if err != nil {
+		log.Printf("unable to %s group member %s: %v", what, member, err)
+		return []chttp.MemberResult{{Member: member, Error: err.Error()}}
+	}
+
+	var results []chttp.MemberResult
+	for uuid, app := range apps {
+		res := chttp.MemberResult{Member: member, UUID: uuid, OK: true}
+		err := op(uuid, app)
+		if err != nil {
+			log.Printf("unable to %s device %s: %v", what, uuid, err)
+			res.OK, res.Error = false, err.Error()
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// groupList returns every group, by name.
+func (h *Handler) groupList() []chttp.Group {
+	h.mu.Lock()
+	groups := make([]chttp.Group, 0, len(h.groups))
+	for name, members := range h.groups {
+		groups = append(groups, chttp.Group{Name: name, Members: members})
+	}
+	h.mu.Unlock()
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// setGroupMembers creates the group name, or replaces its members.
+func (h *Handler) setGroupMembers(name string, members []string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.checkGroup(name, members); err != nil {
+		return err
+	}
+	h.groups[name] = members
+	log.Printf("set group %s to %v", name, members)
+	return nil
+}
+
+// checkGroup returns why name can't be a group of members, or nil if it
+// can. h.mu must be held.
+func (h *Handler) checkGroup(name string, members []string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("a group needs a name")
+	}
+	if name == scheduler.AllDevices {
+		return fmt.Errorf("%q can't be used as a group name", name)
+	}
+	if len(members) == 0 {
+		return errors.New("a group needs at least one member")
+	}
+	for _, member := range members {
+		if _, ok := h.groups[member]; ok {
+			return fmt.Errorf("member %s is a group, groups can't be nested", member)
+		}
+	}
+	return nil
+}
+
+// removeGroup removes the group name.
+func (h *Handler) removeGroup(name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.groups[name]; !ok {
+		return errGroupNotFound
+	}
+	delete(h.groups, name)
+	log.Printf("deleted group %s", name)
+	return nil
+}
+
+func (h *Handler) listGroups(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.groupList(), "groups")
+}
+
+// setGroup creates the group 'name', or replaces its members.
+func (h *Handler) setGroup(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("name")
+	if name == "" {
+		httpValidationError(w, "missing 'name' in query paramater")
+		return
+	}
+	members := q["member"]
+	if len(members) == 0 {
+		httpValidationError(w, "missing 'member' in query params")
+		return
+	}
+	if err := h.setGroupMembers(name, members); err != nil {
+		httpValidationError(w, err.Error())
+		return
+	}
+	writeJSON(w, chttp.Group{Name: name, Members: members}, "group")
+}
+
+func (h *Handler) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if err := h.removeGroup(name); err != nil {
+		httpValidationError(w, fmt.Sprintf("group %s doesn't exist", name))
+		return
+	}
+	fmt.Fprintf(w, "Deleted group %s\n", name)
+}
@@ -2,26 +2,174 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/avinash240/pusher/internal/scheduler"
 	application "github.com/avinash240/pusher/internal/server/application"
+	auth "github.com/avinash240/pusher/internal/server/auth"
 	chttp "github.com/avinash240/pusher/internal/server/chttp"
+	dev "github.com/avinash240/pusher/internal/server/device"
 	dns "github.com/avinash240/pusher/internal/server/dns"
+	media "github.com/avinash240/pusher/internal/server/media"
+	ui "github.com/avinash240/pusher/internal/server/ui"
+	live "github.com/avinash240/pusher/internal/streaming/live"
+	probe "github.com/avinash240/pusher/internal/streaming/probe"
+	"github.com/avinash240/pusher/internal/transcode"
 )
 
+// DefaultMaxTranscodes is the number of transcoder processes allowed to run
+// at once across all devices unless configured otherwise.
+const DefaultMaxTranscodes = 2
+
+// errShuttingDown is returned when connecting devices once Shutdown has
+// been called.
+var errShuttingDown = errors.New("server is shutting down")
+
 // Application Handler
 type Handler struct {
 	mu      sync.Mutex
 	apps    map[string]*application.Application
 	mux     *http.ServeMux
+	server  *http.Server
 	verbose bool
+	// No more devices are connected once 'shuttingDown' is set.
+	shuttingDown bool
+	// Connections in progress to devices that weren't connected, keyed by
+	// uuid, which others needing the device wait for.
+	connecting map[string]*pendingConnect
+
+	// Requests are authorised by 'auth' for the scope of their route, and
+	// pass through 'middleware', outermost first, before being routed.
+	auth       *auth.Authenticator
+	middleware []Middleware
+	handler    http.Handler
+
+	// The API is served over TLS with 'tlsConfig' when it is set.
+	tlsConfig *tls.Config
+	// Pages from 'allowedOrigins', besides those served by the host the
+	// API is reached at, may open the control socket.
+	allowedOrigins []string
+
+	maxTranscodes int
+	transcoder    *transcode.Manager
+	// Completed HLS output is kept in 'hlsCacheDir' for every device, when
+	// it is set.
+	hlsCacheDir string
+
+	// Every connected device serves its media from a namespace on the
+	// same media server.
+	media *media.Server
+	// Media loaded by the local media server, listed for the web remote.
+	library *Library
+
+	// Capabilities of every device discovered so far, keyed by uuid.
+	capabilities map[string]dev.Capabilities
+	// The uuids of every device discovered so far, keyed by their friendly
+	// and mdns names.
+	uuids map[string]string
+
+	// Probe results are shared by every device.
+	prober *probe.Prober
+
+	// Live sources any device can play, keyed by name.
+	liveSpecs []live.Spec
+	live      map[string]*live.Stream
+
+	// How long sleep timers fade the volume out for unless told otherwise.
+	sleepFade time.Duration
+
+	// Scheduled actions, persisted to 'schedulePath'.
+	schedulePath string
+	scheduler    *scheduler.Scheduler
+
+	// Routes registered on mux, besides version 1 of the API which is
+	// served under apiPrefix, and the handler of each method they are
+	// served for, keyed by pattern.
+	routes  []Route
+	methods map[string]map[string]http.Handler
+	api     *apiRouter
+
+	// Groups of devices controlled together, keyed by group name. Members
+	// are device uuids or friendly names.
+	groups map[string][]string
+
+	// Devices playing in step, keyed by group name, and how they are kept
+	// in step.
+	syncGroups    map[string]*application.SyncGroup
+	syncInterval  time.Duration
+	syncTolerance time.Duration
+
+	// Changes to connected devices, streamed to clients.
+	events *eventHub
+}
+
+type HandlerOption func(*Handler)
+
+// WithMaxTranscodes limits the number of transcoder processes running at
+// once across all devices. Zero or less means no limit.
+func WithMaxTranscodes(n int) HandlerOption {
+	return func(h *Handler) {
+		h.maxTranscodes = n
+	}
+}
+
+// WithHLSCacheDir keeps completed HLS output in dir, so it is reused by
+// every device and across restarts. Devices playing the same file share
+// its output while it is segmented. Output is removed with each session
+// unless it is set.
+func WithHLSCacheDir(dir string) HandlerOption {
+	return func(h *Handler) {
+		h.hlsCacheDir = dir
+	}
+}
+
+// Middleware wraps the handler requests are served by.
+type Middleware func(http.Handler) http.Handler
+
+// WithMiddleware passes every request through mw, the first outermost,
+// before it is routed.
+func WithMiddleware(mw ...Middleware) HandlerOption {
+	return func(h *Handler) {
+		h.middleware = append(h.middleware, mw...)
+	}
+}
+
+// WithAuth only serves requests made with a token a authenticates, and
+// that has the scope their route needs. Every request is served unless it
+// is set.
+func WithAuth(a *auth.Authenticator) HandlerOption {
+	return func(h *Handler) {
+		h.auth = a
+	}
+}
+
+// WithTLSConfig serves the API over TLS with c, which needs to provide the
+// certificate, such as by its GetCertificate.
+func WithTLSConfig(c *tls.Config) HandlerOption {
+	return func(h *Handler) {
+		h.tlsConfig = c
+	}
+}
+
+// WithAllowedOrigins lets pages from origins, such as
+// "https://remote.example.com", open the control socket as well as pages
+// served from the host the API is reached at.
+func WithAllowedOrigins(origins ...string) HandlerOption {
+	return func(h *Handler) {
+		h.allowedOrigins = append(h.allowedOrigins, origins...)
+	}
 }
 
 // Device info data structure
@@ -33,67 +181,423 @@ type device struct {
 	Host string `json:"host"`
 
 	UUID       string            `json:"uuid"`
-	Device     string            `json:'device_type"`
+	Device     string            `json:"device_type"`
 	Status     string            `json:"status"`
-	DeviceName string            `json"device_name"`
-	InfoFields map[string]string `json"info_fields"`
+	DeviceName string            `json:"device_name"`
+	InfoFields map[string]string `json:"info_fields"`
+
+	Capabilities dev.Capabilities `json:"capabilities"`
+}
+
+// WithMediaServer serves media for every device from s instead of the
+// shared default media server.
+func WithMediaServer(s *media.Server) HandlerOption {
+	return func(h *Handler) {
+		h.media = s
+	}
+}
+
+// WithLibrary lists the media loaded by the local media server sharing l,
+// as NewLocalServer does with WithLocalServerLibrary.
+func WithLibrary(l *Library) HandlerOption {
+	return func(h *Handler) {
+		h.library = l
+	}
 }
 
-func NewHandler(verbose bool) *Handler {
+// WithLiveSources makes the live sources described by specs available to
+// every device.
+func WithLiveSources(specs ...live.Spec) HandlerOption {
+	return func(h *Handler) {
+		h.liveSpecs = append(h.liveSpecs, specs...)
+	}
+}
+
+// WithSleepFade fades the volume out over d before sleep timers go off,
+// unless a timer is set with a fade of its own.
+func WithSleepFade(d time.Duration) HandlerOption {
+	return func(h *Handler) {
+		h.sleepFade = d
+	}
+}
+
+// WithSchedulePath persists schedules and their history to path. Schedules
+// aren't persisted unless it is set.
+func WithSchedulePath(path string) HandlerOption {
+	return func(h *Handler) {
+		h.schedulePath = path
+	}
+}
+
+// WithGroups adds groups of devices that can be controlled together, keyed
+// by group name. Members are device uuids or friendly names. Groups without
+// a name, or named the same as a group already added, are skipped.
+func WithGroups(groups map[string][]string) HandlerOption {
+	return func(h *Handler) {
+		names := make([]string, 0, len(groups))
+		for name := range groups {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			err := h.checkGroup(name, groups[name])
+			if _, ok := h.groups[name]; ok && err == nil {
+				err = errors.New("a group with the same name was already added")
+			}
+			for _, member := range groups[name] {
+				if _, ok := groups[member]; ok && err == nil {
+					err = fmt.Errorf("member %s is a group, groups can't be nested", member)
+				}
+			}
+			if err != nil {
+				log.Printf("skipping group %q: %v", name, err)
+				continue
+			}
+			h.groups[name] = groups[name]
+		}
+	}
+}
+
+// WithEventHeartbeat sends idle event streams a heartbeat every d.
+// Intervals that aren't positive keep DefaultEventHeartbeat.
+func WithEventHeartbeat(d time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if d > 0 {
+			h.events.heartbeat = d
+		}
+	}
+}
+
+// WithSyncDrift checks sync groups for drift every interval, and seeks the
+// devices that have drifted by more than tolerance. Intervals that aren't
+// positive keep DefaultSyncInterval.
+func WithSyncDrift(interval, tolerance time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if interval > 0 {
+			h.syncInterval = interval
+		}
+		h.syncTolerance = tolerance
+	}
+}
+
+func NewHandler(verbose bool, opts ...HandlerOption) *Handler {
 	handler := &Handler{
-		verbose: verbose,
-		apps:    map[string]*application.Application{},
-		mux:     http.NewServeMux(),
-		mu:      sync.Mutex{},
+		verbose:       verbose,
+		apps:          map[string]*application.Application{},
+		connecting:    map[string]*pendingConnect{},
+		methods:       map[string]map[string]http.Handler{},
+		mux:           http.NewServeMux(),
+		mu:            sync.Mutex{},
+		maxTranscodes: DefaultMaxTranscodes,
+		capabilities:  map[string]dev.Capabilities{},
+		uuids:         map[string]string{},
+		groups:        map[string][]string{},
+		sleepFade:     application.DefaultSleepFade,
+		syncGroups:    map[string]*application.SyncGroup{},
+		syncInterval:  application.DefaultSyncInterval,
+		syncTolerance: application.DefaultSyncTolerance,
+		events:        newEventHub(),
+	}
+	for _, o := range opts {
+		o(handler)
+	}
+	if handler.media == nil {
+		handler.media = media.Default()
+	}
+	if handler.library == nil {
+		handler.library = NewLibrary()
+	}
+	handler.transcoder = transcode.NewManager(handler.maxTranscodes)
+	handler.prober = probe.NewProber()
+	handler.live = map[string]*live.Stream{}
+	for _, spec := range handler.liveSpecs {
+		stream, err := spec.NewStream(handler.transcoder)
+		if err != nil {
+			log.Printf("skipping live source: %v", err)
+			continue
+		}
+		handler.live[spec.Name] = stream
 	}
+	sched, err := scheduler.New(handler.schedulePath, scheduleRunner{handler})
+	if err != nil {
+		// The scheduler refuses changes rather than overwrite schedules
+		// that can't be read.
+		log.Printf("schedules can't be changed until they load: %v", err)
+	}
+	handler.scheduler = sched
+	handler.scheduler.Start()
 	handler.registerHandlers()
+	handler.handler = handler.mux
+	for i := len(handler.middleware) - 1; i >= 0; i-- {
+		handler.handler = handler.middleware[i](handler.handler)
+	}
 	return handler
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.mux.ServeHTTP(w, r)
+	h.handler.ServeHTTP(w, r)
 }
 
+// Serve serves the API on addr, over TLS when it is configured, until
+// Shutdown is called, when it returns http.ErrServerClosed.
 func (h *Handler) Serve(addr string) error {
+	server := &http.Server{Addr: addr, Handler: h, TLSConfig: h.tlsConfig}
+	h.mu.Lock()
+	h.server = server
+	h.mu.Unlock()
+	if h.tlsConfig != nil {
+		log.Printf("starting https server on %s", addr)
+		return server.ListenAndServeTLS("", "")
+	}
 	log.Printf("starting http server on %s", addr)
-	return http.ListenAndServe(addr, h)
+	return server.ListenAndServe()
+}
+
+// Shutdown closes every connected application, stopping their media first
+// if stopMedia is set, stops serving the API, shuts down the media server
+// and waits for transcoders to exit. Applications are closed before waiting
+// on requests in flight, so slow requests can't use up ctx before devices
+// are disconnected. It stops waiting once ctx is done.
+func (h *Handler) Shutdown(ctx context.Context, stopMedia bool) error {
+	var errs []string
+
+	h.mu.Lock()
+	h.shuttingDown = true
+	server := h.server
+	apps := h.apps
+	h.apps = map[string]*application.Application{}
+	syncGroups := h.syncGroups
+	h.syncGroups = map[string]*application.SyncGroup{}
+	h.mu.Unlock()
+
+	// Event streams never go idle, so they are ended for the server to
+	// shut down.
+	h.events.close()
+
+	if err := h.scheduler.Stop(ctx); err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, g := range syncGroups {
+		g.Close()
+	}
+
+	closeErrs := make(chan error, len(apps))
+	var wg sync.WaitGroup
+	for uuid, app := range apps {
+		wg.Add(1)
+		go func(uuid string, app *application.Application) {
+			defer wg.Done()
+			log.Printf("disconnecting device %s", uuid)
+			if err := app.Close(stopMedia); err != nil {
+				closeErrs <- fmt.Errorf("device %s: %v", uuid, err)
+			}
+		}(uuid, app)
+	}
+	closed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		errs = append(errs, "devices: timed out disconnecting")
+	}
+	for len(closeErrs) > 0 {
+		errs = append(errs, (<-closeErrs).Error())
+	}
+
+	if server != nil {
+		log.Printf("shutting down http server")
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("http server: %v", err))
+			server.Close()
+		}
+	}
+
+	for _, stream := range h.live {
+		stream.Close()
+	}
+
+	log.Printf("shutting down media server")
+	if err := h.media.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Sprintf("media server: %v", err))
+	}
+
+	h.transcoder.StopAll()
+	if err := h.transcoder.Wait(ctx); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to shut down cleanly: %s", strings.Join(errs, "; "))
+	}
+	return nil
 }
 
 func (h *Handler) registerHandlers() {
+	// Each route is registered with the scope tokens need to use it, once
+	// tokens are needed.
 	/*
 		GET /devices
-		POST /connect?uuid=<device_uuid>&addr=<device_addr>&port=<device_port>
-		POST /disconnect?uuid=<device_uuid>
-		POST /disconnect-all
+		POST /connect?uuid=<device_uuid>&addr=<device_addr>&port=<device_port>&hls=<bool>&image_width=<int>&image_height=<int>
+		POST /disconnect?uuid=<device_uuid>|group=<group_name>&stop=<bool>
+		POST /disconnect-all?stop=<bool>
 		POST /status?uuid=<device_uuid>
-		POST /pause?uuid=<device_uuid>
-		POST /unpause?uuid=<device_uuid>
-		POST /mute?uuid=<device_uuid>
-		POST /unmute?uuid=<device_uuid>
-		POST /stop?uuid=<device_uuid>
+		POST /pause?uuid=<device_uuid>|group=<group_name>
+		POST /unpause?uuid=<device_uuid>|group=<group_name>
+		POST /mute?uuid=<device_uuid>|group=<group_name>
+		POST /unmute?uuid=<device_uuid>|group=<group_name>
+		POST /stop?uuid=<device_uuid>|group=<group_name>
 		GET /volume?uuid=<device_uuid>
-		POST /volume?uuid=<device_uuid>&volume=<float>
-		POST /rewind?uuid=<device_uuid>&seconds=<int>
-		POST /seek?uuid=<device_uuid>&seconds=<int>
-		POST /seek-to?uuid=<device_uuid>&seconds=<float>
-		POST /load?uuid=<device_uuid>&path=<filepath_or_url>&content_type=<string>
+		POST /volume?uuid=<device_uuid>|group=<group_name>&volume=<float>
+		POST /rewind?uuid=<device_uuid>|group=<group_name>&seconds=<int>
+		POST /seek?uuid=<device_uuid>|group=<group_name>&seconds=<int>
+		POST /seek-to?uuid=<device_uuid>|group=<group_name>&seconds=<float>
+		POST /next?uuid=<device_uuid>|group=<group_name>
+		POST /previous?uuid=<device_uuid>|group=<group_name>
+		POST /skip?uuid=<device_uuid>|group=<group_name>
+		POST /load?uuid=<device_uuid>|group=<group_name>&path=<filepath_url_or_playlist>&content_type=<string>&relay=<bool>&reload_metadata=<bool>&allow_any_client=<bool>
+		POST /announce?uuid=<device_uuid>[&uuid=<device_uuid>...]&path=<filepath_or_url>&content_type=<string>&volume=<float>
+		GET /groups
+		POST /groups/set?name=<group_name>&member=<device_uuid_or_name>[&member=<device_uuid_or_name>...]
+		POST /groups/delete?name=<group_name>
+		GET /sync
+		POST /sync/play?name=<group_name>[&uuid=<device_uuid>&uuid=<device_uuid>...]&path=<filepath_or_url>&content_type=<string>
+		POST /sync/pause?name=<group_name>
+		POST /sync/unpause?name=<group_name>
+		POST /sync/stop?name=<group_name>
+		POST /slideshow?uuid=<device_uuid>&path=<filepath>[&path=<filepath>...]&duration=<int>&repeat=<bool>
+		GET /transcodes
+		POST /transcodes/stop?id=<job_id>
+		POST /sleep?uuid=<device_uuid>&minutes=<int>|until=<end_of_item|end_of_queue>&action=<stop|pause>&fade=<seconds>
+		POST /sleep/extend?uuid=<device_uuid>&minutes=<int>
+		POST /sleep/cancel?uuid=<device_uuid>
+		GET /schedules
+		POST /schedules/add (json schedule)
+		POST /schedules/update?id=<schedule_id> (json schedule)
+		POST /schedules/delete?id=<schedule_id>
+		POST /schedules/run?id=<schedule_id>
+		GET /schedules/history?id=<schedule_id>
+		GET /live
+		POST /live/load?uuid=<device_uuid>&source=<live_source_name>
+		GET /events?uuid=<device_uuid>[&uuid=<device_uuid>...]&last_event_id=<event_id> (server-sent events)
+		GET /ws?uuid=<device_uuid>[&uuid=<device_uuid>...]&last_event_id=<event_id> (websocket, json chttp.Command and chttp.SocketMessage)
+		GET /openapi.json
+		GET /openapi/media.json
+		GET /docs
+		GET /ui/ (web remote control)
 	*/
 
-	h.mux.HandleFunc("/devices", h.listDevices)
-	h.mux.HandleFunc("/connect", h.connect)
-	h.mux.HandleFunc("/disconnect", h.disconnect)
-	// h.mux.HandleFunc("/disconnect-all", h.disconnectAll)
-	// h.mux.HandleFunc("/status", h.status)
-	// h.mux.HandleFunc("/pause", h.pause)
-	// h.mux.HandleFunc("/unpause", h.unpause)
-	// h.mux.HandleFunc("/mute", h.mute)
-	// h.mux.HandleFunc("/unmute", h.unmute)
-	// h.mux.HandleFunc("/stop", h.stop)
-	// h.mux.HandleFunc("/volume", h.volume)
-	// h.mux.HandleFunc("/rewind", h.rewind)
-	// h.mux.HandleFunc("/seek", h.seek)
-	// h.mux.HandleFunc("/seek-to", h.seekTo)
-	h.mux.HandleFunc("/load", h.load)
+	h.handle(http.MethodGet, "/devices", auth.Read, h.listDevices)
+	h.handle(http.MethodPost, "/connect", auth.Admin, h.connect)
+	h.handle(http.MethodPost, "/disconnect", auth.Admin, h.disconnect)
+	h.handle(http.MethodPost, "/disconnect-all", auth.Admin, h.disconnectAll)
+	h.handle(http.MethodPost, "/status", auth.Read, h.status)
+	h.handle(http.MethodPost, "/pause", auth.Control, h.pause)
+	h.handle(http.MethodPost, "/unpause", auth.Control, h.unpause)
+	h.handle(http.MethodPost, "/mute", auth.Control, h.mute)
+	h.handle(http.MethodPost, "/unmute", auth.Control, h.unmute)
+	h.handle(http.MethodPost, "/stop", auth.Control, h.stop)
+	h.handle(http.MethodGet, "/volume", auth.Control, h.volume)
+	h.handle(http.MethodPost, "/volume", auth.Control, h.volume)
+	h.handle(http.MethodPost, "/rewind", auth.Control, h.rewind)
+	h.handle(http.MethodPost, "/seek", auth.Control, h.seek)
+	h.handle(http.MethodPost, "/seek-to", auth.Control, h.seekTo)
+	h.handle(http.MethodPost, "/next", auth.Control, h.next)
+	h.handle(http.MethodPost, "/previous", auth.Control, h.previous)
+	h.handle(http.MethodPost, "/skip", auth.Control, h.skip)
+	h.handle(http.MethodPost, "/load", auth.Admin, h.load)
+	h.handle(http.MethodPost, "/announce", auth.Admin, h.announce)
+	h.handle(http.MethodGet, "/groups", auth.Read, h.listGroups)
+	h.handle(http.MethodPost, "/groups/set", auth.Admin, h.setGroup)
+	h.handle(http.MethodPost, "/groups/delete", auth.Admin, h.deleteGroup)
+	h.handle(http.MethodGet, "/sync", auth.Read, h.listSyncGroups)
+	h.handle(http.MethodPost, "/sync/play", auth.Admin, h.playSync)
+	h.handle(http.MethodPost, "/sync/pause", auth.Control, h.pauseSync)
+	h.handle(http.MethodPost, "/sync/unpause", auth.Control, h.unpauseSync)
+	h.handle(http.MethodPost, "/sync/stop", auth.Control, h.stopSync)
+	h.handle(http.MethodPost, "/slideshow", auth.Admin, h.slideshow)
+	h.handle(http.MethodGet, "/transcodes", auth.Read, h.listTranscodes)
+	h.handle(http.MethodPost, "/transcodes/stop", auth.Control, h.stopTranscode)
+	h.handle(http.MethodPost, "/sleep", auth.Control, h.sleep)
+	h.handle(http.MethodPost, "/sleep/extend", auth.Control, h.extendSleep)
+	h.handle(http.MethodPost, "/sleep/cancel", auth.Control, h.cancelSleep)
+	h.handle(http.MethodGet, "/schedules", auth.Read, h.listSchedules)
+	h.handle(http.MethodPost, "/schedules/add", auth.Admin, h.addSchedule)
+	h.handle(http.MethodPost, "/schedules/update", auth.Admin, h.updateSchedule)
+	h.handle(http.MethodPost, "/schedules/delete", auth.Admin, h.deleteSchedule)
+	h.handle(http.MethodPost, "/schedules/run", auth.Admin, h.runSchedule)
+	h.handle(http.MethodGet, "/schedules/history", auth.Read, h.scheduleHistory)
+	h.handle(http.MethodGet, "/live", auth.Read, h.listLive)
+	h.handle(http.MethodPost, "/live/load", auth.Control, h.loadLive)
+	h.handle(http.MethodGet, "/events", auth.Read, h.streamEvents)
+	h.handle(http.MethodGet, "/ws", auth.Control, h.socket)
+
+	h.handle(http.MethodGet, "/openapi.json", auth.None, h.openAPI)
+	h.handle(http.MethodGet, "/openapi/media.json", auth.None, h.mediaOpenAPI)
+	h.handle(http.MethodGet, "/docs", auth.None, h.docs)
+	// The page asks for a token, which its requests to the api are made with.
+	h.handle(http.MethodGet, "/ui/", auth.None, ui.Handler("/ui/").ServeHTTP)
+
+	h.registerAPI()
+}
+
+// handle registers handler for method requests to the route pattern,
+// serving those authorised for scope. Requests with methods no handler is
+// registered for are refused.
+func (h *Handler) handle(method, pattern string, scope auth.Scope, handler http.HandlerFunc) {
+	h.routes = append(h.routes, Route{Method: method, Path: pattern, Scope: scope})
+	methods, ok := h.methods[pattern]
+	if !ok {
+		methods = map[string]http.Handler{}
+		h.methods[pattern] = methods
+		h.mux.Handle(pattern, byMethod(methods))
+	}
+	methods[method] = h.auth.Require(scope, handler)
+}
+
+// byMethod serves requests with the handler of their method in methods.
+// HEAD requests are served by the handler of GET.
+func byMethod(methods map[string]http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		if handler, ok := methods[method]; ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		allowed := make([]string, 0, len(methods))
+		for m := range methods {
+			allowed = append(allowed, m)
+		}
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		w.Header().Add("Content-Type", "text/plain")
+		http.Error(w, fmt.Sprintf("%s isn't allowed, use %s", r.Method, strings.Join(allowed, " or ")), http.StatusMethodNotAllowed)
+	})
+}
+
+// Route is a route that is served. Method is empty for routes that are
+// served whatever the method, which is left for the handler to check.
+// Scope is what tokens need to be authorised for, when tokens are needed.
+type Route struct {
+	Method string
+	Path   string
+	Scope  auth.Scope
+}
+
+// Routes returns every route the Handler serves, including version 1 of
+// the API.
+func (h *Handler) Routes() []Route {
+	routes := make([]Route, 0, len(h.routes)+len(h.api.routes))
+	routes = append(routes, h.routes...)
+	for _, rt := range h.api.routes {
+		routes = append(routes, Route{Method: rt.method, Path: apiPrefix + rt.pattern, Scope: rt.scope})
+	}
+	return routes
 }
 
 func (h *Handler) app(uuid string) (*application.Application, bool) {
@@ -130,6 +634,13 @@ func (h *Handler) discoverDnsEntries(ctx context.Context, iface string, waitq st
 		return
 	}
 	for d := range devicesChan {
+		capabilities := dev.FromInfoFields(d.InfoFields)
+		h.mu.Lock()
+		h.capabilities[d.UUID] = capabilities
+		h.uuids[d.DeviceName] = d.UUID
+		h.uuids[d.Name] = d.UUID
+		h.mu.Unlock()
+
 		devices = append(devices, device{
 			Addr:       d.AddrV4.String(),
 			Port:       d.Port,
@@ -140,6 +651,8 @@ func (h *Handler) discoverDnsEntries(ctx context.Context, iface string, waitq st
 			Status:     d.Status,
 			DeviceName: d.DeviceName,
 			InfoFields: d.InfoFields,
+
+			Capabilities: capabilities,
 		})
 	}
 
@@ -210,20 +723,29 @@ func (h *Handler) connect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	applicationOptions := []application.ApplicationOption{
-		application.WithDebug(h.verbose),
-		application.WithCacheDisabled(true),
+	var imageWidth, imageHeight int
+	if v := q.Get("image_width"); v != "" {
+		if imageWidth, err = strconv.Atoi(v); err != nil {
+			httpValidationError(w, "'image_width' is not a number")
+			return
+		}
+	}
+	if v := q.Get("image_height"); v != "" {
+		if imageHeight, err = strconv.Atoi(v); err != nil {
+			httpValidationError(w, "'image_height' is not a number")
+			return
+		}
 	}
 
-	app := application.NewApplication(applicationOptions...)
-	if err := app.Start(deviceAddr, devicePortI); err != nil {
+	_, err = h.connectDevice(deviceUUID, deviceAddr, devicePortI,
+		application.WithHLS(q.Get("hls") == "true"),
+		application.WithImageResolution(imageWidth, imageHeight),
+	)
+	if err != nil {
 		log.Printf("unable to start application: %v", err)
 		httpError(w, fmt.Errorf("unable to start application: %v", err))
 		return
 	}
-	h.mu.Lock()
-	h.apps[deviceUUID] = app
-	h.mu.Unlock()
 
 	w.Header().Add("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(chttp.ConnectResponse{DeviceUUID: deviceUUID, Connected: true}); err != nil {
@@ -234,9 +756,137 @@ func (h *Handler) connect(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// pendingConnect is a connection in progress, whose result is set once
+// 'done' is closed.
+type pendingConnect struct {
+	done chan struct{}
+	app  *application.Application
+	err  error
+}
+
+// connectOnce returns the application of the device uuid, connecting to it
+// at addr and port if it isn't connected. Callers needing the same device
+// at once share a single connection to it.
+func (h *Handler) connectOnce(uuid, addr string, port int) (*application.Application, error) {
+	h.mu.Lock()
+	if app, ok := h.apps[uuid]; ok {
+		h.mu.Unlock()
+		return app, nil
+	}
+	if p, ok := h.connecting[uuid]; ok {
+		h.mu.Unlock()
+		<-p.done
+		return p.app, p.err
+	}
+	p := &pendingConnect{done: make(chan struct{})}
+	h.connecting[uuid] = p
+	h.mu.Unlock()
+
+	p.app, p.err = h.connectDevice(uuid, addr, port)
+	h.mu.Lock()
+	delete(h.connecting, uuid)
+	h.mu.Unlock()
+	close(p.done)
+	return p.app, p.err
+}
+
+// connectDevice connects to the device uuid at addr and port, with opts
+// added to the options every application is started with.
+func (h *Handler) connectDevice(uuid, addr string, port int, opts ...application.ApplicationOption) (*application.Application, error) {
+	// Devices that haven't been discovered are assumed to be video
+	// chromecasts.
+	h.mu.Lock()
+	capabilities, ok := h.capabilities[uuid]
+	shuttingDown := h.shuttingDown
+	h.mu.Unlock()
+	if shuttingDown {
+		return nil, errShuttingDown
+	}
+	if !ok {
+		capabilities = dev.Unknown
+	}
+
+	applicationOptions := append([]application.ApplicationOption{
+		application.WithDebug(h.verbose),
+		application.WithCacheDisabled(true),
+		application.WithTranscoder(h.transcoder),
+		application.WithSessionID(uuid),
+		application.WithMediaServer(h.media),
+		application.WithCapabilities(capabilities),
+		application.WithProber(h.prober),
+	}, opts...)
+	if h.hlsCacheDir != "" {
+		applicationOptions = append(applicationOptions, application.WithHLSCacheDir(h.hlsCacheDir))
+	}
+
+	app := application.NewApplication(applicationOptions...)
+	if err := app.Start(addr, port); err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	if h.shuttingDown {
+		h.mu.Unlock()
+		app.Close(false)
+		return nil, errShuttingDown
+	}
+	h.apps[uuid] = app
+	h.mu.Unlock()
+	h.media.AllowClients(uuid, deviceIPs(addr)...)
+	h.events.connected(uuid, app)
+	return app, nil
+}
+
+// deviceIPs returns the addresses of the device at addr, which may be a
+// host name.
+func deviceIPs(addr string) []net.IP {
+	if ip := net.ParseIP(addr); ip != nil {
+		return []net.IP{ip}
+	}
+	ips, err := net.LookupIP(addr)
+	if err != nil {
+		log.Printf("unable to look up address of device at %s: %v", addr, err)
+	}
+	return ips
+}
+
+// disconnected stops serving media to the device uuid, sending events for
+// it and syncing the groups it is in, once it has been disconnected.
+func (h *Handler) disconnected(uuid string) {
+	h.media.RemoveClients(uuid)
+	h.events.disconnected(uuid)
+
+	var closed []*application.SyncGroup
+	h.mu.Lock()
+	for name, g := range h.syncGroups {
+		if _, ok := g.Members()[uuid]; ok {
+			delete(h.syncGroups, name)
+			closed = append(closed, g)
+		}
+	}
+	h.mu.Unlock()
+	for _, g := range closed {
+		log.Printf("removing sync group %s, device %s was disconnected", g.Name(), uuid)
+		g.Close()
+	}
+}
+
 func (h *Handler) disconnect(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 
+	stopMedia := q.Get("stop") == "true"
+	if group := q.Get("group"); group != "" {
+		// Members that aren't connected are reported as such, rather than
+		// connected to.
+		h.fanOut(w, group, "disconnect", false, func(uuid string, app *application.Application) error {
+			h.mu.Lock()
+			delete(h.apps, uuid)
+			h.mu.Unlock()
+			h.disconnected(uuid)
+			return app.Close(stopMedia)
+		})
+		return
+	}
+
 	deviceUUID := q.Get("uuid")
 	if deviceUUID == "" {
 		httpValidationError(w, "missing 'uuid' in query paramater")
@@ -251,7 +901,6 @@ func (h *Handler) disconnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	stopMedia := q.Get("stop") == "true"
 	if err := app.Close(stopMedia); err != nil {
 		log.Printf("unable to close application: %v", err)
 	}
@@ -259,10 +908,37 @@ func (h *Handler) disconnect(w http.ResponseWriter, r *http.Request) {
 	h.mu.Lock()
 	delete(h.apps, deviceUUID)
 	h.mu.Unlock()
+	h.disconnected(deviceUUID)
 	fmt.Fprintf(w, "Disconnected from %v\n", deviceUUID)
 }
 
 func (h *Handler) load(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	path := q.Get("path")
+	if path == "" {
+		httpValidationError(w, "missing 'path' in query paramater")
+		return
+	}
+
+	contentType := q.Get("content_type")
+	relay := q.Get("relay") == "true"
+	anyClient := q.Get("allow_any_client") == "true"
+	if relay && !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		httpValidationError(w, "only http and https urls can be relayed")
+		return
+	}
+	load := h.reportLoad(func(uuid string, app *application.Application) error {
+		if relay {
+			return app.LoadRelay(path, contentType, q.Get("reload_metadata") == "true", true, anyClient)
+		}
+		return app.Load(path, contentType, true, true, true, anyClient)
+	})
+
+	if group := q.Get("group"); group != "" {
+		h.fanOut(w, group, "load media on", true, load)
+		return
+	}
+
 	app, found := h.appForRequest(w, r)
 	if !found {
 		return
@@ -270,18 +946,279 @@ func (h *Handler) load(w http.ResponseWriter, r *http.Request) {
 
 	log.Println("loading media for device")
 
+	if err := load(q.Get("uuid"), app); err != nil {
+		log.Printf("unable to load media for device: %v", err)
+		if errors.Is(err, application.ErrUnsupportedMedia) {
+			httpValidationError(w, err.Error())
+			return
+		}
+		httpError(w, fmt.Errorf("unable to load media for device: %w", err))
+		return
+	}
+}
+
+func (h *Handler) announce(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
+	uuids := q["uuid"]
+	if len(uuids) == 0 {
+		httpValidationError(w, "missing 'uuid' in query params")
+		return
+	}
 	path := q.Get("path")
 	if path == "" {
 		httpValidationError(w, "missing 'path' in query paramater")
 		return
 	}
+	opts := application.AnnounceOptions{ContentType: q.Get("content_type")}
+	if v := q.Get("volume"); v != "" {
+		volume, err := strconv.ParseFloat(v, 32)
+		if err != nil || volume <= 0 || volume > 1 {
+			httpValidationError(w, "'volume' is not between 0 and 1")
+			return
+		}
+		opts.Volume = float32(volume)
+	}
 
-	contentType := q.Get("content_type")
+	apps := map[string]*application.Application{}
+	for _, uuid := range uuids {
+		app, ok := h.app(uuid)
+		if !ok {
+			httpValidationError(w, fmt.Sprintf("device %s is not connected", uuid))
+			return
+		}
+		apps[uuid] = app
+	}
 
-	if err := app.Load(path, contentType, true, true, true); err != nil {
-		log.Printf("unable to load media for device: %v", err)
-		httpError(w, fmt.Errorf("unable to load media for device: %w", err))
+	log.Printf("announcing %s on %d devices", path, len(apps))
+
+	// Every device announces at once, and is restored once its own
+	// announcement has finished.
+	results := make([]chttp.AnnounceResult, 0, len(apps))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for uuid, app := range apps {
+		wg.Add(1)
+		go func(uuid string, app *application.Application) {
+			defer wg.Done()
+			res := chttp.AnnounceResult{UUID: uuid, Announced: true}
+			if err := app.Announce(path, opts); err != nil {
+				log.Printf("unable to announce on device %s: %v", uuid, err)
+				res.Announced, res.Error = false, err.Error()
+			}
+			mu.Lock()
+			results = append(results, res)
+			mu.Unlock()
+		}(uuid, app)
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool { return results[i].UUID < results[j].UUID })
+
+	writeJSON(w, results, "announcements")
+}
+
+func (h *Handler) listTranscodes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.transcoder.Jobs()); err != nil {
+		log.Printf("error encoding json: %v", err)
+		httpError(w, fmt.Errorf("unable to json encode transcodes: %v", err))
+		return
+	}
+}
+
+func (h *Handler) stopTranscode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		httpValidationError(w, "missing or invalid 'id' in query paramater")
+		return
+	}
+
+	log.Printf("stopping transcode %d", id)
+
+	if err := h.transcoder.Stop(id); err != nil {
+		httpValidationError(w, err.Error())
+		return
+	}
+	fmt.Fprintf(w, "Stopped transcode %d\n", id)
+}
+
+func (h *Handler) slideshow(w http.ResponseWriter, r *http.Request) {
+	app, found := h.appForRequest(w, r)
+	if !found {
+		return
+	}
+
+	q := r.URL.Query()
+	paths := q["path"]
+	if len(paths) == 0 {
+		httpValidationError(w, "missing 'path' in query paramater")
+		return
+	}
+	if !app.Capabilities().VideoOut {
+		httpValidationError(w, "device has no display, unable to show a slideshow")
+		return
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			httpValidationError(w, fmt.Sprintf("unable to find %q", path))
+			return
+		}
+	}
+
+	duration := 10
+	if v := q.Get("duration"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d <= 0 {
+			httpValidationError(w, "'duration' is not a positive number")
+			return
+		}
+		duration = d
+	}
+	repeat := q.Get("repeat") == "true"
+
+	log.Printf("starting slideshow of %d images for device", len(paths))
+
+	// The slideshow runs until the last image has been shown, or forever when
+	// repeating, so only starting it is tied to the request.
+	done, err := app.StartSlideshow(paths, duration, repeat)
+	if err != nil {
+		log.Printf("unable to start slideshow for device: %v", err)
+		httpError(w, fmt.Errorf("unable to start slideshow for device: %w", err))
+		return
+	}
+	go func() {
+		if err := <-done; err != nil {
+			log.Printf("slideshow for device stopped: %v", err)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) sleep(w http.ResponseWriter, r *http.Request) {
+	app, found := h.appForRequest(w, r)
+	if !found {
+		return
+	}
+
+	q := r.URL.Query()
+	opts := application.SleepOptions{
+		Action: application.SleepAction(q.Get("action")),
+		Fade:   h.sleepFade,
+	}
+	if v := q.Get("minutes"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes <= 0 {
+			httpValidationError(w, "'minutes' is not a positive number")
+			return
+		}
+		opts.Until, opts.After = application.SleepAfter, time.Duration(minutes)*time.Minute
+	} else {
+		opts.Until = application.SleepUntil(q.Get("until"))
+		if opts.Until != application.SleepEndOfItem && opts.Until != application.SleepEndOfQueue {
+			httpValidationError(w, "missing 'minutes', or 'until' isn't end_of_item or end_of_queue")
+			return
+		}
+	}
+	if v := q.Get("fade"); v != "" {
+		fade, err := strconv.Atoi(v)
+		if err != nil || fade < 0 {
+			httpValidationError(w, "'fade' is not a number of seconds")
+			return
+		}
+		opts.Fade = time.Duration(fade) * time.Second
+	}
+
+	log.Printf("setting sleep timer for device: %+v", opts)
+
+	if err := app.Sleep(opts); err != nil {
+		httpValidationError(w, err.Error())
+		return
+	}
+	h.writeSleepStatus(w, app)
+}
+
+func (h *Handler) extendSleep(w http.ResponseWriter, r *http.Request) {
+	app, found := h.appForRequest(w, r)
+	if !found {
+		return
+	}
+
+	minutes, err := strconv.Atoi(r.URL.Query().Get("minutes"))
+	if err != nil || minutes <= 0 {
+		httpValidationError(w, "missing or invalid 'minutes' in query paramater")
+		return
+	}
+	if err := app.ExtendSleep(time.Duration(minutes) * time.Minute); err != nil {
+		httpValidationError(w, err.Error())
+		return
+	}
+	h.writeSleepStatus(w, app)
+}
+
+func (h *Handler) cancelSleep(w http.ResponseWriter, r *http.Request) {
+	app, found := h.appForRequest(w, r)
+	if !found {
+		return
+	}
+
+	if err := app.CancelSleep(); err != nil {
+		httpValidationError(w, err.Error())
+		return
+	}
+	fmt.Fprintln(w, "Cancelled sleep timer")
+}
+
+func (h *Handler) writeSleepStatus(w http.ResponseWriter, app *application.Application) {
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(app.SleepStatus()); err != nil {
+		log.Printf("error encoding json: %v", err)
+		httpError(w, fmt.Errorf("unable to json encode sleep timer: %v", err))
+		return
+	}
+}
+
+func (h *Handler) listLive(w http.ResponseWriter, r *http.Request) {
+	sources := []chttp.LiveSource{}
+	for _, stream := range h.live {
+		sources = append(sources, chttp.LiveSource{
+			Name:        stream.Name(),
+			ContentType: stream.ContentType(),
+			Source:      stream.Source().String(),
+			Listeners:   stream.Listeners(),
+		})
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sources); err != nil {
+		log.Printf("error encoding json: %v", err)
+		httpError(w, fmt.Errorf("unable to json encode live sources: %v", err))
+		return
+	}
+}
+
+func (h *Handler) loadLive(w http.ResponseWriter, r *http.Request) {
+	app, found := h.appForRequest(w, r)
+	if !found {
+		return
+	}
+
+	name := r.URL.Query().Get("source")
+	stream, ok := h.live[name]
+	if !ok {
+		httpValidationError(w, fmt.Sprintf("unknown live source %q", name))
+		return
+	}
+
+	log.Printf("loading live source %s for device", name)
+
+	if err := app.LoadLive(stream, true); err != nil {
+		h.events.loadFailed(r.URL.Query().Get("uuid"), err)
+		log.Printf("unable to load live source for device: %v", err)
+		if errors.Is(err, application.ErrUnsupportedMedia) {
+			httpValidationError(w, err.Error())
+			return
+		}
+		httpError(w, fmt.Errorf("unable to load live source for device: %w", err))
 		return
 	}
 }
@@ -302,6 +1239,8 @@ func (h *Handler) appForRequest(w http.ResponseWriter, r *http.Request) (*applic
 	}
 
 	if err := app.Update(); err != nil {
+		log.Printf("unable to update device %s: %v", deviceUUID, err)
+		httpError(w, fmt.Errorf("unable to update device: %v", err))
 		return nil, false
 	}
 
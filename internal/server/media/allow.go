@@ -0,0 +1,105 @@
+package media
+
+import (
+	"net"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WithClientAllowlist only serves items to the clients allowed by
+// AllowClients, to the host itself and to clients in networks. Devices
+// can't authenticate their requests for media, so this is what stops
+// anyone who learns an item url from fetching it.
+func WithClientAllowlist(networks ...*net.IPNet) ServerOption {
+	return func(s *Server) {
+		s.restrictClients = true
+		s.allowedNetworks = append(s.allowedNetworks, networks...)
+	}
+}
+
+// AllowClients allows the clients at ips to be served items, under name,
+// replacing any allowed under it before. Devices are allowed under their
+// uuid while they are connected.
+func (s *Server) AllowClients(name string, ips ...net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.allowedClients[name] = ips
+}
+
+// RemoveClients stops allowing the clients allowed under name.
+func (s *Server) RemoveClients(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.allowedClients, name)
+}
+
+// ClientAllowed returns whether items may be served to the client making
+// r, logging it when they may not. Every client is allowed unless the
+// server has a client allowlist.
+func (s *Server) ClientAllowed(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if s.allowed(ip) {
+		return true
+	}
+	log.WithField("package", "media").WithFields(log.Fields{
+		"remote": r.RemoteAddr,
+		"path":   r.URL.Path,
+	}).Warn("denied media request from client not in allowlist")
+	return false
+}
+
+func (s *Server) allowed(ip net.IP) bool {
+	if s.listed(ip) {
+		return true
+	}
+	return ip != nil && (ip.IsLoopback() || hostAddr(ip))
+}
+
+// listed returns whether the server has no client allowlist, or ip is in
+// it.
+func (s *Server) listed(ip net.IP) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.restrictClients {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, network := range s.allowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	for _, ips := range s.allowedClients {
+		for _, allowed := range ips {
+			if allowed.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hostAddr returns whether ip is an address of the host, which requests
+// the host makes to itself come from.
+func hostAddr(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if network, ok := addr.(*net.IPNet); ok && network.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package media
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// itemPrefix is the path registered items are served beneath within a
+// session namespace.
+const itemPrefix = "/m/"
+
+var (
+	ErrUnknownToken = errors.New("unknown media token")
+	ErrExpiredToken = errors.New("media token has expired")
+)
+
+// Item is content registered with a session. Items are only reachable
+// through the opaque token returned when they are registered, so nothing
+// about the filesystem is exposed in their URLs.
+type Item struct {
+	// Filename is served when there is no Handler.
+	Filename    string
+	ContentType string
+	// Handler serves the item. Requests for the item URL are passed with
+	// the path "/". Anything following the token in the URL is only passed
+	// as the path when SubPaths is set, which allows items such as HLS
+	// playlists to reference further resources relative to themselves, and
+	// isn't found otherwise.
+	Handler  http.Handler
+	SubPaths bool
+	// AllowAnyClient serves the item to every client, even when the server
+	// has a client allowlist. It is meant for debugging.
+	AllowAnyClient bool
+
+	expires time.Time
+}
+
+// Expires returns when the token for the item expires, or the zero time if
+// it never does.
+func (i *Item) Expires() time.Time { return i.expires }
+
+// Register adds item to the session and returns the token it is served at.
+// The token expires after the server token ttl, if one is set.
+func (ss *Session) Register(item Item) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "unable to generate media token")
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+
+	ttl, key := ss.server.tokenSettings()
+	token := id
+	if ttl > 0 {
+		item.expires = time.Now().Add(ttl)
+		token += "." + strconv.FormatInt(item.expires.Unix(), 36)
+	}
+	if key != nil {
+		token += "." + ss.sign(key, token)
+	}
+
+	ss.itemsMu.Lock()
+	ss.items[id] = &item
+	ss.itemsMu.Unlock()
+	return token, nil
+}
+
+// Unregister removes the item served at token.
+func (ss *Session) Unregister(token string) {
+	id := strings.SplitN(token, ".", 2)[0]
+
+	ss.itemsMu.Lock()
+	delete(ss.items, id)
+	ss.itemsMu.Unlock()
+}
+
+// ItemPath returns the absolute server path of the item served at token,
+// with suffix appended.
+func (ss *Session) ItemPath(token, suffix string) string {
+	return ss.Path(itemPrefix + token + suffix)
+}
+
+// Item returns the item served at token. ErrUnknownToken is returned for
+// tokens that were never issued, have been tampered with or have been
+// unregistered, and ErrExpiredToken for tokens past their expiry.
+func (ss *Session) Item(token string) (*Item, error) {
+	parts := strings.Split(token, ".")
+	_, key := ss.server.tokenSettings()
+	if key != nil {
+		if len(parts) < 2 {
+			return nil, ErrUnknownToken
+		}
+		signed := strings.Join(parts[:len(parts)-1], ".")
+		if !hmac.Equal([]byte(parts[len(parts)-1]), []byte(ss.sign(key, signed))) {
+			return nil, ErrUnknownToken
+		}
+	}
+
+	ss.itemsMu.Lock()
+	defer ss.itemsMu.Unlock()
+
+	item, ok := ss.items[parts[0]]
+	if !ok {
+		return nil, ErrUnknownToken
+	}
+	if !item.expires.IsZero() && time.Now().After(item.expires) {
+		delete(ss.items, parts[0])
+		return nil, ErrExpiredToken
+	}
+	return item, nil
+}
+
+// serveItem serves requests for registered items.
+func (ss *Session) serveItem(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, itemPrefix)
+	token, subPath := rest, "/"
+	if i := strings.Index(rest, "/"); i >= 0 {
+		token, subPath = rest[:i], rest[i:]
+	}
+
+	item, err := ss.Item(token)
+	// Clients that aren't allowed are refused whether or not the token is
+	// valid, so they can't tell which tokens are.
+	if (err != nil || !item.AllowAnyClient) && !ss.server.ClientAllowed(r) {
+		http.Error(w, "media is only served to connected devices", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.WithField("package", "media").WithFields(log.Fields{
+			"session": ss.name,
+			"remote":  r.RemoteAddr,
+		}).WithError(err).Warn("rejected media request")
+		status := http.StatusNotFound
+		if err == ErrExpiredToken {
+			status = http.StatusGone
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if subPath != "/" && (item.Handler == nil || !item.SubPaths) {
+		http.NotFound(w, r)
+		return
+	}
+	if item.Handler == nil {
+		if item.ContentType != "" {
+			w.Header().Set("Content-Type", item.ContentType)
+		}
+		http.ServeFile(w, r, item.Filename)
+		return
+	}
+
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = subPath
+	r2.URL.RawPath = ""
+	item.Handler.ServeHTTP(w, r2)
+}
+
+func (ss *Session) sign(key []byte, token string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ss.name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:22]
+}
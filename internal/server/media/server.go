@@ -0,0 +1,301 @@
+package media
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultPort is the port the shared media server listens on unless
+// configured otherwise.
+const DefaultPort = 9002
+
+// sessionPrefix is the path every session namespace is served beneath.
+const sessionPrefix = "/s/"
+
+var (
+	defaultMu     sync.Mutex
+	defaultServer *Server
+)
+
+// Default returns the shared media server, creating it on DefaultPort the
+// first time it is needed.
+func Default() *Server {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultServer == nil {
+		defaultServer = NewServer(DefaultPort)
+	}
+	return defaultServer
+}
+
+// SetDefault replaces the shared media server. It should be called before
+// anything registers content with the default server.
+func SetDefault(s *Server) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	defaultServer = s
+}
+
+// Server serves media for every session from a single listener. Content is
+// registered in per-session namespaces served beneath /s/<session>/, which
+// are deregistered by closing the session. The root namespace, with an
+// empty name, serves every other path.
+type Server struct {
+	mu       sync.Mutex
+	port     int
+	listener net.Listener
+	server   *http.Server
+	sessions map[string]*Session
+
+	// Tokens for registered items expire after 'tokenTTL' if it is set,
+	// and are signed with 'signingKey' if it is set.
+	tokenTTL   time.Duration
+	signingKey []byte
+
+	// Items are only served to allowed clients when 'restrictClients' is
+	// set: those in 'allowedNetworks', and those in 'allowedClients' keyed
+	// by the name they were allowed under.
+	restrictClients bool
+	allowedNetworks []*net.IPNet
+	allowedClients  map[string][]net.IP
+
+	done chan struct{}
+	err  error
+}
+
+type ServerOption func(*Server)
+
+// WithTokenTTL expires item tokens ttl after they are registered.
+func WithTokenTTL(ttl time.Duration) ServerOption {
+	return func(s *Server) {
+		s.tokenTTL = ttl
+	}
+}
+
+// WithSigningKey signs item tokens with an HMAC using key, so tokens can't
+// be forged or have their expiry changed.
+func WithSigningKey(key []byte) ServerOption {
+	return func(s *Server) {
+		if len(key) > 0 {
+			s.signingKey = key
+		}
+	}
+}
+
+// NewServer returns a Server that will listen on port once started. A port
+// of zero picks any available port.
+func NewServer(port int, opts ...ServerOption) *Server {
+	s := &Server{
+		port:           port,
+		sessions:       map[string]*Session{},
+		allowedClients: map[string][]net.IP{},
+		done:           make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// Start starts listening if the server isn't already running.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener != nil {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(s.port))
+	if err != nil {
+		return errors.Wrap(err, "unable to bind to local tcp address")
+	}
+	s.listener = listener
+	s.port = listener.Addr().(*net.TCPAddr).Port
+	s.server = &http.Server{Handler: s}
+
+	go func(server *http.Server, port int) {
+		log.WithField("package", "media").Infof("media server listening on %d", port)
+		err := server.Serve(listener)
+		if err == http.ErrServerClosed {
+			err = nil
+		}
+		if err != nil {
+			log.WithField("package", "media").WithError(err).Error("error serving HTTP")
+		}
+		s.err = err
+		close(s.done)
+	}(s.server, s.port)
+	return nil
+}
+
+// Wait blocks until the server stops serving. It returns nil if the server
+// was shut down, otherwise the reason it stopped.
+func (s *Server) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Shutdown stops the server from accepting new requests and waits for
+// requests in flight to finish, or for ctx to be done. Every session is
+// deregistered.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	server := s.server
+	s.sessions = map[string]*Session{}
+	s.mu.Unlock()
+
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
+
+// Port returns the port the server is, or will be, listening on.
+func (s *Server) Port() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.port
+}
+
+// NewSession creates the namespace for name, replacing any existing
+// namespace of the same name.
+func (s *Server) NewSession(name string) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss := &Session{
+		name:   name,
+		server: s,
+		mux:    http.NewServeMux(),
+		items:  map[string]*Item{},
+	}
+	ss.mux.HandleFunc(itemPrefix, ss.serveItem)
+	if name != "" {
+		ss.prefix = sessionPrefix + url.PathEscape(name)
+	}
+	s.sessions[name] = ss
+	return ss
+}
+
+// RemoveSession deregisters the namespace for name. Requests for its
+// content fail from then on.
+func (s *Server) RemoveSession(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, name)
+}
+
+func (s *Server) tokenSettings() (time.Duration, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.tokenTTL, s.signingKey
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Session names are escaped in paths, so they are split on the escaped
+	// path, where a slash in a name can't be taken for the end of it.
+	name, rest := "", ""
+	if path := r.URL.EscapedPath(); strings.HasPrefix(path, sessionPrefix) {
+		escaped := strings.TrimPrefix(path, sessionPrefix)
+		if i := strings.Index(escaped, "/"); i >= 0 {
+			escaped, rest = escaped[:i], escaped[i:]
+		}
+		unescaped, err := url.PathUnescape(escaped)
+		if err != nil || unescaped == "" {
+			http.NotFound(w, r)
+			return
+		}
+		name = unescaped
+	}
+
+	s.mu.Lock()
+	ss, ok := s.sessions[name]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if name != "" {
+		stripped, err := stripSession(r, rest)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		r = stripped
+	}
+	ss.mux.ServeHTTP(w, r)
+}
+
+// stripSession returns r for the path rest of its session namespace, which
+// is escaped.
+func stripSession(r *http.Request, rest string) (*http.Request, error) {
+	path, err := url.PathUnescape(rest)
+	if err != nil {
+		return nil, err
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = path
+	r2.URL.RawPath = rest
+	return r2, nil
+}
+
+// Session is a namespace of content on a Server.
+type Session struct {
+	name   string
+	prefix string
+	server *Server
+	mux    *http.ServeMux
+
+	itemsMu sync.Mutex
+	// Registered items keyed by the id part of their token.
+	items map[string]*Item
+}
+
+// Name returns the name of the session.
+func (ss *Session) Name() string { return ss.name }
+
+// Handle registers handler for pattern, relative to the session namespace.
+func (ss *Session) Handle(pattern string, handler http.Handler) {
+	ss.mux.Handle(pattern, handler)
+}
+
+// HandleFunc registers handler for pattern, relative to the session
+// namespace.
+func (ss *Session) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	ss.mux.HandleFunc(pattern, handler)
+}
+
+// Path returns the absolute server path of p within the session namespace.
+func (ss *Session) Path(p string) string {
+	return ss.prefix + p
+}
+
+// Close deregisters the session from its server, unless it has since been
+// replaced by a new session of the same name.
+func (ss *Session) Close() {
+	s := ss.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sessions[ss.name] == ss {
+		delete(s.sessions, ss.name)
+	}
+}
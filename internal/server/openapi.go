@@ -0,0 +1,600 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	auth "github.com/avinash240/pusher/internal/server/auth"
+	chttp "github.com/avinash240/pusher/internal/server/chttp"
+)
+
+// openAPIVersion is the version of the OpenAPI specification the documents
+// follow.
+const openAPIVersion = "3.0.3"
+
+// openAPIDoc is an OpenAPI document, with only the parts of the
+// specification that are used.
+type openAPIDoc struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Servers    []openAPIServer                         `json:"servers,omitempty"`
+	Tags       []openAPITag                            `json:"tags,omitempty"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL         string                           `json:"url"`
+	Description string                           `json:"description,omitempty"`
+	Variables   map[string]openAPIServerVariable `json:"variables,omitempty"`
+}
+
+type openAPIServerVariable struct {
+	Default     string `json:"default"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPITag struct {
+	Name string `json:"name"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *schema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                    `json:"required,omitempty"`
+	Content  map[string]openAPIMedia `json:"content"`
+}
+
+type openAPIMedia struct {
+	Schema *schema `json:"schema"`
+}
+
+// openAPIResponse is a response, or a reference to one in the components
+// when Ref is set.
+type openAPIResponse struct {
+	Ref         string                  `json:"$ref,omitempty"`
+	Description string                  `json:"description,omitempty"`
+	Content     map[string]openAPIMedia `json:"content,omitempty"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*schema                `json:"schemas"`
+	Responses       map[string]*openAPIResponse       `json:"responses"`
+	SecuritySchemes map[string]*openAPISecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type openAPISecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme"`
+	Description string `json:"description,omitempty"`
+}
+
+// schema is a json schema, as OpenAPI uses them. The empty schema allows
+// any value.
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	OneOf                []*schema          `json:"oneOf,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaSet makes the schemas of go types as they are encoded as json.
+// Structs are added to the components, and referred to by name.
+type schemaSet struct {
+	components map[string]*schema
+	names      map[reflect.Type]string
+}
+
+func newSchemaSet() *schemaSet {
+	return &schemaSet{
+		components: map[string]*schema{},
+		names:      map[reflect.Type]string{},
+	}
+}
+
+// of returns the schema of values like v. A oneOf is any of its
+// alternatives.
+func (s *schemaSet) of(v interface{}) *schema {
+	if alternatives, ok := v.(oneOf); ok {
+		sc := &schema{}
+		for _, a := range alternatives {
+			sc.OneOf = append(sc.OneOf, s.of(a))
+		}
+		return sc
+	}
+	return s.typeOf(reflect.TypeOf(v))
+}
+
+func (s *schemaSet) typeOf(t reflect.Type) *schema {
+	if t == timeType {
+		return &schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.typeOf(t.Elem())
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &schema{Type: "string", Enum: enums[t]}
+	case reflect.Slice, reflect.Array:
+		return &schema{Type: "array", Items: s.typeOf(t.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: s.typeOf(t.Elem())}
+	case reflect.Struct:
+		return s.component(t)
+	}
+	return &schema{}
+}
+
+// component returns a reference to the schema of the struct t, adding it to
+// the components the first time.
+func (s *schemaSet) component(t reflect.Type) *schema {
+	name, ok := s.names[t]
+	if !ok {
+		name = strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, taken := s.components[name]; taken {
+			pkg := path.Base(t.PkgPath())
+			name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+		}
+		s.names[t] = name
+		sc := &schema{Type: "object", Properties: map[string]*schema{}}
+		// Set before the fields, as structs may refer to themselves.
+		s.components[name] = sc
+		s.fields(t, sc)
+	}
+	return &schema{Ref: "#/components/schemas/" + name}
+}
+
+// fields adds the fields of the struct t to sc. Fields of embedded structs
+// are added as their own, as they are encoded.
+func (s *schemaSet) fields(t reflect.Type, sc *schema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i:]
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.fields(ft, sc)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fs := s.typeOf(f.Type)
+		if f.Type.Kind() == reflect.Ptr && fs.Ref == "" && !strings.Contains(opts, "omitempty") {
+			fs.Nullable = true
+		}
+		sc.Properties[name] = fs
+		if !strings.Contains(opts, "omitempty") {
+			sc.Required = append(sc.Required, name)
+		}
+	}
+}
+
+// paramSchema returns the schema of the param p.
+func paramSchema(p param) *schema {
+	sc := &schema{Type: p.typ, Enum: p.enum}
+	if p.repeated {
+		return &schema{Type: "array", Items: sc}
+	}
+	return sc
+}
+
+// Responses every operation may return, in the components.
+const (
+	refValidationError = "#/components/responses/ValidationError"
+	refError           = "#/components/responses/Error"
+	refAPIError        = "#/components/responses/APIError"
+	refNotFound        = "#/components/responses/NotFound"
+	refUnauthorized    = "#/components/responses/Unauthorized"
+	refForbidden       = "#/components/responses/Forbidden"
+)
+
+// securityScheme is the name of the security scheme operations needing a
+// token refer to.
+const securityScheme = "bearer"
+
+// routeScopes returns the scopes of routes, keyed by their method and path,
+// or nil when tokens aren't needed.
+func routeScopes(a *auth.Authenticator, routes []Route) map[string]auth.Scope {
+	if a == nil {
+		return nil
+	}
+	scopes := map[string]auth.Scope{}
+	for _, rt := range routes {
+		scopes[rt.Method+" "+rt.Path] = rt.Scope
+	}
+	return scopes
+}
+
+// newOpenAPIDoc returns the document describing ops. Operations outside of
+// version 1 of the API fail with the responses in errors, by status. When
+// scopes is set, operations need a token with the scope of their route.
+func newOpenAPIDoc(info openAPIInfo, servers []openAPIServer, ops []operation, errors map[string]string, scopes map[string]auth.Scope) *openAPIDoc {
+	s := newSchemaSet()
+	text := map[string]openAPIMedia{"text/plain": {Schema: &schema{Type: "string"}}}
+	doc := &openAPIDoc{
+		OpenAPI: openAPIVersion,
+		Info:    info,
+		Servers: servers,
+		Paths:   map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas: s.components,
+			Responses: map[string]*openAPIResponse{
+				"ValidationError": {Description: "The request isn't valid.", Content: text},
+				"Error":           {Description: "The request failed.", Content: text},
+				"NotFound":        {Description: "Nothing is served at the path.", Content: text},
+				"Unauthorized":    {Description: "A valid bearer token is needed.", Content: text},
+				"Forbidden":       {Description: "The bearer token doesn't have the scope needed.", Content: text},
+				"APIError": {
+					Description: "The request failed.",
+					Content:     map[string]openAPIMedia{"application/json": {Schema: s.of(chttp.ErrorResponse{})}},
+				},
+			},
+		},
+	}
+	if scopes != nil {
+		doc.Components.SecuritySchemes = map[string]*openAPISecurityScheme{securityScheme: {
+			Type:   "http",
+			Scheme: "bearer",
+			Description: "Tokens have the read, control or admin scope, each including those before it. " +
+				"Event streams and WebSockets may pass the token in the access_token query param instead.",
+		}}
+	}
+	// Clients match on the code of errors, so every code is listed.
+	s.components["ErrorBody"].Properties["code"].Enum = apiErrorCodes
+
+	tags := map[string]bool{}
+	for _, op := range ops {
+		o := &openAPIOperation{
+			OperationID: op.id,
+			Summary:     op.summary,
+			Tags:        []string{op.tag},
+			Responses:   map[string]*openAPIResponse{},
+		}
+		tags[op.tag] = true
+
+		for _, p := range op.pathParams() {
+			o.Parameters = append(o.Parameters, openAPIParameter{
+				Name:        p.name,
+				In:          p.in,
+				Description: p.desc,
+				Required:    true,
+				Schema:      paramSchema(p),
+			})
+		}
+		for _, p := range op.params {
+			o.Parameters = append(o.Parameters, openAPIParameter{
+				Name:        p.name,
+				In:          p.in,
+				Description: p.desc,
+				Required:    p.required || p.in == "path",
+				Schema:      paramSchema(p),
+			})
+		}
+		if op.body != nil {
+			o.RequestBody = &openAPIRequestBody{
+				Required: !op.optionalBody,
+				Content:  map[string]openAPIMedia{"application/json": {Schema: s.of(op.body)}},
+			}
+		}
+
+		status := op.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		res := &openAPIResponse{Description: op.returns}
+		if res.Description == "" {
+			res.Description = http.StatusText(status)
+		}
+		if op.response != nil || op.raw != "" || op.stream != nil {
+			res.Content = map[string]openAPIMedia{}
+		}
+		if op.response != nil {
+			res.Content["application/json"] = openAPIMedia{Schema: s.of(op.response)}
+		}
+		if op.raw != "" {
+			res.Content[op.raw] = openAPIMedia{Schema: &schema{Type: "string"}}
+		}
+		if op.stream != nil {
+			res.Content["text/event-stream"] = openAPIMedia{Schema: s.of(op.stream)}
+		}
+		o.Responses[strconv.Itoa(status)] = res
+
+		switch {
+		case strings.HasPrefix(op.path, apiPrefix+"/"):
+			o.Responses["4XX"] = &openAPIResponse{Ref: refAPIError}
+			o.Responses["5XX"] = &openAPIResponse{Ref: refAPIError}
+		case !op.static:
+			for status, ref := range errors {
+				o.Responses[status] = &openAPIResponse{Ref: ref}
+			}
+		}
+
+		// Routes of the local media server are registered whatever the
+		// method.
+		scope, ok := scopes[strings.ToUpper(op.method)+" "+op.path]
+		if !ok {
+			scope = scopes[" "+op.path]
+		}
+		if scope != auth.None {
+			o.Description = "Needs a token with the " + string(scope) + " scope."
+			o.Security = []map[string][]string{{securityScheme: {}}}
+			if !strings.HasPrefix(op.path, apiPrefix+"/") {
+				o.Responses["401"] = &openAPIResponse{Ref: refUnauthorized}
+				o.Responses["403"] = &openAPIResponse{Ref: refForbidden}
+			}
+		}
+
+		if doc.Paths[op.path] == nil {
+			doc.Paths[op.path] = map[string]*openAPIOperation{}
+		}
+		doc.Paths[op.path][strings.ToLower(op.method)] = o
+	}
+
+	for name := range tags {
+		doc.Tags = append(doc.Tags, openAPITag{Name: name})
+	}
+	sort.Slice(doc.Tags, func(i, j int) bool { return doc.Tags[i].Name < doc.Tags[j].Name })
+	return doc
+}
+
+// apiDoc returns the document describing the routes of the Handler.
+func (h *Handler) apiDoc() *openAPIDoc {
+	return newOpenAPIDoc(openAPIInfo{
+		Title: "pusher",
+		Description: "Controls Chromecast devices. The routes under " + apiPrefix + " take and return json, " +
+			"and return every error as an ErrorResponse. The other routes take query params, " +
+			"and return errors as text.",
+		Version: "1.0.0",
+	}, nil, apiOperations, map[string]string{
+		"400": refValidationError,
+		"500": refError,
+	}, routeScopes(h.auth, h.Routes()))
+}
+
+// mediaDoc returns the document describing the routes of the local media
+// server, which is served on its own port and accepts the same tokens.
+func (h *Handler) mediaDoc() *openAPIDoc {
+	return newOpenAPIDoc(openAPIInfo{
+		Title:       "pusher media server",
+		Description: "Serves media loaded by the local server to devices.",
+		Version:     "1.0.0",
+	}, []openAPIServer{{
+		URL: "http://{host}:{port}",
+		Variables: map[string]openAPIServerVariable{
+			"host": {Default: "localhost", Description: "Address of the host pusher runs on."},
+			"port": {Default: strconv.Itoa(h.media.Port()), Description: "Port of the media server."},
+		},
+	}}, mediaOperations, map[string]string{
+		"400": refValidationError,
+		"404": refNotFound,
+		"500": refError,
+	}, routeScopes(h.auth, LocalServerRoutes()))
+}
+
+func (h *Handler) openAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.apiDoc(), "openapi document")
+}
+
+func (h *Handler) mediaOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.mediaDoc(), "openapi document")
+}
+
+// docSection is a document as it is shown on the docs page.
+type docSection struct {
+	Doc  *openAPIDoc
+	URL  string
+	Ops  []docOperation
+	Tags []string
+}
+
+type docOperation struct {
+	Method    string
+	Path      string
+	Tag       string
+	Op        *openAPIOperation
+	Body      string
+	Responses []docResponse
+}
+
+type docResponse struct {
+	Status      string
+	Description string
+	Types       string
+}
+
+// methodOrder is the order operations on the same path are listed in.
+var methodOrder = map[string]int{"get": 0, "put": 1, "post": 2, "delete": 3}
+
+func newDocSection(doc *openAPIDoc, url string) docSection {
+	section := docSection{Doc: doc, URL: url}
+	for _, t := range doc.Tags {
+		section.Tags = append(section.Tags, t.Name)
+	}
+	for p, ops := range doc.Paths {
+		for method, o := range ops {
+			op := docOperation{Method: strings.ToUpper(method), Path: p, Tag: o.Tags[0], Op: o}
+			if o.RequestBody != nil {
+				op.Body = schemaName(o.RequestBody.Content["application/json"].Schema)
+			}
+			for status, res := range o.Responses {
+				if res.Ref != "" {
+					res = doc.Components.Responses[path.Base(res.Ref)]
+				}
+				var types []string
+				for ct, m := range res.Content {
+					types = append(types, ct+" "+schemaName(m.Schema))
+				}
+				sort.Strings(types)
+				op.Responses = append(op.Responses, docResponse{Status: status, Description: res.Description, Types: strings.Join(types, ", ")})
+			}
+			sort.Slice(op.Responses, func(i, j int) bool { return op.Responses[i].Status < op.Responses[j].Status })
+			section.Ops = append(section.Ops, op)
+		}
+	}
+	sort.Slice(section.Ops, func(i, j int) bool {
+		a, b := section.Ops[i], section.Ops[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return methodOrder[strings.ToLower(a.Method)] < methodOrder[strings.ToLower(b.Method)]
+	})
+	return section
+}
+
+// schemaName describes sc in a few words, by the name of its component.
+func schemaName(sc *schema) string {
+	switch {
+	case sc == nil:
+		return ""
+	case sc.Ref != "":
+		return path.Base(sc.Ref)
+	case len(sc.OneOf) > 0:
+		names := make([]string, len(sc.OneOf))
+		for i, a := range sc.OneOf {
+			names[i] = schemaName(a)
+		}
+		return strings.Join(names, " | ")
+	case sc.Type == "array":
+		return schemaName(sc.Items) + "[]"
+	case sc.Type == "":
+		return "any"
+	}
+	return sc.Type
+}
+
+var docsTemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>pusher API</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; color: #222; }
+h2 { border-bottom: 1px solid #ccc; }
+.op { margin: 1em 0; padding: .5em 1em; border-left: 4px solid #ccc; }
+.method { font-weight: bold; display: inline-block; width: 4.5em; }
+.path { font-family: monospace; font-size: 1.1em; }
+table { border-collapse: collapse; margin: .5em 0; }
+td, th { text-align: left; padding: .1em 1em .1em 0; vertical-align: top; }
+code { font-size: .95em; }
+</style>
+</head>
+<body>
+{{range $section := .}}
+<h1>{{.Doc.Info.Title}}</h1>
+<p>{{.Doc.Info.Description}}</p>
+<p>OpenAPI {{.Doc.OpenAPI}} document: <a href="{{.URL}}">{{.URL}}</a></p>
+{{- range .Doc.Servers}}
+<p>Served at <code>{{.URL}}</code>{{range $name, $v := .Variables}}, {{$name}} <code>{{$v.Default}}</code>{{end}}</p>
+{{- end}}
+{{range $tag := .Tags}}
+<h2>{{$tag}}</h2>
+{{range $section.Ops}}{{if eq .Tag $tag}}
+<div class="op" id="{{.Op.OperationID}}">
+<div><span class="method">{{.Method}}</span> <span class="path">{{.Path}}</span></div>
+<p>{{.Op.Summary}}</p>
+{{if .Op.Description}}<p>{{.Op.Description}}</p>{{end}}
+{{- if .Op.Parameters}}
+<table>
+<tr><th>Param</th><th>In</th><th>Type</th><th></th><th>Description</th></tr>
+{{- range .Op.Parameters}}
+<tr><td><code>{{.Name}}</code></td><td>{{.In}}</td><td>{{.Schema.Type}}{{if .Schema.Items}} of {{.Schema.Items.Type}}{{end}}{{if .Schema.Enum}} ({{range $i, $e := .Schema.Enum}}{{if $i}}, {{end}}{{$e}}{{end}}){{end}}</td><td>{{if .Required}}required{{end}}</td><td>{{.Description}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Body}}
+<p>Body: <code>application/json {{.Body}}</code></p>
+{{- end}}
+<table>
+{{- range .Responses}}
+<tr><td>{{.Status}}</td><td>{{.Description}}</td><td><code>{{.Types}}</code></td></tr>
+{{- end}}
+</table>
+</div>
+{{end}}{{end}}
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// docs writes a page describing every route, from the OpenAPI documents.
+func (h *Handler) docs(w http.ResponseWriter, r *http.Request) {
+	sections := []docSection{
+		newDocSection(h.apiDoc(), "/openapi.json"),
+		newDocSection(h.mediaDoc(), "/openapi/media.json"),
+	}
+	var page bytes.Buffer
+	if err := docsTemplate.Execute(&page, sections); err != nil {
+		log.Printf("error writing docs: %v", err)
+		httpError(w, fmt.Errorf("unable to write docs: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	page.WriteTo(w)
+}
@@ -0,0 +1,730 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/avinash240/pusher/internal/scheduler"
+	application "github.com/avinash240/pusher/internal/server/application"
+	chttp "github.com/avinash240/pusher/internal/server/chttp"
+	dev "github.com/avinash240/pusher/internal/server/device"
+	"github.com/avinash240/pusher/internal/transcode"
+)
+
+// operation describes a route for the OpenAPI documents. Every route that
+// is served needs one, or the documents are missing it.
+type operation struct {
+	method  string
+	path    string
+	id      string
+	tag     string
+	summary string
+	params  []param
+
+	// body is the json request body, if any, which is needed unless
+	// optionalBody is set.
+	body         interface{}
+	optionalBody bool
+
+	// status is written on success, http.StatusOK unless set, and is
+	// described by returns. response is the json written, raw the content
+	// type of anything else written, and stream the json data of each
+	// server-sent event written.
+	status   int
+	returns  string
+	response interface{}
+	raw      string
+	stream   interface{}
+
+	// static is set for routes that don't fail.
+	static bool
+}
+
+// pathParams returns the params named in the path of op, unless they are
+// described by op.params.
+func (op operation) pathParams() []param {
+	var params []param
+	for _, s := range strings.Split(op.path, "/") {
+		if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+			continue
+		}
+		p := param{name: s[1 : len(s)-1], in: "path", typ: "string", desc: pathParamDescriptions[s]}
+		for _, described := range op.params {
+			if described.in == "path" && described.name == p.name {
+				p.name = ""
+			}
+		}
+		if p.name != "" {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+var pathParamDescriptions = map[string]string{
+	"{uuid}":  "UUID of a connected device.",
+	"{name}":  "Name of the group.",
+	"{token}": "Token of a loaded item, from the url listed at /content.",
+}
+
+// param is a query or path param of an operation.
+type param struct {
+	name     string
+	in       string
+	typ      string
+	desc     string
+	required bool
+	repeated bool
+	enum     []string
+}
+
+func query(name, typ, desc string) param {
+	return param{name: name, in: "query", typ: typ, desc: desc}
+}
+
+// must returns p, required.
+func (p param) must() param {
+	p.required = true
+	return p
+}
+
+// multi returns p, given any number of times.
+func (p param) multi() param {
+	p.repeated = true
+	return p
+}
+
+// of returns p, limited to values.
+func (p param) of(values ...string) param {
+	p.enum = values
+	return p
+}
+
+// oneOf is a response that is one of its values.
+type oneOf []interface{}
+
+// enums are the values of string types that are limited to some values.
+var enums = map[reflect.Type][]string{
+	reflect.TypeOf(application.SleepUntil("")): {
+		string(application.SleepAfter),
+		string(application.SleepEndOfItem),
+		string(application.SleepEndOfQueue),
+	},
+	reflect.TypeOf(application.SleepAction("")): {
+		string(application.SleepStop),
+		string(application.SleepPause),
+	},
+	reflect.TypeOf(scheduler.ActionType("")): {
+		string(scheduler.Connect),
+		string(scheduler.Load),
+		string(scheduler.Volume),
+		string(scheduler.Stop),
+		string(scheduler.Disconnect),
+	},
+	reflect.TypeOf(dev.Mode("")): {
+		string(dev.DirectPlay),
+		string(dev.Remux),
+		string(dev.Transcode),
+	},
+}
+
+// tagged returns ops with their tag set to tag.
+func tagged(tag string, ops ...operation) []operation {
+	for i := range ops {
+		ops[i].tag = tag
+	}
+	return ops
+}
+
+func concat(sections ...[]operation) []operation {
+	var ops []operation
+	for _, s := range sections {
+		ops = append(ops, s...)
+	}
+	return ops
+}
+
+// targets returns the params of a route run on a device or every member of
+// a group, followed by params.
+func targets(params ...param) []param {
+	return append([]param{
+		query("uuid", "string", "UUID of a connected device, needed unless 'group' is set."),
+		query("group", "string", "Name of a group, to run on every member of instead."),
+	}, params...)
+}
+
+var (
+	uuidParam     = query("uuid", "string", "UUID of a connected device.").must()
+	stopParam     = query("stop", "boolean", "Stop the media playing first.")
+	playbackState = oneOf{chttp.StatusResponse{}, chttp.GroupResponse{}}
+	volumeState   = oneOf{chttp.VolumeResponse{}, chttp.GroupResponse{}}
+)
+
+// playback returns the operation of a legacy route controlling playback.
+func playback(path, id, summary string, params ...param) operation {
+	return operation{
+		method:   http.MethodPost,
+		path:     path,
+		id:       id,
+		summary:  summary,
+		params:   targets(params...),
+		returns:  "The status of the device, or the result of every member of the group.",
+		response: playbackState,
+	}
+}
+
+// apiOperations describes every route of the Handler.
+var apiOperations = concat(
+	tagged("devices",
+		operation{
+			method: http.MethodGet, path: "/devices", id: "listDevices",
+			summary: "Discovers the devices on the network.",
+			params: []param{
+				query("interface", "string", "Network interface to discover devices on."),
+				query("wait", "integer", "Seconds to wait for devices to answer, 3 unless set."),
+			},
+			response: []device{},
+		},
+		operation{
+			method: http.MethodPost, path: "/connect", id: "connect",
+			summary: "Connects to a device, looking up its address unless it is given.",
+			params: []param{
+				uuidParam,
+				query("addr", "string", "Address of the device."),
+				query("port", "integer", "Port of the device."),
+				query("interface", "string", "Network interface to look the device up on."),
+				query("wait", "integer", "Seconds to wait for the device to answer the lookup."),
+				query("hls", "boolean", "Serve video to the device as HLS."),
+				query("image_width", "integer", "Width images are scaled to fit."),
+				query("image_height", "integer", "Height images are scaled to fit."),
+			},
+			response: chttp.ConnectResponse{},
+		},
+		operation{
+			method: http.MethodPost, path: "/disconnect", id: "disconnect",
+			summary: "Disconnects from a device, or every member of a group.",
+			params:  targets(stopParam),
+			returns: "The device disconnected from, or the result of every member of the group.",
+			raw:     "text/plain", response: chttp.GroupResponse{},
+		},
+		operation{
+			method: http.MethodPost, path: "/disconnect-all", id: "disconnectAll",
+			summary:  "Disconnects from every connected device.",
+			params:   []param{stopParam},
+			response: []chttp.ConnectResponse{},
+		},
+		operation{
+			method: http.MethodPost, path: "/status", id: "status",
+			summary:  "Returns the status of a device and the media playing on it.",
+			params:   []param{uuidParam},
+			response: chttp.StatusResponse{},
+		},
+	),
+	tagged("playback",
+		playback("/pause", "pause", "Pauses the media playing."),
+		playback("/unpause", "unpause", "Resumes the media paused."),
+		playback("/stop", "stop", "Stops the media playing."),
+		playback("/next", "next", "Skips to the next item in the queue."),
+		playback("/previous", "previous", "Goes back to the previous item in the queue."),
+		playback("/skip", "skip", "Skips to the end of the media playing."),
+		playback("/rewind", "rewind", "Rewinds the media playing.",
+			query("seconds", "integer", "Seconds to rewind by.").must()),
+		playback("/seek", "seek", "Seeks forward in the media playing.",
+			query("seconds", "integer", "Seconds to seek by.").must()),
+		playback("/seek-to", "seekTo", "Seeks to a time in the media playing.",
+			query("seconds", "number", "Seconds from the start of the media.").must()),
+		operation{
+			method: http.MethodPost, path: "/load", id: "load",
+			summary: "Loads a file, directory, playlist or url.",
+			params: targets(
+				query("path", "string", "File, directory, playlist or url to load.").must(),
+				query("content_type", "string", "Content type of the media, detected unless set."),
+				query("relay", "boolean", "Relay the http or https url through pusher, such as an internet radio station."),
+				query("reload_metadata", "boolean", "Reload the metadata of relayed stations as it changes."),
+				query("allow_any_client", "boolean", "Serve the media to any client, even with the client allowlist on, for debugging."),
+			),
+			returns:  "Nothing for a device, or the result of every member of the group.",
+			response: chttp.GroupResponse{},
+		},
+		operation{
+			method: http.MethodPost, path: "/announce", id: "announce",
+			summary: "Plays a clip on devices, then restores what was playing.",
+			params: []param{
+				query("uuid", "string", "UUID of a connected device.").must().multi(),
+				query("path", "string", "File or url of the clip.").must(),
+				query("content_type", "string", "Content type of the clip, detected unless set."),
+				query("volume", "number", "Volume the clip is played at, between 0 and 1."),
+			},
+			response: []chttp.AnnounceResult{},
+		},
+		operation{
+			method: http.MethodPost, path: "/slideshow", id: "slideshow",
+			summary: "Shows images on a device with a display.",
+			params: []param{
+				uuidParam,
+				query("path", "string", "Image to show.").must().multi(),
+				query("duration", "integer", "Seconds each image is shown for, 10 unless set."),
+				query("repeat", "boolean", "Start over once every image has been shown."),
+			},
+			status: http.StatusAccepted,
+		},
+	),
+	tagged("volume",
+		operation{
+			method: http.MethodGet, path: "/volume", id: "volume",
+			summary:  "Returns the volume of a device.",
+			params:   []param{uuidParam},
+			response: chttp.VolumeResponse{},
+		},
+		operation{
+			method: http.MethodPost, path: "/volume", id: "setVolume",
+			summary:  "Sets the volume of a device, or every member of a group.",
+			params:   targets(query("volume", "number", "Volume between 0 and 1.").must()),
+			returns:  "The volume of the device, or the result of every member of the group.",
+			response: volumeState,
+		},
+		operation{
+			method: http.MethodPost, path: "/mute", id: "mute",
+			summary:  "Mutes a device, or every member of a group.",
+			params:   targets(),
+			returns:  "The volume of the device, or the result of every member of the group.",
+			response: volumeState,
+		},
+		operation{
+			method: http.MethodPost, path: "/unmute", id: "unmute",
+			summary:  "Unmutes a device, or every member of a group.",
+			params:   targets(),
+			returns:  "The volume of the device, or the result of every member of the group.",
+			response: volumeState,
+		},
+	),
+	tagged("groups",
+		operation{
+			method: http.MethodGet, path: "/groups", id: "listGroups",
+			summary:  "Lists every group.",
+			response: []chttp.Group{},
+		},
+		operation{
+			method: http.MethodPost, path: "/groups/set", id: "setGroup",
+			summary: "Creates a group, or replaces its members.",
+			params: []param{
+				query("name", "string", "Name of the group.").must(),
+				query("member", "string", "UUID or friendly name of a device.").must().multi(),
+			},
+			response: chttp.Group{},
+		},
+		operation{
+			method: http.MethodPost, path: "/groups/delete", id: "deleteGroup",
+			summary: "Deletes a group.",
+			params:  []param{query("name", "string", "Name of the group.").must()},
+			raw:     "text/plain",
+		},
+	),
+	tagged("sync",
+		operation{
+			method: http.MethodGet, path: "/sync", id: "listSyncGroups",
+			summary:  "Lists every sync group.",
+			response: []application.SyncStatus{},
+		},
+		operation{
+			method: http.MethodPost, path: "/sync/play", id: "playSync",
+			summary: "Plays media on devices in step, creating the sync group if the devices are given.",
+			params: []param{
+				query("name", "string", "Name of the sync group.").must(),
+				query("uuid", "string", "UUID of a connected device, needed at least twice for a new sync group.").multi(),
+				query("path", "string", "File or url to play.").must(),
+				query("content_type", "string", "Content type of the media, detected unless set."),
+			},
+			response: application.SyncStatus{},
+		},
+		operation{
+			method: http.MethodPost, path: "/sync/pause", id: "pauseSync",
+			summary:  "Pauses every device of a sync group.",
+			params:   []param{query("name", "string", "Name of the sync group.").must()},
+			response: application.SyncStatus{},
+		},
+		operation{
+			method: http.MethodPost, path: "/sync/unpause", id: "unpauseSync",
+			summary:  "Resumes every device of a sync group in step.",
+			params:   []param{query("name", "string", "Name of the sync group.").must()},
+			response: application.SyncStatus{},
+		},
+		operation{
+			method: http.MethodPost, path: "/sync/stop", id: "stopSync",
+			summary: "Stops every device of a sync group, and removes it.",
+			params:  []param{query("name", "string", "Name of the sync group.").must()},
+			raw:     "text/plain",
+		},
+	),
+	tagged("transcodes",
+		operation{
+			method: http.MethodGet, path: "/transcodes", id: "listTranscodes",
+			summary:  "Lists the transcoder processes running.",
+			response: []transcode.JobInfo{},
+		},
+		operation{
+			method: http.MethodPost, path: "/transcodes/stop", id: "stopTranscode",
+			summary: "Stops a transcoder process.",
+			params:  []param{query("id", "integer", "ID of the transcode job.").must()},
+			raw:     "text/plain",
+		},
+	),
+	tagged("sleep",
+		operation{
+			method: http.MethodPost, path: "/sleep", id: "sleep",
+			summary: "Sets a sleep timer, for some minutes or until the end of the item or queue.",
+			params: []param{
+				uuidParam,
+				query("minutes", "integer", "Minutes until the timer ends, needed unless 'until' is set."),
+				query("until", "string", "End of what the timer ends at.").of(
+					string(application.SleepEndOfItem), string(application.SleepEndOfQueue)),
+				query("action", "string", "What is done once the timer ends, stop unless set.").of(
+					string(application.SleepStop), string(application.SleepPause)),
+				query("fade", "integer", "Seconds the volume fades out for."),
+			},
+			response: application.SleepInfo{},
+		},
+		operation{
+			method: http.MethodPost, path: "/sleep/extend", id: "extendSleep",
+			summary: "Extends the sleep timer.",
+			params: []param{
+				uuidParam,
+				query("minutes", "integer", "Minutes to extend the timer by.").must(),
+			},
+			response: application.SleepInfo{},
+		},
+		operation{
+			method: http.MethodPost, path: "/sleep/cancel", id: "cancelSleep",
+			summary: "Cancels the sleep timer.",
+			params:  []param{uuidParam},
+			raw:     "text/plain",
+		},
+	),
+	tagged("schedules",
+		operation{
+			method: http.MethodGet, path: "/schedules", id: "listSchedules",
+			summary:  "Lists every schedule.",
+			response: []scheduler.Job{},
+		},
+		operation{
+			method: http.MethodPost, path: "/schedules/add", id: "addSchedule",
+			summary:  "Adds a schedule.",
+			body:     scheduler.Job{},
+			response: scheduler.Job{},
+		},
+		operation{
+			method: http.MethodPost, path: "/schedules/update", id: "updateSchedule",
+			summary:  "Replaces a schedule.",
+			params:   []param{query("id", "string", "ID of the schedule.").must()},
+			body:     scheduler.Job{},
+			response: scheduler.Job{},
+		},
+		operation{
+			method: http.MethodPost, path: "/schedules/delete", id: "deleteSchedule",
+			summary: "Deletes a schedule.",
+			params:  []param{query("id", "string", "ID of the schedule.").must()},
+			raw:     "text/plain",
+		},
+		operation{
+			method: http.MethodPost, path: "/schedules/run", id: "runSchedule",
+			summary: "Runs the actions of a schedule now.",
+			params:  []param{query("id", "string", "ID of the schedule.").must()},
+			raw:     "text/plain",
+		},
+		operation{
+			method: http.MethodGet, path: "/schedules/history", id: "scheduleHistory",
+			summary:  "Lists the runs of a schedule, or of every schedule, newest first.",
+			params:   []param{query("id", "string", "ID of the schedule.")},
+			response: []scheduler.Run{},
+		},
+	),
+	tagged("live",
+		operation{
+			method: http.MethodGet, path: "/live", id: "listLive",
+			summary:  "Lists the live sources devices can play.",
+			response: []chttp.LiveSource{},
+		},
+		operation{
+			method: http.MethodPost, path: "/live/load", id: "loadLive",
+			summary: "Plays a live source on a device.",
+			params: []param{
+				uuidParam,
+				query("source", "string", "Name of the live source.").must(),
+			},
+		},
+	),
+	tagged("events",
+		operation{
+			method: http.MethodGet, path: "/events", id: "streamEvents",
+			summary: "Streams changes to devices as server-sent events, starting with the status of every device " +
+				"followed unless resuming. Comments are sent as a heartbeat.",
+			params: []param{
+				query("uuid", "string", "UUID of a device to follow, every device unless set.").multi(),
+				query("last_event_id", "integer", "ID of the last event seen, to resume from. The Last-Event-ID header is used when set."),
+			},
+			returns: "Events of the types status, media_status, volume, application, connected, disconnected and load_failed.",
+			stream:  chttp.Event{},
+		},
+		operation{
+			method: http.MethodGet, path: "/ws", id: "controlSocket",
+			summary: "Opens a WebSocket to send commands over, as json Command messages, and be sent their results " +
+				"and the events of the devices followed, as json SocketMessage messages. Commands are run in the order " +
+				"they are sent, and their results have the id of the command.",
+			params: []param{
+				query("uuid", "string", "UUID of a device to follow, every device unless set.").multi(),
+				query("last_event_id", "integer", "ID of the last event seen, to resume from."),
+			},
+			status:  http.StatusSwitchingProtocols,
+			returns: "Switched to the WebSocket protocol.",
+		},
+	),
+	tagged("docs",
+		operation{
+			method: http.MethodGet, path: "/openapi.json", id: "openAPI",
+			summary: "Returns this document.", response: map[string]interface{}{}, static: true,
+		},
+		operation{
+			method: http.MethodGet, path: "/openapi/media.json", id: "mediaOpenAPI",
+			summary: "Returns the document describing the local media server.", response: map[string]interface{}{}, static: true,
+		},
+		operation{
+			method: http.MethodGet, path: "/docs", id: "docs",
+			summary: "Returns a page describing every route.", raw: "text/html", static: true,
+		},
+		operation{
+			method: http.MethodGet, path: "/ui/", id: "ui",
+			summary: "Serves the web remote control, which lists, connects and controls devices and plays the media " +
+				"loaded on the local media server. Its assets are public, and it asks for a token when one is needed.",
+			raw: "text/html", static: true,
+		},
+	),
+
+	tagged("v1-devices",
+		operation{
+			method: http.MethodGet, path: apiPrefix + "/devices", id: "apiListDevices",
+			summary: "Discovers the devices on the network.",
+			params: []param{
+				query("interface", "string", "Network interface to discover devices on."),
+				query("wait", "integer", "Seconds to wait for devices to answer, 3 unless set."),
+			},
+			response: []device{},
+		},
+		operation{
+			method: http.MethodGet, path: apiPrefix + "/devices/{uuid}", id: "apiDeviceStatus",
+			summary:  "Returns the status of a device and the media playing on it.",
+			response: chttp.StatusResponse{},
+		},
+		operation{
+			method: http.MethodPut, path: apiPrefix + "/devices/{uuid}/connection", id: "apiConnect",
+			summary: "Connects to a device, looking up its address unless it is given.",
+			body:    chttp.ConnectRequest{}, optionalBody: true,
+			response: chttp.ConnectResponse{},
+		},
+		operation{
+			method: http.MethodDelete, path: apiPrefix + "/devices/{uuid}/connection", id: "apiDisconnect",
+			summary: "Disconnects from a device.",
+			params:  []param{stopParam},
+			status:  http.StatusNoContent,
+		},
+		operation{
+			method: http.MethodPost, path: apiPrefix + "/devices/{uuid}/playback", id: "apiPlayback",
+			summary:  "Controls the media playing.",
+			body:     chttp.PlaybackRequest{},
+			response: chttp.StatusResponse{},
+		},
+		operation{
+			method: http.MethodGet, path: apiPrefix + "/devices/{uuid}/volume", id: "apiVolume",
+			summary:  "Returns the volume of a device.",
+			response: chttp.VolumeResponse{},
+		},
+		operation{
+			method: http.MethodPut, path: apiPrefix + "/devices/{uuid}/volume", id: "apiSetVolume",
+			summary:  "Sets the volume of a device.",
+			body:     chttp.VolumeRequest{},
+			response: chttp.VolumeResponse{},
+		},
+		operation{
+			method: http.MethodPost, path: apiPrefix + "/devices/{uuid}/media", id: "apiLoad",
+			summary:  "Loads a file, directory, playlist or url.",
+			body:     chttp.LoadRequest{},
+			response: chttp.StatusResponse{},
+		},
+		operation{
+			method: http.MethodPost, path: apiPrefix + "/devices/{uuid}/live", id: "apiLoadLive",
+			summary:  "Plays a live source.",
+			body:     chttp.LiveRequest{},
+			response: chttp.StatusResponse{},
+		},
+		operation{
+			method: http.MethodGet, path: apiPrefix + "/devices/{uuid}/sleep", id: "apiSleepStatus",
+			summary:  "Returns the sleep timer.",
+			response: application.SleepInfo{},
+		},
+		operation{
+			method: http.MethodPut, path: apiPrefix + "/devices/{uuid}/sleep", id: "apiSleep",
+			summary:  "Sets a sleep timer, for some minutes or until the end of the item or queue.",
+			body:     chttp.SleepRequest{},
+			response: application.SleepInfo{},
+		},
+		operation{
+			method: http.MethodPost, path: apiPrefix + "/devices/{uuid}/sleep/extend", id: "apiExtendSleep",
+			summary:  "Extends the sleep timer.",
+			body:     chttp.SleepRequest{},
+			response: application.SleepInfo{},
+		},
+		operation{
+			method: http.MethodDelete, path: apiPrefix + "/devices/{uuid}/sleep", id: "apiCancelSleep",
+			summary: "Cancels the sleep timer.",
+			status:  http.StatusNoContent,
+		},
+	),
+	tagged("v1-groups",
+		operation{
+			method: http.MethodGet, path: apiPrefix + "/groups", id: "apiListGroups",
+			summary:  "Lists every group.",
+			response: []chttp.Group{},
+		},
+		operation{
+			method: http.MethodPut, path: apiPrefix + "/groups/{name}", id: "apiSetGroup",
+			summary:  "Creates a group, or replaces its members.",
+			body:     chttp.GroupRequest{},
+			response: chttp.Group{},
+		},
+		operation{
+			method: http.MethodDelete, path: apiPrefix + "/groups/{name}", id: "apiDeleteGroup",
+			summary: "Deletes a group.",
+			status:  http.StatusNoContent,
+		},
+		operation{
+			method: http.MethodDelete, path: apiPrefix + "/groups/{name}/connection", id: "apiDisconnectGroup",
+			summary:  "Disconnects from every member of a group.",
+			params:   []param{stopParam},
+			response: chttp.GroupResponse{},
+		},
+		operation{
+			method: http.MethodPost, path: apiPrefix + "/groups/{name}/playback", id: "apiGroupPlayback",
+			summary:  "Controls the media playing on every member of a group.",
+			body:     chttp.PlaybackRequest{},
+			response: chttp.GroupResponse{},
+		},
+		operation{
+			method: http.MethodPut, path: apiPrefix + "/groups/{name}/volume", id: "apiGroupVolume",
+			summary:  "Sets the volume of every member of a group.",
+			body:     chttp.VolumeRequest{},
+			response: chttp.GroupResponse{},
+		},
+		operation{
+			method: http.MethodPost, path: apiPrefix + "/groups/{name}/media", id: "apiGroupLoad",
+			summary:  "Loads a file, directory, playlist or url on every member of a group.",
+			body:     chttp.LoadRequest{},
+			response: chttp.GroupResponse{},
+		},
+	),
+	tagged("v1-schedules",
+		operation{
+			method: http.MethodGet, path: apiPrefix + "/schedules", id: "apiListSchedules",
+			summary:  "Lists every schedule.",
+			response: []scheduler.Job{},
+		},
+		operation{
+			method: http.MethodPost, path: apiPrefix + "/schedules", id: "apiAddSchedule",
+			summary:  "Adds a schedule.",
+			body:     scheduler.Job{},
+			status:   http.StatusCreated,
+			response: scheduler.Job{},
+		},
+		operation{
+			method: http.MethodGet, path: apiPrefix + "/schedules/{id}", id: "apiSchedule",
+			summary:  "Returns a schedule.",
+			params:   []param{{name: "id", in: "path", typ: "string", desc: "ID of the schedule."}},
+			response: scheduler.Job{},
+		},
+		operation{
+			method: http.MethodPut, path: apiPrefix + "/schedules/{id}", id: "apiUpdateSchedule",
+			summary:  "Replaces a schedule.",
+			params:   []param{{name: "id", in: "path", typ: "string", desc: "ID of the schedule."}},
+			body:     scheduler.Job{},
+			response: scheduler.Job{},
+		},
+		operation{
+			method: http.MethodDelete, path: apiPrefix + "/schedules/{id}", id: "apiDeleteSchedule",
+			summary: "Deletes a schedule.",
+			params:  []param{{name: "id", in: "path", typ: "string", desc: "ID of the schedule."}},
+			status:  http.StatusNoContent,
+		},
+		operation{
+			method: http.MethodPost, path: apiPrefix + "/schedules/{id}/run", id: "apiRunSchedule",
+			summary:  "Runs the actions of a schedule now.",
+			params:   []param{{name: "id", in: "path", typ: "string", desc: "ID of the schedule."}},
+			status:   http.StatusAccepted,
+			response: scheduler.Job{},
+		},
+		operation{
+			method: http.MethodGet, path: apiPrefix + "/schedules/{id}/history", id: "apiScheduleHistory",
+			summary:  "Lists the runs of a schedule, newest first.",
+			params:   []param{{name: "id", in: "path", typ: "string", desc: "ID of the schedule."}},
+			response: []scheduler.Run{},
+		},
+	),
+	tagged("v1-transcodes",
+		operation{
+			method: http.MethodGet, path: apiPrefix + "/transcodes", id: "apiListTranscodes",
+			summary:  "Lists the transcoder processes running.",
+			response: []transcode.JobInfo{},
+		},
+		operation{
+			method: http.MethodDelete, path: apiPrefix + "/transcodes/{id}", id: "apiStopTranscode",
+			summary: "Stops a transcoder process.",
+			params:  []param{{name: "id", in: "path", typ: "integer", desc: "ID of the transcode job."}},
+			status:  http.StatusNoContent,
+		},
+	),
+	tagged("v1-live",
+		operation{
+			method: http.MethodGet, path: apiPrefix + "/live", id: "apiListLive",
+			summary:  "Lists the live sources devices can play.",
+			response: []chttp.LiveSource{},
+		},
+	),
+	tagged("v1-library",
+		operation{
+			method: http.MethodGet, path: apiPrefix + "/library", id: "apiListLibrary",
+			summary:  "Lists the media loaded by the local media server, empty until some is.",
+			response: []chttp.LibraryItem{},
+		},
+	),
+)
+
+// mediaOperations describes every route of the local media server.
+var mediaOperations = tagged("media",
+	operation{
+		method: http.MethodPost, path: "/load", id: "loadMedia",
+		summary: "Loads a directory, file, playlist or url, replacing the media loaded.",
+		params: []param{
+			query("target", "string", "Directory, file or playlist to serve, or an http or https stream to relay.").must(),
+			query("allow_any_client", "boolean", "Serve the items to any client, even with the client allowlist on, for debugging."),
+		},
+		raw: "text/plain",
+	},
+	operation{
+		method: http.MethodGet, path: "/content", id: "listContent",
+		summary: "Lists the media loaded as csv of id and url, or describes an item.",
+		params: []param{
+			query("id", "integer", "Index of an item to describe as json."),
+		},
+		returns:  "The csv list of items, or the item with 'id'.",
+		raw:      "text/plain",
+		response: contentDetails{},
+	},
+	operation{
+		method: http.MethodGet, path: "/", id: "serveMedia",
+		summary: "Rejects requests for anything but loaded media, which is only served at the urls listed at /content.",
+	},
+	operation{
+		method: http.MethodGet, path: "/m/{token}", id: "mediaItem",
+		summary: "Serves a loaded item. Range requests are supported for files. With the client allowlist on, " +
+			"items are only served to connected devices, the host and the networks allowed, and are forbidden otherwise.",
+		raw: "application/octet-stream",
+	},
+)
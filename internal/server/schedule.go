@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/avinash240/pusher/internal/scheduler"
+	application "github.com/avinash240/pusher/internal/server/application"
+)
+
+// scheduleRunner runs scheduled actions against devices, discovering and
+// connecting to them as needed.
+type scheduleRunner struct {
+	h *Handler
+}
+
+func (r scheduleRunner) RunAction(ctx context.Context, a scheduler.Action) error {
+	apps, err := r.h.appsFor(ctx, a.Device, a.Type != scheduler.Disconnect)
+	if err != nil && len(apps) == 0 {
+		return err
+	}
+
+	var errs []error
+	if err != nil {
+		// The members of a group that could be connected to still run
+		// the action.
+		errs = append(errs, err)
+	}
+	for uuid, app := range apps {
+		if err := r.run(uuid, app, a); err != nil {
+			errs = append(errs, fmt.Errorf("device %s: %v", uuid, err))
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return fmt.Errorf("%d devices failed, first: %v", len(errs), errs[0])
+	}
+}
+
+func (r scheduleRunner) run(uuid string, app *application.Application, a scheduler.Action) error {
+	switch a.Type {
+	case scheduler.Connect:
+		return nil
+	case scheduler.Load:
+		err := app.Load(a.Path, a.ContentType, true, true, true, false)
+		if err != nil {
+			r.h.events.loadFailed(uuid, err)
+		}
+		return err
+	case scheduler.Volume:
+		return app.SetVolume(a.Volume)
+	case scheduler.Stop:
+		if err := app.Update(); err != nil {
+			return err
+		}
+		// Devices with nothing playing are already stopped.
+		if err := app.StopMedia(); err != nil && err != application.ErrNoMediaStop {
+			return err
+		}
+		return nil
+	case scheduler.Disconnect:
+		h := r.h
+		h.mu.Lock()
+		delete(h.apps, uuid)
+		h.mu.Unlock()
+		h.disconnected(uuid)
+		return app.Close(false)
+	}
+	return errors.Errorf("unknown action %q", a.Type)
+}
+
+// appsFor returns the applications of the devices named by uuid or friendly
+// name, of every member of the group name, or of every device for
+// scheduler.AllDevices, keyed by uuid. Devices that aren't connected are
+// connected to when connect is set, and left out otherwise. Members of a
+// group that can't be connected to are left out too, and returned as an
+// error along with the applications of the rest.
+func (h *Handler) appsFor(ctx context.Context, name string, connect bool) (map[string]*application.Application, error) {
+	members, ok := h.group(name)
+	if !ok {
+		return h.deviceApps(ctx, name, connect)
+	}
+	apps := map[string]*application.Application{}
+	var errs []error
+	for _, member := range members {
+		memberApps, err := h.deviceApps(ctx, member, connect)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "member %s", member))
+			continue
+		}
+		for uuid, app := range memberApps {
+			apps[uuid] = app
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return apps, nil
+	case 1:
+		return apps, errs[0]
+	default:
+		return apps, fmt.Errorf("%d members failed, first: %v", len(errs), errs[0])
+	}
+}
+
+// deviceApps is appsFor, for devices alone.
+func (h *Handler) deviceApps(ctx context.Context, name string, connect bool) (map[string]*application.Application, error) {
+	apps := map[string]*application.Application{}
+	if app, ok := h.app(name); ok {
+		apps[name] = app
+		return apps, nil
+	}
+	h.mu.Lock()
+	uuid, ok := h.uuids[name]
+	h.mu.Unlock()
+	if app, found := h.app(uuid); ok && found {
+		apps[uuid] = app
+		return apps, nil
+	}
+
+	devices := h.discoverDnsEntries(ctx, "", "")
+	for _, d := range devices {
+		if name != scheduler.AllDevices && name != d.UUID && name != d.DeviceName && name != d.Name {
+			continue
+		}
+		if app, ok := h.app(d.UUID); ok {
+			apps[d.UUID] = app
+			continue
+		}
+		if !connect {
+			continue
+		}
+		log.Printf("connecting to %s (%s) for schedule", d.DeviceName, d.UUID)
+		app, err := h.connectOnce(d.UUID, d.Addr, d.Port)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to connect to device %s", d.UUID)
+		}
+		apps[d.UUID] = app
+	}
+	if len(apps) == 0 && connect {
+		return nil, errors.Errorf("no device found for %q", name)
+	}
+	return apps, nil
+}
+
+func (h *Handler) listSchedules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.scheduler.Jobs(), "schedules")
+}
+
+func (h *Handler) addSchedule(w http.ResponseWriter, r *http.Request) {
+	var job scheduler.Job
+	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+		httpValidationError(w, fmt.Sprintf("unable to decode schedule: %v", err))
+		return
+	}
+	job, err := h.scheduler.Add(job)
+	if errors.Is(err, scheduler.ErrNotLoaded) {
+		httpError(w, err)
+		return
+	}
+	if err != nil {
+		httpValidationError(w, err.Error())
+		return
+	}
+	log.Printf("added schedule %s (%s)", job.ID, job.Name)
+	writeJSON(w, job, "schedule")
+}
+
+func (h *Handler) updateSchedule(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		httpValidationError(w, "missing 'id' in query paramater")
+		return
+	}
+	var job scheduler.Job
+	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+		httpValidationError(w, fmt.Sprintf("unable to decode schedule: %v", err))
+		return
+	}
+	job, err := h.scheduler.Update(id, job)
+	if errors.Is(err, scheduler.ErrNotLoaded) {
+		httpError(w, err)
+		return
+	}
+	if err != nil {
+		httpValidationError(w, err.Error())
+		return
+	}
+	log.Printf("updated schedule %s (%s)", job.ID, job.Name)
+	writeJSON(w, job, "schedule")
+}
+
+func (h *Handler) deleteSchedule(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	err := h.scheduler.Remove(id)
+	if errors.Is(err, scheduler.ErrNotLoaded) {
+		httpError(w, err)
+		return
+	}
+	if err != nil {
+		httpValidationError(w, err.Error())
+		return
+	}
+	log.Printf("deleted schedule %s", id)
+	fmt.Fprintf(w, "Deleted schedule %s\n", id)
+}
+
+func (h *Handler) runSchedule(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if err := h.scheduler.RunNow(id); err != nil {
+		httpValidationError(w, err.Error())
+		return
+	}
+	log.Printf("running schedule %s", id)
+	fmt.Fprintf(w, "Running schedule %s\n", id)
+}
+
+func (h *Handler) scheduleHistory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.scheduler.History(r.URL.Query().Get("id")), "schedule history")
+}
+
+// writeJSON writes v as the json response, describing it as what in
+// errors.
+func writeJSON(w http.ResponseWriter, v interface{}, what string) {
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error encoding json: %v", err)
+		httpError(w, fmt.Errorf("unable to json encode %s: %v", what, err))
+	}
+}
@@ -1,15 +1,23 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
+	auth "github.com/avinash240/pusher/internal/server/auth"
+	dev "github.com/avinash240/pusher/internal/server/device"
+	media "github.com/avinash240/pusher/internal/server/media"
 	ls "github.com/avinash240/pusher/internal/streaming"
+	probe "github.com/avinash240/pusher/internal/streaming/probe"
+	radio "github.com/avinash240/pusher/internal/streaming/radio"
 )
 
 // getLocalAddress returns IP address for eth0 or wlan0, or error if no matching
@@ -64,16 +72,23 @@ type streamItem struct {
 	contentType string
 	contentURL  string
 	transcode   bool
+	// token is the opaque token the item is served at.
+	token string
 }
 
-// load takes http.Response, http.Request from http handler, and the server
-// address and port number; returns an array of streaming items or error.
-// load is used by webserver as a http handle function. load walks the directory
-// specified to the webserver in the media_file parameter, and load assets into
-// data structure for streaming. load must be ran first or server will not serve
-// content.
-func load(w http.ResponseWriter, r *http.Request, address net.IP, port int) ([]streamItem, error) {
+// load takes http.Response, http.Request from http handler, the media session
+// to serve from, and the server address and port number; returns an array of
+// streaming items or error. load is used by webserver as a http handle
+// function. load walks the directory specified to the webserver in the target
+// parameter, and registers assets with the session for streaming. Assets are
+// only served at the opaque token urls listed by contentQuery. Remote http
+// targets are relayed rather than walked. Items are served to any client,
+// even those not in the allowlist of the media server, when the
+// allow_any_client parameter is set, which is meant for debugging. load
+// must be ran first or server will not serve content.
+func load(w http.ResponseWriter, r *http.Request, session *media.Session, address net.IP, port int) ([]streamItem, error) {
 	target := r.URL.Query().Get("target")
+	allowAny := r.URL.Query().Get("allow_any_client") == "true"
 	//transcode := r.URL.Query().Get("live_streaming")
 	//TODO: something with live_streaming transcoding with ffmeg?
 	if target == "" {
@@ -82,11 +97,8 @@ func load(w http.ResponseWriter, r *http.Request, address net.IP, port int) ([]s
 		http.Error(w, msg, 400)
 		return nil, fmt.Errorf(msg)
 	}
-	if strings.Contains(target, "://") { //Do we expect remote URL here?
-		msg := fmt.Sprintf("Remote URL not supported: %s", target)
-		sendMsg(msg)
-		http.Error(w, msg, 400)
-		return nil, fmt.Errorf(msg)
+	if strings.Contains(target, "://") {
+		return loadRelay(w, target, session, address, port, allowAny)
 	}
 
 	var streamItems []streamItem
@@ -99,8 +111,6 @@ func load(w http.ResponseWriter, r *http.Request, address net.IP, port int) ([]s
 	streamItems = make([]streamItem, len(assets.FilePaths))
 	for i, item := range assets.FilePaths {
 		filename := filepath.Base(item)
-		p := filepath.Join(assets.StrictPath, filename)
-		streamItems[i].filename = p
 		ext := filepath.Ext(filename)
 		if ext == "" {
 			streamItems[i].contentType = "Unknown"
@@ -109,12 +119,31 @@ func load(w http.ResponseWriter, r *http.Request, address net.IP, port int) ([]s
 		}
 
 		streamItems[i].transcode = false //TODO: something about transcoding
+		// Playlists may list remote media, which is played from where it is.
+		if assets.Entries != nil && assets.Entries[i].Remote() {
+			streamItems[i].filename = item
+			streamItems[i].contentURL = item
+			continue
+		}
+
+		p, err := filepath.Abs(item)
+		if err != nil {
+			p = filepath.Join(assets.StrictPath, filename)
+		}
+		streamItems[i].filename = p
+		token, err := session.Register(media.Item{Filename: p, AllowAnyClient: allowAny})
+		if err != nil {
+			unregister(session, streamItems[:i])
+			sendMsg(err.Error())
+			http.Error(w, err.Error(), 500)
+			return nil, err
+		}
+		streamItems[i].token = token
 		url := fmt.Sprintf(
-			"http://%s:%d?media_file=%s&live_streaming=%t",
+			"http://%s:%d%s",
 			address.String(),
 			port,
-			streamItems[i].filename,
-			streamItems[i].transcode,
+			session.ItemPath(token, ""),
 		)
 		streamItems[i].contentURL = url
 	}
@@ -124,71 +153,80 @@ func load(w http.ResponseWriter, r *http.Request, address net.IP, port int) ([]s
 	return streamItems, nil
 }
 
-// mediaServer is used by webserver as a http handle function. mediaServer will
-// list for a URL formate to serve content from loaded media. load() needs to be
-// ran first
-func mediaServer(w http.ResponseWriter, r *http.Request, sI []streamItem, loaded bool) error {
-	if !loaded {
-		msg := "no media loaded"
+// loadRelay registers a relay of the remote stream at target, such as an
+// internet radio station, so it is served through the media server with any
+// ICY metadata stripped. Only http and https streams can be relayed.
+func loadRelay(w http.ResponseWriter, target string, session *media.Session, address net.IP, port int, allowAny bool) ([]streamItem, error) {
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		msg := fmt.Sprintf("Remote URL not supported: %s", target)
 		sendMsg(msg)
 		http.Error(w, msg, 400)
-		return fmt.Errorf(msg)
+		return nil, fmt.Errorf(msg)
 	}
-	// URL Format: media_file=%s&live_streaming=%t
-	qs := strings.Split(r.URL.RawQuery, "&")
-	mf := r.URL.Query().Get("media_file")
-	//ls, err := strconv.ParseBool(r.URL.Query().Get("live_streaming")) // live stream transcoding?
-	//if err != nil {
-	// 	http.Error(w,err.Err(),400)
-	// 	return err
-	// }
-	if mf == "" {
-		msg := fmt.Sprintf("media_file parameter not found in %s", qs)
-		sendMsg(msg)
-		http.Error(w, msg, 400)
-		return fmt.Errorf(msg)
+	token, err := session.Register(media.Item{
+		Filename:       target,
+		Handler:        radio.NewRelay(target),
+		AllowAnyClient: allowAny,
+	})
+	if err != nil {
+		sendMsg(err.Error())
+		http.Error(w, err.Error(), 500)
+		return nil, err
+	}
+	item := streamItem{
+		filename:    target,
+		contentType: "Unknown",
+		contentURL:  fmt.Sprintf("http://%s:%d%s", address.String(), port, session.ItemPath(token, "")),
+		token:       token,
 	}
-	contentAvailable := false
-	var idx []int
+	sendMsg(fmt.Sprintf("relaying %s", target))
+	fmt.Fprint(w, "Loaded assets.")
+	return []streamItem{item}, nil
+}
 
-	for i, v := range sI {
-		if strings.Contains(v.filename, mf) {
-			contentAvailable = true
-			idx = append(idx, i)
-		}
+// unregister removes items from the session so their urls stop working.
+func unregister(session *media.Session, sI []streamItem) {
+	for _, item := range sI {
+		session.Unregister(item.token)
 	}
-	if len(idx) >= 2 {
-		msgI := fmt.Sprintf("'%s' matches multiple loaded; try again", mf)
-		sendMsg(msgI)
-		matches := []string{msgI, "\nid,url"}
-		for _, i := range idx {
-			s := fmt.Sprintf("%d,%s", i, sI[i].contentURL)
-			matches = append(matches, s)
-		}
-		msg := strings.Join(matches, "\n")
+}
+
+// mediaServer is used by webserver as a http handle function for any request
+// that isn't for a loaded item. Loaded media is only served at the opaque
+// token urls listed by contentQuery, so requests naming files are rejected.
+// load() needs to be ran first
+func mediaServer(w http.ResponseWriter, r *http.Request, sI []streamItem, loaded bool) error {
+	if !loaded {
+		msg := "no media loaded"
+		sendMsg(msg)
 		http.Error(w, msg, 400)
-		return fmt.Errorf(msgI)
+		return fmt.Errorf(msg)
 	}
-	if !contentAvailable {
-		msg := fmt.Sprintf("%s not found in loaded media", mf)
+	if r.URL.Query().Get("media_file") != "" {
+		msg := "media is only served from the urls listed at /content"
 		sendMsg(msg)
 		http.Error(w, msg, 400)
 		return fmt.Errorf(msg)
-	} else {
-		mf = sI[idx[0]].filename
 	}
-
-	//TODO: something for live_streaming parameter? ffmpeg?
-
-	http.ServeFile(w, r, mf)
-	msg := fmt.Sprintf("served file: %s", mf)
+	msg := fmt.Sprintf("%s not found in loaded media", r.URL.Path)
 	sendMsg(msg)
-	return nil
+	http.Error(w, msg, 404)
+	return fmt.Errorf(msg)
+}
+
+// contentDetails is the description of a single loaded asset.
+type contentDetails struct {
+	Filename    string        `json:"filename"`
+	ContentType string        `json:"contentType"`
+	ContentURL  string        `json:"contentURL"`
+	Transcode   bool          `json:"transcode"`
+	Probe       *probe.Info   `json:"probe,omitempty"`
+	Decision    *dev.Decision `json:"decision,omitempty"`
 }
 
 // contentQuery is used by webserver to display a list of loaded assets or error
 // if no loaded content.
-func contentQuery(w http.ResponseWriter, r *http.Request, sI []streamItem, loaded bool) {
+func contentQuery(w http.ResponseWriter, r *http.Request, sI []streamItem, loaded bool, prober *probe.Prober) {
 	if !loaded {
 		msg := fmt.Sprintln("no media loaded")
 		sendMsg(msg)
@@ -211,12 +249,27 @@ func contentQuery(w http.ResponseWriter, r *http.Request, sI []streamItem, loade
 		} else {
 			msg := fmt.Sprintf("sent details for %s", sI[item].filename)
 			sendMsg(msg)
-			v := fmt.Sprintf("{\n  \"filename\": \"%s\",\n  \"contentType\": \"%s\",\n  \"contentURL\": \"%s\",\n  \"transcode\": %t\n}\n",
-				sI[item].filename,
-				sI[item].contentType,
-				sI[item].contentURL,
-				sI[item].transcode)
-			fmt.Fprintf(w, "%s", v)
+			details := contentDetails{
+				Filename:    sI[item].filename,
+				ContentType: sI[item].contentType,
+				ContentURL:  sI[item].contentURL,
+				Transcode:   sI[item].transcode,
+			}
+			// Describe how a chromecast would play local media.
+			if sI[item].token != "" && !strings.Contains(sI[item].filename, "://") {
+				if info, err := prober.Probe(r.Context(), sI[item].filename); err == nil {
+					d := dev.Unknown.Decide(info)
+					details.Probe = info
+					details.Decision = &d
+				}
+			}
+			v, err := json.MarshalIndent(details, "", "  ")
+			if err != nil {
+				sendMsg(err.Error())
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			fmt.Fprintf(w, "%s\n", v)
 			return
 		}
 	}
@@ -228,39 +281,144 @@ func contentQuery(w http.ResponseWriter, r *http.Request, sI []streamItem, loade
 	sendMsg("Outputed list of assets")
 }
 
-// NewLocalServer starts a webserver on port 9002 and all available address. If
-// port not available server will exit.
-func NewLocalServer() {
-	port := 9002
-	loaded := false
-	var sI []streamItem
-	address, err := getLocalAddress()
-	if err != nil {
-		log.Fatalln(err)
+// Library is the media loaded by the local media server. It is shared with
+// the API, which lists it for the web remote.
+type Library struct {
+	mu     sync.Mutex
+	loaded bool
+	items  []streamItem
+}
+
+// NewLibrary returns a library with no media loaded.
+func NewLibrary() *Library {
+	return &Library{}
+}
+
+// set replaces the items loaded, returning those they replace.
+func (l *Library) set(items []streamItem) []streamItem {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	old := l.items
+	l.items, l.loaded = items, true
+	return old
+}
+
+// get returns the items loaded, and whether any load has succeeded.
+func (l *Library) get() ([]streamItem, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.items, l.loaded
+}
+
+// localServer is the media loaded by the local media server.
+type localServer struct {
+	server  *media.Server
+	session *media.Session
+	address net.IP
+	port    int
+	prober  *probe.Prober
+	library *Library
+
+	// Requests are authorised by 'auth' for the scope of their route.
+	auth *auth.Authenticator
+}
+
+// LocalServerOption configures the local media server.
+type LocalServerOption func(*localServer)
+
+// WithLocalServerAuth only serves requests to load or list media made with
+// a token a authenticates, and that has the scope in localScopes. Media is
+// served to devices at the urls listed whatever the token.
+func WithLocalServerAuth(a *auth.Authenticator) LocalServerOption {
+	return func(s *localServer) {
+		s.auth = a
 	}
+}
 
-	msg := fmt.Sprintf("Listening on 0.0.0.0:%d", port)
-	sendMsg(msg)
+// WithLocalServerLibrary loads media into l, rather than a library of its
+// own.
+func WithLocalServerLibrary(l *Library) LocalServerOption {
+	return func(s *localServer) {
+		s.library = l
+	}
+}
 
-	http.HandleFunc("/load", func(w http.ResponseWriter, r *http.Request) {
-		sI, err = load(w, r, address, port)
-		if err == nil {
-			loaded = true
-		}
-	})
+// localScopes are the scopes the routes of the local media server need.
+// Loading reads any path on the host, so it is only for admins.
+var localScopes = map[string]auth.Scope{
+	"/load":    auth.Admin,
+	"/content": auth.Read,
+}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		err := mediaServer(w, r, sI, loaded)
-		if err != nil {
-			return
-		}
-	})
+// handlers returns the handler of every route the local media server
+// serves, by path.
+func (s *localServer) handlers() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"/load": func(w http.ResponseWriter, r *http.Request) {
+			items, err := load(w, r, s.session, s.address, s.port)
+			if err == nil {
+				unregister(s.session, s.library.set(items))
+			}
+		},
+		"/": func(w http.ResponseWriter, r *http.Request) {
+			if !s.server.ClientAllowed(r) {
+				http.Error(w, "media is only served to connected devices", 403)
+				return
+			}
+			items, loaded := s.library.get()
+			mediaServer(w, r, items, loaded)
+		},
+		"/content": func(w http.ResponseWriter, r *http.Request) {
+			items, loaded := s.library.get()
+			contentQuery(w, r, items, loaded, s.prober)
+		},
+	}
+}
 
-	http.HandleFunc("/content", func(w http.ResponseWriter, r *http.Request) {
-		contentQuery(w, r, sI, loaded)
-	})
+// LocalServerRoutes returns every route NewLocalServer serves, sorted by
+// path.
+func LocalServerRoutes() []Route {
+	var routes []Route
+	for path := range (&localServer{}).handlers() {
+		routes = append(routes, Route{Path: path, Scope: localScopes[path]})
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Path < routes[j].Path })
+	return routes
+}
+
+// NewLocalServer serves loaded media from the root namespace of the shared
+// media server, which listens on port 9002 and all available addresses
+// unless configured otherwise. If port not available server will exit.
+// NewLocalServer returns once the media server has been shut down.
+func NewLocalServer(opts ...LocalServerOption) {
+	ms := media.Default()
+	address, err := getLocalAddress()
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	portStr := strconv.Itoa(port)
-	listenAddr := strings.Join([]string{":", portStr}, "")
-	log.Fatal(http.ListenAndServe(listenAddr, nil))
+	s := &localServer{
+		server:  ms,
+		session: ms.NewSession(""),
+		address: address,
+		port:    ms.Port(),
+		prober:  probe.NewProber(),
+		library: NewLibrary(),
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	for path, handler := range s.handlers() {
+		s.session.HandleFunc(path, s.auth.Require(localScopes[path], handler).ServeHTTP)
+	}
+
+	if err := ms.Start(); err != nil {
+		log.Fatalln(err)
+	}
+	msg := fmt.Sprintf("Listening on 0.0.0.0:%d", ms.Port())
+	sendMsg(msg)
+	if err := ms.Wait(); err != nil {
+		log.Fatal(err)
+	}
+	sendMsg("local server stopped")
 }
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	application "github.com/avinash240/pusher/internal/server/application"
+	auth "github.com/avinash240/pusher/internal/server/auth"
+	chttp "github.com/avinash240/pusher/internal/server/chttp"
+)
+
+// Commands run over the control socket.
+const (
+	commandStatus   = "status"
+	commandPlayback = "playback"
+	commandVolume   = "volume"
+	commandLoad     = "load"
+	commandQueue    = "queue"
+)
+
+// Types of the messages sent over the control socket.
+const (
+	socketResult = "result"
+	socketEvent  = "event"
+)
+
+const (
+	// socketMaxMessage is the largest command accepted, in bytes.
+	socketMaxMessage = 64 << 10
+	// socketWriteTimeout is how long a message may take to be written
+	// before the client is disconnected.
+	socketWriteTimeout = 10 * time.Second
+)
+
+// socketConn is a client of the control socket. Results and events are
+// written from their own goroutines, so writes are serialised by mu.
+type socketConn struct {
+	ws *websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *socketConn) send(msg chttp.SocketMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ws.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	return websocket.JSON.Send(c.ws, msg)
+}
+
+// socket serves the control socket, a WebSocket clients send commands over
+// as chttp.Command, and are sent their results and the events of the
+// devices 'uuid', or of every device, as chttp.SocketMessage. Commands are
+// run in the order they are sent, those loading media only for admins.
+// Events start as they do for streamEvents, resuming from 'last_event_id'.
+func (h *Handler) socket(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	// Browsers send the cookies and credentials of the host to sockets
+	// opened by pages of any site, so those are refused.
+	if !h.originAllowed(r) {
+		log.Printf("refusing control socket opened from %q", r.Header.Get("Origin"))
+		http.Error(w, "the control socket can't be opened from "+r.Header.Get("Origin"), http.StatusForbidden)
+		return
+	}
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		ws.MaxPayloadBytes = socketMaxMessage
+		_, err := h.auth.Authorize(r, auth.Admin)
+		h.serveSocket(&socketConn{ws: ws}, auth.Caller(r), err == nil, q["uuid"], q.Get("last_event_id"))
+	}}.ServeHTTP(w, r)
+}
+
+// originAllowed reports whether the page that made r, if any, was served
+// from the host r was sent to or from an allowed origin. Only browsers send
+// an Origin, so requests from other clients are allowed.
+func (h *Handler) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range h.allowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveSocket serves the control socket to c, the caller named remote in
+// logs. Media is only loaded for callers that are admin, as it may be any
+// file on the host or url.
+func (h *Handler) serveSocket(c *socketConn, remote string, admin bool, uuids []string, lastID string) {
+	defer c.ws.Close()
+	s, first := h.events.subscribe(uuids, lastID)
+	defer h.events.unsubscribe(s)
+	log.Printf("control socket opened by %s", remote)
+
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		// Closing the socket ends the loop reading commands below.
+		defer c.ws.Close()
+		for i := range first {
+			if err := c.send(chttp.SocketMessage{Type: socketEvent, Event: &first[i]}); err != nil {
+				return
+			}
+		}
+		for {
+			select {
+			case ev := <-s.events:
+				if err := c.send(chttp.SocketMessage{Type: socketEvent, Event: &ev}); err != nil {
+					return
+				}
+			case <-s.dropped:
+				log.Printf("%s fell behind on events, disconnecting", remote)
+				return
+			case <-h.events.done:
+				return
+			case <-closed:
+				return
+			}
+		}
+	}()
+
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(c.ws, &data); err != nil {
+			log.Printf("control socket closed by %s", remote)
+			return
+		}
+		var cmd chttp.Command
+		var res interface{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&cmd)
+		if err != nil {
+			err = invalid("unable to decode command: %v", err)
+		} else {
+			log.Printf("command %s from %s", cmd.Command, remote)
+			res, err = h.runCommand(cmd, admin)
+		}
+
+		msg := chttp.SocketMessage{Type: socketResult, ID: cmd.ID, OK: err == nil, Result: res}
+		if err != nil {
+			apiErr := toAPIError(err)
+			if apiErr.status >= http.StatusInternalServerError {
+				log.Printf("command %s from %s failed: %v", cmd.Command, remote, err)
+			}
+			msg.Error = &chttp.ErrorBody{Code: apiErr.code, Message: apiErr.message, Details: apiErr.details}
+		}
+		if err := c.send(msg); err != nil {
+			return
+		}
+	}
+}
+
+// runCommand runs cmd, returning the status of its device or the result of
+// every member of its group. Commands loading media need admin.
+func (h *Handler) runCommand(cmd chttp.Command, admin bool) (interface{}, error) {
+	if (cmd.UUID == "") == (cmd.Group == "") {
+		return nil, invalid("one of 'uuid' or 'group' is needed")
+	}
+
+	var op deviceOp
+	var err error
+	what := cmd.Command
+	switch cmd.Command {
+	case commandStatus:
+		if cmd.Group != "" {
+			return nil, invalid("status is only for devices")
+		}
+		app, err := h.apiApp(map[string]string{"uuid": cmd.UUID})
+		if err != nil {
+			return nil, err
+		}
+		return statusOf(app), nil
+	case commandPlayback:
+		if cmd.Playback == nil {
+			return nil, invalid("missing 'playback'")
+		}
+		what = cmd.Playback.Action
+		op, err = playbackOp(*cmd.Playback)
+	case commandVolume:
+		if cmd.Volume == nil {
+			return nil, invalid("missing 'volume'")
+		}
+		what = "set volume of"
+		op, err = volumeOp(*cmd.Volume)
+	case commandLoad:
+		if !admin {
+			return nil, newAPIError(http.StatusForbidden, codeForbidden, "loading media needs the admin scope")
+		}
+		if cmd.Load == nil {
+			return nil, invalid("missing 'load'")
+		}
+		what = "load media on"
+		if op, err = loadOp(*cmd.Load); err == nil {
+			op = h.reportLoad(op)
+		}
+	case commandQueue:
+		if !admin {
+			return nil, newAPIError(http.StatusForbidden, codeForbidden, "loading media needs the admin scope")
+		}
+		if cmd.Queue == nil {
+			return nil, invalid("missing 'queue'")
+		}
+		what = "load queue on"
+		if op, err = queueOp(*cmd.Queue); err == nil {
+			op = h.reportLoad(op)
+		}
+	case "":
+		return nil, invalid("missing 'command'")
+	default:
+		return nil, invalid("unknown command %q, expected status, playback, volume, load or queue", cmd.Command)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cmd.Group != "" {
+		return h.apiRunOnGroup(map[string]string{"name": cmd.Group}, what, true, op)
+	}
+	return h.socketRun(cmd.UUID, what, op)
+}
+
+// socketRun runs op on the device uuid. Unlike apiRun its status isn't
+// fetched once op has run, to keep the round trip short while a client is
+// seeking, so the status returned is as op left it: up to date before
+// playback operations, which update it first, and as last known
+// otherwise. Changes to it follow as events.
+func (h *Handler) socketRun(uuid, what string, op deviceOp) (interface{}, error) {
+	app, ok := h.app(uuid)
+	if !ok {
+		return nil, newAPIError(http.StatusNotFound, codeNotConnected, "device %s is not connected", uuid)
+	}
+	log.Printf("%s device %s", what, uuid)
+	if err := op(uuid, app); err != nil {
+		return nil, err
+	}
+	return statusOf(app), nil
+}
+
+// queueOp returns the operation req asks for.
+func queueOp(req chttp.QueueRequest) (deviceOp, error) {
+	if len(req.Paths) == 0 {
+		return nil, invalid("missing 'paths'")
+	}
+	return func(uuid string, app *application.Application) error {
+		return app.QueueLoad(req.Paths, req.ContentType, true, true)
+	}, nil
+}
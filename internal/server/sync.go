@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	application "github.com/avinash240/pusher/internal/server/application"
+)
+
+// syncGroup returns the sync group name, writing an error if there is none.
+func (h *Handler) syncGroup(w http.ResponseWriter, r *http.Request) (*application.SyncGroup, bool) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		httpValidationError(w, "missing 'name' in query paramater")
+		return nil, false
+	}
+	h.mu.Lock()
+	g, ok := h.syncGroups[name]
+	h.mu.Unlock()
+	if !ok {
+		httpValidationError(w, fmt.Sprintf("sync group %s doesn't exist", name))
+		return nil, false
+	}
+	return g, true
+}
+
+func (h *Handler) listSyncGroups(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	statuses := make([]application.SyncStatus, 0, len(h.syncGroups))
+	groups := make([]*application.SyncGroup, 0, len(h.syncGroups))
+	for _, g := range h.syncGroups {
+		groups = append(groups, g)
+	}
+	h.mu.Unlock()
+
+	for _, g := range groups {
+		statuses = append(statuses, g.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	writeJSON(w, statuses, "sync groups")
+}
+
+// playSync plays media on a sync group, which is made up of the devices
+// given, or the devices it was made up of when it was last played.
+func (h *Handler) playSync(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("name")
+	if name == "" {
+		httpValidationError(w, "missing 'name' in query paramater")
+		return
+	}
+	path := q.Get("path")
+	if path == "" {
+		httpValidationError(w, "missing 'path' in query paramater")
+		return
+	}
+
+	h.mu.Lock()
+	g, ok := h.syncGroups[name]
+	h.mu.Unlock()
+
+	if uuids := q["uuid"]; len(uuids) > 0 {
+		if len(uuids) < 2 {
+			httpValidationError(w, "a sync group needs at least 2 devices")
+			return
+		}
+		apps := map[string]*application.Application{}
+		for _, uuid := range uuids {
+			app, ok := h.app(uuid)
+			if !ok {
+				httpValidationError(w, fmt.Sprintf("device %s is not connected", uuid))
+				return
+			}
+			apps[uuid] = app
+		}
+		g = application.NewSyncGroup(name, apps,
+			application.WithSyncInterval(h.syncInterval),
+			application.WithSyncTolerance(h.syncTolerance),
+		)
+		// The group replaced is closed, whichever request stored it.
+		h.mu.Lock()
+		replaced, ok := h.syncGroups[name]
+		h.syncGroups[name] = g
+		h.mu.Unlock()
+		if ok {
+			replaced.Close()
+		}
+	} else if !ok {
+		httpValidationError(w, "missing 'uuid' in query params")
+		return
+	} else if !h.connected(g) {
+		httpValidationError(w, fmt.Sprintf("devices of sync group %s have been disconnected, play it with 'uuid' again", name))
+		return
+	}
+
+	log.Printf("playing %s on sync group %s", path, name)
+	if err := g.Play(path, q.Get("content_type")); err != nil {
+		log.Printf("unable to play on sync group %s: %v", name, err)
+		httpError(w, fmt.Errorf("unable to play on sync group: %v", err))
+		return
+	}
+	writeJSON(w, g.Status(), "sync group")
+}
+
+// connected returns whether every member of g is still connected, as the
+// application it was made with.
+func (h *Handler) connected(g *application.SyncGroup) bool {
+	members := g.Members()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for uuid, app := range members {
+		if h.apps[uuid] != app {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handler) pauseSync(w http.ResponseWriter, r *http.Request) {
+	g, ok := h.syncGroup(w, r)
+	if !ok {
+		return
+	}
+	log.Printf("pausing sync group %s", g.Name())
+	if err := g.Pause(); err != nil {
+		log.Printf("unable to pause sync group %s: %v", g.Name(), err)
+		httpError(w, fmt.Errorf("unable to pause sync group: %v", err))
+		return
+	}
+	writeJSON(w, g.Status(), "sync group")
+}
+
+func (h *Handler) unpauseSync(w http.ResponseWriter, r *http.Request) {
+	g, ok := h.syncGroup(w, r)
+	if !ok {
+		return
+	}
+	log.Printf("unpausing sync group %s", g.Name())
+	if err := g.Unpause(); err != nil {
+		log.Printf("unable to unpause sync group %s: %v", g.Name(), err)
+		httpError(w, fmt.Errorf("unable to unpause sync group: %v", err))
+		return
+	}
+	writeJSON(w, g.Status(), "sync group")
+}
+
+// stopSync stops the media of a sync group and removes the group.
+func (h *Handler) stopSync(w http.ResponseWriter, r *http.Request) {
+	g, ok := h.syncGroup(w, r)
+	if !ok {
+		return
+	}
+	h.mu.Lock()
+	delete(h.syncGroups, g.Name())
+	h.mu.Unlock()
+
+	log.Printf("stopping sync group %s", g.Name())
+	if err := g.Stop(); err != nil {
+		log.Printf("unable to stop sync group %s: %v", g.Name(), err)
+		httpError(w, fmt.Errorf("unable to stop sync group: %v", err))
+		return
+	}
+	fmt.Fprintf(w, "Stopped sync group %s\n", g.Name())
+}
@@ -0,0 +1,484 @@
+// The web remote control of pusher. Devices are listed, connected and
+// controlled with version 1 of the API, kept up to date by the event stream,
+// and queues are loaded over the control socket. The media loaded on the
+// local media server is listed by the API too, so the token is only ever
+// sent to the origin of the page.
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+
+const settings = {
+	get token() {
+		return localStorage.getItem("pusher.token") || "";
+	},
+	save(token) {
+		localStorage.setItem("pusher.token", token);
+	},
+};
+
+const state = {
+	// Discovered devices by uuid.
+	devices: new Map(),
+	// Status of connected devices by uuid, and when it was received.
+	statuses: new Map(),
+	received: new Map(),
+	selected: "",
+	library: [],
+	queued: new Set(),
+	seeking: false,
+	events: null,
+};
+
+// showError shows err until the next request succeeds, opening the
+// settings when a token is needed.
+function showError(err) {
+	const msg = $("message");
+	msg.textContent = err.message || String(err);
+	msg.hidden = false;
+	if (err.status === 401) {
+		$("settings").hidden = false;
+	}
+}
+
+function clearError() {
+	$("message").hidden = true;
+}
+
+// withToken returns url with the token in its query, for requests that
+// can't set headers.
+function withToken(url) {
+	if (!settings.token) {
+		return url;
+	}
+	return url + (url.includes("?") ? "&" : "?") + "access_token=" + encodeURIComponent(settings.token);
+}
+
+// api makes a request to version 1 of the API, returning its json body or
+// throwing the error it describes.
+async function api(method, path, body) {
+	const opts = { method, headers: {} };
+	if (settings.token) {
+		opts.headers.Authorization = "Bearer " + settings.token;
+	}
+	if (body !== undefined) {
+		opts.headers["Content-Type"] = "application/json";
+		opts.body = JSON.stringify(body);
+	}
+	const resp = await fetch("/api/v1" + path, opts);
+	if (resp.status === 204) {
+		return null;
+	}
+	const data = await resp.json().catch(() => null);
+	if (!resp.ok) {
+		const err = new Error((data && data.error && data.error.message) || resp.statusText);
+		err.status = resp.status;
+		err.code = data && data.error && data.error.code;
+		throw err;
+	}
+	return data;
+}
+
+// run runs fn, showing its error.
+async function run(fn) {
+	try {
+		await fn();
+		clearError();
+	} catch (err) {
+		showError(err);
+	}
+}
+
+function formatTime(seconds) {
+	if (!isFinite(seconds) || seconds < 0) {
+		seconds = 0;
+	}
+	seconds = Math.floor(seconds);
+	const h = Math.floor(seconds / 3600);
+	const m = Math.floor((seconds % 3600) / 60);
+	const s = String(seconds % 60).padStart(2, "0");
+	return h > 0 ? `${h}:${String(m).padStart(2, "0")}:${s}` : `${m}:${s}`;
+}
+
+function button(label, onClick, primary) {
+	const b = document.createElement("button");
+	b.type = "button";
+	b.textContent = label;
+	if (primary) {
+		b.className = "primary";
+	}
+	b.addEventListener("click", onClick);
+	return b;
+}
+
+// Devices
+
+async function refreshDevices() {
+	const list = $("devices");
+	list.textContent = "Looking for devices…";
+	await run(async () => {
+		const devices = await api("GET", "/devices");
+		state.devices = new Map(devices.map((d) => [d.uuid, d]));
+		// Devices connected before the page was opened are only known by
+		// their status.
+		await Promise.all(devices.map(async (d) => {
+			try {
+				setStatus(d.uuid, await api("GET", "/devices/" + encodeURIComponent(d.uuid)));
+			} catch (err) {
+				if (err.code !== "device_not_connected") {
+					throw err;
+				}
+			}
+		}));
+	});
+	renderDevices();
+}
+
+function deviceName(uuid) {
+	const d = state.devices.get(uuid);
+	return (d && (d.device_name || d.name)) || uuid;
+}
+
+function renderDevices() {
+	const list = $("devices");
+	list.textContent = "";
+	const uuids = new Set([...state.devices.keys(), ...state.statuses.keys()]);
+	if (uuids.size === 0) {
+		list.textContent = "No devices found.";
+		return;
+	}
+	for (const uuid of uuids) {
+		const li = document.createElement("li");
+		li.classList.toggle("selected", uuid === state.selected);
+		const name = document.createElement("span");
+		name.className = "name";
+		name.textContent = deviceName(uuid);
+		li.append(name);
+		if (state.statuses.has(uuid)) {
+			li.append(
+				button("Control", () => select(uuid), true),
+				button("Disconnect", () => disconnect(uuid)),
+			);
+		} else {
+			li.append(button("Connect", () => connect(uuid)));
+		}
+		list.append(li);
+	}
+}
+
+async function connect(uuid) {
+	await run(async () => {
+		const d = state.devices.get(uuid);
+		await api("PUT", `/devices/${encodeURIComponent(uuid)}/connection`, d ? { addr: d.addr, port: d.port } : {});
+		setStatus(uuid, await api("GET", "/devices/" + encodeURIComponent(uuid)));
+		select(uuid);
+	});
+}
+
+async function disconnect(uuid) {
+	await run(async () => {
+		await api("DELETE", `/devices/${encodeURIComponent(uuid)}/connection`);
+		removeStatus(uuid);
+	});
+}
+
+function select(uuid) {
+	state.selected = uuid;
+	$("queue").disabled = state.queued.size === 0 || !uuid;
+	renderDevices();
+	renderPlayer();
+}
+
+// Status
+
+function setStatus(uuid, status) {
+	state.statuses.set(uuid, status);
+	state.received.set(uuid, Date.now());
+}
+
+function removeStatus(uuid) {
+	state.statuses.delete(uuid);
+	state.received.delete(uuid);
+	if (state.selected === uuid) {
+		state.selected = "";
+	}
+	renderDevices();
+	renderPlayer();
+}
+
+// position returns the position of the media playing on the device
+// selected, counting the time since its status was received.
+function position(status) {
+	let t = status.current_time || 0;
+	if (status.player_state === "PLAYING") {
+		t += (Date.now() - state.received.get(state.selected)) / 1000;
+	}
+	return status.duration > 0 ? Math.min(t, status.duration) : t;
+}
+
+function renderPlayer() {
+	const status = state.statuses.get(state.selected);
+	$("player").hidden = !status;
+	if (!status) {
+		return;
+	}
+	$("player-name").textContent = deviceName(state.selected);
+	$("title").textContent = status.title || (status.content_id ? status.content_id.split("/").pop() : "") ||
+		status.display_name || "Nothing playing";
+	$("artist").textContent = [status.artist, status.subtitle].filter(Boolean).join(" · ");
+	$("state").textContent = (status.player_state || "IDLE").toLowerCase();
+
+	const art = $("artwork");
+	if (status.artwork) {
+		if (art.getAttribute("src") !== status.artwork) {
+			art.src = status.artwork;
+		}
+		art.hidden = false;
+		$("artwork-placeholder").hidden = true;
+	} else {
+		art.hidden = true;
+		art.removeAttribute("src");
+		$("artwork-placeholder").hidden = false;
+	}
+
+	$("play-pause").innerHTML = status.player_state === "PLAYING" ? "&#9208;" : "&#9654;";
+	$("mute").textContent = status.volume_muted ? "Unmute" : "Mute";
+	if (document.activeElement !== $("volume")) {
+		$("volume").value = status.volume_level;
+	}
+	renderProgress();
+}
+
+function renderProgress() {
+	const status = state.statuses.get(state.selected);
+	if (!status || state.seeking) {
+		return;
+	}
+	const t = position(status);
+	const seek = $("seek");
+	seek.max = Math.max(status.duration || 0, 0);
+	seek.value = t;
+	seek.disabled = !(status.duration > 0);
+	$("elapsed").textContent = formatTime(t);
+	$("duration").textContent = status.duration > 0 ? formatTime(status.duration) : "live";
+}
+
+// follow follows the events of every connected device, updating their
+// status as it changes.
+function follow() {
+	if (state.events) {
+		state.events.close();
+	}
+	const events = new EventSource(withToken("/events"));
+	const apply = (e) => {
+		const ev = JSON.parse(e.data);
+		if (ev.type === "status" || ev.type === "connected") {
+			setStatus(ev.uuid, ev.status || {});
+		} else {
+			setStatus(ev.uuid, Object.assign({}, state.statuses.get(ev.uuid), ev.status));
+		}
+		if (ev.type === "connected" || !state.devices.has(ev.uuid)) {
+			renderDevices();
+		}
+		if (ev.uuid === state.selected) {
+			renderPlayer();
+		}
+	};
+	for (const type of ["status", "media_status", "volume", "application", "connected"]) {
+		events.addEventListener(type, apply);
+	}
+	events.addEventListener("disconnected", (e) => removeStatus(JSON.parse(e.data).uuid));
+	events.addEventListener("load_failed", (e) => {
+		const ev = JSON.parse(e.data);
+		showError(new Error(`Unable to play on ${deviceName(ev.uuid)}: ${ev.error}`));
+	});
+	state.events = events;
+}
+
+// Controls
+
+function playback(action, seconds) {
+	return run(async () => {
+		const body = { action };
+		if (seconds !== undefined) {
+			body.seconds = seconds;
+		}
+		setStatus(state.selected, await api("POST", `/devices/${encodeURIComponent(state.selected)}/playback`, body));
+		renderPlayer();
+	});
+}
+
+function setVolume(body) {
+	return run(async () => {
+		const v = await api("PUT", `/devices/${encodeURIComponent(state.selected)}/volume`, body);
+		const status = state.statuses.get(state.selected);
+		status.volume_level = v.level;
+		status.volume_muted = v.muted;
+		renderPlayer();
+	});
+}
+
+// Library
+
+async function refreshLibrary() {
+	const list = $("library");
+	list.textContent = "Loading…";
+	state.queued.clear();
+	try {
+		state.library = await api("GET", "/library");
+		clearError();
+	} catch (err) {
+		state.library = [];
+		list.textContent = err.message;
+		return;
+	}
+	renderLibrary();
+}
+
+function isURL(path) {
+	return path.includes("://");
+}
+
+function renderLibrary() {
+	const list = $("library");
+	list.textContent = "";
+	if (state.library.length === 0) {
+		list.textContent = "No media is loaded on the media server.";
+	}
+	state.library.forEach((item) => {
+		const li = document.createElement("li");
+		const check = document.createElement("input");
+		check.type = "checkbox";
+		check.setAttribute("aria-label", "Select");
+		check.addEventListener("change", () => {
+			if (check.checked) {
+				state.queued.add(item.filename);
+			} else {
+				state.queued.delete(item.filename);
+			}
+			$("queue").disabled = state.queued.size === 0 || !state.selected;
+		});
+		const name = document.createElement("span");
+		name.className = "name";
+		name.textContent = isURL(item.filename) ? item.filename : item.filename.split(/[\\/]/).pop();
+		name.title = item.filename + (item.content_type ? ` (${item.content_type})` : "");
+		li.append(check, name, button("Play", () => play(item.filename)));
+		list.append(li);
+	});
+	$("queue").disabled = true;
+}
+
+function play(path) {
+	return run(async () => {
+		if (!state.selected) {
+			throw new Error("Connect to a device, and choose it, to play media.");
+		}
+		const body = { path };
+		if (isURL(path)) {
+			body.relay = true;
+		}
+		setStatus(state.selected, await api("POST", `/devices/${encodeURIComponent(state.selected)}/media`, body));
+		renderPlayer();
+	});
+}
+
+// queue loads paths on the device selected as a queue, which only the
+// control socket can do.
+function queue(paths) {
+	return run(() => new Promise((resolve, reject) => {
+		const scheme = location.protocol === "https:" ? "wss:" : "ws:";
+		const ws = new WebSocket(withToken(`${scheme}//${location.host}/ws?uuid=${encodeURIComponent(state.selected)}`));
+		const timer = setTimeout(() => {
+			ws.close();
+			reject(new Error("The queue took too long to load."));
+		}, 60000);
+		ws.onopen = () => ws.send(JSON.stringify({
+			id: "queue",
+			command: "queue",
+			uuid: state.selected,
+			queue: { paths },
+		}));
+		ws.onmessage = (e) => {
+			const msg = JSON.parse(e.data);
+			if (msg.type !== "result" || msg.id !== "queue") {
+				return;
+			}
+			clearTimeout(timer);
+			ws.close();
+			if (msg.ok) {
+				resolve();
+			} else {
+				reject(new Error(msg.error ? msg.error.message : "Unable to load the queue."));
+			}
+		};
+		ws.onerror = () => {
+			clearTimeout(timer);
+			reject(new Error("Unable to open the control socket, does the token have the control scope?"));
+		};
+	}));
+}
+
+// Setup
+
+document.querySelectorAll(".controls [data-action]").forEach((b) => {
+	b.addEventListener("click", () => {
+		const action = b.dataset.action;
+		if (action === "rewind") {
+			playback(action, 10);
+		} else if (action === "seek") {
+			playback(action, 30);
+		} else {
+			playback(action);
+		}
+	});
+});
+
+$("play-pause").addEventListener("click", () => {
+	const status = state.statuses.get(state.selected) || {};
+	playback(status.player_state === "PLAYING" ? "pause" : "unpause");
+});
+
+$("seek").addEventListener("input", () => {
+	state.seeking = true;
+	$("elapsed").textContent = formatTime(Number($("seek").value));
+});
+$("seek").addEventListener("change", async () => {
+	await playback("seek_to", Number($("seek").value));
+	state.seeking = false;
+});
+
+$("volume").addEventListener("change", () => setVolume({ level: Number($("volume").value) }));
+$("mute").addEventListener("click", () => {
+	const status = state.statuses.get(state.selected) || {};
+	setVolume({ muted: !status.volume_muted });
+});
+
+// Artwork the browser can't load, such as from hosts media isn't served
+// to, is left out.
+$("artwork").addEventListener("error", () => {
+	$("artwork").hidden = true;
+	$("artwork-placeholder").hidden = false;
+});
+
+$("queue").addEventListener("click", () => queue([...state.queued]));
+$("refresh").addEventListener("click", refreshDevices);
+$("library-refresh").addEventListener("click", refreshLibrary);
+
+$("settings-toggle").addEventListener("click", () => {
+	$("settings").hidden = !$("settings").hidden;
+});
+$("settings-form").addEventListener("submit", (e) => {
+	e.preventDefault();
+	settings.save($("token").value.trim());
+	$("settings").hidden = true;
+	start();
+});
+
+function start() {
+	$("token").value = settings.token;
+	follow();
+	refreshDevices();
+	refreshLibrary();
+}
+
+setInterval(renderProgress, 500);
+start();
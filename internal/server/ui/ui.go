@@ -0,0 +1,32 @@
+// Package ui is the web remote control, a single page built on version 1
+// of the API and the event stream, compiled into the binary.
+package ui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the page and its assets at prefix, which ends in a slash.
+func Handler(prefix string) http.Handler {
+	assets, err := fs.Sub(static, "static")
+	if err != nil {
+		// The directory is embedded, so it is always there.
+		panic(err)
+	}
+	files := http.StripPrefix(prefix, http.FileServer(http.FS(assets)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// The page is small, and always the one of the binary serving it.
+		w.Header().Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	})
+}
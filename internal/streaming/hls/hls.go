@@ -0,0 +1,443 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/avinash240/pusher/internal/transcode"
+)
+
+const (
+	// ContentType is the content type chromecasts expect for HLS playlists.
+	ContentType = "application/x-mpegURL"
+
+	// PlaylistName is the name of the playlist served for every stream.
+	PlaylistName = "index.m3u8"
+
+	segmentPattern  = "segment%05d.ts"
+	segmentType     = "video/MP2T"
+	endListTag      = "#EXT-X-ENDLIST"
+	playlistTimeout = time.Second * 30
+)
+
+// DefaultSegmentDuration is the target duration in seconds of every segment
+// produced by a Segmenter.
+var DefaultSegmentDuration = 6
+
+var ErrSegmenterClosed = errors.New("hls segmenter is closed")
+
+// Segmenter transcodes local media files into HLS playlists and segments
+// on demand. Output for every file is written to its own directory beneath
+// the segmenter directory, and served by the handler for its stream as
+// /index.m3u8 and /segmentNNNNN.ts. Segmenters sharing a cache directory
+// share its streams, so each is only segmented once at a time.
+type Segmenter struct {
+	mu     sync.Mutex
+	cache  *cache
+	cached bool
+	closed bool
+	debug  bool
+	// The streams added to the segmenter, which it holds a reference to.
+	streams map[string]*stream
+
+	jobs    *transcode.Manager
+	session string
+
+	SegmentDuration int
+	// CodecArgs are the ffmpeg output codec arguments segments are encoded
+	// with.
+	CodecArgs []string
+}
+
+// DefaultCodecArgs encode H.264 video and stereo AAC audio, which every
+// video chromecast can play.
+var DefaultCodecArgs = []string{
+	"-vcodec", "h264",
+	"-acodec", "aac",
+	"-ac", "2", // chromecasts don't support more than two audio channels
+}
+
+// cache is a directory streams are segmented into, by id.
+type cache struct {
+	dir string
+	// refs counts the segmenters using the cache, guarded by caches.mu.
+	refs int
+
+	mu      sync.Mutex
+	streams map[string]*stream
+}
+
+// caches are the cache directories in use, by absolute path.
+var caches = struct {
+	mu   sync.Mutex
+	dirs map[string]*cache
+}{dirs: map[string]*cache{}}
+
+// openCache returns the cache of dir, shared with every other segmenter
+// using it.
+func openCache(dir string) (*cache, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	caches.mu.Lock()
+	defer caches.mu.Unlock()
+	c, ok := caches.dirs[abs]
+	if !ok {
+		c = &cache{dir: abs, streams: map[string]*stream{}}
+		caches.dirs[abs] = c
+	}
+	c.refs++
+	return c, nil
+}
+
+// closeCache stops sharing c once the last segmenter using it is closed.
+func closeCache(c *cache) {
+	caches.mu.Lock()
+	defer caches.mu.Unlock()
+	c.refs--
+	if c.refs == 0 {
+		delete(caches.dirs, c.dir)
+	}
+}
+
+// acquire returns the stream id of filename, adding a reference to it.
+func (c *cache) acquire(id, filename string) *stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.streams[id]
+	if !ok {
+		st = &stream{
+			id:       id,
+			filename: filename,
+			dir:      filepath.Join(c.dir, id),
+			done:     make(chan struct{}),
+		}
+		c.streams[id] = st
+	}
+	st.refs++
+	return st
+}
+
+// release removes a reference to st. Once it has none its segmenting is
+// stopped, and its output removed unless it is complete. The cache stays
+// locked until then, so the stream can't be started again meanwhile.
+func (c *cache) release(st *stream) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st.refs--
+	if st.refs > 0 {
+		return
+	}
+	delete(c.streams, st.id)
+	st.stop()
+	if !st.complete() {
+		os.RemoveAll(st.dir)
+	}
+}
+
+// stream is a single media file being segmented.
+type stream struct {
+	id       string
+	filename string
+	dir      string
+	// refs counts the segmenters the stream was added to, guarded by the
+	// mutex of its cache.
+	refs int
+
+	mu      sync.Mutex
+	started bool
+	stopped bool
+	job     *transcode.Job
+	done    chan struct{}
+	err     error
+}
+
+// NewSegmenter returns a Segmenter writing to cacheDir, running ffmpeg
+// through jobs as part of session. Completed outputs in cacheDir are reused
+// between sessions. When cacheDir is empty a temporary directory is used
+// instead, and removed when the segmenter is closed.
+func NewSegmenter(cacheDir string, jobs *transcode.Manager, session string, debug bool) (*Segmenter, error) {
+	s := &Segmenter{
+		cached:          cacheDir != "",
+		debug:           debug,
+		streams:         map[string]*stream{},
+		jobs:            jobs,
+		session:         session,
+		SegmentDuration: DefaultSegmentDuration,
+		CodecArgs:       DefaultCodecArgs,
+	}
+	if s.cached {
+		if err := os.MkdirAll(cacheDir, 0755); err != nil {
+			return nil, errors.Wrap(err, "unable to create hls cache directory")
+		}
+		c, err := openCache(cacheDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to open hls cache directory")
+		}
+		s.cache = c
+		return s, nil
+	}
+	dir, err := ioutil.TempDir("", "pusher-hls-")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create hls directory")
+	}
+	s.cache = &cache{dir: dir, streams: map[string]*stream{}}
+	return s, nil
+}
+
+// Add registers filename with the segmenter and returns the id used to
+// request its playlist. Segmenting doesn't start until the playlist is
+// first requested.
+func (s *Segmenter) Add(filename string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return "", ErrSegmenterClosed
+	}
+
+	id, err := streamID(filename, s.CodecArgs)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := s.streams[id]; !ok {
+		s.streams[id] = s.cache.acquire(id, filename)
+	}
+	return id, nil
+}
+
+// Handler returns the handler serving the playlist and segments for the
+// stream with the given id.
+func (s *Segmenter) Handler(id string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		st, ok := s.streams[id]
+		closed := s.closed
+		s.mu.Unlock()
+		if !ok || closed {
+			http.NotFound(w, r)
+			return
+		}
+		s.serve(st, w, r)
+	})
+}
+
+func (s *Segmenter) serve(st *stream, w http.ResponseWriter, r *http.Request) {
+	// Chromecasts load HLS media with XHR, so the response needs CORS
+	// headers or the receiver will refuse it.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case name == PlaylistName:
+		if err := s.start(st); err != nil {
+			s.log("unable to start segmenting %s: %v", st.filename, err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err := st.waitForPlaylist(); err != nil {
+			s.log("unable to serve playlist for %s: %v", st.filename, err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeFile(w, r, filepath.Join(st.dir, PlaylistName))
+	case strings.HasSuffix(name, ".ts") && name == filepath.Base(name):
+		w.Header().Set("Content-Type", segmentType)
+		http.ServeFile(w, r, filepath.Join(st.dir, name))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Close stops segmenting the streams no other segmenter shares. Output
+// that isn't complete is removed, as is everything when the segmenter isn't
+// backed by a cache directory.
+func (s *Segmenter) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	streams := s.streams
+	s.mu.Unlock()
+
+	for _, st := range streams {
+		s.cache.release(st)
+	}
+
+	if !s.cached {
+		return os.RemoveAll(s.cache.dir)
+	}
+	closeCache(s.cache)
+	return nil
+}
+
+// start starts segmenting st, unless it has been already. It returns an
+// error, leaving st to be started by a later request, when it can't be
+// started yet. Other errors are those of the stream.
+func (s *Segmenter) start(st *stream) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.stopped {
+		return ErrSegmenterClosed
+	}
+	if st.started {
+		return nil
+	}
+	if st.complete() {
+		s.log("using cached hls output for %s", st.filename)
+		st.started = true
+		close(st.done)
+		return nil
+	}
+
+	// Anything left over is from an interrupted run and can't be trusted.
+	// Only the segmenters sharing the cache could be writing it, and they
+	// share st as well.
+	os.RemoveAll(st.dir)
+	if err := os.MkdirAll(st.dir, 0755); err != nil {
+		st.started = true
+		st.err = errors.Wrap(err, "unable to create hls stream directory")
+		close(st.done)
+		return nil
+	}
+
+	args := []string{"ffmpeg", "-i", st.filename}
+	args = append(args, s.CodecArgs...)
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(s.SegmentDuration),
+		"-hls_list_size", "0",
+		"-hls_playlist_type", "event",
+		"-hls_segment_filename", filepath.Join(st.dir, segmentPattern),
+		filepath.Join(st.dir, PlaylistName),
+	)
+
+	s.log("segmenting %s into %s", st.filename, st.dir)
+	// Segmenting outlives the playlist request that started it, so it is
+	// only tied to the session. Streams in a cache directory are shared
+	// with other sessions, so they are stopped with the last of them
+	// rather than with the session of any.
+	session := s.session
+	if s.cached {
+		session = "hls:" + st.id
+	}
+	job, err := s.jobs.Start(context.Background(), transcode.Spec{
+		Session:  session,
+		Filename: st.filename,
+		Args:     args,
+		Verbose:  s.debug,
+	})
+	if err == transcode.ErrLimitReached {
+		return errors.Wrap(err, "unable to start ffmpeg")
+	}
+	st.started = true
+	if err != nil {
+		st.err = errors.Wrap(err, "unable to start ffmpeg")
+		close(st.done)
+		return nil
+	}
+	st.job = job
+	go func() {
+		if err := job.Wait(); err != nil {
+			st.err = errors.Wrap(err, "error segmenting")
+		}
+		close(st.done)
+	}()
+	return nil
+}
+
+// stop stops segmenting st, waiting for it to exit, and keeps it from
+// being started again.
+func (st *stream) stop() {
+	st.mu.Lock()
+	st.stopped = true
+	started, job := st.started, st.job
+	st.mu.Unlock()
+	if job != nil {
+		job.Stop()
+	}
+	if started {
+		<-st.done
+	}
+}
+
+// waitForPlaylist blocks until the playlist has been written with at least
+// one segment, or the segmenter has exited.
+func (st *stream) waitForPlaylist() error {
+	ticker := time.NewTicker(time.Millisecond * 250)
+	defer ticker.Stop()
+	timeout := time.After(playlistTimeout)
+	for {
+		if st.hasSegments() {
+			return nil
+		}
+		select {
+		case <-st.done:
+			if st.hasSegments() {
+				return nil
+			}
+			if st.err != nil {
+				return st.err
+			}
+			return fmt.Errorf("no playlist produced for %q", st.filename)
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for playlist for %q", st.filename)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (st *stream) playlist() []byte {
+	b, _ := ioutil.ReadFile(filepath.Join(st.dir, PlaylistName))
+	return b
+}
+
+func (st *stream) hasSegments() bool {
+	return bytes.Contains(st.playlist(), []byte("#EXTINF"))
+}
+
+func (st *stream) complete() bool {
+	return bytes.Contains(st.playlist(), []byte(endListTag))
+}
+
+func (s *Segmenter) log(message string, args ...interface{}) {
+	if s.debug {
+		log.WithField("package", "hls").Infof(message, args...)
+	}
+}
+
+// streamID returns a stable id for filename encoded with codecArgs that
+// changes whenever the file is modified, so cached output is never served
+// for stale content.
+func streamID(filename string, codecArgs []string) (string, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to find %q", filename)
+	}
+	h := sha1.New()
+	fmt.Fprintf(h, "%s:%d:%d:%s", abs, fi.Size(), fi.ModTime().UnixNano(), strings.Join(codecArgs, " "))
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
@@ -0,0 +1,91 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	orientationTag = 0x0112
+	// maxExifScan bounds how much of a file is read looking for exif data.
+	maxExifScan = 1 << 20
+)
+
+// exifOrientation returns the exif orientation (1-8) of the JPEG or TIFF
+// image in r, or 1 if there is none.
+func exifOrientation(r io.Reader) int {
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxExifScan))
+	if err != nil || len(b) < 4 {
+		return 1
+	}
+
+	// TIFF files are an exif structure themselves.
+	if bytes.HasPrefix(b, []byte("II*\x00")) || bytes.HasPrefix(b, []byte("MM\x00*")) {
+		return tiffOrientation(b)
+	}
+
+	if b[0] != 0xFF || b[1] != 0xD8 {
+		return 1
+	}
+	// Walk the JPEG markers looking for the APP1 exif segment.
+	for i := 2; i+4 <= len(b); {
+		if b[i] != 0xFF {
+			return 1
+		}
+		marker := b[i+1]
+		// Start of scan, image data follows and there are no more headers.
+		if marker == 0xDA {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(b[i+2:]))
+		start, end := i+4, i+2+length
+		if end > len(b) {
+			return 1
+		}
+		if marker == 0xE1 && bytes.HasPrefix(b[start:end], []byte("Exif\x00\x00")) {
+			return tiffOrientation(b[start+6 : end])
+		}
+		i = end
+	}
+	return 1
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of the TIFF
+// structure in b.
+func tiffOrientation(b []byte) int {
+	if len(b) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(b[4:]))
+	if offset+2 > len(b) {
+		return 1
+	}
+	entries := int(order.Uint16(b[offset:]))
+	for i := 0; i < entries; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(b) {
+			return 1
+		}
+		if order.Uint16(b[entry:]) != orientationTag {
+			continue
+		}
+		orientation := int(order.Uint16(b[entry+8:]))
+		if orientation < 1 || orientation > 8 {
+			return 1
+		}
+		return orientation
+	}
+	return 1
+}
@@ -0,0 +1,274 @@
+package imaging
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// DefaultMaxWidth and DefaultMaxHeight are the bounds images are scaled
+	// down to fit unless a device needs otherwise.
+	DefaultMaxWidth  = 1920
+	DefaultMaxHeight = 1080
+
+	jpegQuality = 90
+)
+
+// castFormats are the image formats chromecasts can display as they are,
+// keyed by the format name image.Decode reports.
+var castFormats = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"bmp":  "image/bmp",
+	"webp": "image/webp",
+}
+
+// Result is a processed image.
+type Result struct {
+	// Filename of the image to serve, which is the original file when it
+	// didn't need processing.
+	Filename    string
+	ContentType string
+}
+
+// Processor prepares images for display on chromecasts. Images are
+// rotated according to their exif orientation, scaled down to fit the
+// device and converted to a format the device can display. Results are
+// cached on disk.
+type Processor struct {
+	dir string
+
+	mu sync.Mutex
+	// Per output file locks, so the same image isn't processed twice at once.
+	inflight map[string]*sync.Mutex
+}
+
+// NewProcessor returns a Processor caching its output in dir, or in a
+// directory beneath the system temporary directory if dir is empty.
+func NewProcessor(dir string) (*Processor, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "pusher-images")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "unable to create image cache directory")
+	}
+	return &Processor{
+		dir:      dir,
+		inflight: map[string]*sync.Mutex{},
+	}, nil
+}
+
+// Process returns the image to serve for filename on a device displaying
+// at most maxWidth by maxHeight pixels. Zero bounds use the defaults.
+// image.ErrFormat is returned for files that aren't a known image format.
+func (p *Processor) Process(filename string, maxWidth, maxHeight int) (Result, error) {
+	if maxWidth <= 0 {
+		maxWidth = DefaultMaxWidth
+	}
+	if maxHeight <= 0 {
+		maxHeight = DefaultMaxHeight
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return Result{}, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return Result{}, err
+	}
+	key := cacheKey(filename, fi, maxWidth, maxHeight)
+
+	lock := p.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, ext := range []string{".jpg", ".png"} {
+		cached := filepath.Join(p.dir, key+ext)
+		if _, err := os.Stat(cached); err == nil {
+			return Result{Filename: cached, ContentType: contentTypeForExt(ext)}, nil
+		}
+	}
+
+	config, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return Result{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Result{}, err
+	}
+	orientation := exifOrientation(f)
+
+	contentType, playable := castFormats[format]
+	fits := config.Width <= maxWidth && config.Height <= maxHeight
+	if orientation == 1 && playable && fits {
+		return Result{Filename: filename, ContentType: contentType}, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Result{}, err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "unable to decode image")
+	}
+	// Scale before rotating, there are far fewer pixels to move that way.
+	// Orientations 5-8 are rotated by 90 degrees, so they have to fit the
+	// bounds the other way round.
+	if orientation >= 5 {
+		img = orient(scale(img, maxHeight, maxWidth), orientation)
+	} else {
+		img = orient(scale(img, maxWidth, maxHeight), orientation)
+	}
+
+	ext := ".jpg"
+	if hasAlpha(img) {
+		ext = ".png"
+	}
+	out := filepath.Join(p.dir, key+ext)
+	if err := write(out, img); err != nil {
+		return Result{}, err
+	}
+	return Result{Filename: out, ContentType: contentTypeForExt(ext)}, nil
+}
+
+func (p *Processor) lock(key string) *sync.Mutex {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l, ok := p.inflight[key]
+	if !ok {
+		l = &sync.Mutex{}
+		p.inflight[key] = l
+	}
+	return l
+}
+
+// orient transforms img so it displays upright given its exif orientation.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// Orientations 5-8 swap the width and height.
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // mirrored horizontally and rotated 270 clockwise
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // mirrored horizontally and rotated 90 clockwise
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 270 clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// scale scales img down, keeping its aspect ratio, to fit within maxWidth
+// by maxHeight. Images that already fit are returned as they are.
+func scale(img image.Image, maxWidth, maxHeight int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxWidth && h <= maxHeight {
+		return img
+	}
+	ratio := float64(maxWidth) / float64(w)
+	if r := float64(maxHeight) / float64(h); r < ratio {
+		ratio = r
+	}
+	dw, dh := int(float64(w)*ratio), int(float64(h)*ratio)
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func hasAlpha(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	return false
+}
+
+// write encodes img to filename, as a PNG or JPEG depending on the
+// extension. The file is written in place atomically, so a partially
+// written file is never served from the cache.
+func write(filename string, img image.Image) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".processing-")
+	if err != nil {
+		return errors.Wrap(err, "unable to create image")
+	}
+	defer os.Remove(tmp.Name())
+
+	if filepath.Ext(filename) == ".png" {
+		err = png.Encode(tmp, img)
+	} else {
+		err = jpeg.Encode(tmp, img, &jpeg.Options{Quality: jpegQuality})
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to encode image")
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+func contentTypeForExt(ext string) string {
+	if ext == ".png" {
+		return "image/png"
+	}
+	return "image/jpeg"
+}
+
+func cacheKey(filename string, fi os.FileInfo, maxWidth, maxHeight int) string {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		abs = filename
+	}
+	h := sha1.New()
+	fmt.Fprintf(h, "%s:%d:%d:%dx%d", abs, fi.Size(), fi.ModTime().UnixNano(), maxWidth, maxHeight)
+	return hex.EncodeToString(h.Sum(nil))
+}
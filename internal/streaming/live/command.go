@@ -0,0 +1,78 @@
+package live
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// assignment matches the environment assignments that may lead a command.
+var assignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// ParseCommand splits command into its arguments the way a POSIX shell
+// would, without any expansion. Single quotes preserve everything up to the
+// next single quote, double quotes preserve everything except backslash
+// escapes of '"', '\', '$' and '`', and a backslash outside of quotes
+// escapes the next character. Leading NAME=value words are returned as env
+// rather than args.
+func ParseCommand(command string) (args, env []string, err error) {
+	var (
+		word    strings.Builder
+		inWord  bool
+		escaped bool
+		quote   rune
+		words   []string
+	)
+	for _, c := range command {
+		switch {
+		case escaped:
+			if quote == '"' && !strings.ContainsRune("\"\\$`\n", c) {
+				word.WriteRune('\\')
+			}
+			if c != '\n' {
+				word.WriteRune(c)
+			}
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if escaped {
+		return nil, nil, errors.New("command ends with an escape")
+	}
+	if quote != 0 {
+		return nil, nil, errors.Errorf("unterminated %c quote in command", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	for len(words) > 0 && assignment.MatchString(words[0]) {
+		env = append(env, words[0])
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return nil, nil, errors.New("no command specified")
+	}
+	return words, env, nil
+}
@@ -0,0 +1,223 @@
+package live
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/avinash240/pusher/internal/transcode"
+)
+
+// ErrExhausted is returned by sources that can't be opened again, such as
+// readers that have reached EOF.
+var ErrExhausted = errors.New("live source is exhausted")
+
+// Source produces live media.
+type Source interface {
+	// Open starts the source and returns its output. The source is stopped
+	// when the output is closed or ctx is done.
+	Open(ctx context.Context) (io.ReadCloser, error)
+	String() string
+}
+
+// Command returns a source that runs args through jobs as part of session,
+// with env added to its environment, and reads its stdout.
+func Command(jobs *transcode.Manager, session string, args, env []string) Source {
+	return &commandSource{jobs: jobs, session: session, args: args, env: env}
+}
+
+type commandSource struct {
+	jobs    *transcode.Manager
+	session string
+	args    []string
+	env     []string
+}
+
+func (s *commandSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	job, err := s.jobs.Start(ctx, transcode.Spec{
+		Session:  s.session,
+		Filename: s.String(),
+		Args:     s.args,
+		Env:      s.env,
+		Stdout:   pw,
+	})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		err := job.Wait()
+		if err == nil {
+			err = io.EOF
+		}
+		pw.CloseWithError(err)
+	}()
+	return &closer{ReadCloser: pr, close: func() { job.Stop() }}, nil
+}
+
+func (s *commandSource) String() string { return fmt.Sprintf("command %q", s.args[0]) }
+
+// Pipe returns a source reading the named pipe at path. The pipe is opened
+// again whenever its writer closes it.
+func Pipe(path string) Source {
+	return &pipeSource{path: path}
+}
+
+type pipeSource struct {
+	path string
+}
+
+func (s *pipeSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	type result struct {
+		f   *os.File
+		err error
+	}
+	// Opening a named pipe blocks until there is a writer.
+	opened := make(chan result, 1)
+	go func() {
+		f, err := os.Open(s.path)
+		opened <- result{f, err}
+	}()
+	select {
+	case r := <-opened:
+		return r.f, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-opened; r.f != nil {
+				r.f.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+func (s *pipeSource) String() string { return fmt.Sprintf("pipe %q", s.path) }
+
+// TCP returns a source listening on addr, reading the first connection
+// accepted until it closes.
+func TCP(addr string) Source {
+	return &tcpSource{addr: addr}
+}
+
+type tcpSource struct {
+	addr string
+}
+
+func (s *tcpSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to listen on %s", s.addr)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Close()
+		case <-stop:
+		}
+	}()
+
+	conn, err := l.Accept()
+	if err != nil {
+		l.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Wrapf(err, "unable to accept on %s", s.addr)
+	}
+	// Only one sender is read at a time.
+	l.Close()
+	return conn, nil
+}
+
+func (s *tcpSource) String() string { return fmt.Sprintf("tcp %s", s.addr) }
+
+// UDP returns a source reading the datagrams sent to addr.
+func UDP(addr string) Source {
+	return &udpSource{addr: addr}
+}
+
+type udpSource struct {
+	addr string
+}
+
+func (s *udpSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to listen on %s", s.addr)
+	}
+	return &packetReader{conn: conn, buf: make([]byte, 64<<10)}, nil
+}
+
+func (s *udpSource) String() string { return fmt.Sprintf("udp %s", s.addr) }
+
+// packetReader reads datagrams as a stream, holding on to whatever didn't
+// fit in the previous read.
+type packetReader struct {
+	conn    net.PacketConn
+	buf     []byte
+	pending []byte
+}
+
+func (r *packetReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		n, _, err := r.conn.ReadFrom(r.buf)
+		if err != nil {
+			return 0, err
+		}
+		r.pending = r.buf[:n]
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *packetReader) Close() error { return r.conn.Close() }
+
+// Reader returns a source reading r, for media produced in process. The
+// source can only be read once, so streams of it run until r is exhausted
+// whether or not anyone is listening.
+func Reader(name string, r io.Reader) Source {
+	return &readerSource{name: name, r: r}
+}
+
+type readerSource struct {
+	name string
+
+	mu     sync.Mutex
+	r      io.Reader
+	opened bool
+}
+
+func (s *readerSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.opened {
+		return nil, ErrExhausted
+	}
+	s.opened = true
+	if rc, ok := s.r.(io.ReadCloser); ok {
+		return rc, nil
+	}
+	return ioutil.NopCloser(s.r), nil
+}
+
+func (s *readerSource) String() string { return fmt.Sprintf("reader %q", s.name) }
+
+type closer struct {
+	io.ReadCloser
+	close func()
+}
+
+func (c *closer) Close() error {
+	c.close()
+	return c.ReadCloser.Close()
+}
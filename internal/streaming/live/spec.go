@@ -0,0 +1,57 @@
+package live
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/avinash240/pusher/internal/transcode"
+)
+
+// Spec describes a named live source. Exactly one of Command, Pipe, TCP and
+// UDP is set.
+type Spec struct {
+	Name        string
+	ContentType string
+
+	// Command is run as parsed by ParseCommand, with Env added to its
+	// environment.
+	Command string
+	Env     []string
+	// Pipe is the path of a named pipe.
+	Pipe string
+	// TCP and UDP are addresses to listen on.
+	TCP string
+	UDP string
+}
+
+// NewStream returns the stream described by spec, running commands through
+// jobs.
+func (spec Spec) NewStream(jobs *transcode.Manager) (*Stream, error) {
+	if spec.Name == "" {
+		return nil, errors.New("live source has no name")
+	}
+	if spec.ContentType == "" {
+		return nil, errors.Errorf("live source %q has no content type", spec.Name)
+	}
+
+	var sources []Source
+	if spec.Command != "" {
+		args, env, err := ParseCommand(spec.Command)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid command for live source %q", spec.Name)
+		}
+		sources = append(sources, Command(jobs, "live:"+spec.Name, args, append(env, spec.Env...)))
+	}
+	if spec.Pipe != "" {
+		sources = append(sources, Pipe(spec.Pipe))
+	}
+	if spec.TCP != "" {
+		sources = append(sources, TCP(spec.TCP))
+	}
+	if spec.UDP != "" {
+		sources = append(sources, UDP(spec.UDP))
+	}
+	if len(sources) != 1 {
+		return nil, errors.Errorf("live source %q needs exactly one of command, pipe, tcp or udp", spec.Name)
+	}
+	return NewStream(spec.Name, spec.ContentType, sources[0]), nil
+}
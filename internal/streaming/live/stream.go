@@ -0,0 +1,277 @@
+package live
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	minRestartDelay = time.Second
+	maxRestartDelay = time.Second * 30
+
+	// chunkSize is the most read from a source at once.
+	chunkSize = 32 << 10
+	// listenerBuffer is the number of chunks a listener may fall behind by
+	// before it is dropped.
+	listenerBuffer = 64
+)
+
+// IdleTimeout is how long a stream keeps its source running after the last
+// listener has gone.
+var IdleTimeout = time.Second * 10
+
+// Stream serves a live source to any number of listeners over HTTP. The
+// source is started when the first listener arrives, restarted with backoff
+// whenever it exits, and stopped once nobody has been listening for
+// IdleTimeout. Sources that can only be read once run until they are
+// exhausted instead.
+type Stream struct {
+	name        string
+	contentType string
+	source      Source
+	alwaysOn    bool
+
+	mu        sync.Mutex
+	listeners map[chan []byte]struct{}
+	cancel    context.CancelFunc
+	idle      *time.Timer
+	closed    bool
+}
+
+// NewStream returns a stream named name of content of contentType from
+// source.
+func NewStream(name, contentType string, source Source) *Stream {
+	s := &Stream{
+		name:        name,
+		contentType: contentType,
+		source:      source,
+		listeners:   map[chan []byte]struct{}{},
+	}
+	if _, ok := source.(*readerSource); ok {
+		// The producer of the reader would block if it wasn't read.
+		s.alwaysOn = true
+		s.mu.Lock()
+		s.start()
+		s.mu.Unlock()
+	}
+	return s
+}
+
+// Name returns the name of the stream.
+func (s *Stream) Name() string { return s.name }
+
+// ContentType returns the content type of the stream.
+func (s *Stream) ContentType() string { return s.contentType }
+
+// Source returns the source of the stream.
+func (s *Stream) Source() Source { return s.source }
+
+// Listeners returns the number of listeners.
+func (s *Stream) Listeners() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.listeners)
+}
+
+// Close stops the source and disconnects every listener.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.stop()
+	for l := range s.listeners {
+		close(l)
+		delete(s.listeners, l)
+	}
+}
+
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	// Don't start the source just to answer a HEAD request.
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", s.contentType)
+		return
+	}
+
+	l := s.listen()
+	if l == nil {
+		http.Error(w, "live stream has ended", http.StatusGone)
+		return
+	}
+	defer s.unlisten(l)
+
+	w.Header().Set("Content-Type", s.contentType)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		select {
+		case chunk, ok := <-l:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Stream) listen() chan []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	l := make(chan []byte, listenerBuffer)
+	s.listeners[l] = struct{}{}
+	if s.idle != nil {
+		s.idle.Stop()
+		s.idle = nil
+	}
+	if s.cancel == nil {
+		s.start()
+	}
+	return l
+}
+
+func (s *Stream) unlisten(l chan []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.listeners[l]; ok {
+		close(l)
+		delete(s.listeners, l)
+	}
+	if len(s.listeners) == 0 && !s.alwaysOn && s.cancel != nil && s.idle == nil {
+		s.idle = time.AfterFunc(IdleTimeout, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			if len(s.listeners) == 0 {
+				s.logger().Info("stopping idle live source")
+				s.stop()
+			}
+		})
+	}
+}
+
+// start runs the source until it is stopped. s.mu must be held.
+func (s *Stream) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.run(ctx)
+}
+
+// stop stops the source. s.mu must be held.
+func (s *Stream) stop() {
+	if s.idle != nil {
+		s.idle.Stop()
+		s.idle = nil
+	}
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+}
+
+func (s *Stream) run(ctx context.Context) {
+	logger := s.logger()
+	delay := minRestartDelay
+	for {
+		started := time.Now()
+		rc, err := s.source.Open(ctx)
+		if err == nil {
+			logger.Info("live source started")
+			err = s.pump(ctx, rc)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err == ErrExhausted || (s.alwaysOn && err == io.EOF) {
+			logger.Info("live source ended")
+			s.Close()
+			return
+		}
+
+		// Sources that ran for a while are restarted promptly.
+		if time.Since(started) > maxRestartDelay {
+			delay = minRestartDelay
+		}
+		logger.WithError(err).Warnf("live source exited, restarting in %v", delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// pump sends everything read from rc to the listeners until rc fails or ctx
+// is done.
+func (s *Stream) pump(ctx context.Context, rc io.ReadCloser) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// Closing the source unblocks any read in progress.
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		rc.Close()
+	}()
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := rc.Read(buf)
+		if n > 0 {
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			s.broadcast(chunk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Stream) broadcast(chunk []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for l := range s.listeners {
+		select {
+		case l <- chunk:
+		default:
+			// Listeners that can't keep up are dropped rather than
+			// holding everyone else up.
+			s.logger().Warn("dropping slow live listener")
+			close(l)
+			delete(s.listeners, l)
+		}
+	}
+}
+
+func (s *Stream) logger() *log.Entry {
+	return log.WithField("package", "live").WithFields(log.Fields{
+		"stream": s.name,
+		"source": s.source.String(),
+	})
+}
@@ -3,12 +3,17 @@ package streaming
 import (
 	"os"
 	"path/filepath"
+
+	"github.com/avinash240/pusher/internal/streaming/playlist"
 )
 
 // LocalAudio is a data structure that stores the path of the audio source(s).
 type LocalAudio struct {
 	FilePaths  []string
 	StrictPath string
+	// Entries is set when the path is a playlist, and describes each of
+	// FilePaths, which may include remote URLs.
+	Entries []playlist.Entry
 }
 
 // StreamingData is a data structure that retains data bytes for pushing to
@@ -18,7 +23,8 @@ type StreamingData struct {
 }
 
 // NewLocalStream returns a pointer to an instance of LocalAudio with FilePaths
-// translated for local audio data source(s).
+// translated for local audio data source(s). Playlist files are expanded to
+// the entries they list.
 func NewLocalStream(path string) (*LocalAudio, error) {
 	var files []string
 	p, err := os.Open(path)
@@ -30,6 +36,19 @@ func NewLocalStream(path string) (*LocalAudio, error) {
 		return nil, err
 	}
 	absP, _ := filepath.Abs(path)
+	if fS.Mode().IsRegular() && playlist.IsPlaylist(path) {
+		entries, err := playlist.Load(path)
+		if err != nil && err != playlist.ErrHLSPlaylist {
+			return nil, err
+		}
+		// HLS playlists are streams rather than lists, so are served as they are.
+		if err == nil {
+			for _, e := range entries {
+				files = append(files, e.Location)
+			}
+			return &LocalAudio{FilePaths: files, StrictPath: absP, Entries: entries}, nil
+		}
+	}
 	if fS.Mode().IsRegular() { //Is regular file
 		files = append(files, path)
 		return &LocalAudio{FilePaths: files, StrictPath: absP}, nil
@@ -0,0 +1,74 @@
+package playlist
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hlsTags only appear in HLS playlists.
+var hlsTags = []string{
+	"#EXT-X-TARGETDURATION",
+	"#EXT-X-MEDIA-SEQUENCE",
+	"#EXT-X-STREAM-INF",
+	"#EXT-X-ENDLIST",
+}
+
+// parseM3U parses plain and extended M3U playlists. Extended entries are
+// described by the #EXTINF line before them:
+//
+//	#EXTINF:<seconds>[ <attributes>],<title>
+func parseM3U(r io.Reader) ([]Entry, error) {
+	var (
+		entries []Entry
+		info    Entry
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			info = parseExtInf(strings.TrimPrefix(line, "#EXTINF:"))
+		case strings.HasPrefix(line, "#"):
+			for _, tag := range hlsTags {
+				if strings.HasPrefix(line, tag) {
+					return nil, ErrHLSPlaylist
+				}
+			}
+		default:
+			info.Location = line
+			entries = append(entries, info)
+			info = Entry{}
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func parseExtInf(s string) Entry {
+	var e Entry
+	// The title follows the first comma outside of quoted attributes.
+	quoted := false
+	split := -1
+	for i, c := range s {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ',' && !quoted {
+			split = i
+			break
+		}
+	}
+	head := s
+	if split >= 0 {
+		head = s[:split]
+		e.Title = strings.TrimSpace(s[split+1:])
+	}
+	if fields := strings.Fields(head); len(fields) > 0 {
+		if secs, err := strconv.ParseFloat(fields[0], 64); err == nil && secs > 0 {
+			e.Duration = time.Duration(secs * float64(time.Second))
+		}
+	}
+	return e
+}
@@ -0,0 +1,192 @@
+package playlist
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Format is a playlist file format.
+type Format string
+
+const (
+	M3U  Format = "m3u"
+	PLS  Format = "pls"
+	XSPF Format = "xspf"
+)
+
+// maxSize is the largest playlist that will be read.
+const maxSize = 10 << 20
+
+var (
+	// ErrHLSPlaylist is returned for M3U8 files that are HLS playlists. They
+	// describe a single stream, so should be played as they are rather than
+	// expanded into their entries.
+	ErrHLSPlaylist = errors.New("playlist is an HLS stream")
+
+	ErrUnknownFormat = errors.New("unknown playlist format")
+)
+
+var client = &http.Client{Timeout: time.Second * 30}
+
+// Entry is a single item in a playlist.
+type Entry struct {
+	// Location is an absolute local path or a remote URL.
+	Location string
+	Title    string
+	Artist   string
+	// Duration is zero when the playlist doesn't give one.
+	Duration time.Duration
+}
+
+// Remote reports whether the entry is served from a remote URL.
+func (e Entry) Remote() bool {
+	return isURL(e.Location)
+}
+
+// FormatOf returns the playlist format of name, a local path or URL, going
+// by its extension.
+func FormatOf(name string) (Format, bool) {
+	if u, err := url.Parse(name); err == nil && isURL(name) {
+		name = u.Path
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".m3u", ".m3u8":
+		return M3U, true
+	case ".pls":
+		return PLS, true
+	case ".xspf":
+		return XSPF, true
+	}
+	return "", false
+}
+
+// IsPlaylist reports whether name, a local path or URL, has the extension
+// of a known playlist format.
+func IsPlaylist(name string) bool {
+	_, ok := FormatOf(name)
+	return ok
+}
+
+// Load reads the playlist at location, a local path or an http(s) URL.
+// Relative entries are resolved against the location of the playlist.
+func Load(location string) ([]Entry, error) {
+	format, ok := FormatOf(location)
+	if !ok {
+		return nil, ErrUnknownFormat
+	}
+
+	if isURL(location) {
+		resp, err := client.Get(location)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to fetch playlist %q", location)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.Errorf("unable to fetch playlist %q: %s", location, resp.Status)
+		}
+		// Use the final URL so entries resolve against any redirect.
+		return Parse(resp.Body, format, resp.Request.URL.String())
+	}
+
+	abs, err := filepath.Abs(location)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f, format, abs)
+}
+
+// Parse reads a playlist in the given format from r. base is the location
+// of the playlist, a local path or URL, that relative entries are resolved
+// against.
+func Parse(r io.Reader, format Format, base string) ([]Entry, error) {
+	r = io.LimitReader(r, maxSize)
+
+	var (
+		entries []Entry
+		err     error
+	)
+	switch format {
+	case M3U:
+		entries, err = parseM3U(r)
+	case PLS:
+		entries, err = parsePLS(r)
+	case XSPF:
+		entries, err = parseXSPF(r)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := entries[:0]
+	for _, e := range entries {
+		location, err := resolve(base, e.Location)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid playlist entry %q", e.Location)
+		}
+		if location == "" {
+			continue
+		}
+		e.Location = location
+		resolved = append(resolved, e)
+	}
+	return resolved, nil
+}
+
+// resolve returns location relative to the playlist at base.
+func resolve(base, location string) (string, error) {
+	location = strings.TrimSpace(location)
+	if location == "" || isURL(location) {
+		return location, nil
+	}
+
+	if strings.HasPrefix(location, "file:") {
+		u, err := url.Parse(location)
+		if err != nil {
+			return "", err
+		}
+		location = u.Path
+		if location == "" {
+			location = u.Opaque
+		}
+		if filepath.IsAbs(location) {
+			return filepath.Clean(location), nil
+		}
+	}
+
+	if isURL(base) {
+		b, err := url.Parse(base)
+		if err != nil {
+			return "", err
+		}
+		ref, err := url.Parse(filepath.ToSlash(location))
+		if err != nil {
+			return "", err
+		}
+		return b.ResolveReference(ref).String(), nil
+	}
+
+	if filepath.IsAbs(location) {
+		return filepath.Clean(location), nil
+	}
+	return filepath.Join(filepath.Dir(base), filepath.FromSlash(location)), nil
+}
+
+func isURL(s string) bool {
+	lower := strings.ToLower(s)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
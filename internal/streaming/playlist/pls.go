@@ -0,0 +1,94 @@
+package playlist
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// parsePLS parses PLS playlists, which list numbered keys for each entry:
+//
+//	[playlist]
+//	File1=<location>
+//	Title1=<title>
+//	Length1=<seconds, or -1 when unknown>
+func parsePLS(r io.Reader) ([]Entry, error) {
+	byIndex := map[int]*Entry{}
+	inPlaylist := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			inPlaylist = strings.EqualFold(line, "[playlist]")
+			continue
+		}
+		if !inPlaylist {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+
+		var field string
+		for _, f := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, f) {
+				field = f
+				break
+			}
+		}
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(key, field))
+		if err != nil {
+			continue
+		}
+		e, ok := byIndex[n]
+		if !ok {
+			e = &Entry{}
+			byIndex[n] = e
+		}
+		switch field {
+		case "file":
+			e.Location = value
+		case "title":
+			e.Title = value
+		case "length":
+			if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+				e.Duration = time.Duration(secs * float64(time.Second))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(byIndex) == 0 && !inPlaylist {
+		return nil, errors.New("missing [playlist] section")
+	}
+
+	indexes := make([]int, 0, len(byIndex))
+	for n := range byIndex {
+		indexes = append(indexes, n)
+	}
+	sort.Ints(indexes)
+
+	entries := make([]Entry, 0, len(indexes))
+	for _, n := range indexes {
+		if byIndex[n].Location != "" {
+			entries = append(entries, *byIndex[n])
+		}
+	}
+	return entries, nil
+}
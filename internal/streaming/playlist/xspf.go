@@ -0,0 +1,54 @@
+package playlist
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// xspfPlaylist is the subset of XSPF that is used. Durations are given in
+// milliseconds.
+type xspfPlaylist struct {
+	XMLName xml.Name `xml:"playlist"`
+	Tracks  []struct {
+		Locations []string `xml:"location"`
+		Title     string   `xml:"title"`
+		Creator   string   `xml:"creator"`
+		Duration  int64    `xml:"duration"`
+	} `xml:"trackList>track"`
+}
+
+// parseXSPF parses XSPF playlists. Tracks may list several locations, of
+// which the first is used.
+func parseXSPF(r io.Reader) ([]Entry, error) {
+	var p xspfPlaylist
+	if err := xml.NewDecoder(r).Decode(&p); err != nil {
+		return nil, errors.Wrap(err, "unable to decode xspf playlist")
+	}
+
+	entries := make([]Entry, 0, len(p.Tracks))
+	for _, t := range p.Tracks {
+		if len(t.Locations) == 0 {
+			continue
+		}
+		// Locations are URIs, so relative references are percent-encoded
+		// where a local path wouldn't be.
+		location := strings.TrimSpace(t.Locations[0])
+		if !isURL(location) && !strings.HasPrefix(location, "file:") {
+			if unescaped, err := url.PathUnescape(location); err == nil {
+				location = unescaped
+			}
+		}
+		entries = append(entries, Entry{
+			Location: location,
+			Title:    strings.TrimSpace(t.Title),
+			Artist:   strings.TrimSpace(t.Creator),
+			Duration: time.Duration(t.Duration) * time.Millisecond,
+		})
+	}
+	return entries, nil
+}
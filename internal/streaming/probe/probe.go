@@ -0,0 +1,232 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// probeTimeout bounds how long ffprobe may take for a single file.
+const probeTimeout = time.Second * 15
+
+var (
+	// ErrUnavailable is returned when a file can't be probed natively and
+	// ffprobe isn't installed.
+	ErrUnavailable = errors.New("ffprobe is not available")
+
+	// ErrNoMedia is returned for files without any audio or video.
+	ErrNoMedia = errors.New("no audio or video streams found")
+)
+
+// Stream is a single audio, video or subtitle stream of a file.
+type Stream struct {
+	Index   int    `json:"index"`
+	Type    string `json:"type"`
+	Codec   string `json:"codec"`
+	Profile string `json:"profile,omitempty"`
+	BitRate int64  `json:"bit_rate,omitempty"`
+
+	// Set for video streams.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+
+	// Set for audio streams.
+	Channels   int `json:"channels,omitempty"`
+	SampleRate int `json:"sample_rate,omitempty"`
+
+	// attachedPic is set for cover art, which ffprobe reports as video.
+	attachedPic bool
+}
+
+// Info describes the container and streams of a media file.
+type Info struct {
+	// Container is the comma separated list of format names ffprobe
+	// reports, e.g. "matroska,webm".
+	Container string   `json:"container"`
+	Duration  float64  `json:"duration"`
+	BitRate   int64    `json:"bit_rate"`
+	Streams   []Stream `json:"streams"`
+}
+
+// Video returns the first video stream, ignoring cover art, or nil if
+// there isn't one.
+func (i *Info) Video() *Stream {
+	for k := range i.Streams {
+		if s := &i.Streams[k]; s.Type == "video" && !s.attachedPic {
+			return s
+		}
+	}
+	return nil
+}
+
+// Audio returns the first audio stream, or nil if there isn't one.
+func (i *Info) Audio() *Stream {
+	for k := range i.Streams {
+		if s := &i.Streams[k]; s.Type == "audio" {
+			return s
+		}
+	}
+	return nil
+}
+
+// IsContainer reports whether the file is in any of the named formats.
+func (i *Info) IsContainer(names ...string) bool {
+	for _, format := range strings.Split(i.Container, ",") {
+		for _, name := range names {
+			if format == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Prober probes media files, caching the result for every file until it is
+// modified.
+type Prober struct {
+	mu    sync.Mutex
+	cache map[string]*Info
+}
+
+// NewProber returns an empty Prober.
+func NewProber() *Prober {
+	return &Prober{cache: map[string]*Info{}}
+}
+
+// Probe returns the description of filename. ErrNoMedia is returned for
+// files that aren't audio or video, such as images.
+func (p *Prober) Probe(ctx context.Context, filename string) (*Info, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to find %q", filename)
+	}
+	h := sha1.New()
+	fmt.Fprintf(h, "%s:%d:%d", abs, fi.Size(), fi.ModTime().UnixNano())
+	key := hex.EncodeToString(h.Sum(nil))
+
+	p.mu.Lock()
+	info, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	info, err = probeWAV(abs)
+	if err != nil {
+		info, err = ffprobe(ctx, abs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Video() == nil && info.Audio() == nil {
+		return nil, ErrNoMedia
+	}
+
+	p.mu.Lock()
+	p.cache[key] = info
+	p.mu.Unlock()
+	return info, nil
+}
+
+// ffprobeOutput is the subset of 'ffprobe -print_format json' that is used.
+// Numbers other than dimensions and channels are reported as strings.
+type ffprobeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+	Streams []struct {
+		Index       int    `json:"index"`
+		CodecType   string `json:"codec_type"`
+		CodecName   string `json:"codec_name"`
+		Profile     string `json:"profile"`
+		BitRate     string `json:"bit_rate"`
+		Width       int    `json:"width"`
+		Height      int    `json:"height"`
+		Channels    int    `json:"channels"`
+		SampleRate  string `json:"sample_rate"`
+		Disposition struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+	} `json:"streams"`
+}
+
+func ffprobe(ctx context.Context, filename string) (*Info, error) {
+	path, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return nil, ErrUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path,
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		filename,
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.Errorf("unable to probe %q: %s", filename, msg)
+		}
+		return nil, errors.Wrapf(err, "unable to probe %q", filename)
+	}
+
+	var out ffprobeOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, errors.Wrap(err, "unable to decode ffprobe output")
+	}
+
+	info := &Info{
+		Container: out.Format.FormatName,
+		Duration:  parseFloat(out.Format.Duration),
+		BitRate:   parseInt(out.Format.BitRate),
+	}
+	for _, s := range out.Streams {
+		info.Streams = append(info.Streams, Stream{
+			Index:       s.Index,
+			Type:        s.CodecType,
+			Codec:       s.CodecName,
+			Profile:     s.Profile,
+			BitRate:     parseInt(s.BitRate),
+			Width:       s.Width,
+			Height:      s.Height,
+			Channels:    s.Channels,
+			SampleRate:  int(parseInt(s.SampleRate)),
+			attachedPic: s.Disposition.AttachedPic != 0,
+		})
+	}
+	return info, nil
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func parseInt(s string) int64 {
+	i, _ := strconv.ParseInt(s, 10, 64)
+	return i
+}
@@ -0,0 +1,115 @@
+package probe
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+var errNotWAV = errors.New("not a wav file")
+
+// wavFormats maps the format tag of a wav 'fmt ' chunk to the name ffprobe
+// gives the codec, for the formats worth recognising.
+var wavFormats = map[uint16]string{
+	0x0001: "pcm",
+	0x0003: "pcm_f",
+	0x0006: "pcm_alaw",
+	0x0007: "pcm_mulaw",
+	0x0055: "mp3",
+}
+
+// probeWAV reads the description of a RIFF wav file from its headers, so
+// the commonest uncompressed audio can be probed without ffprobe.
+func probeWAV(filename string) (*Info, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var header [12]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		return nil, errNotWAV
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, errNotWAV
+	}
+
+	var (
+		stream   *Stream
+		byteRate uint32
+		dataSize uint32
+	)
+	for stream == nil || dataSize == 0 {
+		var chunk [8]byte
+		if _, err := io.ReadFull(f, chunk[:]); err != nil {
+			break
+		}
+		id := string(chunk[0:4])
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+
+		switch id {
+		case "fmt ":
+			var fmtChunk [16]byte
+			if size < 16 {
+				return nil, errNotWAV
+			}
+			if _, err := io.ReadFull(f, fmtChunk[:]); err != nil {
+				return nil, errNotWAV
+			}
+			tag := binary.LittleEndian.Uint16(fmtChunk[0:2])
+			bits := binary.LittleEndian.Uint16(fmtChunk[14:16])
+			byteRate = binary.LittleEndian.Uint32(fmtChunk[8:12])
+			stream = &Stream{
+				Type:       "audio",
+				Codec:      wavCodec(tag, bits),
+				Channels:   int(binary.LittleEndian.Uint16(fmtChunk[2:4])),
+				SampleRate: int(binary.LittleEndian.Uint32(fmtChunk[4:8])),
+				BitRate:    int64(byteRate) * 8,
+			}
+			size -= 16
+		case "data":
+			dataSize = size
+			size = 0
+		}
+		// Chunks are padded to an even size.
+		if _, err := f.Seek(int64(size+size%2), io.SeekCurrent); err != nil {
+			break
+		}
+	}
+	if stream == nil {
+		return nil, errNotWAV
+	}
+
+	info := &Info{
+		Container: "wav",
+		BitRate:   stream.BitRate,
+		Streams:   []Stream{*stream},
+	}
+	if byteRate > 0 {
+		info.Duration = float64(dataSize) / float64(byteRate)
+	}
+	return info, nil
+}
+
+// wavCodec returns the name ffprobe gives the codec, which for pcm includes
+// the sample size, e.g. "pcm_s16le".
+func wavCodec(tag, bits uint16) string {
+	name, ok := wavFormats[tag]
+	if !ok {
+		return "unknown"
+	}
+	switch name {
+	case "pcm":
+		if bits == 8 {
+			return "pcm_u8"
+		}
+		return "pcm_s" + strconv.Itoa(int(bits)) + "le"
+	case "pcm_f":
+		return "pcm_f" + strconv.Itoa(int(bits)) + "le"
+	}
+	return name
+}
@@ -0,0 +1,108 @@
+package radio
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Metadata is the metadata interleaved with the audio of an ICY stream.
+type Metadata struct {
+	StreamTitle string `json:"stream_title"`
+	StreamURL   string `json:"stream_url,omitempty"`
+}
+
+// ArtistTitle splits StreamTitle into an artist and a title, which stations
+// usually separate with " - ". The whole StreamTitle is returned as the title
+// when there is no separator.
+func (m Metadata) ArtistTitle() (artist, title string) {
+	if i := strings.Index(m.StreamTitle, " - "); i > 0 {
+		return strings.TrimSpace(m.StreamTitle[:i]), strings.TrimSpace(m.StreamTitle[i+3:])
+	}
+	return "", strings.TrimSpace(m.StreamTitle)
+}
+
+// ParseMetadata parses a metadata block such as "StreamTitle='A - B';".
+// Values may contain quotes and semicolons, only "';" ends them. Blocks
+// that aren't valid UTF-8 are decoded as Latin-1, which older servers send.
+func ParseMetadata(block string) Metadata {
+	block = strings.TrimRight(block, "\x00")
+	if !utf8.ValidString(block) {
+		runes := make([]rune, len(block))
+		for i := 0; i < len(block); i++ {
+			runes[i] = rune(block[i])
+		}
+		block = string(runes)
+	}
+
+	fields := map[string]string{}
+	for block != "" {
+		i := strings.Index(block, "='")
+		if i < 0 {
+			break
+		}
+		key, rest := block[:i], block[i+2:]
+		end := strings.Index(rest, "';")
+		if end < 0 {
+			fields[key] = strings.TrimSuffix(rest, "'")
+			break
+		}
+		fields[key] = rest[:end]
+		block = rest[end+2:]
+	}
+	return Metadata{
+		StreamTitle: fields["StreamTitle"],
+		StreamURL:   fields["StreamUrl"],
+	}
+}
+
+// NewReader returns a reader of the audio in r, an ICY stream with a
+// metadata block every metaint bytes of audio. The metadata blocks are
+// stripped, and onMetadata is called with every one that isn't empty.
+func NewReader(r io.Reader, metaint int, onMetadata func(Metadata)) io.Reader {
+	return &metadataReader{r: r, metaint: metaint, remaining: metaint, onMetadata: onMetadata}
+}
+
+type metadataReader struct {
+	r          io.Reader
+	metaint    int
+	remaining  int
+	onMetadata func(Metadata)
+}
+
+func (r *metadataReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		if err := r.readMetadata(); err != nil {
+			return 0, err
+		}
+		r.remaining = r.metaint
+	}
+	if len(p) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.r.Read(p)
+	r.remaining -= n
+	return n, err
+}
+
+// readMetadata reads a metadata block, which is a byte holding its length
+// in multiples of 16 followed by the padded metadata.
+func (r *metadataReader) readMetadata() error {
+	var length [1]byte
+	if _, err := io.ReadFull(r.r, length[:]); err != nil {
+		return err
+	}
+	if length[0] == 0 {
+		return nil
+	}
+	block := make([]byte, int(length[0])*16)
+	if _, err := io.ReadFull(r.r, block); err != nil {
+		return errors.Wrap(err, "truncated icy metadata")
+	}
+	if r.onMetadata != nil {
+		r.onMetadata(ParseMetadata(string(block)))
+	}
+	return nil
+}
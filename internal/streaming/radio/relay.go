@@ -0,0 +1,232 @@
+// Package radio relays internet radio streams, which are often plain HTTP,
+// only reachable from the host, or interleaved with ICY metadata that
+// devices can't play, through the media server.
+package radio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultContentType is served when neither the relay nor the station name
+// a content type.
+const DefaultContentType = "audio/mpeg"
+
+// Relay serves a remote stream, with any ICY metadata stripped from the
+// audio and kept as the current track. Every request to the relay opens the
+// remote stream again.
+type Relay struct {
+	url         string
+	contentType string
+	client      *http.Client
+	onMetadata  func(Metadata)
+
+	mu       sync.Mutex
+	station  string
+	metadata Metadata
+}
+
+type RelayOption func(*Relay)
+
+// WithContentType serves the stream as contentType rather than the content
+// type the station sends.
+func WithContentType(contentType string) RelayOption {
+	return func(r *Relay) {
+		r.contentType = contentType
+	}
+}
+
+// WithMetadataFunc calls f whenever the track playing changes.
+func WithMetadataFunc(f func(Metadata)) RelayOption {
+	return func(r *Relay) {
+		r.onMetadata = f
+	}
+}
+
+// WithClient fetches the remote stream with c instead of a client that
+// understands ICY responses.
+func WithClient(c *http.Client) RelayOption {
+	return func(r *Relay) {
+		r.client = c
+	}
+}
+
+// NewRelay returns a relay of the stream at url.
+func NewRelay(url string, opts ...RelayOption) *Relay {
+	r := &Relay{url: url}
+	for _, o := range opts {
+		o(r)
+	}
+	if r.client == nil {
+		r.client = NewClient()
+	}
+	return r
+}
+
+// URL returns the url of the remote stream.
+func (r *Relay) URL() string { return r.url }
+
+// Station returns the name the station gives itself, if it has been
+// connected to.
+func (r *Relay) Station() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.station
+}
+
+// Metadata returns the metadata of the track playing.
+func (r *Relay) Metadata() Metadata {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.metadata
+}
+
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	logger := log.WithField("package", "radio").WithField("url", r.url)
+
+	upstream, err := http.NewRequestWithContext(req.Context(), http.MethodGet, r.url, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	upstream.Header.Set("Icy-MetaData", "1")
+	upstream.Header.Set("User-Agent", "pusher")
+	resp, err := r.client.Do(upstream)
+	if err != nil {
+		logger.WithError(err).Warn("unable to connect to station")
+		http.Error(w, "unable to connect to station", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.Warnf("station responded with %s", resp.Status)
+		http.Error(w, fmt.Sprintf("station responded with %s", resp.Status), http.StatusBadGateway)
+		return
+	}
+
+	if name := resp.Header.Get("Icy-Name"); name != "" {
+		r.mu.Lock()
+		r.station = name
+		r.mu.Unlock()
+	}
+	contentType := r.contentType
+	if contentType == "" {
+		contentType = resp.Header.Get("Content-Type")
+	}
+	if contentType == "" {
+		contentType = DefaultContentType
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	var body io.Reader = resp.Body
+	if metaint, err := strconv.Atoi(resp.Header.Get("Icy-Metaint")); err == nil && metaint > 0 {
+		body = NewReader(resp.Body, metaint, r.setMetadata)
+	}
+	logger.Info("relaying station")
+	if err := copyFlushing(w, body); err != nil && req.Context().Err() == nil {
+		logger.WithError(err).Warn("relay stopped")
+	}
+}
+
+func (r *Relay) setMetadata(m Metadata) {
+	r.mu.Lock()
+	changed := m != r.metadata
+	r.metadata = m
+	r.mu.Unlock()
+
+	if changed {
+		log.WithField("package", "radio").WithField("url", r.url).Infof("now playing %q", m.StreamTitle)
+		if r.onMetadata != nil {
+			r.onMetadata(m)
+		}
+	}
+}
+
+// copyFlushing copies src to w, flushing every write so the device isn't
+// left waiting on buffered audio.
+func copyFlushing(w http.ResponseWriter, src io.Reader) error {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 16<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// NewClient returns a client that understands the "ICY 200 OK" status line
+// older SHOUTcast servers respond with.
+func NewClient() *http.Client {
+	dialer := &net.Dialer{Timeout: time.Second * 10, KeepAlive: time.Second * 30}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to dial %s", addr)
+		}
+		return &icyConn{Conn: conn}, nil
+	}
+	transport.ResponseHeaderTimeout = time.Second * 15
+	return &http.Client{Transport: transport}
+}
+
+// icyConn rewrites an "ICY" status line as "HTTP/1.0", which is otherwise
+// the same. TLS records never start with "ICY ", so it is safe to use under
+// TLS too.
+type icyConn struct {
+	net.Conn
+	checked bool
+	pending []byte
+}
+
+func (c *icyConn) Read(p []byte) (int, error) {
+	if !c.checked {
+		c.checked = true
+		prefix := make([]byte, 4)
+		n, err := io.ReadFull(c.Conn, prefix)
+		if string(prefix[:n]) == "ICY " {
+			c.pending = []byte("HTTP/1.0 ")
+		} else {
+			c.pending = prefix[:n]
+		}
+		if err != nil && n == 0 {
+			return 0, err
+		}
+	}
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avinash240/pusher/internal/server/application"
+)
+
+func TestAnnounce(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	device := newFakeChromecast(t)
+	defer device.Close()
+	app := device.Connect(t)
+	defer app.Close(false)
+
+	clip := "http://127.0.0.1:1/doorbell.mp3"
+	opts := application.AnnounceOptions{ContentType: "audio/mpeg", Volume: 0.8, Timeout: 5 * time.Second}
+	// finishClip finishes the clip once device is playing it, as it would
+	// at its end.
+	finishClip := func(device *fakeChromecast) {
+		go eventually(func() bool {
+			if m, ok := device.Media(); ok && m.Media.ContentId == clip {
+				device.Finish()
+				return true
+			}
+			return false
+		})
+	}
+	// playing reports whether device is playing content in state once it
+	// is restored.
+	playing := func(device *fakeChromecast, content, state string) bool {
+		return eventually(func() bool {
+			m, ok := device.Media()
+			return ok && m.Media.ContentId == content && m.PlayerState == state
+		})
+	}
+
+	// Test against interrupting media played by another sender. Passes if
+	// it is playing again where it was, at the volume it was.
+	log.Println("* Test for restoring playing media")
+	device.Play("http://127.0.0.1:1/song.mp3", 600, 100)
+	finishClip(device)
+	if err := app.Announce(clip, opts); err != nil {
+		t.Errorf("Announce() failed with issue:\n%+v", err)
+	}
+	if !playing(device, "http://127.0.0.1:1/song.mp3", "PLAYING") {
+		t.Errorf("Announce() failed with issue: media wasn't restored")
+	}
+	if m, _ := device.Media(); m.CurrentTime < 100 || m.CurrentTime > 105 {
+		t.Errorf("Announce() failed with issue: restored at %v", m.CurrentTime)
+	}
+	if !eventually(func() bool { return device.Volume().Level == 0.5 }) {
+		t.Errorf("Announce() failed with issue: volume restored to %v", device.Volume().Level)
+	}
+
+	// Test against interrupting a paused queue. Passes if the queue is
+	// loaded again at the item it was at, still paused.
+	log.Println("* Test for restoring a paused queue")
+	queue := []string{"http://127.0.0.1:1/one.mp3", "http://127.0.0.1:1/two.mp3"}
+	if err := app.QueueLoad(queue, "audio/mpeg", false, true); err != nil {
+		t.Errorf("QueueLoad() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	if err := app.Update(); err != nil {
+		t.Errorf("Update() failed with issue:\n%+v", err)
+	}
+	if err := app.Pause(); err != nil {
+		t.Errorf("Pause() failed with issue:\n%+v", err)
+	}
+	queueLoads := device.Received("QUEUE_LOAD")
+	finishClip(device)
+	if err := app.Announce(clip, opts); err != nil {
+		t.Errorf("Announce() failed with issue:\n%+v", err)
+	}
+	if !playing(device, queue[0], "PAUSED") {
+		m, _ := device.Media()
+		t.Errorf("Announce() failed with issue: restored %+v", m)
+	}
+	if n := device.Received("QUEUE_LOAD"); n != queueLoads+1 {
+		t.Errorf("Announce() failed with issue: queue loaded %d times", n-queueLoads)
+	}
+
+	// Test against announcements that don't finish. Passes if they time
+	// out, and the media is restored regardless.
+	log.Println("* Test for announcements timing out")
+	device.Play("http://127.0.0.1:1/song.mp3", 600, 100)
+	timeout := opts
+	timeout.Timeout = 200 * time.Millisecond
+	if err := app.Announce(clip, timeout); !errors.Is(err, application.ErrAnnounceTimeout) {
+		t.Errorf("Announce() failed with issue: expected a timeout, got %v", err)
+	}
+	if !playing(device, "http://127.0.0.1:1/song.mp3", "PLAYING") {
+		t.Errorf("Announce() failed with issue: media wasn't restored")
+	}
+
+	// Test against interrupting a device showing its idle screen. Passes
+	// if it goes back to it.
+	log.Println("* Test for restoring the idle screen")
+	idle := newFakeChromecast(t)
+	defer idle.Close()
+	idleApp := idle.Connect(t)
+	defer idleApp.Close(false)
+	finishClip(idle)
+	if err := idleApp.Announce(clip, opts); err != nil {
+		t.Errorf("Announce() failed with issue:\n%+v", err)
+	}
+	if idle.Received("LAUNCH") == 0 || !eventually(func() bool { return idle.Received("STOP") > 0 }) {
+		t.Errorf("Announce() failed with issue: device wasn't launched then stopped")
+	}
+	if m, ok := idle.Media(); ok {
+		t.Errorf("Announce() failed with issue: %+v left loaded", m)
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
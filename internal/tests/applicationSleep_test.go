@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avinash240/pusher/internal/server/application"
+)
+
+func TestSleepTimer(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	device := newFakeChromecast(t)
+	defer device.Close()
+	app := device.Connect(t)
+	defer app.Close(false)
+
+	// Test against timers waiting for the end of media when nothing is
+	// playing. Passes if they aren't set.
+	log.Println("* Test for sleeping with nothing playing")
+	if err := app.Sleep(application.SleepOptions{Until: application.SleepEndOfItem}); err != application.ErrNoMediaSleep {
+		t.Errorf("Sleep() failed with issue: set with nothing playing: %v", err)
+	}
+	device.Play("http://127.0.0.1:1/song.mp3", 600, 0)
+	if err := app.Update(); err != nil {
+		t.Errorf("Update() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+
+	remaining := func() float64 {
+		info := app.SleepStatus()
+		if info == nil || info.Remaining == nil {
+			return -1
+		}
+		return *info.Remaining
+	}
+
+	// Test against arming, extending and cancelling a timer. Passes if the
+	// time remaining follows each change, and the timer is gone once
+	// cancelled.
+	log.Println("* Test for arming, extending and cancelling")
+	if err := app.Sleep(application.SleepOptions{Until: application.SleepAfter, After: 10 * time.Minute}); err != nil {
+		t.Errorf("Sleep() failed with issue:\n%+v", err)
+	}
+	if r := remaining(); r < 590 || r > 600 {
+		t.Errorf("SleepStatus() failed with issue: %v seconds remaining once armed", r)
+	}
+	if err := app.ExtendSleep(0); err != application.ErrSleepExtension {
+		t.Errorf("ExtendSleep() failed with issue: extended by nothing: %v", err)
+	}
+	if err := app.ExtendSleep(5 * time.Minute); err != nil {
+		t.Errorf("ExtendSleep() failed with issue:\n%+v", err)
+	}
+	if r := remaining(); r < 890 || r > 900 {
+		t.Errorf("SleepStatus() failed with issue: %v seconds remaining once extended", r)
+	}
+	if err := app.CancelSleep(); err != nil {
+		t.Errorf("CancelSleep() failed with issue:\n%+v", err)
+	}
+	if info := app.SleepStatus(); info != nil {
+		t.Errorf("SleepStatus() failed with issue: %+v once cancelled", info)
+	}
+	if err := app.CancelSleep(); err != application.ErrNoSleepTimer {
+		t.Errorf("CancelSleep() failed with issue: cancelled twice: %v", err)
+	}
+	if err := app.ExtendSleep(time.Minute); err != application.ErrNoSleepTimer {
+		t.Errorf("ExtendSleep() failed with issue: extended once cancelled: %v", err)
+	}
+
+	// Test against a timer fading out, then pausing the media. Passes if
+	// the volume is lowered while fading, and restored once paused.
+	log.Println("* Test for fading out and going off")
+	fade := application.SleepOptions{
+		Until:  application.SleepAfter,
+		After:  2500 * time.Millisecond,
+		Action: application.SleepPause,
+		Fade:   2 * time.Second,
+	}
+	if err := app.Sleep(fade); err != nil {
+		t.Errorf("Sleep() failed with issue:\n%+v", err)
+	}
+	time.Sleep(1800 * time.Millisecond)
+	if info := app.SleepStatus(); info == nil || !info.Fading {
+		t.Errorf("SleepStatus() failed with issue: %+v while fading", info)
+	}
+	if v := device.Volume(); v.Level <= 0 || v.Level > 0.4 {
+		t.Errorf("Sleep() failed with issue: volume %v while fading", v.Level)
+	}
+	time.Sleep(1200 * time.Millisecond)
+	if m, _ := device.Media(); m.PlayerState != "PAUSED" {
+		t.Errorf("Sleep() failed with issue: media %s once gone off", m.PlayerState)
+	}
+	if v := device.Volume(); v.Level != 0.5 {
+		t.Errorf("Sleep() failed with issue: volume %v once gone off", v.Level)
+	}
+	if info := app.SleepStatus(); info != nil {
+		t.Errorf("SleepStatus() failed with issue: %+v once gone off", info)
+	}
+
+	// Test against cancelling a timer while it is fading out. Passes if the
+	// volume is restored and the media left playing.
+	log.Println("* Test for cancelling while fading out")
+	device.Play("http://127.0.0.1:1/song.mp3", 600, 0)
+	app.Update()
+	if err := app.Sleep(fade); err != nil {
+		t.Errorf("Sleep() failed with issue:\n%+v", err)
+	}
+	time.Sleep(1800 * time.Millisecond)
+	if err := app.CancelSleep(); err != nil {
+		t.Errorf("CancelSleep() failed with issue:\n%+v", err)
+	}
+	time.Sleep(1200 * time.Millisecond)
+	if m, _ := device.Media(); m.PlayerState != "PLAYING" {
+		t.Errorf("CancelSleep() failed with issue: media %s once cancelled", m.PlayerState)
+	}
+	if v := device.Volume(); v.Level != 0.5 {
+		t.Errorf("CancelSleep() failed with issue: volume %v once cancelled", v.Level)
+	}
+
+	// Test against a timer waiting for the end of the item playing. Passes
+	// if it ends with the item, and stops the media when it does.
+	log.Println("* Test for sleeping at the end of the item")
+	if err := app.Sleep(application.SleepOptions{Until: application.SleepEndOfItem}); err != nil {
+		t.Errorf("Sleep() failed with issue:\n%+v", err)
+	}
+	if r := remaining(); r < 590 || r > 600 {
+		t.Errorf("SleepStatus() failed with issue: %v seconds remaining in the item", r)
+	}
+	device.Finish()
+	for i := 0; i < 50 && app.SleepStatus() != nil; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if info := app.SleepStatus(); info != nil {
+		t.Errorf("SleepStatus() failed with issue: %+v once the item ended", info)
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avinash240/pusher/internal/server/application"
+)
+
+func TestSyncGroup(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	uuids := []string{"a", "b", "c"}
+	devices := map[string]*fakeChromecast{}
+	apps := map[string]*application.Application{}
+	for _, uuid := range uuids {
+		devices[uuid] = newFakeChromecast(t)
+		defer devices[uuid].Close()
+		apps[uuid] = devices[uuid].Connect(t)
+		defer apps[uuid].Close(false)
+	}
+	song := "http://127.0.0.1:1/song.mp3"
+	g := application.NewSyncGroup("kitchen", apps,
+		application.WithSyncInterval(200*time.Millisecond),
+		application.WithSyncTolerance(500*time.Millisecond),
+	)
+	defer g.Close()
+	// inState reports whether the device uuid is playing the song in state,
+	// once the requests sent to it have arrived.
+	inState := func(uuid, state string) bool {
+		return eventually(func() bool {
+			m, ok := devices[uuid].Media()
+			return ok && m.Media.ContentId == song && m.PlayerState == state
+		})
+	}
+	statusOf := func(uuid string) application.SyncDeviceStatus {
+		for _, d := range g.Status().Devices {
+			if d.UUID == uuid {
+				return d
+			}
+		}
+		return application.SyncDeviceStatus{}
+	}
+
+	// Test against starting every device together. Passes if each was
+	// loaded paused, then played.
+	log.Println("* Test for starting together")
+	if err := g.Play(song, "audio/mpeg"); err != nil {
+		t.Errorf("Play() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	for _, uuid := range uuids {
+		if !inState(uuid, "PLAYING") || devices[uuid].Received("PLAY") != 1 {
+			m, _ := devices[uuid].Media()
+			t.Errorf("Play() failed with issue: device %s is at %+v", uuid, m)
+		}
+	}
+	if status := g.Status(); status.Path != song || status.Started == nil {
+		t.Errorf("Status() failed with issue: %+v once started", status)
+	}
+
+	// Test against a device drifting ahead of the others. Passes if only
+	// it is seeked back in line.
+	log.Println("* Test for correcting drift")
+	devices["b"].Skew(2)
+	if !eventually(func() bool { return statusOf("b").Resyncs > 0 }) {
+		t.Errorf("Status() failed with issue: drifted device wasn't resynced: %+v", statusOf("b"))
+	}
+	if d := statusOf("b").Drift; d < 1.5 || d > 2.5 {
+		t.Errorf("Status() failed with issue: drift of %v measured", d)
+	}
+	devices["b"].Skew(0)
+	for _, uuid := range []string{"a", "c"} {
+		if n := devices[uuid].Received("SEEK"); n != 0 || statusOf(uuid).Resyncs != 0 {
+			t.Errorf("Status() failed with issue: device %s in line was seeked %d times", uuid, n)
+		}
+	}
+
+	// Test against pausing and unpausing. Passes if every device is paused,
+	// then lined up and played again.
+	log.Println("* Test for pausing and unpausing")
+	if err := g.Pause(); err != nil {
+		t.Errorf("Pause() failed with issue:\n%+v", err)
+	}
+	for _, uuid := range uuids {
+		if !inState(uuid, "PAUSED") {
+			t.Errorf("Pause() failed with issue: device %s isn't paused", uuid)
+		}
+	}
+	if err := g.Unpause(); err != nil {
+		t.Errorf("Unpause() failed with issue:\n%+v", err)
+	}
+	for _, uuid := range uuids {
+		if !inState(uuid, "PLAYING") {
+			t.Errorf("Unpause() failed with issue: device %s isn't playing", uuid)
+		}
+	}
+
+	// Test against a device that can't be reached. Passes if it is left
+	// out with its error, and the rest play.
+	log.Println("* Test for a device failing")
+	devices["c"].Close()
+	if err := g.Play(song, "audio/mpeg"); err != nil {
+		t.Errorf("Play() failed with issue:\n%+v", err)
+	}
+	if statusOf("c").Error == "" {
+		t.Errorf("Status() failed with issue: no error for the device that failed")
+	}
+	for _, uuid := range []string{"a", "b"} {
+		if !inState(uuid, "PLAYING") || statusOf(uuid).Error != "" {
+			t.Errorf("Play() failed with issue: device %s isn't playing", uuid)
+		}
+	}
+
+	// Test against every device failing. Passes if playing fails.
+	log.Println("* Test for every device failing")
+	devices["a"].Close()
+	devices["b"].Close()
+	if err := g.Play(song, "audio/mpeg"); err == nil {
+		t.Errorf("Play() failed with issue: played with no device reachable")
+	}
+
+	// Test against a group checking for drift every zero seconds. Passes if
+	// it plays, checking at the default interval instead.
+	log.Println("* Test for an interval of zero")
+	device := newFakeChromecast(t)
+	defer device.Close()
+	app := device.Connect(t)
+	defer app.Close(false)
+	zero := application.NewSyncGroup("zero", map[string]*application.Application{"d": app}, application.WithSyncInterval(0))
+	defer zero.Close()
+	if err := zero.Play(song, "audio/mpeg"); err != nil {
+		t.Errorf("Play() failed with issue:\n%+v", err)
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
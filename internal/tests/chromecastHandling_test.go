@@ -43,7 +43,7 @@ func TestLoadChromecastMiddleware(t *testing.T) {
 		log.Println(k, v)
 	}
 	if len(d) > 0 && len(d[0].UUID) > 0 {
-		resp, err := http.Get("http://localhost:8081/connect?uuid=" + d[0].UUID)
+		resp, err := http.Post("http://localhost:8081/connect?uuid="+d[0].UUID, "", nil)
 		if err != nil {
 			t.Errorf(err.Error())
 			t.FailNow()
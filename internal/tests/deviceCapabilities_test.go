@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"testing"
+
+	dev "github.com/avinash240/pusher/internal/server/device"
+)
+
+func TestDeviceCapabilities(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against a speaker. Passes if video is neither displayed nor
+	// transcoded to.
+	log.Println("* Test for audio only device: Google Nest Mini")
+	c := dev.FromInfoFields(map[string]string{"md": "Google Nest Mini", "ca": "199172"})
+	if c.VideoOut || !c.AudioOut || c.CanDisplay("video/mp4") || !c.CanDisplay("audio/mpeg") {
+		t.Errorf("FromInfoFields() failed with issue: unexpected capabilities %+v", c)
+	}
+	if p := c.TranscodeProfile(); p.ContentType != dev.AudioProfile.ContentType {
+		t.Errorf("TranscodeProfile() failed with issue: expected %q, got %q", dev.AudioProfile.ContentType, p.ContentType)
+	}
+	log.Printf("*\t got capabilities: %+v", c)
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against a group. Passes if it is a multizone group.
+	log.Println("* Test for multizone group")
+	c = dev.FromInfoFields(map[string]string{"md": "Google Cast Group", "ca": "199204"})
+	if !c.MultizoneGroup || c.VideoOut {
+		t.Errorf("FromInfoFields() failed with issue: unexpected capabilities %+v", c)
+	}
+	log.Printf("*\t got capabilities: %+v", c)
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against a 4K chromecast. Passes if HEVC and 4K are supported.
+	log.Println("* Test for video device: Chromecast Ultra")
+	c = dev.FromInfoFields(map[string]string{"md": "Chromecast Ultra", "ca": "201221"})
+	if !c.VideoOut || !c.SupportsVideoCodec("hevc") || c.MaxResolution.Width != 3840 {
+		t.Errorf("FromInfoFields() failed with issue: unexpected capabilities %+v", c)
+	}
+	log.Printf("*\t got capabilities: %+v", c)
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against an unknown device. Passes if it is assumed to be a
+	// chromecast.
+	log.Println("* Test for unknown device")
+	c = dev.FromInfoFields(map[string]string{})
+	if c.Known || !c.VideoOut || !c.SupportsVideoCodec("h264") {
+		t.Errorf("FromInfoFields() failed with issue: unexpected capabilities %+v", c)
+	}
+	log.Printf("*\t got capabilities: %+v", c)
+	log.Println(strings.Repeat("*", strRp))
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+
+	"github.com/avinash240/pusher/internal/server/application"
+	cast "github.com/avinash240/pusher/internal/server/cast"
+	pb "github.com/avinash240/pusher/internal/server/cast/proto"
+	"github.com/avinash240/pusher/internal/server/certs"
+	"github.com/avinash240/pusher/internal/server/media"
+)
+
+const (
+	fakeAppID       = "CC1AD845"
+	fakeTransportID = "web-0"
+	fakeSender      = "sender-0"
+
+	fakeNamespaceRecv  = "urn:x-cast:com.google.cast.receiver"
+	fakeNamespaceMedia = "urn:x-cast:com.google.cast.media"
+)
+
+// fakeChromecast is a device running the default media receiver, which
+// answers the cast protocol closely enough for applications to connect to
+// it and control its media. Media doesn't play, but its position moves on
+// while it is playing.
+type fakeChromecast struct {
+	listener net.Listener
+	dir      string
+
+	mu    sync.Mutex
+	conns map[net.Conn]*sync.Mutex
+	// The type of every request received, in order.
+	received []string
+	// Set once the connection of the last application has been closed.
+	closedAt time.Time
+
+	launched bool
+	volume   cast.Volume
+	media    *cast.Media
+	sessions int
+	// Where the media was when it last started playing or was seeked, and
+	// when.
+	position float32
+	since    time.Time
+	// Positions are reported 'skew' seconds ahead of where the media is.
+	skew float32
+	// Requests aren't answered while 'unresponsive' is set.
+	unresponsive bool
+}
+
+// fakeRequest is every field of the requests answered, by name.
+type fakeRequest struct {
+	Type        string               `json:"type"`
+	RequestID   int                  `json:"requestId"`
+	Autoplay    *bool                `json:"autoplay"`
+	CurrentTime float32              `json:"currentTime"`
+	ResumeState string               `json:"resumeState"`
+	StartIndex  int                  `json:"startIndex"`
+	Media       cast.MediaItem       `json:"media"`
+	Items       []cast.QueueLoadItem `json:"items"`
+	Volume      struct {
+		Level *float32 `json:"level"`
+		Muted *bool    `json:"muted"`
+	} `json:"volume"`
+}
+
+// newFakeChromecast starts a device listening on the loopback address.
+func newFakeChromecast(t *testing.T) *fakeChromecast {
+	dir, err := ioutil.TempDir("", "pusher-fake-chromecast")
+	if err != nil {
+		t.Errorf("TempDir() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if _, err := certs.EnsureSelfSigned(certFile, keyFile); err != nil {
+		t.Errorf("EnsureSelfSigned() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	store, err := certs.Load(certFile, keyFile)
+	if err != nil {
+		t.Errorf("Load() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: store.GetCertificate})
+	if err != nil {
+		t.Errorf("Listen() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+
+	f := &fakeChromecast{
+		listener: l,
+		dir:      dir,
+		conns:    map[net.Conn]*sync.Mutex{},
+		volume:   cast.Volume{Level: 0.5},
+	}
+	go f.accept()
+	return f
+}
+
+// Connect returns an application connected to the device, serving media
+// from a server of its own.
+func (f *fakeChromecast) Connect(t *testing.T) *application.Application {
+	addr, port := f.Addr()
+	app := application.NewApplication(
+		application.WithCacheDisabled(true),
+		application.WithConnectionRetries(1),
+		application.WithMediaServer(media.NewServer(0)),
+	)
+	if err := app.Start(addr, port); err != nil {
+		t.Errorf("Start() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	return app
+}
+
+// Addr returns the address and port applications connect to.
+func (f *fakeChromecast) Addr() (string, int) {
+	addr := f.listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+// Close stops listening and closes every connection.
+func (f *fakeChromecast) Close() {
+	f.listener.Close()
+	f.mu.Lock()
+	for conn := range f.conns {
+		conn.Close()
+	}
+	f.mu.Unlock()
+	os.RemoveAll(f.dir)
+}
+
+// Received returns how many requests of messageType have been received.
+func (f *fakeChromecast) Received(messageType string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, t := range f.received {
+		if t == messageType {
+			n++
+		}
+	}
+	return n
+}
+
+// ClosedAt returns when the last connection was closed, or the zero time
+// if one is still open.
+func (f *fakeChromecast) ClosedAt() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closedAt
+}
+
+// Media returns the media loaded, with its current position.
+func (f *fakeChromecast) Media() (cast.Media, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.media == nil {
+		return cast.Media{}, false
+	}
+	return f.mediaStatus(), true
+}
+
+// Volume returns the volume of the device.
+func (f *fakeChromecast) Volume() cast.Volume {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.volume
+}
+
+// Play starts playing content at position, as another sender would.
+func (f *fakeChromecast) Play(content string, duration, position float32) {
+	f.mu.Lock()
+	f.launched = true
+	f.load(cast.MediaItem{ContentId: content, Duration: duration}, position, true)
+	f.mu.Unlock()
+}
+
+// Finish ends the media playing, telling every application.
+func (f *fakeChromecast) Finish() {
+	f.mu.Lock()
+	if f.media == nil {
+		f.mu.Unlock()
+		return
+	}
+	f.media.PlayerState, f.media.IdleReason = "IDLE", "FINISHED"
+	status := f.mediaStatus()
+	f.media = nil
+	f.mu.Unlock()
+	f.broadcast(fakeNamespaceMedia, &cast.MediaStatusResponse{
+		PayloadHeader: cast.PayloadHeader{Type: "MEDIA_STATUS"},
+		Status:        []cast.Media{status},
+	})
+}
+
+// Skew reports positions seconds ahead of where the media is, as a device
+// that has drifted would.
+func (f *fakeChromecast) Skew(seconds float32) {
+	f.mu.Lock()
+	f.skew = seconds
+	f.mu.Unlock()
+}
+
+// SetUnresponsive stops, or starts, answering requests.
+func (f *fakeChromecast) SetUnresponsive(unresponsive bool) {
+	f.mu.Lock()
+	f.unresponsive = unresponsive
+	f.mu.Unlock()
+}
+
+func (f *fakeChromecast) accept() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns[conn] = &sync.Mutex{}
+		f.closedAt = time.Time{}
+		f.mu.Unlock()
+		go f.serve(conn)
+	}
+}
+
+func (f *fakeChromecast) serve(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		f.mu.Lock()
+		delete(f.conns, conn)
+		if len(f.conns) == 0 {
+			f.closedAt = time.Now()
+		}
+		f.mu.Unlock()
+	}()
+
+	for {
+		var length uint32
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		msg := &pb.CastMessage{}
+		if err := proto.Unmarshal(data, msg); err != nil {
+			return
+		}
+		var req fakeRequest
+		if err := json.Unmarshal([]byte(msg.GetPayloadUtf8()), &req); err != nil {
+			continue
+		}
+
+		f.mu.Lock()
+		f.received = append(f.received, req.Type)
+		unresponsive := f.unresponsive
+		answer := f.answer(msg.GetNamespace(), req)
+		f.mu.Unlock()
+		if answer == nil || unresponsive {
+			continue
+		}
+		f.send(conn, msg.GetDestinationId(), msg.GetNamespace(), answer)
+	}
+}
+
+// answer applies req to the device, and returns what it answers with. It
+// is called holding 'mu'.
+func (f *fakeChromecast) answer(namespace string, req fakeRequest) cast.Payload {
+	switch namespace {
+	case fakeNamespaceRecv:
+		switch req.Type {
+		case "LAUNCH":
+			f.launched = true
+		case "STOP":
+			f.launched, f.media = false, nil
+		case "SET_VOLUME":
+			if req.Volume.Level != nil {
+				f.volume.Level = *req.Volume.Level
+			} else if req.Volume.Muted != nil {
+				f.volume.Muted = *req.Volume.Muted
+			}
+		case "GET_STATUS":
+		default:
+			return nil
+		}
+		resp := &cast.ReceiverStatusResponse{PayloadHeader: cast.PayloadHeader{Type: "RECEIVER_STATUS"}}
+		if f.launched {
+			resp.Status.Applications = []cast.Application{{
+				AppId:       fakeAppID,
+				DisplayName: "Default Media Receiver",
+				SessionId:   "fake-session",
+				TransportId: fakeTransportID,
+			}}
+		}
+		resp.Status.Volume = f.volume
+		resp.RequestId = req.RequestID
+		return resp
+
+	case fakeNamespaceMedia:
+		switch req.Type {
+		case "LOAD":
+			f.load(req.Media, req.CurrentTime, req.Autoplay == nil || *req.Autoplay)
+		case "QUEUE_LOAD":
+			if req.StartIndex < len(req.Items) {
+				item := req.Items[req.StartIndex]
+				f.load(item.Media, req.CurrentTime, item.Autoplay)
+			}
+		case "PLAY", "PAUSE", "SEEK":
+			if f.media == nil {
+				break
+			}
+			position := f.currentTime()
+			state := f.media.PlayerState
+			switch {
+			case req.Type == "PLAY" || req.ResumeState == "PLAYBACK_START":
+				state = "PLAYING"
+			case req.Type == "PAUSE" || req.ResumeState == "PLAYBACK_PAUSE":
+				state = "PAUSED"
+			}
+			if req.Type == "SEEK" {
+				position = req.CurrentTime
+			}
+			f.media.PlayerState = state
+			f.position, f.since = position, time.Now()
+		case "STOP":
+			if f.media != nil {
+				f.media.PlayerState, f.media.IdleReason = "IDLE", "CANCELLED"
+				status := f.mediaStatus()
+				f.media = nil
+				return &cast.MediaStatusResponse{
+					PayloadHeader: cast.PayloadHeader{Type: "MEDIA_STATUS", RequestId: req.RequestID},
+					Status:        []cast.Media{status},
+				}
+			}
+		case "GET_STATUS", "QUEUE_UPDATE":
+		default:
+			return nil
+		}
+		resp := &cast.MediaStatusResponse{
+			PayloadHeader: cast.PayloadHeader{Type: "MEDIA_STATUS", RequestId: req.RequestID},
+			Status:        []cast.Media{},
+		}
+		if f.media != nil {
+			resp.Status = append(resp.Status, f.mediaStatus())
+		}
+		return resp
+	}
+	return nil
+}
+
+// load replaces the media with item. It is called holding 'mu'.
+func (f *fakeChromecast) load(item cast.MediaItem, position float32, autoplay bool) {
+	f.sessions++
+	state := "PAUSED"
+	if autoplay {
+		state = "PLAYING"
+	}
+	f.media = &cast.Media{
+		MediaSessionId: f.sessions,
+		PlayerState:    state,
+		Volume:         f.volume,
+		Media:          item,
+	}
+	f.position, f.since = position, time.Now()
+}
+
+// currentTime returns where the media is. It is called holding 'mu'.
+func (f *fakeChromecast) currentTime() float32 {
+	position := f.position
+	if f.media.PlayerState == "PLAYING" {
+		position += float32(time.Since(f.since).Seconds())
+	}
+	return position
+}
+
+// mediaStatus describes the media. It is called holding 'mu'.
+func (f *fakeChromecast) mediaStatus() cast.Media {
+	status := *f.media
+	status.CurrentTime = f.currentTime() + f.skew
+	return status
+}
+
+// broadcast sends payload to every application connected.
+func (f *fakeChromecast) broadcast(namespace string, payload interface{}) {
+	f.mu.Lock()
+	conns := make([]net.Conn, 0, len(f.conns))
+	for conn := range f.conns {
+		conns = append(conns, conn)
+	}
+	f.mu.Unlock()
+	for _, conn := range conns {
+		f.send(conn, fakeTransportID, namespace, payload)
+	}
+}
+
+func (f *fakeChromecast) send(conn net.Conn, source, namespace string, payload interface{}) {
+	f.mu.Lock()
+	writeMu, ok := f.conns[conn]
+	f.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	payloadUtf8, destination := string(data), fakeSender
+	msg, err := proto.Marshal(&pb.CastMessage{
+		ProtocolVersion: pb.CastMessage_CASTV2_1_0.Enum(),
+		SourceId:        &source,
+		DestinationId:   &destination,
+		Namespace:       &namespace,
+		PayloadType:     pb.CastMessage_STRING.Enum(),
+		PayloadUtf8:     &payloadUtf8,
+	})
+	if err != nil {
+		return
+	}
+
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	binary.Write(conn, binary.BigEndian, uint32(len(msg)))
+	conn.Write(msg)
+}
+
+// eventually reports whether cond holds within a couple of seconds, for
+// requests sent without waiting for an answer.
+func eventually(cond func() bool) bool {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/avinash240/pusher/internal/server/media"
+)
+
+func TestMediaAllowlist(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	s := media.NewServer(0, media.WithClientAllowlist(network))
+	session := s.NewSession("device")
+	token, err := session.Register(media.Item{Filename: "./test_data/a_ascii.txt"})
+	if err != nil {
+		t.Errorf("Register() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	anyToken, err := session.Register(media.Item{Filename: "./test_data/a_ascii.txt", AllowAnyClient: true})
+	if err != nil {
+		t.Errorf("Register() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	fetch := func(token, remote string) int {
+		req := httptest.NewRequest(http.MethodGet, session.ItemPath(token, ""), nil)
+		req.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Test against clients in and out of the allowlist. Passes if items
+	// are only served to the host, allowed networks and allowed devices,
+	// unless they allow any client. Clients that aren't allowed can't tell
+	// valid tokens from unknown ones.
+	log.Println("* Test for serving items to allowed clients")
+	device := net.ParseIP("192.0.2.1")
+	for _, tc := range []struct {
+		name   string
+		token  string
+		remote string
+		allow  bool
+		status int
+	}{
+		{"unknown client", token, "192.0.2.1:4000", false, http.StatusForbidden},
+		{"host", token, "127.0.0.1:4000", false, http.StatusOK},
+		{"allowed network", token, "10.1.2.3:4000", false, http.StatusOK},
+		{"connected device", token, "192.0.2.1:4000", true, http.StatusOK},
+		{"any client item", anyToken, "192.0.2.1:4000", false, http.StatusOK},
+		{"unknown client with an unknown token", "missing", "192.0.2.1:4000", false, http.StatusForbidden},
+		{"host with an unknown token", "missing", "127.0.0.1:4000", false, http.StatusNotFound},
+	} {
+		s.RemoveClients("device")
+		if tc.allow {
+			s.AllowClients("device", device)
+		}
+		if status := fetch(tc.token, tc.remote); status != tc.status {
+			t.Errorf("ServeHTTP() failed with issue: %s got status %d, expected %d", tc.name, status, tc.status)
+		}
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/avinash240/pusher/internal/server/media"
+)
+
+func TestMediaSessionNames(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against session names that need escaping in paths. Passes if
+	// items are served from each session, and not from another.
+	log.Println("* Test for session names needing escaping")
+	s := media.NewServer(0)
+	names := []string{"device", "Living Room", "a/b", "100%", "café?"}
+	sessions := map[string]*media.Session{}
+	tokens := map[string]string{}
+	for _, name := range names {
+		sessions[name] = s.NewSession(name)
+		token, err := sessions[name].Register(media.Item{Filename: "./test_data/a_ascii.txt"})
+		if err != nil {
+			t.Errorf("Register() failed with issue:\n%+v", err)
+			t.FailNow()
+		}
+		tokens[name] = token
+	}
+	for _, name := range names {
+		path := sessions[name].ItemPath(tokens[name], "")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("ServeHTTP() failed with issue: session %q got status %d for %s", name, w.Code, path)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, sessions["device"].ItemPath(tokens["a/b"], ""), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("ServeHTTP() failed with issue: item served from another session with status %d", w.Code)
+	}
+
+	// Test against paths beneath an item. Passes if they're only passed to
+	// handlers serving resources beneath their item.
+	log.Println("* Test for paths beneath items")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	plain, _ := sessions["device"].Register(media.Item{Handler: ok})
+	nested, _ := sessions["device"].Register(media.Item{Handler: ok, SubPaths: true})
+	for _, tc := range []struct {
+		path   string
+		status int
+	}{
+		{sessions["device"].ItemPath(tokens["device"], "/anything"), http.StatusNotFound},
+		{sessions["device"].ItemPath(plain, ""), http.StatusOK},
+		{sessions["device"].ItemPath(plain, "/anything"), http.StatusNotFound},
+		{sessions["device"].ItemPath(nested, "/segment00000.ts"), http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if w.Code != tc.status {
+			t.Errorf("ServeHTTP() failed with issue: status %d for %s", w.Code, tc.path)
+		}
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/avinash240/pusher/internal/scheduler"
+)
+
+func TestCron(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against cron expressions. Passes if the next run after a
+	// Wednesday morning is the expected time.
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Errorf("LoadLocation() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	from := time.Date(2021, time.March, 3, 6, 30, 0, 0, loc)
+	tests := map[string]time.Time{
+		"45 6 * * mon-fri":    time.Date(2021, time.March, 3, 6, 45, 0, 0, loc),
+		"0 7 * * 1,3,5":       time.Date(2021, time.March, 3, 7, 0, 0, 0, loc),
+		"*/20 * * * *":        time.Date(2021, time.March, 3, 6, 40, 0, 0, loc),
+		"0 23 * * sun":        time.Date(2021, time.March, 7, 23, 0, 0, 0, loc),
+		"0 9 1 * *":           time.Date(2021, time.April, 1, 9, 0, 0, 0, loc),
+		"0 9 15 * 0":          time.Date(2021, time.March, 7, 9, 0, 0, 0, loc),
+		"30 1 * * 7":          time.Date(2021, time.March, 7, 1, 30, 0, 0, loc),
+		"@daily":              time.Date(2021, time.March, 4, 0, 0, 0, 0, loc),
+		"0 12 29 feb *":       time.Date(2024, time.February, 29, 12, 0, 0, 0, loc),
+		"0 6-8/2 * jan-dec *": time.Date(2021, time.March, 3, 8, 0, 0, 0, loc),
+	}
+	for expr, want := range tests {
+		log.Printf("* Test for expression: %s", expr)
+		c, err := scheduler.ParseCron(expr)
+		if err != nil {
+			t.Errorf("ParseCron() failed with issue:\n%+v", err)
+			continue
+		}
+		if got := c.Next(from); !got.Equal(want) {
+			t.Errorf("Next() failed with issue: %q got %v, expected %v", expr, got, want)
+		}
+	}
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against the night clocks go back. Passes if a daily job runs once
+	// in the hour shown twice, and an hourly job runs in both.
+	log.Println("* Test for clocks going back")
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Errorf("LoadLocation() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	daily, _ := scheduler.ParseCron("30 1 * * *")
+	hourly, _ := scheduler.ParseCron("@hourly")
+	first := time.Date(2026, time.November, 1, 5, 30, 0, 0, time.UTC).In(ny)
+	for _, tc := range []struct {
+		cron *scheduler.Cron
+		from time.Time
+		want time.Time
+	}{
+		{daily, time.Date(2026, time.November, 1, 0, 10, 0, 0, ny), first},
+		{daily, first, time.Date(2026, time.November, 2, 1, 30, 0, 0, ny)},
+		{hourly, first, time.Date(2026, time.November, 1, 6, 0, 0, 0, time.UTC)},
+	} {
+		if got := tc.cron.Next(tc.from); !got.Equal(tc.want) {
+			t.Errorf("Next() failed with issue: from %v got %v, expected %v", tc.from, got, tc.want)
+		}
+	}
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against invalid expressions. Passes if they are rejected.
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "* * * foo *"} {
+		log.Printf("* Test for expression: %s", expr)
+		if _, err := scheduler.ParseCron(expr); err == nil {
+			t.Errorf("ParseCron() failed with issue: expected an error for %q", expr)
+		}
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
+
+type recordingRunner struct {
+	mu      sync.Mutex
+	actions []scheduler.Action
+}
+
+func (r *recordingRunner) RunAction(ctx context.Context, a scheduler.Action) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.actions = append(r.actions, a)
+	return nil
+}
+
+func TestScheduler(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against a job persisted and run on demand. Passes if it is loaded
+	// again with its history, and its actions ran in order.
+	log.Println("* Test for persisted job")
+	dir, err := ioutil.TempDir("", "pusher-schedules")
+	if err != nil {
+		t.Errorf("TempDir() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "schedules.json")
+
+	runner := &recordingRunner{}
+	s, err := scheduler.New(path, runner)
+	if err != nil {
+		t.Errorf("New() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	job, err := s.Add(scheduler.Job{
+		Name:     "alarm",
+		Cron:     "0 7 * * mon-fri",
+		TimeZone: "America/New_York",
+		Enabled:  true,
+		Actions: []scheduler.Action{
+			{Type: scheduler.Volume, Device: "Kitchen", Volume: 0.3},
+			{Type: scheduler.Load, Device: "Kitchen", Path: "http://example.com/radio.mp3"},
+		},
+	})
+	if err != nil {
+		t.Errorf("Add() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	if job.NextRun == nil || job.NextRun.In(time.UTC).Hour() != 11 && job.NextRun.In(time.UTC).Hour() != 12 {
+		t.Errorf("Add() failed with issue: next run %v", job.NextRun)
+	}
+	if err := s.RunNow(job.ID); err != nil {
+		t.Errorf("RunNow() failed with issue:\n%+v", err)
+	}
+	if err := s.Stop(context.Background()); err != nil {
+		t.Errorf("Stop() failed with issue:\n%+v", err)
+	}
+	if len(runner.actions) != 2 || runner.actions[0].Type != scheduler.Volume {
+		t.Errorf("RunNow() failed with issue: ran %+v", runner.actions)
+	}
+
+	s, err = scheduler.New(path, runner)
+	if err != nil {
+		t.Errorf("New() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	if jobs := s.Jobs(); len(jobs) != 1 || jobs[0].LastRun == nil || jobs[0].NextRun == nil {
+		t.Errorf("New() failed with issue: loaded %+v", jobs)
+	}
+	if runs := s.History(job.ID); len(runs) != 1 || runs[0].Error != "" {
+		t.Errorf("History() failed with issue: %+v", runs)
+	}
+
+	// Test against invalid jobs. Passes if they are rejected.
+	log.Println("* Test for invalid jobs")
+	invalid := []scheduler.Job{
+		{Name: "no schedule", Enabled: true, Actions: []scheduler.Action{{Type: scheduler.Stop, Device: "*"}}},
+		{Name: "bad zone", Cron: "@daily", TimeZone: "Mars/Olympus", Enabled: true, Actions: []scheduler.Action{{Type: scheduler.Stop, Device: "*"}}},
+		{Name: "in the past", At: "2001-01-01 07:00", Enabled: true, Actions: []scheduler.Action{{Type: scheduler.Stop, Device: "*"}}},
+		{Name: "bad volume", Cron: "@daily", Enabled: true, Actions: []scheduler.Action{{Type: scheduler.Volume, Device: "*", Volume: 2}}},
+	}
+	for _, j := range invalid {
+		if _, err := s.Add(j); err == nil {
+			t.Errorf("Add() failed with issue: expected an error for %q", j.Name)
+		}
+	}
+
+	// Test against a job that only runs once, due while the scheduler
+	// wasn't running. Passes if it is recorded as missed and disabled.
+	log.Println("* Test for missed jobs")
+	missed := `{"jobs": [{"id": "once", "name": "once", "at": "2001-01-01T07:00:00Z", "enabled": true, "actions": [{"type": "stop", "device": "*"}]}]}`
+	if err := ioutil.WriteFile(path, []byte(missed), 0644); err != nil {
+		t.Errorf("WriteFile() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	s, err = scheduler.New(path, runner)
+	if err != nil {
+		t.Errorf("New() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	if job, err := s.Job("once"); err != nil || job.Enabled || job.NextRun != nil {
+		t.Errorf("New() failed with issue: missed job loaded as %+v", job)
+	}
+	if runs := s.History("once"); len(runs) != 1 || runs[0].Error == "" {
+		t.Errorf("History() failed with issue: %+v", runs)
+	}
+
+	// Test against schedules that can't be loaded. Passes if the error is
+	// returned, and they are never overwritten.
+	log.Println("* Test for schedules that can't be loaded")
+	corrupt := []byte(`{"jobs": [`)
+	if err := ioutil.WriteFile(path, corrupt, 0644); err != nil {
+		t.Errorf("WriteFile() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	s, err = scheduler.New(path, runner)
+	if err == nil || s == nil {
+		t.Errorf("New() failed with issue: loaded a corrupt file")
+		t.FailNow()
+	}
+	if _, err := s.Add(scheduler.Job{Name: "new", Cron: "@daily", Enabled: true, Actions: []scheduler.Action{{Type: scheduler.Stop, Device: "*"}}}); !errors.Is(err, scheduler.ErrNotLoaded) {
+		t.Errorf("Add() failed with issue: expected ErrNotLoaded, got %v", err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != string(corrupt) {
+		t.Errorf("Add() failed with issue: overwrote schedules with %s", b)
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
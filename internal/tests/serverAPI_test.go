@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	srv "github.com/avinash240/pusher/internal/server"
+	chttp "github.com/avinash240/pusher/internal/server/chttp"
+)
+
+func TestAPI(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	h := srv.NewHandler(false)
+	defer h.Shutdown(context.Background(), false)
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	do := func(method, path, contentType, body string) *http.Response {
+		req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Errorf("NewRequest() failed with issue:\n%+v", err)
+			t.FailNow()
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("%s %s failed with issue:\n%+v", method, path, err)
+			t.FailNow()
+		}
+		return resp
+	}
+
+	// Test against requests that fail. Passes if each is answered with its
+	// status and the error envelope with its code.
+	log.Println("* Test for error responses")
+	tests := []struct {
+		method, path, contentType, body string
+		status                          int
+		code                            string
+	}{
+		{"GET", "/api/v1/nowhere", "", "", http.StatusNotFound, "not_found"},
+		{"DELETE", "/api/v1/devices", "", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"GET", "/api/v1/devices/abc", "", "", http.StatusNotFound, "device_not_connected"},
+		{"POST", "/api/v1/devices/abc/playback", "application/json", `{"action":"dance"}`, http.StatusBadRequest, "invalid_request"},
+		{"POST", "/api/v1/devices/abc/playback", "application/json", `{"action":"pause","speed":2}`, http.StatusBadRequest, "invalid_request"},
+		{"PUT", "/api/v1/devices/abc/volume", "application/json", `{"level":2}`, http.StatusBadRequest, "invalid_request"},
+		{"PUT", "/api/v1/devices/abc/volume", "text/plain", `{"level":1}`, http.StatusUnsupportedMediaType, "unsupported_content_type"},
+		{"POST", "/api/v1/devices/abc/media", "application/json", ``, http.StatusBadRequest, "invalid_request"},
+		{"PUT", "/api/v1/devices/abc/connection", "application/json", `{"addr":"10.0.0.2"}`, http.StatusBadRequest, "invalid_request"},
+		{"DELETE", "/api/v1/devices/abc/connection", "", "", http.StatusNotFound, "device_not_connected"},
+		{"POST", "/api/v1/groups/attic/playback", "application/json", `{"action":"pause"}`, http.StatusNotFound, "not_found"},
+		{"GET", "/api/v1/schedules/abc", "", "", http.StatusNotFound, "not_found"},
+		{"DELETE", "/api/v1/transcodes/abc", "", "", http.StatusBadRequest, "invalid_request"},
+	}
+	for _, test := range tests {
+		resp := do(test.method, test.path, test.contentType, test.body)
+		var e chttp.ErrorResponse
+		err := json.NewDecoder(resp.Body).Decode(&e)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != test.status || e.Error.Code != test.code || e.Error.Message == "" {
+			t.Errorf("%s %s failed with issue: status %d, error %+v, expected %d %s", test.method, test.path, resp.StatusCode, e.Error, test.status, test.code)
+		}
+	}
+	if resp := do("DELETE", "/api/v1/devices", "", ""); resp.Header.Get("Allow") != "GET" {
+		t.Errorf("DELETE /api/v1/devices failed with issue: Allow %q", resp.Header.Get("Allow"))
+	}
+
+	// Test against managing groups and schedules. Passes if each request
+	// gets the status of its resource.
+	log.Println("* Test for resource responses")
+	if resp := do("PUT", "/api/v1/groups/downstairs", "application/json", `{"members":["Kitchen"]}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("PUT /api/v1/groups/downstairs failed with issue: status %d", resp.StatusCode)
+	}
+	if resp := do("DELETE", "/api/v1/groups/downstairs", "", ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE /api/v1/groups/downstairs failed with issue: status %d", resp.StatusCode)
+	}
+	resp := do("POST", "/api/v1/schedules", "application/json",
+		`{"name":"night","cron":"0 23 * * *","enabled":true,"actions":[{"type":"stop","device":"*"}]}`)
+	var job struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&job)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || job.ID == "" {
+		t.Errorf("POST /api/v1/schedules failed with issue: status %d, id %q", resp.StatusCode, job.ID)
+	}
+	if resp := do("GET", "/api/v1/schedules/"+job.ID+"/history", "", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /api/v1/schedules/{id}/history failed with issue: status %d", resp.StatusCode)
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
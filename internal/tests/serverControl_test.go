@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	srv "github.com/avinash240/pusher/internal/server"
+	"github.com/avinash240/pusher/internal/server/media"
+)
+
+func TestControl(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	// Media is served from a server of its own, leaving the default one
+	// for the local server.
+	h := srv.NewHandler(false, srv.WithMediaServer(media.NewServer(0)))
+	defer h.Shutdown(context.Background(), false)
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	// Test against control requests that can't be run. Passes if they are
+	// rejected without a device being contacted.
+	log.Println("* Test for invalid control requests")
+	for _, path := range []string{
+		"/status?uuid=unknown",
+		"/pause",
+		"/next?uuid=unknown",
+		"/previous?uuid=unknown",
+		"/skip?uuid=unknown",
+		"/mute?uuid=unknown",
+		"/seek?uuid=unknown&seconds=ten",
+		"/rewind?uuid=unknown",
+		"/seek-to?uuid=unknown&seconds=-5",
+		"/volume?uuid=unknown&volume=1.5",
+	} {
+		resp, err := http.Post(s.URL+path, "", nil)
+		if err != nil {
+			t.Errorf("POST %s failed with issue:\n%+v", path, err)
+			continue
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("POST %s failed with issue: status %d, expected %d", path, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	// Test against requests with methods routes aren't served for. Passes
+	// if they are refused with the methods that are allowed.
+	log.Println("* Test for methods that aren't allowed")
+	for _, tc := range []struct {
+		method, path, allow string
+	}{
+		{http.MethodGet, "/pause?uuid=unknown", "POST"},
+		{http.MethodGet, "/load?uuid=unknown&path=/etc/passwd", "POST"},
+		{http.MethodDelete, "/volume?uuid=unknown", "GET, POST"},
+		{http.MethodPost, "/devices", "GET"},
+	} {
+		req, _ := http.NewRequest(tc.method, s.URL+tc.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("%s %s failed with issue:\n%+v", tc.method, tc.path, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != tc.allow {
+			t.Errorf("%s %s failed with issue: status %d, allowed %q", tc.method, tc.path, resp.StatusCode, resp.Header.Get("Allow"))
+		}
+	}
+
+	// Test against disconnecting with nothing connected. Passes if an empty
+	// list is returned.
+	log.Println("* Test for disconnecting every device")
+	resp, err := http.Post(s.URL+"/disconnect-all", "", nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("POST /disconnect-all failed with issue: %v %v", resp, err)
+	} else if resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("POST /disconnect-all failed with issue: content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	// Test against starting a slideshow. Passes if it is accepted once the
+	// images are queued on the device, and images that can't be shown are
+	// reported.
+	log.Println("* Test for starting slideshows")
+	device := newFakeChromecast(t)
+	defer device.Close()
+	addr, port := device.Addr()
+	resp, err = http.Post(fmt.Sprintf("%s/connect?uuid=fake&addr=%s&port=%d", s.URL, addr, port), "", nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("POST /connect failed with issue: %v %v", resp, err)
+		t.FailNow()
+	}
+	dir, err := ioutil.TempDir("", "pusher-slideshow")
+	if err != nil {
+		t.Errorf("TempDir() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	picture := filepath.Join(dir, "picture.png")
+	f, _ := os.Create(picture)
+	png.Encode(f, image.NewNRGBA(image.Rect(0, 0, 40, 20)))
+	f.Close()
+	for _, tc := range []struct {
+		path   string
+		status int
+		queued int
+	}{
+		{"./test_data/a_ascii.txt", http.StatusInternalServerError, 0},
+		{picture, http.StatusAccepted, 1},
+	} {
+		resp, err := http.Post(s.URL+"/slideshow?uuid=fake&duration=60&path="+url.QueryEscape(tc.path), "", nil)
+		if err != nil || resp.StatusCode != tc.status {
+			t.Errorf("POST /slideshow failed with issue: %s got %v %v", tc.path, resp, err)
+		}
+		for i := 0; i < 50 && device.Received("QUEUE_LOAD") < tc.queued; i++ {
+			time.Sleep(20 * time.Millisecond)
+		}
+		if queued := device.Received("QUEUE_LOAD"); queued != tc.queued {
+			t.Errorf("POST /slideshow failed with issue: %s queued %d times", tc.path, queued)
+		}
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	srv "github.com/avinash240/pusher/internal/server"
+	"github.com/avinash240/pusher/internal/server/media"
+)
+
+func TestEvents(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	h := srv.NewHandler(false,
+		srv.WithMediaServer(media.NewServer(0)),
+		srv.WithEventHeartbeat(100*time.Millisecond),
+	)
+	s := httptest.NewServer(h)
+	defer s.Close()
+	device := newFakeChromecast(t)
+	defer device.Close()
+	addr, port := device.Addr()
+
+	// follow streams events, resuming after lastID if it is set, and
+	// returns the events and heartbeats sent until it is cancelled.
+	follow := func(lastID string) (<-chan sse, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/events?uuid=fake", nil)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("GET /events failed with issue:\n%+v", err)
+			t.FailNow()
+		}
+		events := make(chan sse, 64)
+		go readEvents(resp, events)
+		return events, cancel
+	}
+	post := func(path string) {
+		resp, err := http.Post(s.URL+path, "", nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Errorf("POST %s failed with issue: %v %v", path, resp, err)
+			t.FailNow()
+		}
+		resp.Body.Close()
+	}
+
+	// Test against the changes made to a device. Passes if only the fields
+	// that changed are sent, and nothing is sent when nothing changed.
+	log.Println("* Test for sending changes")
+	events, cancel := follow("")
+	post(fmt.Sprintf("/connect?uuid=fake&addr=%s&port=%d", addr, port))
+	connected := nextEvent(events, "connected")
+	post("/volume?uuid=fake&volume=0.3")
+	post("/volume?uuid=fake&volume=0.3")
+	post("/mute?uuid=fake")
+	first, second := nextEvent(events, "volume"), nextEvent(events, "volume")
+	if connected.id == "" || len(first.status) != 1 || first.status["volume_level"] == nil {
+		t.Errorf("GET /events failed with issue: sent %+v once connected, then %+v", connected, first)
+	}
+	if len(second.status) != 1 || second.status["volume_muted"] != true {
+		t.Errorf("GET /events failed with issue: sent %+v once muted, expected only volume_muted", second)
+	}
+
+	// Test against idle streams. Passes if they are sent heartbeats.
+	log.Println("* Test for heartbeats")
+	if ev := nextEvent(events, "heartbeat"); ev.typ != "heartbeat" {
+		t.Errorf("GET /events failed with issue: no heartbeat sent")
+	}
+	cancel()
+
+	// Test against resuming from the last event seen. Passes if only the
+	// events after it are sent, rather than the status of the device.
+	log.Println("* Test for resuming from the last event")
+	events, cancel = follow(first.id)
+	if ev := nextEvent(events, ""); ev.id != second.id || ev.typ != "volume" {
+		t.Errorf("GET /events failed with issue: resumed with %+v, expected event %s", ev, second.id)
+	}
+	cancel()
+	events, cancel = follow("1000")
+	if ev := nextEvent(events, ""); ev.id != "" || ev.typ != "status" || ev.status["volume_muted"] != true {
+		t.Errorf("GET /events failed with issue: started over with %+v, expected the status", ev)
+	}
+	cancel()
+
+	// Test against following every device. Passes if the stream opens as
+	// server-sent events.
+	log.Println("* Test for streaming events")
+	req, _ := http.NewRequest(http.MethodGet, s.URL+"/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("GET /events failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Errorf("GET /events failed with issue: status %d, content type %q", resp.StatusCode, ct)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "retry: ") {
+		t.Errorf("GET /events failed with issue: first line %q, %v", line, err)
+	}
+
+	// Test against shutting down. Passes if the stream is ended rather
+	// than holding up the shut down.
+	log.Println("* Test for ending streams on shut down")
+	ended := make(chan struct{})
+	go func() {
+		ioutil.ReadAll(resp.Body)
+		close(ended)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h.Shutdown(ctx, false)
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Shutdown() failed with issue: event stream wasn't ended")
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
+
+// sse is a server-sent event, or a heartbeat.
+type sse struct {
+	id, typ string
+	status  map[string]interface{}
+}
+
+// readEvents sends the events of the stream resp to events until it ends.
+func readEvents(resp *http.Response, events chan<- sse) {
+	defer resp.Body.Close()
+	defer close(events)
+	r := bufio.NewReader(resp.Body)
+	var ev sse
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, ": heartbeat"):
+			ev.typ = "heartbeat"
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.typ = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var data struct {
+				Status map[string]interface{} `json:"status"`
+			}
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &data)
+			ev.status = data.Status
+		case line == "" && ev.typ != "":
+			events <- ev
+			ev = sse{}
+		}
+	}
+}
+
+// nextEvent returns the next event of type typ, or the next event of any
+// type but a heartbeat if typ is empty. It returns an empty event if none
+// is sent in time.
+func nextEvent(events <-chan sse, typ string) sse {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return sse{}
+			}
+			if ev.typ == typ || (typ == "" && ev.typ != "heartbeat") {
+				return ev
+			}
+		case <-timeout:
+			return sse{}
+		}
+	}
+}
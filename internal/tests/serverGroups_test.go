@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	srv "github.com/avinash240/pusher/internal/server"
+	"github.com/avinash240/pusher/internal/server/media"
+)
+
+func TestGroups(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	h := srv.NewHandler(false, srv.WithGroups(map[string][]string{
+		"downstairs": {"Kitchen speaker", "Living Room TV"},
+	}))
+	defer h.Shutdown(context.Background(), false)
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	type group struct {
+		Name    string   `json:"name"`
+		Members []string `json:"members"`
+	}
+	listGroups := func() []group {
+		resp, err := http.Get(s.URL + "/groups")
+		if err != nil {
+			t.Errorf("GET /groups failed with issue:\n%+v", err)
+			t.FailNow()
+		}
+		defer resp.Body.Close()
+		var groups []group
+		if err := json.NewDecoder(resp.Body).Decode(&groups); err != nil {
+			t.Errorf("GET /groups failed with issue:\n%+v", err)
+			t.FailNow()
+		}
+		return groups
+	}
+
+	// Test against groups from config and the API. Passes if both are
+	// listed with their members.
+	log.Println("* Test for listing groups")
+	resp, err := http.Post(s.URL+"/groups/set?name=upstairs&member=Bedroom&member=Office", "", nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("POST /groups/set failed with issue: %v %v", resp, err)
+	}
+	groups := listGroups()
+	if len(groups) != 2 || groups[0].Name != "downstairs" || len(groups[1].Members) != 2 {
+		t.Errorf("GET /groups failed with issue: listed %+v", groups)
+	}
+
+	// Test against invalid requests. Passes if they are rejected.
+	log.Println("* Test for invalid group requests")
+	for _, path := range []string{
+		"/groups/set?name=nested&member=downstairs",
+		"/groups/set?name=empty",
+		"/groups/delete?name=attic",
+		"/pause?group=attic",
+		"/volume?group=downstairs&volume=2",
+	} {
+		resp, err := http.Post(s.URL+path, "", nil)
+		if err != nil {
+			t.Errorf("POST %s failed with issue:\n%+v", path, err)
+			continue
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("POST %s failed with issue: status %d, expected %d", path, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	resp, err = http.Post(s.URL+"/groups/delete?name=upstairs", "", nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("POST /groups/delete failed with issue: %v %v", resp, err)
+	}
+	if groups := listGroups(); len(groups) != 1 {
+		t.Errorf("POST /groups/delete failed with issue: listed %+v", groups)
+	}
+
+	// Test against invalid groups from config. Passes if groups without a
+	// name, or named the same as one already added, are skipped.
+	log.Println("* Test for invalid configured groups")
+	configured := srv.NewHandler(false,
+		srv.WithMediaServer(media.NewServer(0)),
+		srv.WithGroups(map[string][]string{"": {"Kitchen"}, " ": {"Bedroom"}, "attic": {"Attic"}}),
+		srv.WithGroups(map[string][]string{"attic": {"Office"}, "*": {"Hall"}, "nested": {"attic"}}),
+	)
+	defer configured.Shutdown(context.Background(), false)
+	rec := httptest.NewRecorder()
+	configured.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/groups", nil))
+	groups = nil
+	if err := json.NewDecoder(rec.Body).Decode(&groups); err != nil {
+		t.Errorf("GET /groups failed with issue:\n%+v", err)
+	}
+	if len(groups) != 1 || groups[0].Name != "attic" || groups[0].Members[0] != "Attic" {
+		t.Errorf("WithGroups() failed with issue: listed %+v", groups)
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
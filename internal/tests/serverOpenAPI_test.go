@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	srv "github.com/avinash240/pusher/internal/server"
+)
+
+func TestOpenAPI(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	h := srv.NewHandler(false)
+	defer h.Shutdown(context.Background(), false)
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	type document struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas   map[string]json.RawMessage `json:"schemas"`
+			Responses map[string]json.RawMessage `json:"responses"`
+		} `json:"components"`
+	}
+	getDocument := func(path string) (document, []byte) {
+		var doc document
+		resp, err := http.Get(s.URL + path)
+		if err != nil {
+			t.Errorf("GET %s failed with issue:\n%+v", path, err)
+			t.FailNow()
+		}
+		defer resp.Body.Close()
+		var raw json.RawMessage
+		if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+			t.Errorf("GET %s failed with issue:\n%+v", path, err)
+			t.FailNow()
+		}
+		json.Unmarshal(raw, &doc)
+		return doc, raw
+	}
+
+	// Test against every route served. Passes if each is described by the
+	// documents, with its method when it has one.
+	log.Println("* Test for routes missing from the documents")
+	documented := func(doc document, name string, routes []srv.Route) {
+		for _, rt := range routes {
+			ops, ok := doc.Paths[rt.Path]
+			if !ok {
+				t.Errorf("%s failed with issue: route %s is missing", name, rt.Path)
+				continue
+			}
+			if _, ok := ops[strings.ToLower(rt.Method)]; rt.Method != "" && !ok {
+				t.Errorf("%s failed with issue: route %s %s is missing", name, rt.Method, rt.Path)
+			}
+		}
+	}
+	api, apiRaw := getDocument("/openapi.json")
+	media, mediaRaw := getDocument("/openapi/media.json")
+	if !strings.HasPrefix(api.OpenAPI, "3.") || !strings.HasPrefix(media.OpenAPI, "3.") {
+		t.Errorf("GET /openapi.json failed with issue: openapi %q and %q", api.OpenAPI, media.OpenAPI)
+	}
+	documented(api, "/openapi.json", h.Routes())
+	documented(media, "/openapi/media.json", srv.LocalServerRoutes())
+
+	// Test against the references in the documents. Passes if each refers
+	// to a component of its document.
+	log.Println("* Test for references in the documents")
+	ref := regexp.MustCompile(`"\$ref":"#/components/(schemas|responses)/([^"]+)"`)
+	for _, d := range []struct {
+		doc document
+		raw []byte
+	}{{api, apiRaw}, {media, mediaRaw}} {
+		for _, m := range ref.FindAllSubmatch(d.raw, -1) {
+			components := d.doc.Components.Schemas
+			if string(m[1]) == "responses" {
+				components = d.doc.Components.Responses
+			}
+			if _, ok := components[string(m[2])]; !ok {
+				t.Errorf("GET /openapi.json failed with issue: %s doesn't refer to a component", m[0])
+			}
+		}
+	}
+
+	resp, err := http.Get(s.URL + "/docs")
+	if err != nil || resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("GET /docs failed with issue: %v %v", resp, err)
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	srv "github.com/avinash240/pusher/internal/server"
+)
+
+func TestShutdown(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	device := newFakeChromecast(t)
+	defer device.Close()
+	addr, port := device.Addr()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("Listen() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	serverAddr := l.Addr().String()
+	l.Close()
+	h := srv.NewHandler(false)
+	go h.Serve(serverAddr)
+	base := "http://" + serverAddr
+
+	connect := fmt.Sprintf("%s/connect?uuid=fake&addr=%s&port=%d", base, addr, port)
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = http.Post(connect, "", nil); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("POST /connect failed with issue: %v %v", resp, err)
+		t.FailNow()
+	}
+
+	// Test against shutting down while a request is waiting on the device.
+	// Passes if the device is disconnected, with its media stopped, long
+	// before the request gives up.
+	log.Println("* Test for shutting down with requests in flight")
+	go http.Post(base+"/announce?uuid=fake&path=http://127.0.0.1:1/clip.mp3", "", nil)
+	for i := 0; i < 100 && device.Received("LOAD") == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if device.Received("LOAD") == 0 {
+		t.Errorf("POST /announce failed with issue: announcement never loaded")
+		t.FailNow()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	started := time.Now()
+	if err := h.Shutdown(ctx, true); err == nil {
+		t.Errorf("Shutdown() failed with issue: request in flight wasn't reported")
+	}
+	closedAt := device.ClosedAt()
+	switch {
+	case closedAt.IsZero():
+		t.Errorf("Shutdown() failed with issue: device wasn't disconnected")
+	case closedAt.Sub(started) > time.Second:
+		t.Errorf("Shutdown() failed with issue: device disconnected after %v", closedAt.Sub(started))
+	}
+	if device.Received("CLOSE") == 0 {
+		t.Errorf("Shutdown() failed with issue: device media wasn't stopped")
+	}
+	if _, err := http.Get(base + "/devices"); err == nil {
+		t.Errorf("Shutdown() failed with issue: still serving")
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	srv "github.com/avinash240/pusher/internal/server"
+	chttp "github.com/avinash240/pusher/internal/server/chttp"
+)
+
+func TestControlSocket(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	h := srv.NewHandler(false, srv.WithAllowedOrigins("https://remote.example.com/"))
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/ws", "", s.URL)
+	if err != nil {
+		t.Errorf("Dial() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	defer ws.Close()
+
+	// Test against sockets opened by pages of other sites. Passes if only
+	// those from allowed origins are accepted.
+	log.Println("* Test for checking origins")
+	url := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws"
+	if other, err := websocket.Dial(url, "", "http://evil.example"); err == nil {
+		other.Close()
+		t.Errorf("Dial() failed with issue: socket opened from another site")
+	}
+	if other, err := websocket.Dial(url, "", "https://remote.example.com"); err != nil {
+		t.Errorf("Dial() failed with issue:\n%+v", err)
+	} else {
+		other.Close()
+	}
+
+	// Test against commands that can't be run. Passes if each result has
+	// the id of its command and the code of its error.
+	log.Println("* Test for results of commands")
+	for _, tc := range []struct {
+		cmd  string
+		id   string
+		code string
+	}{
+		{`{"id":"1","command":"status","uuid":"missing"}`, "1", "device_not_connected"},
+		{`{"id":"2","command":"playback","uuid":"missing","playback":{"action":"jump"}}`, "2", "invalid_request"},
+		{`{"id":"3","command":"volume","group":"missing","volume":{"level":0.5}}`, "3", "not_found"},
+		{`{"id":"4","command":"load"}`, "4", "invalid_request"},
+		{`{"command":"status","unknown":true}`, "", "invalid_request"},
+	} {
+		if err := websocket.Message.Send(ws, tc.cmd); err != nil {
+			t.Errorf("Send() failed with issue:\n%+v", err)
+			t.FailNow()
+		}
+		var msg chttp.SocketMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			t.Errorf("Receive() failed with issue:\n%+v", err)
+			t.FailNow()
+		}
+		if msg.Type != "result" || msg.ID != tc.id || msg.OK || msg.Error == nil || msg.Error.Code != tc.code {
+			t.Errorf("%s failed with issue: result %+v, expected error %s", tc.cmd, msg, tc.code)
+		}
+	}
+
+	// Test against shutting down. Passes if the socket is closed rather
+	// than holding up the shut down.
+	log.Println("* Test for closing sockets on shut down")
+	ended := make(chan struct{})
+	go func() {
+		var data []byte
+		for websocket.Message.Receive(ws, &data) == nil {
+		}
+		close(ended)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h.Shutdown(ctx, false)
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Shutdown() failed with issue: control socket wasn't closed")
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
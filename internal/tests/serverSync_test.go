@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	srv "github.com/avinash240/pusher/internal/server"
+	"github.com/avinash240/pusher/internal/server/application"
+	"github.com/avinash240/pusher/internal/server/media"
+)
+
+func TestSyncGroupRoutes(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	h := srv.NewHandler(false, srv.WithMediaServer(media.NewServer(0)))
+	s := httptest.NewServer(h)
+	defer s.Close()
+	post := func(path string) int {
+		resp, err := http.Post(s.URL+path, "", nil)
+		if err != nil {
+			t.Errorf("POST %s failed with issue:\n%+v", path, err)
+			t.FailNow()
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	groups := func() []application.SyncStatus {
+		resp, err := http.Get(s.URL + "/sync")
+		if err != nil {
+			t.Errorf("GET /sync failed with issue:\n%+v", err)
+			t.FailNow()
+		}
+		defer resp.Body.Close()
+		var statuses []application.SyncStatus
+		json.NewDecoder(resp.Body).Decode(&statuses)
+		return statuses
+	}
+	for _, uuid := range []string{"a", "b"} {
+		device := newFakeChromecast(t)
+		defer device.Close()
+		addr, port := device.Addr()
+		if status := post(fmt.Sprintf("/connect?uuid=%s&addr=%s&port=%d", uuid, addr, port)); status != http.StatusOK {
+			t.Errorf("POST /connect failed with issue: status %d", status)
+			t.FailNow()
+		}
+	}
+	play := "/sync/play?name=kitchen&path=http://127.0.0.1:1/song.mp3&content_type=audio/mpeg"
+
+	// Test against disconnecting a member of a sync group. Passes if the
+	// group is removed, and isn't played again until it is made again.
+	log.Println("* Test for disconnecting members")
+	if status := post(play + "&uuid=a&uuid=b"); status != http.StatusOK {
+		t.Errorf("POST /sync/play failed with issue: status %d", status)
+	}
+	if status := post("/disconnect?uuid=a"); status != http.StatusOK {
+		t.Errorf("POST /disconnect failed with issue: status %d", status)
+	}
+	if statuses := groups(); len(statuses) != 0 {
+		t.Errorf("GET /sync failed with issue: %+v listed once a member was disconnected", statuses)
+	}
+	for _, path := range []string{play, "/sync/pause?name=kitchen", "/sync/unpause?name=kitchen", "/sync/stop?name=kitchen"} {
+		if status := post(path); status != http.StatusBadRequest {
+			t.Errorf("POST %s failed with issue: status %d for a removed group", path, status)
+		}
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
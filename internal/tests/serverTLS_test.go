@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	srv "github.com/avinash240/pusher/internal/server"
+	"github.com/avinash240/pusher/internal/server/certs"
+)
+
+func TestTLS(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	dir, err := ioutil.TempDir("", "pusher-tls")
+	if err != nil {
+		t.Errorf("TempDir() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	// Test against a first and second run. Passes if a certificate is only
+	// generated the first time.
+	log.Println("* Test for generating self-signed certificates")
+	for i, expected := range []bool{true, false} {
+		generated, err := certs.EnsureSelfSigned(certFile, keyFile)
+		if err != nil || generated != expected {
+			t.Errorf("EnsureSelfSigned() failed with issue: run %d generated %v, %v", i+1, generated, err)
+		}
+	}
+	store, err := certs.Load(certFile, keyFile)
+	if err != nil {
+		t.Errorf("Load() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("Listen() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	addr := l.Addr().String()
+	l.Close()
+	h := srv.NewHandler(false, srv.WithTLSConfig(&tls.Config{GetCertificate: store.GetCertificate}))
+	go h.Serve(addr)
+	defer h.Shutdown(context.Background(), false)
+
+	fingerprint := func() string {
+		for i := 0; i < 50; i++ {
+			conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+			if err != nil {
+				time.Sleep(20 * time.Millisecond)
+				continue
+			}
+			defer conn.Close()
+			return certs.Fingerprint(conn.ConnectionState().PeerCertificates[0].Raw)
+		}
+		t.Errorf("Serve() failed with issue: unable to connect over TLS")
+		t.FailNow()
+		return ""
+	}
+
+	// Test against serving, then renewing, the certificate. Passes if the
+	// certificate served is the one loaded each time.
+	log.Println("* Test for serving and reloading certificates")
+	if served := fingerprint(); served != store.Fingerprint() {
+		t.Errorf("Serve() failed with issue: served %s, expected %s", served, store.Fingerprint())
+	}
+	old := store.Fingerprint()
+	os.Remove(certFile)
+	if _, err := certs.EnsureSelfSigned(certFile, keyFile); err != nil {
+		t.Errorf("EnsureSelfSigned() failed with issue:\n%+v", err)
+	}
+	if err := store.Reload(); err != nil {
+		t.Errorf("Reload() failed with issue:\n%+v", err)
+	}
+	if served := fingerprint(); served == old || served != store.Fingerprint() {
+		t.Errorf("Reload() failed with issue: served %s, expected %s", served, store.Fingerprint())
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	srv "github.com/avinash240/pusher/internal/server"
+	"github.com/avinash240/pusher/internal/server/auth"
+)
+
+func TestWebUI(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	a, err := auth.New([]auth.Token{{Name: "reader", Token: "reader-token-0123456789", Scopes: []auth.Scope{auth.Read}}})
+	if err != nil {
+		t.Errorf("New() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	h := srv.NewHandler(false, srv.WithAuth(a))
+	defer h.Shutdown(context.Background(), false)
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	// Test against the page and its assets, requested without a token.
+	// Passes if each is served, as the page asks for the token itself.
+	log.Println("* Test for serving the web remote")
+	for _, tc := range []struct {
+		path, contentType, contains string
+	}{
+		{"/ui/", "text/html", `<script src="app.js">`},
+		{"/ui/app.js", "javascript", "/api/v1"},
+		{"/ui/style.css", "text/css", "body"},
+	} {
+		resp, err := http.Get(s.URL + tc.path)
+		if err != nil {
+			t.Errorf("GET %s failed with issue:\n%+v", tc.path, err)
+			continue
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), tc.contentType) ||
+			!strings.Contains(string(body), tc.contains) {
+			t.Errorf("GET %s failed with issue: status %d, content type %q", tc.path, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+	}
+
+	// Test against anything but the assets. Passes if it isn't served.
+	log.Println("* Test for requests the web remote doesn't serve")
+	resp, err := http.Post(s.URL+"/ui/", "text/plain", nil)
+	if err != nil {
+		t.Errorf("POST /ui/ failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST /ui/ failed with issue: status %d", resp.StatusCode)
+	}
+	if resp, err := http.Get(s.URL + "/ui/missing.js"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /ui/missing.js failed with issue: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
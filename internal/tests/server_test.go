@@ -1,19 +1,27 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
 
 	srv "github.com/avinash240/pusher/internal/server"
+	chttp "github.com/avinash240/pusher/internal/server/chttp"
+	media "github.com/avinash240/pusher/internal/server/media"
 )
 
 func TestServer(t *testing.T) {
 	strRp := 100
 	log.Println(strings.Repeat("*", strRp))
-	go srv.NewLocalServer()
+	library := srv.NewLibrary()
+	go srv.NewLocalServer(srv.WithLocalServerLibrary(library))
 	log.Println("* Initializing server")
 
 	time.Sleep(1 * time.Second) // wait for web server to load
@@ -28,7 +36,7 @@ func TestServer(t *testing.T) {
 		t.Errorf("expected status 400 got %d", resp.StatusCode)
 		t.FailNow()
 	}
-	resp, err = http.Get("http://100.115.92.202:9002/load?target=./test_data/")
+	resp, err = http.Get("http://localhost:9002/load?target=./test_data/")
 	if err != nil {
 		t.Error(err)
 		t.FailNow()
@@ -40,8 +48,8 @@ func TestServer(t *testing.T) {
 	}
 
 	log.Println(strings.Repeat("*", strRp))
-	log.Println("* Requesting Media")
-	resp, err = http.Get("http://localhost:9002/?media_file=ascii")
+	log.Println("* Requesting Media by filename")
+	resp, err = http.Get("http://localhost:9002/?media_file=a_ascii.txt")
 	if err != nil {
 		t.Error(err)
 		t.FailNow()
@@ -50,23 +58,83 @@ func TestServer(t *testing.T) {
 		t.Errorf("expected status 400, got %d", resp.StatusCode)
 		t.FailNow()
 	} else {
-		log.Println("*  passed check on multiple file match for: ascii")
+		log.Println("*  passed check on rejecting filename for: a_ascii.txt")
 	}
 
 	log.Println(strings.Repeat("*", strRp))
-	log.Println("* Requesting Media")
-	resp, err = http.Get("http://localhost:9002/?media_file=a_ascii.txt")
+	log.Println("* Requesting Media by unknown token")
+	resp, err = http.Get("http://localhost:9002/m/unknown")
 	if err != nil {
 		t.Error(err)
 		t.FailNow()
 	}
-	s := resp.ContentLength
-	buf := make([]byte, s)
-	_, _ = resp.Body.Read(buf)
+	if resp.StatusCode != 404 {
+		t.Errorf("expected status 404, got %d", resp.StatusCode)
+		t.FailNow()
+	} else {
+		log.Println("*  passed check on rejecting unknown token")
+	}
+
+	log.Println(strings.Repeat("*", strRp))
+	log.Println("* Requesting Media by token")
+	resp, err = http.Get("http://localhost:9002/content?id=0")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	item := struct {
+		Filename   string `json:"filename"`
+		ContentURL string `json:"contentURL"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	contentURL, err := url.Parse(item.ContentURL)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected content to only be readable by pages of the media server")
+		t.FailNow()
+	}
+	if strings.Contains(contentURL.String(), "test_data") {
+		t.Errorf("expected opaque url, got %s", contentURL)
+		t.FailNow()
+	}
+	contentURL.Host = "localhost:9002"
+	resp, err = http.Get(contentURL.String())
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	buf, _ := ioutil.ReadAll(resp.Body)
 	if len(buf) < 1000 {
 		t.Errorf("expected 1000 bytes of data, got %d", len(buf))
 		t.FailNow()
 	}
 	log.Printf("*  got data %+s...", buf[:30])
+
+	log.Println(strings.Repeat("*", strRp))
+	log.Println("* Listing Media through the api")
+	h := srv.NewHandler(false, srv.WithMediaServer(media.NewServer(0)), srv.WithLibrary(library))
+	defer h.Shutdown(context.Background(), false)
+	s := httptest.NewServer(h)
+	defer s.Close()
+	resp, err = http.Get(s.URL + "/api/v1/library")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	var items []chttp.LibraryItem
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if len(items) == 0 || items[0].Filename != item.Filename {
+		t.Errorf("expected %s listed first, got %+v", item.Filename, items)
+		t.FailNow()
+	}
 	log.Println(strings.Repeat("*", strRp))
 }
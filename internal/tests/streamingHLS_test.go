@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avinash240/pusher/internal/streaming/hls"
+	"github.com/avinash240/pusher/internal/transcode"
+)
+
+func TestHLSSegmenter(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	cacheDir, err := ioutil.TempDir("", "pusher-hls-cache")
+	if err != nil {
+		t.Errorf("TempDir() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(cacheDir)
+	media := "./test_data/thank_you.wav"
+	jobs := transcode.NewManager(1)
+
+	// Test against adding the same file twice. Passes if both have the
+	// same id.
+	log.Println("* Test for stream ids")
+	s, err := hls.NewSegmenter(cacheDir, jobs, "hls-test", false)
+	if err != nil {
+		t.Errorf("NewSegmenter() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	id, err := s.Add(media)
+	if err != nil {
+		t.Errorf("Add() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	if again, _ := s.Add(media); again != id {
+		t.Errorf("Add() failed with issue: ids %s and %s for the same file", id, again)
+	}
+	if _, err := s.Add("./test_data/missing.wav"); err == nil {
+		t.Errorf("Add() failed with issue: missing file was added")
+	}
+
+	// Test against output completed by an earlier session. Passes if it is
+	// served without segmenting again, and only the playlist and segments
+	// are served.
+	log.Println("* Test for serving cached output")
+	dir := filepath.Join(cacheDir, id)
+	os.MkdirAll(dir, 0755)
+	playlist := "#EXTM3U\n#EXTINF:6.0,\nsegment00000.ts\n#EXT-X-ENDLIST\n"
+	ioutil.WriteFile(filepath.Join(dir, hls.PlaylistName), []byte(playlist), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "segment00000.ts"), []byte("segment"), 0644)
+	srv := httptest.NewServer(s.Handler(id))
+	defer srv.Close()
+	for _, tc := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/" + hls.PlaylistName, http.StatusOK, playlist},
+		{"/segment00000.ts", http.StatusOK, "segment"},
+		{"/segment99999.ts", http.StatusNotFound, ""},
+		{"/notes.txt", http.StatusNotFound, ""},
+	} {
+		resp, err := http.Get(srv.URL + tc.path)
+		if err != nil {
+			t.Errorf("GET %s failed with issue:\n%+v", tc.path, err)
+			continue
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status || (tc.body != "" && string(body) != tc.body) {
+			t.Errorf("GET %s failed with issue: status %d, body %q", tc.path, resp.StatusCode, body)
+		}
+	}
+	if running := jobs.Jobs(); len(running) != 0 {
+		t.Errorf("Handler() failed with issue: %d transcodes started for cached output", len(running))
+	}
+
+	// Test against segmenters of two sessions sharing the cache directory.
+	// Passes if output that isn't complete is only removed once neither
+	// uses it.
+	log.Println("* Test for sharing the cache directory")
+	other := "./test_data/a_ascii.txt"
+	first, _ := hls.NewSegmenter(cacheDir, jobs, "hls-first", false)
+	second, _ := hls.NewSegmenter(cacheDir, jobs, "hls-second", false)
+	otherID, err := first.Add(other)
+	if err != nil {
+		t.Errorf("Add() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	if again, _ := second.Add(other); again != otherID {
+		t.Errorf("Add() failed with issue: ids %s and %s for the same file", otherID, again)
+	}
+	partial := filepath.Join(cacheDir, otherID)
+	os.MkdirAll(partial, 0755)
+	ioutil.WriteFile(filepath.Join(partial, hls.PlaylistName), []byte("#EXTM3U\n#EXTINF:6.0,\nsegment00000.ts\n"), 0644)
+	first.Close()
+	if _, err := os.Stat(partial); err != nil {
+		t.Errorf("Close() failed with issue: output shared with another session removed: %v", err)
+	}
+	second.Close()
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("Close() failed with issue: incomplete output kept once unused: %v", err)
+	}
+
+	// Test against requesting a playlist while every transcode slot is
+	// taken. Passes if it is refused, and started by a later request once
+	// a slot is free.
+	log.Println("* Test for the transcode limit")
+	limited, _ := hls.NewSegmenter("", jobs, "hls-limited", false)
+	defer limited.Close()
+	limitedID, _ := limited.Add(other)
+	busy, err := jobs.Start(context.Background(), transcode.Spec{Session: "busy", Args: []string{"sleep", "30"}})
+	if err != nil {
+		t.Errorf("Start() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	limitedSrv := httptest.NewServer(limited.Handler(limitedID))
+	defer limitedSrv.Close()
+	playlistBody := func() (int, string) {
+		resp, err := http.Get(limitedSrv.URL + "/" + hls.PlaylistName)
+		if err != nil {
+			t.Errorf("GET %s failed with issue:\n%+v", hls.PlaylistName, err)
+			t.FailNow()
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if status, body := playlistBody(); status != http.StatusServiceUnavailable || !strings.Contains(body, transcode.ErrLimitReached.Error()) {
+		t.Errorf("Handler() failed with issue: status %d, body %q with no slot free", status, body)
+	}
+	busy.Stop()
+	<-busy.Done()
+	if _, body := playlistBody(); strings.Contains(body, transcode.ErrLimitReached.Error()) {
+		t.Errorf("Handler() failed with issue: not started once a slot was free: %q", body)
+	}
+
+	// Test against closing segmenters. Passes if complete cached output is
+	// kept and nothing more is added.
+	log.Println("* Test for closing segmenters")
+	if err := s.Close(); err != nil {
+		t.Errorf("Close() failed with issue:\n%+v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, hls.PlaylistName)); err != nil {
+		t.Errorf("Close() failed with issue: cached output removed: %v", err)
+	}
+	if _, err := s.Add(media); err != hls.ErrSegmenterClosed {
+		t.Errorf("Add() failed with issue: added after close: %v", err)
+	}
+	resp, err := http.Get(srv.URL + "/" + hls.PlaylistName)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Handler() failed with issue: status %d after close", resp.StatusCode)
+		}
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/tiff"
+
+	"github.com/avinash240/pusher/internal/streaming/imaging"
+)
+
+func TestImageProcessor(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	dir, err := ioutil.TempDir("", "pusher-images")
+	if err != nil {
+		t.Errorf("TempDir() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	p, err := imaging.NewProcessor(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Errorf("NewProcessor() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+
+	// Images are red on their left half and blue on their right, so
+	// rotations can be told apart.
+	halves := func(w, h int, alpha uint8) *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{R: 255, A: alpha}
+			if x >= w/2 {
+				c = color.NRGBA{B: 255, A: alpha}
+			}
+			for y := 0; y < h; y++ {
+				img.Set(x, y, c)
+			}
+		}
+		return img
+	}
+	write := func(name string, encode func(io.Writer) error) string {
+		filename := filepath.Join(dir, name)
+		var b bytes.Buffer
+		if err := encode(&b); err != nil {
+			t.Errorf("encoding %s failed with issue:\n%+v", name, err)
+			t.FailNow()
+		}
+		ioutil.WriteFile(filename, b.Bytes(), 0644)
+		return filename
+	}
+	decode := func(filename string) image.Image {
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Errorf("Open() failed with issue:\n%+v", err)
+			return nil
+		}
+		defer f.Close()
+		img, _, err := image.Decode(f)
+		if err != nil {
+			t.Errorf("Decode() failed with issue:\n%+v", err)
+			return nil
+		}
+		return img
+	}
+
+	// Test against images a device can display as they are. Passes if the
+	// original file is served.
+	log.Println("* Test for images needing no processing")
+	small := write("small.png", func(w io.Writer) error { return png.Encode(w, halves(40, 20, 255)) })
+	res, err := p.Process(small, 100, 100)
+	if err != nil || res.Filename != small || res.ContentType != "image/png" {
+		t.Errorf("Process() failed with issue: %+v, %v", res, err)
+	}
+
+	// Test against images larger than the display. Passes if they are
+	// scaled down to fit keeping their aspect ratio, as JPEGs unless they
+	// are transparent, and cached.
+	log.Println("* Test for resizing images")
+	for _, tc := range []struct {
+		name        string
+		alpha       uint8
+		contentType string
+	}{
+		{"large.png", 255, "image/jpeg"},
+		{"transparent.png", 128, "image/png"},
+	} {
+		filename := write(tc.name, func(w io.Writer) error { return png.Encode(w, halves(400, 200, tc.alpha)) })
+		res, err := p.Process(filename, 100, 100)
+		if err != nil || res.Filename == filename || res.ContentType != tc.contentType {
+			t.Errorf("Process() failed with issue: %s processed to %+v, %v", tc.name, res, err)
+			continue
+		}
+		if img := decode(res.Filename); img != nil && (img.Bounds().Dx() != 100 || img.Bounds().Dy() != 50) {
+			t.Errorf("Process() failed with issue: %s scaled to %v", tc.name, img.Bounds())
+		}
+		if again, err := p.Process(filename, 100, 100); err != nil || again != res {
+			t.Errorf("Process() failed with issue: %s processed again to %+v, %v", tc.name, again, err)
+		}
+	}
+
+	// Test against formats a device can't display. Passes if they are
+	// converted, and files that aren't images are reported.
+	log.Println("* Test for converting formats")
+	tif := write("small.tiff", func(w io.Writer) error { return tiff.Encode(w, halves(40, 20, 255), nil) })
+	res, err = p.Process(tif, 100, 100)
+	if err != nil || res.ContentType != "image/jpeg" || filepath.Ext(res.Filename) != ".jpg" {
+		t.Errorf("Process() failed with issue: tiff processed to %+v, %v", res, err)
+	} else if img := decode(res.Filename); img != nil && (img.Bounds().Dx() != 40 || img.Bounds().Dy() != 20) {
+		t.Errorf("Process() failed with issue: tiff converted to %v", img.Bounds())
+	}
+	if _, err := p.Process("./test_data/a_ascii.txt", 100, 100); err != image.ErrFormat {
+		t.Errorf("Process() failed with issue: text file processed: %v", err)
+	}
+
+	// Test against a JPEG whose exif data says it is rotated by 90 degrees
+	// clockwise. Passes if it is turned upright.
+	log.Println("* Test for exif orientation")
+	rotated := write("rotated.jpg", func(w io.Writer) error {
+		var b bytes.Buffer
+		if err := jpeg.Encode(&b, halves(40, 20, 255), &jpeg.Options{Quality: 100}); err != nil {
+			return err
+		}
+		// An APP1 segment holding a big endian TIFF structure with only an
+		// orientation of 6, inserted after the start of image marker.
+		exif := []byte("\xff\xe1\x00\x22Exif\x00\x00MM\x00*\x00\x00\x00\x08" +
+			"\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+		w.Write(b.Bytes()[:2])
+		w.Write(exif)
+		_, err := w.Write(b.Bytes()[2:])
+		return err
+	})
+	res, err = p.Process(rotated, 100, 100)
+	if err != nil || res.Filename == rotated {
+		t.Errorf("Process() failed with issue: rotated image processed to %+v, %v", res, err)
+	} else if img := decode(res.Filename); img != nil {
+		top, _, _, _ := img.At(10, 5).RGBA()
+		bottom, _, _, _ := img.At(10, 35).RGBA()
+		if img.Bounds().Dx() != 20 || img.Bounds().Dy() != 40 || top < bottom {
+			t.Errorf("Process() failed with issue: rotated to %v, red %d at the top and %d at the bottom", img.Bounds(), top, bottom)
+		}
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avinash240/pusher/internal/streaming/live"
+)
+
+func TestLiveCommand(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against quoted commands. Passes if quoting and escapes are kept
+	// together, and leading assignments are returned as the environment.
+	command := `LANG=C ffmpeg -i 'my file.mp3' -metadata "title=\"Live\" set" a\ b -f mp3 pipe:1`
+	log.Printf("* Test for command: %s", command)
+	args, env, err := live.ParseCommand(command)
+	if err != nil {
+		t.Errorf("ParseCommand() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	wantArgs := []string{"ffmpeg", "-i", "my file.mp3", "-metadata", `title="Live" set`, "a b", "-f", "mp3", "pipe:1"}
+	if !reflect.DeepEqual(args, wantArgs) || !reflect.DeepEqual(env, []string{"LANG=C"}) {
+		t.Errorf("ParseCommand() failed with issue: got args %q env %q", args, env)
+	}
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against malformed commands. Passes if they are rejected.
+	for _, command := range []string{`ffmpeg -i 'unterminated`, `ffmpeg \`, `A=1 B=2`} {
+		log.Printf("* Test for command: %s", command)
+		if _, _, err := live.ParseCommand(command); err == nil {
+			t.Errorf("ParseCommand() failed with issue: expected an error for %q", command)
+		}
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
+
+func TestLiveReaderStream(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against an in process reader. Passes if a listener receives the
+	// stream with its content type, and the stream ends with the reader.
+	log.Println("* Test for reader stream")
+	content := strings.Repeat("live audio ", 1000)
+	pr, pw := io.Pipe()
+	stream := live.NewStream("reader", "audio/mpeg", live.Reader("reader", pr))
+	defer stream.Close()
+	go func() {
+		// Only produce once someone is listening, so nothing is missed.
+		for stream.Listeners() == 0 {
+			time.Sleep(time.Millisecond * 10)
+		}
+		io.WriteString(pw, content)
+		pw.Close()
+	}()
+
+	server := httptest.NewServer(stream)
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Errorf("Get() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "audio/mpeg" {
+		t.Errorf("ServeHTTP() failed with issue: content type %q", ct)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("ReadAll() failed with issue:\n%+v", err)
+	}
+	if string(b) != content {
+		t.Errorf("ServeHTTP() failed with issue: unexpected body of %d bytes", len(b))
+	}
+	log.Printf("*\t received %d of %d bytes", len(b), len(content))
+	log.Println(strings.Repeat("*", strRp))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ls "github.com/avinash240/pusher/internal/streaming"
+	"github.com/avinash240/pusher/internal/streaming/playlist"
+)
+
+func TestPlaylist(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	wav, _ := filepath.Abs("./test_data/thank_you.wav")
+	remote := "http://example.com/live.mp3"
+
+	// Test every format. Passes if local entries resolve against the
+	// playlist, remote entries are kept and metadata is read.
+	for _, path := range []string{
+		"./test_data/playlists/mixed.m3u",
+		"./test_data/playlists/mixed.pls",
+		"./test_data/playlists/mixed.xspf",
+	} {
+		log.Printf("* Test for playlist: %s", path)
+		entries, err := playlist.Load(path)
+		if err != nil {
+			t.Errorf("Load() failed with issue:\n%+v", err)
+			t.FailNow()
+		}
+		if len(entries) < 2 {
+			t.Errorf("Load() failed with issue: expected at least 2 entries, got %d", len(entries))
+			t.FailNow()
+		}
+		if entries[0].Location != wav || entries[0].Title != "Thank You" ||
+			entries[0].Duration != time.Second*3 || entries[0].Remote() {
+			t.Errorf("Load() failed with issue: unexpected first entry %+v", entries[0])
+		}
+		if entries[1].Location != remote || entries[1].Title != "Live Radio" ||
+			entries[1].Duration != 0 || !entries[1].Remote() {
+			t.Errorf("Load() failed with issue: unexpected second entry %+v", entries[1])
+		}
+		log.Printf("*\t got entries: %+v", entries)
+	}
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against an HLS playlist. Passes if it isn't expanded.
+	path := "./test_data/playlists/stream.m3u8"
+	log.Printf("* Test for HLS playlist: %s", path)
+	if _, err := playlist.Load(path); err != playlist.ErrHLSPlaylist {
+		t.Errorf("Load() failed with issue: expected %v, got %v", playlist.ErrHLSPlaylist, err)
+	}
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against a playlist as a local stream. Passes if the stream lists
+	// the playlist entries.
+	path = "./test_data/playlists/mixed.m3u"
+	localStream, err := ls.NewLocalStream(path)
+	log.Printf("* Test for local stream of playlist: %s", path)
+	if err != nil {
+		t.Errorf("NewLocalStream() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	if len(localStream.FilePaths) != 3 || localStream.FilePaths[0] != wav ||
+		localStream.FilePaths[1] != remote {
+		t.Errorf("NewLocalStream() failed with issue: unexpected files %v", localStream.FilePaths)
+	}
+	log.Printf("*\t got files: %v", localStream.FilePaths)
+	log.Println(strings.Repeat("*", strRp))
+}
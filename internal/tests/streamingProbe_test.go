@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"testing"
+
+	dev "github.com/avinash240/pusher/internal/server/device"
+	"github.com/avinash240/pusher/internal/streaming/probe"
+)
+
+func TestProbe(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against a local wav file. Passes if it is probed without ffprobe
+	// and can be played directly.
+	path := "./test_data/thank_you.wav"
+	log.Printf("* Test for file path: %s", path)
+	info, err := probe.NewProber().Probe(context.Background(), path)
+	if err != nil {
+		t.Errorf("Probe() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	audio := info.Audio()
+	if audio == nil || audio.Codec != "pcm_s16le" || audio.Channels != 2 || info.Duration <= 0 {
+		t.Errorf("Probe() failed with issue: unexpected info %+v", info)
+	}
+	if d := dev.Unknown.Decide(info); d.Mode != dev.DirectPlay || d.ContentType != "audio/wav" {
+		t.Errorf("Decide() failed with issue: expected direct play, got %+v", d)
+	}
+	log.Printf("*\t got info: %+v", info)
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against matroska files. Passes if playable codecs are only
+	// remuxed, and anything else is transcoded.
+	log.Println("* Test for h264/aac matroska")
+	mkv := &probe.Info{
+		Container: "matroska,webm",
+		Streams: []probe.Stream{
+			{Type: "video", Codec: "h264", Width: 1920, Height: 1080},
+			{Type: "audio", Codec: "aac", Channels: 2},
+		},
+	}
+	if d := dev.Unknown.Decide(mkv); d.Mode != dev.Remux || !d.CopyVideo || !d.CopyAudio {
+		t.Errorf("Decide() failed with issue: expected remux, got %+v", d)
+	}
+	log.Println("* Test for hevc/dts matroska")
+	mkv.Streams = []probe.Stream{
+		{Type: "video", Codec: "hevc", Width: 1920, Height: 1080},
+		{Type: "audio", Codec: "dts", Channels: 6},
+	}
+	if d := dev.Unknown.Decide(mkv); d.Mode != dev.Transcode || d.CopyVideo || d.CopyAudio {
+		t.Errorf("Decide() failed with issue: expected transcode, got %+v", d)
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/avinash240/pusher/internal/streaming/radio"
+)
+
+func TestRadioMetadata(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against metadata blocks. Passes if titles containing quotes are
+	// kept whole, and Latin-1 titles are decoded.
+	blocks := map[string]radio.Metadata{
+		"StreamTitle='Guns N' Roses - Don't Cry';StreamUrl='http://a/b';\x00\x00": {
+			StreamTitle: "Guns N' Roses - Don't Cry",
+			StreamURL:   "http://a/b",
+		},
+		"StreamTitle='Bj\xf6rk - J\xf3ga';": {StreamTitle: "Björk - Jóga"},
+	}
+	for block, want := range blocks {
+		log.Printf("* Test for block: %q", block)
+		if got := radio.ParseMetadata(block); got != want {
+			t.Errorf("ParseMetadata() failed with issue: got %+v, expected %+v", got, want)
+		}
+	}
+	artist, title := radio.Metadata{StreamTitle: "Björk - Jóga"}.ArtistTitle()
+	if artist != "Björk" || title != "Jóga" {
+		t.Errorf("ArtistTitle() failed with issue: got %q, %q", artist, title)
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
+
+func TestRadioRelay(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	// Test against a SHOUTcast style station. Passes if the "ICY 200 OK"
+	// response is understood, the metadata is stripped from the audio, and
+	// the track playing is reported.
+	log.Println("* Test for icy station")
+	const metaint = 16
+	audio := bytes.Repeat([]byte("0123456789abcdef"), 4)
+	var stream bytes.Buffer
+	for i := 0; i < len(audio); i += metaint {
+		stream.Write(audio[i : i+metaint])
+		if i == 0 {
+			block := []byte("StreamTitle='Artist - Title';")
+			padded := make([]byte, (len(block)+15)/16*16)
+			copy(padded, block)
+			stream.WriteByte(byte(len(padded) / 16))
+			stream.Write(padded)
+		} else {
+			stream.WriteByte(0)
+		}
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("Listen() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || req.Header.Get("Icy-MetaData") != "1" {
+			return
+		}
+		fmt.Fprintf(conn, "ICY 200 OK\r\nicy-name: Test FM\r\nicy-metaint: %d\r\ncontent-type: audio/mpeg\r\n\r\n", metaint)
+		conn.Write(stream.Bytes())
+	}()
+
+	var titles []string
+	relay := radio.NewRelay("http://"+l.Addr().String()+"/stream", radio.WithMetadataFunc(func(m radio.Metadata) {
+		titles = append(titles, m.StreamTitle)
+	}))
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Errorf("Get() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("ReadAll() failed with issue:\n%+v", err)
+	}
+	if !bytes.Equal(b, audio) {
+		t.Errorf("ServeHTTP() failed with issue: got audio %q", b)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "audio/mpeg" {
+		t.Errorf("ServeHTTP() failed with issue: content type %q", ct)
+	}
+	if relay.Station() != "Test FM" || relay.Metadata().StreamTitle != "Artist - Title" || len(titles) != 1 {
+		t.Errorf("ServeHTTP() failed with issue: station %q, titles %q", relay.Station(), titles)
+	}
+	log.Printf("*\t now playing %q on %q", relay.Metadata().StreamTitle, relay.Station())
+	log.Println(strings.Repeat("*", strRp))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avinash240/pusher/internal/transcode"
+)
+
+func TestTranscodeManager(t *testing.T) {
+	strRp := 100
+	log.Println(strings.Repeat("*", strRp))
+
+	m := transcode.NewManager(1)
+	sleep := transcode.Spec{Session: "a", Filename: "sleep", Args: []string{"sleep", "30"}}
+
+	// Test against starting more jobs than the limit. Passes if the job
+	// over the limit isn't started.
+	log.Println("* Test for the concurrency limit")
+	ctx, cancel := context.WithCancel(context.Background())
+	job, err := m.Start(ctx, sleep)
+	if err != nil {
+		t.Errorf("Start() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	if _, err := m.Start(context.Background(), sleep); err != transcode.ErrLimitReached {
+		t.Errorf("Start() failed with issue: started over the limit: %v", err)
+	}
+	if jobs := m.Jobs(); len(jobs) != 1 || jobs[0].ID != job.ID() || jobs[0].PID == 0 {
+		t.Errorf("Jobs() failed with issue: listed %+v", jobs)
+	}
+
+	// Test against cancelling the context a job was started with. Passes if
+	// it is killed, reaped and its slot freed.
+	log.Println("* Test for cancelling and reaping jobs")
+	cancel()
+	select {
+	case <-job.Done():
+	case <-time.After(5 * time.Second):
+		t.Errorf("Start() failed with issue: job outlived its context")
+		t.FailNow()
+	}
+	if job.Wait() == nil {
+		t.Errorf("Wait() failed with issue: killed job didn't fail")
+	}
+	if jobs := m.Jobs(); len(jobs) != 0 {
+		t.Errorf("Jobs() failed with issue: reaped job listed %+v", jobs)
+	}
+	if err := m.Run(context.Background(), transcode.Spec{Session: "a", Args: []string{"true"}}); err != nil {
+		t.Errorf("Run() failed with issue:\n%+v", err)
+	}
+
+	// Test against stopping jobs by id and by session. Passes if each is
+	// killed, and unknown jobs are reported.
+	log.Println("* Test for stopping jobs")
+	job, err = m.Start(context.Background(), sleep)
+	if err != nil {
+		t.Errorf("Start() failed with issue:\n%+v", err)
+		t.FailNow()
+	}
+	if err := m.Stop(job.ID()); err != nil {
+		t.Errorf("Stop() failed with issue:\n%+v", err)
+	}
+	<-job.Done()
+	if err := m.Stop(job.ID()); err != transcode.ErrUnknownJob {
+		t.Errorf("Stop() failed with issue: stopped a reaped job: %v", err)
+	}
+	job, _ = m.Start(context.Background(), sleep)
+	m.StopSession("a")
+	select {
+	case <-job.Done():
+	default:
+		t.Errorf("StopSession() failed with issue: returned before the job exited")
+	}
+
+	// Test against shutting down. Passes if Wait returns once every job
+	// has exited, and no job is started afterwards.
+	log.Println("* Test for shutting down")
+	if _, err := m.Start(context.Background(), sleep); err != nil {
+		t.Errorf("Start() failed with issue:\n%+v", err)
+	}
+	m.StopAll()
+	wait, cancelWait := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelWait()
+	if err := m.Wait(wait); err != nil {
+		t.Errorf("Wait() failed with issue:\n%+v", err)
+	}
+	if _, err := m.Start(context.Background(), sleep); err != transcode.ErrShutdown {
+		t.Errorf("Start() failed with issue: started after Wait: %v", err)
+	}
+	log.Println(strings.Repeat("*", strRp))
+}
@@ -0,0 +1,361 @@
+package transcode
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// stderrLines is the number of trailing stderr lines kept for each job.
+const stderrLines = 20
+
+var (
+	ErrLimitReached = errors.New("maximum number of concurrent transcodes reached")
+	ErrUnknownJob   = errors.New("unknown transcode job")
+	ErrShutdown     = errors.New("transcode manager is shutting down")
+)
+
+// Manager runs transcoder processes. Every process is tied to the context
+// it was started with and to a session, so it is killed and reaped when
+// either goes away, and the number of processes running at once can be
+// limited.
+type Manager struct {
+	mu     sync.Mutex
+	jobs   map[int]*Job
+	nextID int
+	limit  int
+
+	// Jobs are added to 'wg' holding 'mu', and none are started once
+	// 'closing' is set by Wait.
+	wg      sync.WaitGroup
+	closing bool
+}
+
+// Spec describes a transcoder process to run.
+type Spec struct {
+	// Session the process belongs to, usually the device uuid.
+	Session string
+	// Filename being transcoded, used for logging and reporting.
+	Filename string
+	// Args is the command and its arguments.
+	Args []string
+	// Env is appended to the environment of the current process.
+	Env []string
+	// Stdout receives the transcoded output.
+	Stdout io.Writer
+	// Verbose logs stderr at info rather than debug level.
+	Verbose bool
+}
+
+// Job is a running transcoder process.
+type Job struct {
+	id      int
+	spec    Spec
+	started time.Time
+	cmd     *exec.Cmd
+	cancel  context.CancelFunc
+	done    chan struct{}
+	err     error
+
+	// Closed once stderr has been read to EOF.
+	stderrDone chan struct{}
+
+	mu     sync.Mutex
+	stderr []string
+}
+
+// JobInfo is a point in time description of a job.
+type JobInfo struct {
+	ID       int       `json:"id"`
+	Session  string    `json:"session"`
+	Filename string    `json:"filename"`
+	Command  []string  `json:"command"`
+	PID      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+	Stderr   []string  `json:"stderr"`
+}
+
+// NewManager returns a Manager that runs at most limit processes at once.
+// A limit of zero or less means no limit.
+func NewManager(limit int) *Manager {
+	return &Manager{
+		jobs:  map[int]*Job{},
+		limit: limit,
+	}
+}
+
+// Start starts the process described by spec. The process is killed when
+// ctx is done, or when the job or its session is stopped. ErrLimitReached
+// is returned if the manager is already running its limit of processes,
+// and ErrShutdown once Wait has been called.
+func (m *Manager) Start(ctx context.Context, spec Spec) (*Job, error) {
+	if len(spec.Args) == 0 {
+		return nil, errors.New("no transcode command specified")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closing {
+		return nil, ErrShutdown
+	}
+	if m.limit > 0 && len(m.jobs) >= m.limit {
+		return nil, ErrLimitReached
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := exec.Command(spec.Args[0], spec.Args[1:]...)
+	setProcessGroup(cmd)
+	cmd.Stdout = spec.Stdout
+	if len(spec.Env) > 0 {
+		cmd.Env = append(os.Environ(), spec.Env...)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "unable to capture transcoder stderr")
+	}
+
+	m.nextID++
+	j := &Job{
+		id:      m.nextID,
+		spec:    spec,
+		started: time.Now(),
+		cmd:     cmd,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+
+		stderrDone: make(chan struct{}),
+	}
+	m.wg.Add(1)
+	if err := cmd.Start(); err != nil {
+		m.wg.Done()
+		cancel()
+		return nil, errors.Wrapf(err, "unable to start %q", spec.Args[0])
+	}
+	m.jobs[j.id] = j
+
+	j.logger().Info("transcoder started")
+	go j.captureStderr(stderr)
+	go j.killOnDone(ctx)
+	go m.reap(j)
+	return j, nil
+}
+
+// Run starts the process described by spec and waits for it to exit.
+func (m *Manager) Run(ctx context.Context, spec Spec) error {
+	j, err := m.Start(ctx, spec)
+	if err != nil {
+		return err
+	}
+	return j.Wait()
+}
+
+// Stop kills the job with the given id.
+func (m *Manager) Stop(id int) error {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return ErrUnknownJob
+	}
+	j.Stop()
+	return nil
+}
+
+// StopSession kills every job started for session and waits for them to
+// exit.
+func (m *Manager) StopSession(session string) {
+	m.mu.Lock()
+	var jobs []*Job
+	for _, j := range m.jobs {
+		if j.spec.Session == session {
+			jobs = append(jobs, j)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, j := range jobs {
+		j.Stop()
+		<-j.done
+	}
+}
+
+// StopAll kills every running job.
+func (m *Manager) StopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, j := range m.jobs {
+		j.Stop()
+	}
+}
+
+// Wait stops any more jobs from being started, and blocks until every job
+// has exited and been reaped, or ctx is done.
+func (m *Manager) Wait(ctx context.Context) error {
+	m.mu.Lock()
+	m.closing = true
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "transcoders still running")
+	}
+}
+
+// Jobs returns the running jobs ordered by id.
+func (m *Manager) Jobs() []JobInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]JobInfo, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j.Info())
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].ID < jobs[k].ID })
+	return jobs
+}
+
+func (m *Manager) reap(j *Job) {
+	defer m.wg.Done()
+
+	// All of stderr has to be read before waiting, as Wait closes the pipe.
+	<-j.stderrDone
+	j.err = j.cmd.Wait()
+
+	m.mu.Lock()
+	delete(m.jobs, j.id)
+	m.mu.Unlock()
+
+	logger := j.logger().WithField("duration", time.Since(j.started).Round(time.Millisecond))
+	switch {
+	case j.err == nil:
+		logger.Info("transcoder finished")
+	case j.cmd.ProcessState != nil && !j.cmd.ProcessState.Exited():
+		// Killed because the client went away or the session closed.
+		logger.Info("transcoder stopped")
+	default:
+		logger.WithError(j.err).WithField("stderr", strings.Join(j.lastStderr(), "\n")).Error("transcoder failed")
+	}
+	close(j.done)
+	j.cancel()
+}
+
+// killOnDone kills the process, and anything it started, once ctx is done.
+func (j *Job) killOnDone(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		if err := killProcess(j.cmd); err != nil {
+			j.logger().WithError(err).Debug("unable to kill transcoder")
+		}
+	case <-j.done:
+	}
+}
+
+// ID returns the id of the job.
+func (j *Job) ID() int { return j.id }
+
+// Wait blocks until the process has exited and been reaped.
+func (j *Job) Wait() error {
+	<-j.done
+	return j.err
+}
+
+// Done is closed once the process has exited and been reaped.
+func (j *Job) Done() <-chan struct{} { return j.done }
+
+// Stop kills the process.
+func (j *Job) Stop() { j.cancel() }
+
+// Info describes the job.
+func (j *Job) Info() JobInfo {
+	info := JobInfo{
+		ID:       j.id,
+		Session:  j.spec.Session,
+		Filename: j.spec.Filename,
+		Command:  j.spec.Args,
+		Started:  j.started,
+		Stderr:   j.lastStderr(),
+	}
+	if j.cmd.Process != nil {
+		info.PID = j.cmd.Process.Pid
+	}
+	return info
+}
+
+func (j *Job) captureStderr(r io.Reader) {
+	defer close(j.stderrDone)
+
+	logger := j.logger()
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanLines)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		j.mu.Lock()
+		j.stderr = append(j.stderr, line)
+		if len(j.stderr) > stderrLines {
+			j.stderr = j.stderr[len(j.stderr)-stderrLines:]
+		}
+		j.mu.Unlock()
+
+		if j.spec.Verbose {
+			logger.Info(line)
+		} else {
+			logger.Debug(line)
+		}
+	}
+	// Keep draining if the scanner gave up, otherwise the process blocks
+	// writing to stderr.
+	io.Copy(ioutil.Discard, r)
+}
+
+// scanLines is bufio.ScanLines that also splits on carriage returns, which
+// ffmpeg uses for its progress output.
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func (j *Job) lastStderr() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]string(nil), j.stderr...)
+}
+
+func (j *Job) logger() *log.Entry {
+	return log.WithField("package", "transcode").WithFields(log.Fields{
+		"job":      j.id,
+		"session":  j.spec.Session,
+		"filename": j.spec.Filename,
+	})
+}
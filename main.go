@@ -1,11 +1,27 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	// Schedules may be in any time zone, whether or not the host has the
+	// time zone database.
+	_ "time/tzdata"
 
+	"github.com/avinash240/pusher/internal/config"
 	// "github.com/avinash240/pusher/internal/plugins"
 	srv "github.com/avinash240/pusher/internal/server"
+	"github.com/avinash240/pusher/internal/server/auth"
+	"github.com/avinash240/pusher/internal/server/certs"
+	"github.com/avinash240/pusher/internal/server/media"
+	"github.com/avinash240/pusher/internal/streaming/live"
 )
 
 func main() {
@@ -17,14 +33,194 @@ func main() {
 	// 	fmt.Printf("%+v", p)
 	// }
 
+	cfg, err := config.Load(config.DefaultPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	// Every device session and the local server share one media server.
+	mediaOpts := []media.ServerOption{
+		media.WithTokenTTL(cfg.Media.TokenTTL),
+		media.WithSigningKey([]byte(cfg.Media.SigningKey)),
+	}
+	if cfg.Media.RestrictClients {
+		var networks []*net.IPNet
+		for _, cidr := range cfg.Media.AllowedNetworks {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				log.Fatalf("invalid allowed media network: %v", err)
+			}
+			networks = append(networks, network)
+		}
+		mediaOpts = append(mediaOpts, media.WithClientAllowlist(networks...))
+	}
+	media.SetDefault(media.NewServer(cfg.Media.Port, mediaOpts...))
+
+	tlsConfig, certificates, err := newTLSConfig(cfg.API.TLS)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	tokens, err := apiTokens(cfg.API.Auth)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	authenticator, err := newAuthenticator(tokens, cfg.API.TLS.ClientCAFile != "")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	// The local server is served over http, so its admins can't present
+	// client certificates.
+	localAuthenticator, err := newAuthenticator(tokens, false)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// The api lists the media the local server loads, for the web remote.
+	library := srv.NewLibrary()
 	// /* Testing Server Code*/
-	go srv.NewLocalServer()
+	go srv.NewLocalServer(srv.WithLocalServerAuth(localAuthenticator), srv.WithLocalServerLibrary(library))
+
+	var sources []live.Spec
+	for _, l := range cfg.Live {
+		sources = append(sources, live.Spec{
+			Name:        l.Name,
+			ContentType: l.ContentType,
+			Command:     l.Command,
+			Env:         l.Env,
+			Pipe:        l.Pipe,
+			TCP:         l.TCP,
+			UDP:         l.UDP,
+		})
+	}
 
 	log.Println("")
 	/* Testing Chromecast Connect */
-	c := srv.NewHandler(false)
+	c := srv.NewHandler(false,
+		srv.WithMaxTranscodes(cfg.Transcode.MaxConcurrent),
+		srv.WithHLSCacheDir(cfg.Transcode.HLSCacheDir),
+		srv.WithLiveSources(sources...),
+		srv.WithSleepFade(cfg.Sleep.Fade),
+		srv.WithSchedulePath(cfg.Schedule.Path),
+		srv.WithSyncDrift(cfg.Sync.Interval, cfg.Sync.Tolerance),
+		srv.WithGroups(cfg.Groups),
+		srv.WithAuth(authenticator),
+		srv.WithTLSConfig(tlsConfig),
+		srv.WithAllowedOrigins(cfg.API.AllowedOrigins...),
+		srv.WithLibrary(library),
+	)
 	fmt.Printf("c: %v\n", c)
 
-	s := c.Serve("127.0.0.1:8080")
-	fmt.Printf("s: %v\n", s)
+	go func() {
+		if err := c.Serve(cfg.API.Addr); err != nil && err != http.ErrServerClosed {
+			log.Fatalln(err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	var s os.Signal
+	for s = range signals {
+		if s != syscall.SIGHUP {
+			break
+		}
+		// Certificates are reloaded on SIGHUP, such as once they are renewed.
+		if certificates == nil {
+			log.Println("received SIGHUP, but the api isn't served over https")
+			continue
+		}
+		if err := certificates.Reload(); err != nil {
+			log.Println(err)
+			continue
+		}
+		log.Printf("reloaded certificate, SHA-256 fingerprint %s", certificates.Fingerprint())
+	}
+	log.Printf("received %v, shutting down", s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+	if err := c.Shutdown(ctx, cfg.Shutdown.StopMedia); err != nil {
+		log.Println(err)
+	}
+}
+
+// newTLSConfig returns the TLS config the api is served with, and the
+// certificates it serves, or nil when it is served over http.
+func newTLSConfig(cfg config.TLSConfig) (*tls.Config, *certs.Store, error) {
+	if !cfg.Enabled && cfg.ClientCAFile != "" {
+		return nil, nil, errors.New("client certificates can only be required when tls is enabled")
+	}
+	if !cfg.Enabled {
+		return nil, nil, nil
+	}
+	certFile, keyFile := cfg.CertFile, cfg.KeyFile
+	if certFile == "" && keyFile == "" {
+		certFile, keyFile = config.SelfSignedCertFile, config.SelfSignedKeyFile
+		generated, err := certs.EnsureSelfSigned(certFile, keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if generated {
+			log.Printf("generated self-signed certificate %s", certFile)
+		}
+	}
+	store, err := certs.Load(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("serving the api with certificate %s, SHA-256 fingerprint %s", certFile, store.Fingerprint())
+
+	c := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: store.GetCertificate,
+	}
+	if cfg.ClientCAFile != "" {
+		pool, err := certs.LoadClientCAs(cfg.ClientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Only admins need a certificate, which is checked by the
+		// authenticator.
+		c.ClientCAs = pool
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return c, store, nil
+}
+
+// apiTokens returns the tokens cfg lists, and those in its token file.
+func apiTokens(cfg config.AuthConfig) ([]auth.Token, error) {
+	var tokens []auth.Token
+	for _, t := range cfg.Tokens {
+		token := auth.Token{Name: t.Name, Token: t.Token}
+		for _, s := range t.Scopes {
+			token.Scopes = append(token.Scopes, auth.Scope(s))
+		}
+		tokens = append(tokens, token)
+	}
+	if cfg.TokenFile != "" {
+		fileTokens, err := auth.LoadFile(cfg.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, fileTokens...)
+	}
+	if len(tokens) == 0 {
+		log.Println("no api tokens are configured, every request is served")
+	}
+	return tokens, nil
+}
+
+// newAuthenticator returns the authenticator of tokens, or nil when there
+// are none and every request is served. Admins need client certificates as
+// well when clientCerts is set.
+func newAuthenticator(tokens []auth.Token, clientCerts bool) (*auth.Authenticator, error) {
+	if len(tokens) == 0 {
+		if clientCerts {
+			return nil, errors.New("client certificates are only required of admins when api tokens are configured")
+		}
+		return nil, nil
+	}
+	var opts []auth.Option
+	if clientCerts {
+		opts = append(opts, auth.WithClientCerts(auth.Admin))
+	}
+	return auth.New(tokens, opts...)
 }